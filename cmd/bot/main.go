@@ -2,25 +2,38 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/shopspring/decimal"
 
 	"github.com/romanzzaa/bybit-options-roller/internal/bot"
 	"github.com/romanzzaa/bybit-options-roller/internal/config"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/binance"
 	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/bybit"
 	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/crypto"
 	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/migrations"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/fakeexchange"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/marketdata"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/ticklog"
 	"github.com/romanzzaa/bybit-options-roller/internal/usecase"
 	"github.com/romanzzaa/bybit-options-roller/internal/worker"
 )
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	migrateOnly := flag.Bool("migrate", false, "apply database migrations and exit")
+	flag.Parse()
+
+	logLevel := new(slog.LevelVar)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -28,40 +41,244 @@ func main() {
 		os.Exit(1)
 	}
 
+	if cfg.Bybit.Debug {
+		logLevel.Set(slog.LevelDebug)
+	}
+
 	dbConnConfig := database.Config{
+		Driver:   database.Driver(cfg.Database.Driver),
+		Path:     cfg.Database.Path,
 		Host:     cfg.Database.Host,
 		Port:     cfg.Database.Port,
 		User:     cfg.Database.User,
 		Password: cfg.Database.Password,
 		DBName:   cfg.Database.DBName,
 		SSLMode:  cfg.Database.SSLMode,
+		// Воркер держит соединение под лок задачи весь ролл: остальным запросам нужен запас
+		MaxOpenConns: 25 + cfg.Worker.Count,
+
+		ConnectAttempts: cfg.Database.ConnectAttempts,
+		ConnectBackoff:  cfg.Database.ConnectBackoff,
+		ConnectTimeout:  cfg.Database.ConnectTimeout,
+
+		ReadTimeout:  cfg.Database.ReadTimeout,
+		WriteTimeout: cfg.Database.WriteTimeout,
+
+		SlowQueryThreshold: cfg.Database.SlowQueryThreshold,
+		TaskLockMaxHold:    cfg.Database.TaskLockMaxHold,
 	}
 
-	db, err := database.NewConnection(dbConnConfig)
+	// Подключаемся до миграций: они тоже упадут, если база еще не поднялась
+	db, err := database.NewConnection(context.Background(), dbConnConfig, logger)
 	if err != nil {
 		logger.Error("failed to connect to database", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	taskRepo := database.NewTaskRepository(db, logger)
+	if *migrateOnly || cfg.Database.AutoMigrate {
+		migrate := func() error { return migrations.Migrate(dbConnConfig.ConnectString(), logger) }
+		if db.Driver() == database.DriverSQLite {
+			migrate = func() error { return migrations.MigrateSQLite(db.DB, logger) }
+		}
+		if err := migrate(); err != nil {
+			logger.Error("failed to migrate database", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if *migrateOnly {
+			return
+		}
+	}
+
+	taskRepo := database.NewTaskRepository(db, logger, cfg.EnforceSubscriptions)
+	orderRepo := database.NewOrderRepository(db)
 
 	encryptor, err := crypto.NewEncryptor(cfg.Crypto.EncryptionKey)
 	if err != nil {
 		logger.Error("failed to create encryptor", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	keyring, err := crypto.NewKeyring(cfg.Crypto.KeyVersion, encryptor)
+	if err != nil {
+		logger.Error("failed to create keyring", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
-	keyRepo := database.NewAPIKeyRepository(db, encryptor)
+	keyRepo := database.NewAPIKeyRepository(db, keyring)
+	if n, err := keyRepo.BackfillTestnet(context.Background(), cfg.BybitTestnet); err != nil {
+		logger.Error("failed to backfill api key network", slog.String("error", err.Error()))
+		os.Exit(1)
+	} else if n > 0 {
+		logger.Info("API keys assigned to global network", slog.Int64("count", n), slog.Bool("testnet", cfg.BybitTestnet))
+	}
 	userRepo := database.NewUserRepository(db)
 	licRepo := database.NewLicenseRepository(db)
 
-	bybitClient := bybit.NewClient(cfg.BybitTestnet, cfg.Bybit.Timeout)
-	rollerService := usecase.NewRollerService(bybitClient, taskRepo, logger)
+	bybitEnv, err := bybit.ParseEnvironment(cfg.Bybit.Environment)
+	if err != nil {
+		logger.Error("invalid bybit environment", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	proxies, err := bybit.NewProxies(bybit.ProxyConfig{
+		URL:     cfg.Bybit.ProxyURL,
+		Mainnet: cfg.Bybit.ProxyMainnet,
+		Testnet: cfg.Bybit.ProxyTestnet,
+		Demo:    cfg.Bybit.ProxyDemo,
+	})
+	if err != nil {
+		logger.Error("invalid bybit proxy", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	bybitClient := bybit.NewClient(bybit.ClientConfig{
+		Environment:  bybitEnv,
+		Timeout:      cfg.Bybit.Timeout,
+		OrderTimeout: cfg.Bybit.OrderTimeout,
+		RecvWindow:   cfg.Bybit.RecvWindow,
+		RateLimits: bybit.RateLimits{
+			MarketRPS:  cfg.Bybit.MarketRPS,
+			AccountRPS: cfg.Bybit.AccountRPS,
+			TradeRPS:   cfg.Bybit.TradeRPS,
+		},
+		Retry: bybit.RetryPolicy{
+			MaxAttempts: cfg.Bybit.RetryAttempts,
+			BaseDelay:   cfg.Bybit.RetryBaseDelay,
+			MaxDelay:    cfg.Bybit.RetryMaxDelay,
+
+			RateLimitRetries: cfg.Bybit.RateLimitRetries,
+			MaxRateLimitWait: cfg.Bybit.RateLimitMaxWait,
+		},
+		Deadlines: bybit.OperationDeadlines{
+			Market:  cfg.Bybit.MarketDeadline,
+			Account: cfg.Bybit.AccountDeadline,
+			Trade:   cfg.Bybit.TradeDeadline,
+		},
+		Proxies: proxies,
+		Transport: bybit.TransportConfig{
+			MaxIdleConnsPerHost: cfg.Bybit.MaxIdleConnsPerHost,
+			IdleConnTimeout:     cfg.Bybit.IdleConnTimeout,
+			EnableHTTP2:         cfg.Bybit.HTTP2,
+		},
+		Debug:  cfg.Bybit.Debug,
+		Logger: logger,
+	})
 
-	marketStream := bybit.NewMarketStream(cfg.BybitTestnet)
+	// Сеть задается на уровне ключа, поэтому держим стримы обеих сетей
+	priceSource, err := domain.ParsePriceSource(cfg.Bybit.TriggerPriceSource)
+	if err != nil {
+		logger.Error("invalid trigger price source", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	logger.Info("Trigger price source", slog.String("source", string(priceSource)))
+
+	var exchange domain.ExchangeAdapter = bybitClient
+	var fakeExchange *fakeexchange.Exchange
+	var feeds worker.MarketFeeds
+	var accountStream domain.AccountStreamer // Только для настоящей биржи
+
+	if cfg.FakeExchange {
+		// Локальный режим: весь цикл ролла работает офлайн на бирже в памяти
+		logger.Warn("⚠️ FAKE EXCHANGE: orders are not sent to Bybit (set FAKE_EXCHANGE=false to trade)")
+		fakeExchange = fakeexchange.New(fakeexchange.DefaultConfig(time.Now()))
+		exchange = fakeExchange
+		feeds = worker.MarketFeeds{
+			Mainnet: fakeexchange.NewStream(fakeExchange, time.Second),
+			Testnet: fakeexchange.NewStream(fakeExchange, time.Second),
+		}
+
+		if cfg.Ticks.ReplayFile != "" {
+			// Воспроизведение инцидента: триггеры видят записанные тики вместо сценария фейковой биржи
+			mainnetReplay, err := ticklog.NewReplayStream(cfg.Ticks.ReplayFile, false, cfg.Ticks.ReplaySpeed)
+			if err != nil {
+				logger.Error("failed to load tick replay", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			testnetReplay, err := ticklog.NewReplayStream(cfg.Ticks.ReplayFile, true, cfg.Ticks.ReplaySpeed)
+			if err != nil {
+				logger.Error("failed to load tick replay", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			feeds.Mainnet, feeds.Testnet = mainnetReplay, testnetReplay
+			logger.Info("Replaying recorded ticks",
+				slog.String("file", cfg.Ticks.ReplayFile),
+				slog.Int("mainnet_ticks", mainnetReplay.Len()),
+				slog.Int("testnet_ticks", testnetReplay.Len()),
+				slog.Float64("speed", cfg.Ticks.ReplaySpeed))
+		}
+	} else {
+		// Самопроверка связи: неверный прокси должен быть виден сразу, а не как сбой первого ролла
+		checkCtx, cancelCheck := context.WithTimeout(context.Background(), 15*time.Second)
+		if err := bybitClient.CheckConnectivity(checkCtx); err != nil {
+			if cfg.Bybit.ProxyURL != "" || cfg.Bybit.ProxyMainnet != "" || cfg.Bybit.ProxyTestnet != "" || cfg.Bybit.ProxyDemo != "" {
+				logger.Error("bybit connectivity self-check failed, check proxy settings", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			logger.Warn("bybit connectivity self-check failed", slog.String("error", err.Error()))
+		}
+		cancelCheck()
+
+		streamURLs := bybit.StreamURLs{
+			MainnetLinear:  cfg.Bybit.WSLinearURL,
+			TestnetLinear:  cfg.Bybit.WSLinearURLTestnet,
+			MainnetOption:  cfg.Bybit.WSOptionURL,
+			TestnetOption:  cfg.Bybit.WSOptionURLTestnet,
+			MainnetPrivate: cfg.Bybit.WSPrivateURL,
+			TestnetPrivate: cfg.Bybit.WSPrivateURLTestnet,
+			DemoPrivate:    cfg.Bybit.WSPrivateURLDemo,
+		}
+		mainnetStream := bybit.NewMarketStream(bybit.EnvMainnet, streamURLs, priceSource, proxies)
+		testnetStream := bybit.NewMarketStream(bybit.EnvTestnet, streamURLs, priceSource, proxies)
+		mainnetOptions := bybit.NewOptionStream(bybit.EnvMainnet, streamURLs, proxies)
+		testnetOptions := bybit.NewOptionStream(bybit.EnvTestnet, streamURLs, proxies)
+		for _, stream := range []*bybit.MarketStream{mainnetStream, testnetStream} {
+			stream.SetStaleTimeout(cfg.Bybit.StaleTimeout)
+			stream.SetCoalescing(cfg.Bybit.DedupeTicks, cfg.Bybit.MaxTicksPerSecond)
+		}
+
+		// Самопроверка WebSocket до подписок Manager: свой адрес, который не отвечает, - ошибка конфигурации
+		customURLs := cfg.Bybit.WSLinearURL != "" || cfg.Bybit.WSLinearURLTestnet != "" || cfg.Bybit.WSOptionURL != "" || cfg.Bybit.WSOptionURLTestnet != ""
+		for _, stream := range []*bybit.MarketStream{mainnetStream, testnetStream, mainnetOptions, testnetOptions} {
+			checkCtx, cancelCheck := context.WithTimeout(context.Background(), 10*time.Second)
+			err := stream.CheckConnectivity(checkCtx)
+			cancelCheck()
+			if err == nil {
+				continue
+			}
+			if customURLs {
+				logger.Error("bybit stream self-check failed, check BYBIT_WS_*_URL settings", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			logger.Warn("bybit stream self-check failed", slog.String("error", err.Error()))
+		}
+
+		var mainnetFeed domain.MarketStreamer = mainnetStream
+		if cfg.Bybit.PriceFallback == "binance" {
+			// Только mainnet: цены testnet живут своей жизнью и с Binance не совпадают
+			if cfg.Bybit.StaleTimeout <= 0 {
+				logger.Warn("PRICE_FALLBACK needs BYBIT_WS_STALE_SECONDS > 0 to detect outages")
+			}
+			fallback := binance.NewMarkPriceStream(cfg.Bybit.BinanceStreamURL, priceSource, nil)
+			mainnetFeed = marketdata.NewFailover(mainnetStream, fallback, nil)
+			logger.Info("Binance price fallback enabled", slog.String("url", cfg.Bybit.BinanceStreamURL))
+		}
+
+		accountStream = bybit.NewPrivateStream(proxies, streamURLs)
+
+		feeds = worker.MarketFeeds{
+			Mainnet:        mainnetFeed,
+			Testnet:        testnetStream,
+			MainnetOptions: mainnetOptions,
+			TestnetOptions: testnetOptions,
+		}
+	}
 
-	manager := worker.NewManager(taskRepo, keyRepo, rollerService, marketStream, logger)
+	execution := usecase.DefaultExecutionConfig()
+	execution.Mode = cfg.Execution.Mode
+	execution.ChaseInterval = cfg.Execution.ChaseInterval
+	execution.ChaseStep = decimal.NewFromFloat(cfg.Execution.ChaseStepPercent).Div(decimal.NewFromInt(100))
+	execution.ChaseMaxDistance = decimal.NewFromFloat(cfg.Execution.ChaseMaxDistancePercent).Div(decimal.NewFromInt(100))
+	execution.MaxAccountMMR = decimal.NewFromFloat(cfg.Execution.MaxAccountMMRPercent).Div(decimal.NewFromInt(100))
 
 	tgBot, err := tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
 	if err != nil {
@@ -72,18 +289,77 @@ func main() {
 	tgBot.Debug = false
 	logger.Info("Telegram bot authorized", slog.String("username", tgBot.Self.UserName))
 
-	botHandler := bot.NewHandler(tgBot, userRepo, keyRepo, taskRepo, licRepo, manager, bybitClient, cfg.Telegram.AdminID, logger)
+	// Уведомления пользователям и администратору из роллера, менеджера и свипера
+	notifier := bot.NewNotifier(tgBot, userRepo, bot.NotifierConfig{
+		Workers:   cfg.Telegram.NotifyWorkers,
+		QueueSize: cfg.Telegram.NotifyQueueSize,
+		AdminID:   cfg.Telegram.AdminID,
+	}, logger)
+
+	rollerService := usecase.NewRollerService(exchange, taskRepo, orderRepo, notifier, execution, logger)
+
+	workerConfig := worker.Config{
+		Workers:           cfg.Worker.Count,
+		QueueSize:         cfg.Worker.QueueSize,
+		ReconcileInterval: cfg.Worker.ReconcileInterval,
+		DrainTimeout:      cfg.Worker.DrainTimeout,
+		FailureLimit:      cfg.Worker.FailureLimit,
+		FailureWindow:     cfg.Worker.FailureWindow,
+	}
+	manager := worker.NewManager(taskRepo, keyRepo, rollerService, feeds, notifier, workerConfig, logger)
+
+	var tickRecorder *ticklog.Recorder
+	if cfg.Ticks.RecordDir != "" {
+		tickRecorder, err = ticklog.NewRecorder(ticklog.Config{
+			Dir:           cfg.Ticks.RecordDir,
+			BufferSize:    cfg.Ticks.BufferSize,
+			FlushInterval: cfg.Ticks.FlushInterval,
+			Retention:     cfg.Ticks.Retention,
+		}, logger)
+		if err != nil {
+			logger.Error("failed to start tick recorder", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		manager.SetTickRecorder(tickRecorder)
+		logger.Info("Recording ticks", slog.String("dir", cfg.Ticks.RecordDir))
+	}
+	if accountStream != nil {
+		manager.SetAccountStream(accountStream, orderRepo)
+	}
+	if cfg.Worker.PollInterval > 0 {
+		manager.SetRESTFallback(exchange, priceSource, cfg.Worker.PollInterval)
+	}
+	expirySweeper := worker.NewExpirySweeper(taskRepo, keyRepo, exchange, notifier, cfg.EnforceSubscriptions, 10*time.Minute, logger)
+
+	botHandler := bot.NewHandler(tgBot, userRepo, keyRepo, taskRepo, licRepo, orderRepo, database.NewAdminAuditRepository(db), db, manager, exchange, priceSource, cfg.Telegram.AdminID, cfg.Bybit.Environment, cfg.Telegram.MMRWarnPercent, logger)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	logger.Info("Starting bot...",
 		slog.String("env", cfg.Env),
-		slog.Bool("testnet", cfg.BybitTestnet))
+		slog.String("bybit_env", string(bybitEnv)))
 
-	go manager.Run(ctx)
+	if fakeExchange != nil {
+		go fakeExchange.RunScript(ctx, 5*time.Second)
+	}
+	managerDone := make(chan struct{})
+	go func() {
+		defer close(managerDone)
+		manager.Run(ctx)
+	}()
+	if tickRecorder != nil {
+		go tickRecorder.Run(ctx)
+	}
+	go notifier.Run(ctx)
+	go expirySweeper.Run(ctx)
 	go botHandler.Start(ctx)
 
 	<-ctx.Done()
+	// Снимаем перехват сигналов: повторный Ctrl+C завершит процесс, не дожидаясь роллов
+	cancel()
+	logger.Info("Shutting down, waiting for in-flight rolls...")
+	// Роллы пишут в БД: она закрывается (defer db.Close) только после остановки Manager
+	<-managerDone
 	logger.Info("Bot stopped gracefully")
 }
\ No newline at end of file
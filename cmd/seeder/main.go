@@ -11,6 +11,7 @@ import (
 	"github.com/romanzzaa/bybit-options-roller/internal/domain"
 	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/crypto"
 	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/migrations"
 	"github.com/shopspring/decimal"
     // Не забудьте импорт драйвера, если он не импортирован внутри database
     _ "github.com/lib/pq" 
@@ -31,25 +32,40 @@ func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
 	// 2. Database
-	db, err := database.NewConnection(database.Config{
+	db, err := database.NewConnection(context.Background(), database.Config{
+		Driver: database.Driver(cfg.Database.Driver), Path: cfg.Database.Path,
 		Host: cfg.Database.Host, Port: cfg.Database.Port, User: cfg.Database.User,
 		Password: cfg.Database.Password, DBName: cfg.Database.DBName, SSLMode: cfg.Database.SSLMode,
-	})
+		ConnectAttempts: cfg.Database.ConnectAttempts, ConnectBackoff: cfg.Database.ConnectBackoff,
+		ConnectTimeout: cfg.Database.ConnectTimeout,
+		ReadTimeout: cfg.Database.ReadTimeout, WriteTimeout: cfg.Database.WriteTimeout,
+	}, logger)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
+	// SQLite-база локальная и часто новая: схему накатываем сразу (Postgres - через бота, -migrate)
+	if db.Driver() == database.DriverSQLite {
+		if err := migrations.MigrateSQLite(db.DB, logger); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+	}
+
 	// 3. Encryptor (Нужен для создания API Key)
 	encryptor, err := crypto.NewEncryptor(cfg.Crypto.EncryptionKey)
 	if err != nil {
 		log.Fatalf("Encryptor init failed: %v", err)
 	}
+	keyring, err := crypto.NewKeyring(cfg.Crypto.KeyVersion, encryptor)
+	if err != nil {
+		log.Fatalf("Keyring init failed: %v", err)
+	}
 
 	// 4. Repositories
 	userRepo := database.NewUserRepository(db)
-	keyRepo := database.NewAPIKeyRepository(db, encryptor)
-	taskRepo := database.NewTaskRepository(db, logger)
+	keyRepo := database.NewAPIKeyRepository(db, keyring)
+	taskRepo := database.NewTaskRepository(db, logger, cfg.EnforceSubscriptions)
 
 	ctx := context.Background()
 
@@ -65,17 +81,11 @@ func main() {
         IsBanned:   false,
     }
 
-    // Пытаемся найти или создать
-    existingUser, _ := userRepo.GetByTelegramID(ctx, user.TelegramID)
-    if existingUser != nil {
-        log.Printf("[Seeder] User already exists (ID: %d). Using him.", existingUser.ID)
-        user = existingUser
-    } else {
-        if err := userRepo.Create(ctx, user); err != nil {
-            log.Fatalf("Failed to create user: %v", err)
-        }
-        log.Printf("✅ User created! ID: %d", user.ID)
+    // Находим или создаем
+    if err := userRepo.GetOrCreate(ctx, user); err != nil {
+        log.Fatalf("Failed to create user: %v", err)
     }
+    log.Printf("✅ User ready! ID: %d", user.ID)
 
 	// --- ШАГ 2: Создаем API Key ---
     // ВАЖНО: Тут должны быть валидные ключи от Testnet Bybit, 
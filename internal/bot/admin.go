@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Telegram режет сообщения длиннее 4096 символов; /task показывает последние события и
+// обрезает ошибку, чтобы карточка влезала целиком
+const (
+	telegramMessageMaxRunes = 4096
+	adminTaskEvents         = 10
+	adminTaskErrorMaxRunes  = 1000
+)
+
+// adminCommand - команда администратора. Ответ администратору команда шлет сама, а ошибку
+// возвращает для журнала: она становится итогом команды.
+type adminCommand func(ctx context.Context, msg *tgbotapi.Message) error
+
+// errAdminUsage - команду вызвали с неверными аргументами
+var errAdminUsage = errors.New("invalid arguments")
+
+// newAdminCommands - команды администратора. Доступ проверяет runAdminCommand, по одному месту
+// на все команды: новая команда не может остаться без проверки.
+func (h *Handler) newAdminCommands() map[string]adminCommand {
+	return map[string]adminCommand{
+		"gen":      h.cmdGenAdmin,
+		"streams":  h.cmdStreamsAdmin,
+		"dbstats":  h.cmdDBStatsAdmin,
+		"failed":   h.cmdFailedAdmin,
+		"users":    h.cmdUsersAdmin,
+		"expiring": h.cmdExpiringAdmin,
+		"licenses": h.cmdLicensesAdmin,
+		"revoke":   h.cmdRevokeAdmin,
+		"ban": func(ctx context.Context, msg *tgbotapi.Message) error {
+			return h.cmdBanAdmin(ctx, msg, true)
+		},
+		"unban": func(ctx context.Context, msg *tgbotapi.Message) error {
+			return h.cmdBanAdmin(ctx, msg, false)
+		},
+		"extend":        h.cmdExtendAdmin,
+		"task":          h.cmdTaskAdmin,
+		"broadcast":     h.cmdBroadcastAdmin,
+		"stopbroadcast": h.cmdStopBroadcastAdmin,
+	}
+}
+
+// runAdminCommand выполняет команду только от администратора и пишет в журнал ее итог. Остальным
+// бот не отвечает, как на неизвестную команду.
+func (h *Handler) runAdminCommand(ctx context.Context, msg *tgbotapi.Message, cmd adminCommand) {
+	if h.adminID == 0 || msg.From.ID != h.adminID {
+		h.logger.Warn("Admin command from non-admin", "command", msg.Command(), "telegram_id", msg.From.ID)
+		return
+	}
+
+	result := "ok"
+	if err := cmd(ctx, msg); err != nil {
+		result = err.Error()
+	}
+
+	entry := &domain.AdminAuditEntry{
+		AdminTelegramID: msg.From.ID,
+		Action:          msg.Command(),
+		Details:         strings.TrimSpace(msg.CommandArguments()),
+		Result:          result,
+	}
+	if err := h.audit.Record(ctx, entry); err != nil {
+		// Журнал не должен отнимать у администратора управление ботом
+		h.logger.Error("Failed to write admin audit log", "command", entry.Action, "err", err)
+	}
+}
+
+// cmdExtendAdmin - /extend <telegram_id> <дней>: продлить подписку, как кодом на столько же дней
+func (h *Handler) cmdExtendAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	usage := "Использование: /extend <telegram id> <дней>"
+	parts := strings.Fields(msg.CommandArguments())
+	if len(parts) != 2 {
+		h.send(msg.Chat.ID, usage)
+		return errAdminUsage
+	}
+	telegramID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		h.send(msg.Chat.ID, usage)
+		return errAdminUsage
+	}
+	days, err := strconv.Atoi(parts[1])
+	if err != nil || days <= 0 {
+		h.send(msg.Chat.ID, usage)
+		return errAdminUsage
+	}
+
+	expiresAt, err := h.userRepo.ExtendSubscription(ctx, telegramID, days)
+	switch {
+	case errors.Is(err, domain.ErrUserNotFound):
+		h.send(msg.Chat.ID, "❌ Пользователь не найден.")
+		return err
+	case errors.Is(err, domain.ErrLicenseStackLimit):
+		h.send(msg.Chat.ID, "❌ "+licenseErrorText(err))
+		return err
+	case err != nil:
+		h.logger.Error("Failed to extend subscription", "telegram_id", telegramID, "err", err)
+		h.send(msg.Chat.ID, "Ошибка продления подписки.")
+		return err
+	}
+	h.logger.Info("Subscription extended by admin", "telegram_id", telegramID, "days", days, "expires_at", expiresAt)
+
+	// Задачи пользователя с истекшей подпиской мониторинг не держит: подхватываем их
+	h.reloadManager(ctx)
+	until := expiresAt.UTC().Format("02.01.2006 15:04")
+	h.send(msg.Chat.ID, fmt.Sprintf("✅ Подписка %d продлена на %d дн., до %s UTC.", telegramID, days, until))
+	h.send(telegramID, fmt.Sprintf("🎁 Администратор продлил вашу подписку на %d дн. Она действует до %s UTC.", days, until))
+	return nil
+}
+
+// cmdTaskAdmin - /task <id>: задача любого пользователя с ошибкой и последними событиями
+func (h *Handler) cmdTaskAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	taskID, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(msg.CommandArguments()), "#"), 10, 64)
+	if err != nil {
+		h.send(msg.Chat.ID, "Использование: /task <номер задачи>")
+		return errAdminUsage
+	}
+
+	task, err := h.taskRepo.GetTaskByID(ctx, taskID)
+	if err != nil {
+		h.logger.Error("Failed to fetch task", "task_id", taskID, "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения задачи.")
+		return err
+	}
+	if task == nil {
+		h.send(msg.Chat.ID, "❌ Задача не найдена.")
+		return domain.ErrTaskNotFound
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🗂 Задача #%d, версия %d\n\n", task.ID, task.Version))
+	owner := fmt.Sprintf("user %d", task.UserID)
+	if user, err := h.userRepo.GetByID(ctx, task.UserID); err == nil && user != nil {
+		owner += fmt.Sprintf(" (tg %d", user.TelegramID)
+		if user.Username != "" {
+			owner += ", @" + user.Username
+		}
+		owner += fmt.Sprintf(", подписка до %s UTC)", user.ExpiresAt.UTC().Format("02.01.2006"))
+	}
+	sb.WriteString("Владелец: " + owner + "\n")
+	key := fmt.Sprintf("#%d", task.APIKeyID)
+	if k, err := h.keyRepo.GetByID(ctx, task.APIKeyID); err == nil && k != nil {
+		key += fmt.Sprintf(" %s (%s)", k.Label, k.Network())
+		if !k.IsValid {
+			key += ", выключен"
+		}
+	}
+	sb.WriteString("Ключ: " + key + "\n")
+	if task.Label != "" {
+		sb.WriteString("Название: " + task.Label + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("Статус: %s\n", task.Status))
+	sb.WriteString(fmt.Sprintf("Опцион: %s, объем %s, сторона %s\n", task.CurrentOptionSymbol, task.CurrentQty.String(), task.TargetSide))
+	sb.WriteString(fmt.Sprintf("Базовый актив: %s, триггер %s, шаг %s\n", task.UnderlyingSymbol, task.TriggerPrice.String(), task.NextStrikeStep.String()))
+	if task.PremiumAlertThreshold.IsPositive() {
+		sb.WriteString(fmt.Sprintf("Алерт премии: %s\n", task.PremiumAlertThreshold.String()))
+	}
+	sb.WriteString(fmt.Sprintf("Роллов: %d", task.RollCount))
+	if task.LastTriggeredAt != nil {
+		sb.WriteString(fmt.Sprintf(", последний %s UTC", task.LastTriggeredAt.UTC().Format("02.01.2006 15:04")))
+	}
+	sb.WriteString("\n")
+	sb.WriteString(fmt.Sprintf("Создана %s UTC, изменена %s UTC\n",
+		task.CreatedAt.UTC().Format("02.01.2006 15:04"), task.UpdatedAt.UTC().Format("02.01.2006 15:04")))
+	if task.LastError != "" {
+		sb.WriteString("\nПоследняя ошибка:\n" + truncateRunes(task.LastError, adminTaskErrorMaxRunes) + "\n")
+	}
+
+	events, err := h.taskRepo.ListTaskEvents(ctx, task.ID, adminTaskEvents)
+	if err != nil {
+		h.logger.Warn("Failed to list task events", "task_id", task.ID, "err", err)
+	}
+	if len(events) > 0 {
+		sb.WriteString(fmt.Sprintf("\nПоследние события (%d):\n", len(events)))
+		for _, e := range events {
+			// Карточка без Markdown: ошибки биржи произвольные
+			sb.WriteString("• " + strings.ReplaceAll(formatTaskEvent(e), "`", "") + "\n")
+		}
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, truncateRunes(sb.String(), telegramMessageMaxRunes)))
+	return nil
+}
+
+// truncateRunes обрезает текст до n символов вместе с многоточием
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+type recordingAudit struct {
+	entries []domain.AdminAuditEntry
+}
+
+func (a *recordingAudit) Record(_ context.Context, entry *domain.AdminAuditEntry) error {
+	a.entries = append(a.entries, *entry)
+	return nil
+}
+
+func adminMessage(fromID int64, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		From:     &tgbotapi.User{ID: fromID},
+		Chat:     &tgbotapi.Chat{ID: fromID},
+		Text:     text,
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/extend")}},
+	}
+}
+
+func TestRunAdminCommandRecordsResult(t *testing.T) {
+	audit := &recordingAudit{}
+	h := &Handler{adminID: 1, audit: audit, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	var ran bool
+	h.runAdminCommand(context.Background(), adminMessage(1, "/extend 42 30"), func(context.Context, *tgbotapi.Message) error {
+		// Запись в журнал появляется только после выполнения
+		if len(audit.entries) != 0 {
+			t.Fatal("audit entry written before the command ran")
+		}
+		ran = true
+		return nil
+	})
+	h.runAdminCommand(context.Background(), adminMessage(1, "/extend 42"), func(context.Context, *tgbotapi.Message) error {
+		return errAdminUsage
+	})
+	h.runAdminCommand(context.Background(), adminMessage(2, "/extend 42 30"), func(context.Context, *tgbotapi.Message) error {
+		t.Fatal("command ran for non-admin")
+		return nil
+	})
+
+	if !ran {
+		t.Fatal("command did not run")
+	}
+	if len(audit.entries) != 2 {
+		t.Fatalf("%d audit entries, want 2", len(audit.entries))
+	}
+	if e := audit.entries[0]; e.Action != "extend" || e.Details != "42 30" || e.Result != "ok" {
+		t.Fatalf("entry %+v", e)
+	}
+	if e := audit.entries[1]; e.Result != errAdminUsage.Error() {
+		t.Fatalf("failed command result %q, want %q", e.Result, errAdminUsage.Error())
+	}
+}
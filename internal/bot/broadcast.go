@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Рассылка идет ниже лимита Telegram (~30 сообщений в секунду), чтобы оставить место ответам
+// бота и уведомлениям
+const (
+	broadcastInterval  = time.Second / 20
+	broadcastPageSize  = 500
+	broadcastDBTimeout = 10 * time.Second
+)
+
+// errBroadcastRunning - одновременно идет только одна рассылка
+var errBroadcastRunning = errors.New("previous broadcast is still running")
+
+type broadcastStats struct {
+	sent, blocked, failed int
+}
+
+// cmdBroadcastAdmin - /broadcast <текст>: сообщение всем незабаненным пользователям, кроме
+// заблокировавших бота. Рассылка идет в фоне; одновременно - только одна.
+func (h *Handler) cmdBroadcastAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	text := strings.TrimSpace(msg.CommandArguments())
+	if text == "" {
+		h.send(msg.Chat.ID, "Использование: /broadcast <текст>\nОстановить рассылку: /stopbroadcast")
+		return errAdminUsage
+	}
+
+	recipients, err := h.broadcastRecipients(ctx)
+	if err != nil {
+		h.logger.Error("Failed to list broadcast recipients", "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения пользователей.")
+		return err
+	}
+	if len(recipients) == 0 {
+		h.send(msg.Chat.ID, "📭 Получателей нет.")
+		return nil
+	}
+
+	// Рассылка переживает обработку команды, но не остановку бота
+	runCtx, cancel := context.WithCancel(ctx)
+	h.broadcastMu.Lock()
+	if h.broadcastCancel != nil {
+		h.broadcastMu.Unlock()
+		cancel()
+		h.send(msg.Chat.ID, "⏳ Предыдущая рассылка еще идет. Остановить ее: /stopbroadcast")
+		return errBroadcastRunning
+	}
+	h.broadcastCancel = cancel
+	h.broadcastMu.Unlock()
+
+	h.send(msg.Chat.ID, fmt.Sprintf("📣 Рассылка на %d получателей начата. Остановить: /stopbroadcast", len(recipients)))
+	go func() {
+		defer func() {
+			h.broadcastMu.Lock()
+			h.broadcastCancel = nil
+			h.broadcastMu.Unlock()
+			cancel()
+		}()
+
+		started := time.Now()
+		stats := h.runBroadcast(runCtx, recipients, text)
+		h.logger.Info("Broadcast finished",
+			"recipients", len(recipients), "sent", stats.sent, "blocked", stats.blocked, "failed", stats.failed,
+			"cancelled", runCtx.Err() != nil, "duration", time.Since(started).Round(time.Second))
+
+		title := "📣 Рассылка завершена"
+		if runCtx.Err() != nil {
+			title = "⛔ Рассылка остановлена"
+		}
+		h.send(msg.Chat.ID, fmt.Sprintf("%s.\nОтправлено: %d\nЗаблокировали бота: %d\nОшибок: %d\nНе дошла очередь: %d",
+			title, stats.sent, stats.blocked, stats.failed, len(recipients)-stats.sent-stats.blocked-stats.failed))
+	}()
+	return nil
+}
+
+// cmdStopBroadcastAdmin - /stopbroadcast
+func (h *Handler) cmdStopBroadcastAdmin(_ context.Context, msg *tgbotapi.Message) error {
+	h.broadcastMu.Lock()
+	cancel := h.broadcastCancel
+	h.broadcastMu.Unlock()
+
+	if cancel == nil {
+		h.send(msg.Chat.ID, "Рассылка не идет.")
+		return nil
+	}
+	// Итог пришлет сама рассылка
+	cancel()
+	return nil
+}
+
+// broadcastRecipients собирает получателей заранее: отметки о блокировке по ходу рассылки
+// сдвигали бы страницы выборки
+func (h *Handler) broadcastRecipients(ctx context.Context) ([]domain.UserSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, broadcastDBTimeout)
+	defer cancel()
+
+	var recipients []domain.UserSummary
+	for offset := 0; ; offset += broadcastPageSize {
+		page, err := h.userRepo.List(ctx, domain.UserFilterReachable, broadcastPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, page...)
+		if len(page) < broadcastPageSize {
+			return recipients, nil
+		}
+	}
+}
+
+func (h *Handler) runBroadcast(ctx context.Context, recipients []domain.UserSummary, text string) broadcastStats {
+	limiter := time.NewTicker(broadcastInterval)
+	defer limiter.Stop()
+
+	var stats broadcastStats
+	for _, u := range recipients {
+		select {
+		case <-ctx.Done():
+			return stats
+		case <-limiter.C:
+		}
+
+		// Текст администратора произвольный, поэтому без Markdown
+		err := h.sendBroadcast(ctx, tgbotapi.NewMessage(u.TelegramID, text))
+		var tgErr *tgbotapi.Error
+		switch {
+		case err == nil:
+			stats.sent++
+		case errors.As(err, &tgErr) && tgErr.Code == 403:
+			stats.blocked++
+			markCtx, cancel := context.WithTimeout(ctx, broadcastDBTimeout)
+			if err := h.userRepo.MarkBotBlocked(markCtx, u.ID); err != nil {
+				h.logger.Warn("Failed to mark bot blocked", "user_id", u.ID, "err", err)
+			}
+			cancel()
+		default:
+			stats.failed++
+			h.logger.Warn("Broadcast message failed", "user_id", u.ID, "err", err)
+		}
+	}
+	return stats
+}
+
+// sendBroadcast отправляет сообщение; при ответе 429 ждет, сколько просит Telegram, и повторяет один раз
+func (h *Handler) sendBroadcast(ctx context.Context, msg tgbotapi.MessageConfig) error {
+	_, err := h.bot.Send(msg)
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) || tgErr.RetryAfter <= 0 {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(tgErr.RetryAfter) * time.Second):
+	}
+	_, err = h.bot.Send(msg)
+	return err
+}
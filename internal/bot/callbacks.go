@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback-данные инлайн-кнопок: маршрут и аргументы через ":", например "pos:BTC-27DEC24-100000-C",
+// "task:pause:12:0", "page:active:5". Маршрут - пространство ("pos") или пространство и действие
+// ("task:pause"). Telegram принимает не больше 64 байт.
+const (
+	callbackSep        = ":"
+	callbackDataMaxLen = 64
+)
+
+// Маршруты callback-кнопок
+const (
+	callbackPosition      = "pos"      // выбор позиции в /add
+	callbackPositionPage  = "pos:page" // страница выбора позиции
+	callbackTasks         = "page"     // страница /status
+	callbackDeleteCancel  = "cancel"
+	callbackPause         = "task:pause"
+	callbackResume        = "task:resume"
+	callbackEdit          = "task:edit"
+	callbackDelete        = "task:delete"
+	callbackDeleteConfirm = "task:delete_confirm"
+	callbackTaskLabel     = "task:label"
+	callbackTaskDetails   = "task:details"
+
+	callbackKeyInvalidate        = "key:invalidate"
+	callbackKeyInvalidateConfirm = "key:invalidate_confirm"
+	callbackKeyLabel             = "key:label"
+	callbackKeyDelete            = "key:delete"
+	callbackKeyDeleteConfirm     = "key:delete_confirm"
+	callbackKeyMove              = "key:move" // аргументы: ID старого и нового ключа
+)
+
+// callbackHandler получает аргументы кнопки одной строкой (без маршрута)
+type callbackHandler func(ctx context.Context, cb *tgbotapi.CallbackQuery, payload string)
+
+type callbackRouter struct {
+	routes map[string]callbackHandler
+}
+
+func (h *Handler) newCallbackRouter() *callbackRouter {
+	r := &callbackRouter{routes: make(map[string]callbackHandler)}
+	r.handle(callbackPosition, h.selectPosition)
+	r.handle(callbackPositionPage, h.showPositionPage)
+	r.handle(callbackTasks, h.showTaskPage)
+	r.handle(callbackDeleteCancel, h.closeMessage)
+
+	r.handle(callbackPause, func(ctx context.Context, cb *tgbotapi.CallbackQuery, payload string) {
+		h.toggleTaskPause(ctx, cb, payload, true)
+	})
+	r.handle(callbackResume, func(ctx context.Context, cb *tgbotapi.CallbackQuery, payload string) {
+		h.toggleTaskPause(ctx, cb, payload, false)
+	})
+	r.handle(callbackEdit, h.startEditTask)
+	r.handle(callbackDelete, h.askDeleteTask)
+	r.handle(callbackDeleteConfirm, h.deleteTask)
+	r.handle(callbackTaskLabel, h.askTaskLabel)
+	r.handle(callbackTaskDetails, h.showTaskDetails)
+
+	r.handle(callbackKeyInvalidate, h.askInvalidateKey)
+	r.handle(callbackKeyInvalidateConfirm, h.confirmInvalidateKey)
+	r.handle(callbackKeyLabel, h.askKeyLabel)
+	r.handle(callbackKeyDelete, h.askDeleteKey)
+	r.handle(callbackKeyDeleteConfirm, h.deleteKey)
+	r.handle(callbackKeyMove, h.moveKeyTasks)
+	return r
+}
+
+func (r *callbackRouter) handle(route string, fn callbackHandler) {
+	if _, ok := r.routes[route]; ok {
+		panic("duplicate callback route " + route)
+	}
+	r.routes[route] = fn
+}
+
+// match ищет сначала маршрут "пространство:действие", затем "пространство"
+func (r *callbackRouter) match(data string) (callbackHandler, string) {
+	parts := strings.SplitN(data, callbackSep, 3)
+	if len(parts) >= 2 {
+		if fn, ok := r.routes[parts[0]+callbackSep+parts[1]]; ok {
+			if len(parts) == 3 {
+				return fn, parts[2]
+			}
+			return fn, ""
+		}
+	}
+	if fn, ok := r.routes[parts[0]]; ok {
+		_, payload, _ := strings.Cut(data, callbackSep)
+		return fn, payload
+	}
+	return nil, ""
+}
+
+// handleCallback: кнопки старых сообщений (до смены формата) и неизвестные маршруты не трогают
+// состояние, пользователь видит подсказку
+func (h *Handler) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery) {
+	fn, payload := h.callbacks.match(cb.Data)
+	if fn == nil {
+		h.logger.Warn("Unknown callback", "data", cb.Data, "telegram_id", cb.From.ID)
+		h.bot.Request(tgbotapi.NewCallback(cb.ID, "Кнопка устарела. Откройте меню заново."))
+		return
+	}
+	fn(ctx, cb, payload)
+}
+
+// encodeCallback собирает callback-данные кнопки. Разделитель в аргументах сдвинул бы разбор,
+// поэтому такие аргументы, как и данные длиннее лимита Telegram, - ошибка.
+func encodeCallback(route string, args ...string) (string, error) {
+	for _, arg := range args {
+		if strings.Contains(arg, callbackSep) {
+			return "", fmt.Errorf("callback argument %q contains %q", arg, callbackSep)
+		}
+	}
+	data := strings.Join(append([]string{route}, args...), callbackSep)
+	if len(data) > callbackDataMaxLen {
+		return "", fmt.Errorf("callback data %q is %d bytes, limit %d", data, len(data), callbackDataMaxLen)
+	}
+	return data, nil
+}
+
+// mustCallback - encodeCallback для аргументов известной длины (ID, смещения): ошибка здесь - ошибка в коде
+func mustCallback(route string, args ...string) string {
+	data, err := encodeCallback(route, args...)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// idCallback - callback-данные кнопки над задачей или ключом: маршрут и ID
+func idCallback(route string, id int64) string {
+	return mustCallback(route, strconv.FormatInt(id, 10))
+}
+
+// closeMessage: "Отмена" в подтверждениях - убрать вопрос
+func (h *Handler) closeMessage(_ context.Context, cb *tgbotapi.CallbackQuery, _ string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, "Отменено"))
+	h.bot.Request(tgbotapi.NewDeleteMessage(cb.Message.Chat.ID, cb.Message.MessageID))
+}
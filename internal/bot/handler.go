@@ -1,9 +1,14 @@
 package bot
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"log/slog"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,37 +16,76 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/usecase"
 	"github.com/romanzzaa/bybit-options-roller/internal/worker"
 	"github.com/shopspring/decimal"
 )
 
 // Текстовые константы для кнопок (чтобы не опечататься)
 const (
-	BtnActivate = "🔑 Активировать лицензию"
-	BtnAddKey   = "➕ Добавить API ключи"
-	BtnStatus   = "📊 Статус / Задачи"
-	BtnAdd      = "➕ Добавить задачу"
+	BtnActivate  = "🔑 Активировать лицензию"
+	BtnAddKey    = "➕ Добавить API ключи"
+	BtnStatus    = "📊 Статус / Задачи"
+	BtnAdd       = "➕ Добавить задачу"
+	BtnBalance   = "💰 Баланс"
+	BtnPnL       = "📈 PnL отчет"
+	BtnRotateKey = "♻️ Заменить ключ"
+	BtnKeys      = "🔑 Мои ключи"
 )
 
+// Цена из стрима старше этого помечается в статусе как устаревшая
+const lastPriceMaxAge = time.Minute
+
 type Handler struct {
 	bot      *tgbotapi.BotAPI
 	userRepo domain.UserRepository
 	keyRepo  domain.APIKeyRepository
 	taskRepo domain.TaskRepository
 	licRepo  domain.LicenseRepository
+	orders   domain.OrderRepository
+	dbStats  domain.QueryStatsSource
 	exchange domain.ExchangeAdapter
 	manager  *worker.Manager
-
-	adminID int64
-	logger  *slog.Logger
-	states  map[int64]*UserState
-	mu      sync.RWMutex
+	// Позиции с биржи для /status и /add, на несколько секунд
+	positionCache *positionsCache
+	// Цена, по которой срабатывает триггер: показываем ее название пользователю
+	priceSource domain.PriceSource
+
+	adminID       int64
+	defaultKeyEnv string          // сеть нового ключа по умолчанию: mainnet, testnet, demo
+	mmrWarn       decimal.Decimal // MMR (0..1), с которого баланс показывается с предупреждением
+	logger        *slog.Logger
+	states        map[int64]*UserState
+	expiredStates map[int64]time.Time // истекшие мастера: на следующее сообщение скажем, что сессия закрыта
+	cancelMenu    map[int64]bool      // вместо меню показана кнопка отмены
+	mu            sync.RWMutex
+	callbacks     *callbackRouter
+	adminCommands map[string]adminCommand
+	audit         domain.AdminAuditRepository
+
+	// Отмена идущей рассылки /broadcast; nil - рассылка не идет
+	broadcastMu     sync.Mutex
+	broadcastCancel context.CancelFunc
 }
 
 type UserState struct {
-	Step       string // awaiting_license, awaiting_keys, awaiting_trigger, awaiting_step
+	Step       string // awaiting_license, awaiting_keys, awaiting_position, awaiting_trigger, awaiting_step, awaiting_task_label, awaiting_edit_trigger, awaiting_edit_step
 	TempSymbol string
 	TempPrice  string
+
+	// Правка задачи или ее названия
+	TempTaskID      int64
+
+	// Переименование ключа
+	TempKeyID int64
+
+	// Вопрос бота, на который отвечают ключами: после сохранения в нем остается маска ключа
+	PromptMessageID int
+
+	// Позиции, из которых выбирают в /add
+	Positions []domain.Position
+
+	UpdatedAt time.Time // последний ответ пользователя: по нему состояние истекает
 }
 
 func NewHandler(
@@ -50,23 +94,41 @@ func NewHandler(
 	keyRepo domain.APIKeyRepository,
 	taskRepo domain.TaskRepository,
 	licRepo domain.LicenseRepository,
+	orders domain.OrderRepository,
+	audit domain.AdminAuditRepository,
+	dbStats domain.QueryStatsSource,
 	manager *worker.Manager,
 	exchange domain.ExchangeAdapter,
+	priceSource domain.PriceSource,
 	adminID int64,
+	defaultKeyEnv string,
+	mmrWarnPercent float64,
 	logger *slog.Logger,
 ) *Handler {
-	return &Handler{
-		bot:      bot,
-		userRepo: userRepo,
-		keyRepo:  keyRepo,
-		taskRepo: taskRepo,
-		licRepo:  licRepo,
-		manager:  manager,
-		exchange: exchange,
-		adminID:  adminID,
-		logger:   logger,
-		states:   make(map[int64]*UserState),
+	h := &Handler{
+		bot:           bot,
+		userRepo:      userRepo,
+		keyRepo:       keyRepo,
+		taskRepo:      taskRepo,
+		licRepo:       licRepo,
+		orders:        orders,
+		audit:         audit,
+		dbStats:       dbStats,
+		manager:       manager,
+		exchange:      exchange,
+		positionCache: newPositionsCache(),
+		priceSource:   priceSource,
+		adminID:       adminID,
+		defaultKeyEnv: defaultKeyEnv,
+		mmrWarn:       decimal.NewFromFloat(mmrWarnPercent).Div(decimal.NewFromInt(100)),
+		logger:        logger,
+		states:        make(map[int64]*UserState),
+		expiredStates: make(map[int64]time.Time),
+		cancelMenu:    make(map[int64]bool),
 	}
+	h.callbacks = h.newCallbackRouter()
+	h.adminCommands = h.newAdminCommands()
+	return h
 }
 
 func (h *Handler) Start(ctx context.Context) {
@@ -74,13 +136,28 @@ func (h *Handler) Start(ctx context.Context) {
 	u.Timeout = 60
 
 	updates := h.bot.GetUpdatesChan(u)
+	go h.runStateJanitor(ctx)
 
 	for update := range updates {
-		if update.Message != nil {
-			go h.handleMessage(ctx, update.Message)
-		} else if update.CallbackQuery != nil {
-			go h.handleCallback(ctx, update.CallbackQuery)
+		go h.handleUpdate(ctx, update)
+	}
+}
+
+// handleUpdate обрабатывает один апдейт; паника в обработчике не должна ронять весь бот
+func (h *Handler) handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Error("🔥 Telegram update handler panicked",
+				"update_id", update.UpdateID,
+				"panic", r,
+				"stack", string(debug.Stack()))
 		}
+	}()
+
+	if update.Message != nil {
+		h.handleMessage(ctx, update.Message)
+	} else if update.CallbackQuery != nil {
+		h.handleCallback(ctx, update.CallbackQuery)
 	}
 }
 
@@ -89,16 +166,24 @@ func (h *Handler) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 
 	// Обработка команд
 	if msg.IsCommand() {
+		if cmd, ok := h.adminCommands[msg.Command()]; ok {
+			h.runAdminCommand(ctx, msg, cmd)
+			return
+		}
 		switch msg.Command() {
 		case "start":
 			h.cmdStart(ctx, msg)
-		case "gen":
-			if telegramID == h.adminID {
-				h.cmdGenAdmin(ctx, msg)
-			}
+		case "cancel":
+			h.cmdCancel(ctx, msg)
 		// Остальные команды скрыты за кнопками, но оставим для совместимости
 		case "status":
 			h.cmdStatus(ctx, msg)
+		case "pnl":
+			h.cmdPnL(ctx, msg)
+		case "premium":
+			h.cmdPremiumAlert(ctx, msg)
+		case "orders":
+			h.cmdOrders(ctx, msg)
 		}
 		return
 	}
@@ -117,42 +202,56 @@ func (h *Handler) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 	case BtnAdd:
 		h.cmdAdd(ctx, msg)
 		return
+	case BtnBalance:
+		h.cmdBalance(ctx, msg)
+		return
+	case BtnPnL:
+		h.cmdPnL(ctx, msg)
+		return
+	case BtnRotateKey:
+		h.askForKeyRotation(ctx, msg)
+		return
+	case BtnKeys:
+		h.cmdKeys(ctx, msg)
+		return
+	case BtnCancel:
+		h.cmdCancel(ctx, msg)
+		return
 	}
 
 	// Обработка состояний (State Machine)
-	h.mu.RLock()
-	state := h.states[telegramID]
-	h.mu.RUnlock()
+	state, expired := h.currentState(telegramID)
 
-	if state != nil {
+	switch {
+	case state != nil:
 		h.handleStateMachine(ctx, msg, state)
-	} else {
+		if !h.hasState(telegramID) {
+			h.restoreMenu(ctx, msg.Chat.ID, telegramID)
+		}
+	case expired:
+		h.send(msg.Chat.ID, "⌛ Сессия истекла: ввод не сохранен. Начните заново из меню.")
+		h.showMainMenu(ctx, msg.Chat.ID, telegramID)
+	default:
 		// Если состояния нет и текст не распознан
 		h.send(msg.Chat.ID, "Используйте меню для навигации.")
+		h.restoreMenu(ctx, msg.Chat.ID, telegramID)
 	}
 }
 
 // --- Commands ---
 
 func (h *Handler) cmdStart(ctx context.Context, msg *tgbotapi.Message) {
-	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
-	if err != nil {
-		h.logger.Error("DB error", "err", err)
-		return
+	// Регистрация нового пользователя; существующему обновится только username
+	user := &domain.User{
+		TelegramID: msg.From.ID,
+		Username:   msg.From.UserName,
+		ExpiresAt:  time.Now(), // Истекла сразу
+		IsBanned:   false,
 	}
-
-	// Регистрация нового пользователя
-	if user == nil {
-		newUser := &domain.User{
-			TelegramID: msg.From.ID,
-			Username:   msg.From.UserName,
-			ExpiresAt:  time.Now(), // Истекла сразу
-			IsBanned:   false,
-		}
-		if err := h.userRepo.Create(ctx, newUser); err != nil {
-			h.send(msg.Chat.ID, "⚠️ Ошибка регистрации.")
-			return
-		}
+	if err := h.userRepo.GetOrCreate(ctx, user); err != nil {
+		h.logger.Error("Failed to register user", "telegram_id", msg.From.ID, "err", err)
+		h.send(msg.Chat.ID, "⚠️ Ошибка регистрации.")
+		return
 	}
 
 	// Приветствие и клавиатура
@@ -163,25 +262,139 @@ func (h *Handler) cmdStart(ctx context.Context, msg *tgbotapi.Message) {
 	h.send(msg.Chat.ID, text)
 }
 
-func (h *Handler) cmdGenAdmin(ctx context.Context, msg *tgbotapi.Message) {
+func (h *Handler) cmdGenAdmin(ctx context.Context, msg *tgbotapi.Message) error {
 	parts := strings.Fields(msg.Text)
-	if len(parts) != 2 {
-		h.send(msg.Chat.ID, "Usage: /gen <days>")
-		return
+	if len(parts) != 2 && len(parts) != 3 {
+		h.send(msg.Chat.ID, "Usage: /gen <days> [valid days]")
+		return errAdminUsage
 	}
 
 	days, _ := strconv.Atoi(parts[1])
-	lic, err := h.licRepo.Generate(ctx, days)
+	// Необязательный срок, в течение которого код можно активировать
+	var validUntil *time.Time
+	if len(parts) == 3 {
+		validDays, err := strconv.Atoi(parts[2])
+		if err != nil || validDays <= 0 {
+			h.send(msg.Chat.ID, "Usage: /gen <days> [valid days]")
+			return errAdminUsage
+		}
+		until := time.Now().Add(time.Duration(validDays) * 24 * time.Hour)
+		validUntil = &until
+	}
+	lic, err := h.licRepo.Generate(ctx, days, validUntil)
 	if err != nil {
 		h.send(msg.Chat.ID, "Error generating license")
-		return
+		return err
 	}
 
 	// UX Fix: Используем Monospaced шрифт для копирования по клику
 	// MarkdownV2 требует экранирования, но для простоты используем HTML или Markdown
-	reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Ключ на %d дней:\n`%s`", days, lic.Code))
+	text := fmt.Sprintf("Ключ на %d дней:\n`%s`", days, lic.Code)
+	if validUntil != nil {
+		text += fmt.Sprintf("\nАктивировать до %s UTC", validUntil.UTC().Format("02.01.2006 15:04"))
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
 	reply.ParseMode = "Markdown" 
 	h.bot.Send(reply)
+	return nil
+}
+
+// /failed показывает последние по времени изменения задачи, ошибку каждой обрезает:
+// сообщение Telegram ограничено 4096 символами
+const (
+	failedTasksLimit   = 20
+	failedErrorMaxRune = 150
+)
+
+// cmdFailedAdmin - упавшие задачи с последней ошибкой
+func (h *Handler) cmdFailedAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	tasks, err := h.taskRepo.GetTasksByState(ctx, domain.TaskStateFailed)
+	if err != nil {
+		h.logger.Error("Failed to fetch failed tasks", "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения задач.")
+		return err
+	}
+	if len(tasks) == 0 {
+		h.send(msg.Chat.ID, "✅ Упавших задач нет.")
+		return nil
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].UpdatedAt.After(tasks[j].UpdatedAt) })
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔴 Упавшие задачи: %d\n", len(tasks)))
+	for i, t := range tasks {
+		if i == failedTasksLimit {
+			sb.WriteString(fmt.Sprintf("\n... и еще %d\n", len(tasks)-failedTasksLimit))
+			break
+		}
+		lastError := []rune(t.LastError)
+		if len(lastError) > failedErrorMaxRune {
+			lastError = append(lastError[:failedErrorMaxRune], '…')
+		}
+		sb.WriteString(fmt.Sprintf("\n#%d user %d %s, %s UTC\n%s\n",
+			t.ID, t.UserID, t.CurrentOptionSymbol, t.UpdatedAt.UTC().Format("02.01 15:04"), string(lastError)))
+	}
+
+	// Текст ошибок биржи произвольный, поэтому без Markdown
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+	return nil
+}
+
+// cmdStreamsAdmin показывает состояние WebSocket-соединений рыночных стримов
+func (h *Handler) cmdStreamsAdmin(_ context.Context, msg *tgbotapi.Message) error {
+	var sb strings.Builder
+	sb.WriteString("📡 Рыночные стримы:\n")
+	for _, feed := range h.manager.StreamStatus() {
+		stats := feed.Status.Stats
+		sb.WriteString(fmt.Sprintf("\n%s (%s):\n", feed.Feed, feed.Status.Source))
+		sb.WriteString(fmt.Sprintf("  сообщений %d, тиков %d, потеряно %d, реконнектов %d, отказов подписки %d\n",
+			stats.MessagesReceived, stats.EventsPublished, stats.EventsDropped, stats.Reconnects, stats.SubscribeFailures))
+		if len(feed.Status.Connections) == 0 {
+			sb.WriteString("  нет соединений\n")
+		}
+		for i, conn := range feed.Status.Connections {
+			icon := "🟢"
+			if !conn.Connected {
+				icon = "🔴"
+			}
+			sb.WriteString(fmt.Sprintf("  %s #%d: символов %d, аптайм %s, RTT %s\n",
+				icon, i, conn.Symbols, conn.Uptime.Round(time.Second), conn.LastRTT.Round(time.Millisecond)))
+		}
+	}
+
+	pool := h.manager.Stats()
+	sb.WriteString(fmt.Sprintf("\n⚙️ Воркеры: %d, в очереди %d из %d, в работе %d, активных задач %d\n",
+		pool.Workers, pool.Queued, pool.QueueSize, pool.InFlight, pool.ActiveTasks))
+	h.send(msg.Chat.ID, sb.String())
+	return nil
+}
+
+// Сколько самых затратных запросов показывать в /dbstats
+const dbStatsLimit = 15
+
+// cmdDBStatsAdmin - запросы к базе с момента запуска, самые затратные по суммарному времени первыми
+func (h *Handler) cmdDBStatsAdmin(_ context.Context, msg *tgbotapi.Message) error {
+	stats := h.dbStats.QueryStats()
+	if len(stats) == 0 {
+		h.send(msg.Chat.ID, "🗄 Запросов к базе еще не было.")
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🗄 Запросы к базе (имя, число, ошибки, медленные, среднее, максимум):\n")
+	for i, st := range stats {
+		if i == dbStatsLimit {
+			sb.WriteString(fmt.Sprintf("… и еще %d\n", len(stats)-dbStatsLimit))
+			break
+		}
+		avg := st.Total / time.Duration(st.Count)
+		sb.WriteString(fmt.Sprintf("%s: %d, %d, %d, %s, %s\n",
+			st.Name, st.Count, st.Errors, st.Slow, avg.Round(time.Millisecond), st.Max.Round(time.Millisecond)))
+	}
+
+	// Имена методов содержат символы разметки: шлем без Markdown
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+	return nil
 }
 
 // --- State Machine & Logic ---
@@ -193,30 +406,41 @@ func (h *Handler) handleStateMachine(ctx context.Context, msg *tgbotapi.Message,
 	switch state.Step {
 	case "awaiting_license":
 		h.processLicenseActivation(ctx, msg)
+	case "awaiting_position":
+		h.send(msg.Chat.ID, "Выберите позицию кнопкой под списком или отмените: /cancel")
 	case "awaiting_keys":
-		h.processKeys(ctx, msg)
+		h.processKeys(ctx, msg, state)
+	case "awaiting_key_rotation":
+		h.processKeyRotation(ctx, msg, state)
+	case "awaiting_key_label":
+		h.processKeyLabel(ctx, msg, state)
 	case "awaiting_trigger":
 		h.processTrigger(ctx, msg, state)
 	case "awaiting_step":
 		h.processStep(ctx, msg, state)
+	case "awaiting_task_label":
+		h.processTaskLabel(ctx, msg, state)
+	case "awaiting_edit_trigger":
+		h.processEditTrigger(ctx, msg, state)
+	case "awaiting_edit_step":
+		h.processEditStep(ctx, msg, state)
 	}
 }
 
 // 1. Активация лицензии
 func (h *Handler) askForLicense(chatID int64, userID int64) {
-	h.mu.Lock()
-	h.states[userID] = &UserState{Step: "awaiting_license"}
-	h.mu.Unlock()
-	h.send(chatID, "✍️ Введите ваш лицензионный ключ:")
+	h.setState(userID, &UserState{Step: "awaiting_license"})
+	h.ask(chatID, userID, "✍️ Введите ваш лицензионный ключ:")
 }
 
 func (h *Handler) processLicenseActivation(ctx context.Context, msg *tgbotapi.Message) {
 	code := strings.TrimSpace(msg.Text)
 	user, _ := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
 
-	err := h.licRepo.Redeem(ctx, code, user.ID)
+	expiresAt, err := h.licRepo.Redeem(ctx, code, user.ID)
 	if err != nil {
-		h.send(msg.Chat.ID, fmt.Sprintf("❌ Ошибка: %v\nПопробуйте еще раз или нажмите кнопку меню.", err))
+		h.logger.Warn("License redeem failed", "user_id", user.ID, "err", err)
+		h.send(msg.Chat.ID, fmt.Sprintf("❌ %s\nПопробуйте еще раз или нажмите кнопку меню.", licenseErrorText(err)))
 		return // Оставляем в состоянии awaiting_license или сбрасываем? Лучше оставить.
 	}
 
@@ -224,7 +448,8 @@ func (h *Handler) processLicenseActivation(ctx context.Context, msg *tgbotapi.Me
 	delete(h.states, msg.From.ID) // Сбрасываем состояние
 	h.mu.Unlock()
 
-	h.send(msg.Chat.ID, "✅ Лицензия успешно активирована!")
+	h.send(msg.Chat.ID, fmt.Sprintf("✅ Лицензия успешно активирована! Подписка действует до %s UTC.",
+		expiresAt.UTC().Format("02.01.2006 15:04")))
 	
 	// Flow: Сразу проверяем ключи и перерисовываем меню
 	h.checkKeysAndShowMenu(ctx, msg.Chat.ID, msg.From.ID)
@@ -241,6 +466,11 @@ func (h *Handler) checkKeysAndShowMenu(ctx context.Context, chatID int64, telegr
 
 	// 2. Проверяем ключи по ID базы данных (user.ID)
 	apiKey, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	if errors.Is(err, domain.ErrUnknownKeyVersion) {
+		h.sendNoActiveKey(chatID, err)
+		h.showMainMenu(ctx, chatID, telegramID)
+		return
+	}
 	if err != nil {
 		h.logger.Error("DB Error checking keys", "err", err)
 		return
@@ -259,42 +489,165 @@ func (h *Handler) checkKeysAndShowMenu(ctx context.Context, chatID int64, telegr
 
 // 3. Ввод API ключей
 func (h *Handler) askForAPIKeys(chatID int64, userID int64) {
-	h.mu.Lock()
-	h.states[userID] = &UserState{Step: "awaiting_keys"}
-	h.mu.Unlock()
-	h.send(chatID, "🔒 Введите API Key и Secret через пробел:\n\n`API_KEY API_SECRET`")
+	promptID := h.ask(chatID, userID, "🔒 Введите API Key и Secret через пробел:\n\n`API_KEY API_SECRET`\n\n"+
+		"Сеть ключа можно указать третьим словом: `mainnet`, `testnet` или `demo`. По умолчанию: `"+h.defaultKeyEnv+"`.")
+	h.setState(userID, &UserState{Step: "awaiting_keys", PromptMessageID: promptID})
 }
 
-func (h *Handler) processKeys(ctx context.Context, msg *tgbotapi.Message) {
+func (h *Handler) processKeys(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
+	h.deleteCredentialsMessage(msg)
+
 	parts := strings.Fields(msg.Text)
-	if len(parts) != 2 {
-		h.send(msg.Chat.ID, "❌ Неверный формат. Нужно два значения через пробел.")
+	if len(parts) != 2 && len(parts) != 3 {
+		h.send(msg.Chat.ID, "❌ Неверный формат. Нужно два значения через пробел (и сеть третьим словом).")
+		return
+	}
+
+	network := h.defaultKeyEnv
+	if len(parts) == 3 {
+		network = strings.ToLower(parts[2])
+	}
+	if network != "mainnet" && network != "testnet" && network != "demo" {
+		h.send(msg.Chat.ID, "❌ Неизвестная сеть ключа. Допустимо: `mainnet`, `testnet`, `demo`.")
 		return
 	}
 
 	user, _ := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
 	
 	apiKey := &domain.APIKey{
-		UserID:  user.ID,
-		Key:     parts[0],
-		Secret:  parts[1],
-		Label:   "Main",
-		IsValid: true,
+		UserID:    user.ID,
+		Key:       parts[0],
+		Secret:    parts[1],
+		Label:     "Main",
+		IsValid:   true,
+		IsTestnet: network == "testnet",
+		IsDemo:    network == "demo",
+	}
+
+	// Ключ без прав на опционы отклоняем сразу, а не на первом ролле
+	keyInfo, err := h.validateKey(ctx, *apiKey)
+	if err != nil {
+		h.send(msg.Chat.ID, h.keyValidationMessage(err))
+		return
 	}
+	apiKey.ExpiresAt = keyInfo.ExpiresAt
+	apiKey.AccountUID = keyInfo.AccountUID
 
 	if err := h.keyRepo.Create(ctx, apiKey); err != nil {
 		h.send(msg.Chat.ID, "❌ Ошибка сохранения ключей.")
 		return
 	}
+	// Роллы не должны продолжать работать со старыми ключами из кэша Manager
+	h.manager.InvalidateUserKeys(user.ID)
 
 	h.mu.Lock()
 	delete(h.states, msg.From.ID)
 	h.mu.Unlock()
 
-	h.send(msg.Chat.ID, "✅ API ключи сохранены и зашифрованы.")
+	h.markPromptSaved(msg.Chat.ID, state, apiKey.Key)
+	h.send(msg.Chat.ID, "✅ API ключи ("+network+") сохранены и зашифрованы.\n"+keyPermissionsText(keyInfo))
+	h.offerTaskMove(ctx, msg.Chat.ID, user.ID, apiKey)
+	if !apiKey.ExpiresAt.IsZero() {
+		h.send(msg.Chat.ID, fmt.Sprintf("⏳ Ключ действует до %s. Бот напомнит заранее, чтобы вы успели его заменить.",
+			apiKey.ExpiresAt.Format("02.01.2006")))
+	}
+	h.send(msg.Chat.ID, h.accountDiagnosis(ctx, *apiKey))
 	h.showMainMenu(ctx, msg.Chat.ID, user.TelegramID)
 }
 
+// askForKeyRotation - замена ключа на месте: ID записи не меняется, задачи продолжают работать
+func (h *Handler) askForKeyRotation(ctx context.Context, msg *tgbotapi.Message) {
+	if !h.checkSubscription(ctx, msg) {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	current, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil || current == nil {
+		h.sendNoActiveKey(msg.Chat.ID, err)
+		return
+	}
+
+	promptID := h.ask(msg.Chat.ID, msg.From.ID, "♻️ Введите новые API Key и Secret через пробел:\n\n`API_KEY API_SECRET`\n\n"+
+		"Сеть останется прежней: `"+current.Network()+"`. Задачи продолжат работать с новым ключом.")
+	h.setState(msg.From.ID, &UserState{Step: "awaiting_key_rotation", PromptMessageID: promptID})
+}
+
+func (h *Handler) processKeyRotation(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
+	h.deleteCredentialsMessage(msg)
+
+	parts := strings.Fields(msg.Text)
+	if len(parts) != 2 {
+		h.send(msg.Chat.ID, "❌ Неверный формат. Нужно два значения через пробел.")
+		return
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	current, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil || current == nil {
+		h.cancelState(msg.From.ID)
+		h.sendNoActiveKey(msg.Chat.ID, err)
+		return
+	}
+
+	rotated := *current
+	rotated.Key = parts[0]
+	rotated.Secret = parts[1]
+
+	// Сначала проверка на бирже: плохой ключ не должен заменить рабочий
+	keyInfo, err := h.validateKey(ctx, rotated)
+	if err != nil {
+		h.send(msg.Chat.ID, h.keyValidationMessage(err))
+		return
+	}
+	rotated.ExpiresAt = keyInfo.ExpiresAt
+	rotated.AccountUID = keyInfo.AccountUID
+
+	// Задачи остаются на той же записи, поэтому новый ключ обязан быть от того же аккаунта Bybit:
+	// иначе роллы и восстановление пошли бы в чужой аккаунт
+	currentUID, err := h.keyAccountUID(ctx, *current)
+	if err != nil {
+		h.logger.Warn("Failed to resolve account uid of current key", "api_key_id", current.ID, "err", err)
+		h.cancelState(msg.From.ID)
+		h.send(msg.Chat.ID, "❌ Не удалось проверить аккаунт текущего ключа на Bybit. Добавьте новый ключ кнопкой '"+BtnAddKey+"'.")
+		return
+	}
+	if rotated.AccountUID != currentUID {
+		h.logger.Warn("Refused key rotation to another account", "api_key_id", current.ID, "user_id", user.ID)
+		h.send(msg.Chat.ID, "❌ Новый ключ от другого аккаунта Bybit. Замена возможна только ключом того же аккаунта.")
+		return
+	}
+
+	if err := h.keyRepo.Update(ctx, current.ID, rotated.Key, rotated.Secret, rotated.ExpiresAt, rotated.AccountUID); err != nil {
+		if errors.Is(err, domain.ErrTaskMidRoll) {
+			h.cancelState(msg.From.ID)
+			h.send(msg.Chat.ID, "⏳ Задача этого ключа сейчас посреди ролла. Повторите замену, когда ролл завершится.")
+			return
+		}
+		h.logger.Error("Failed to rotate api key", "api_key_id", current.ID, "err", err)
+		h.send(msg.Chat.ID, "❌ Ошибка сохранения ключей.")
+		return
+	}
+	h.manager.InvalidateUserKeys(user.ID)
+	h.cancelState(msg.From.ID)
+	h.logger.Info("API key rotated", "api_key_id", current.ID, "user_id", user.ID)
+
+	h.markPromptSaved(msg.Chat.ID, state, rotated.Key)
+	h.send(msg.Chat.ID, "✅ Ключ заменен. Задачи продолжат работать с новым ключом.\n"+keyPermissionsText(keyInfo))
+	if !rotated.ExpiresAt.IsZero() {
+		h.send(msg.Chat.ID, fmt.Sprintf("⏳ Ключ действует до %s. Бот напомнит заранее, чтобы вы успели его заменить.",
+			rotated.ExpiresAt.Format("02.01.2006")))
+	}
+	h.send(msg.Chat.ID, h.accountDiagnosis(ctx, rotated))
+}
+
 // --- UI Helpers ---
 
 func (h *Handler) showMainMenu(ctx context.Context, chatID int64, telegramID int64) {
@@ -322,13 +675,24 @@ func (h *Handler) showMainMenu(ctx context.Context, chatID int64, telegramID int
 				tgbotapi.NewKeyboardButton(BtnAdd),
 				tgbotapi.NewKeyboardButton(BtnStatus),
 			))
-			// Можно добавить кнопку "Настройки" или "Обновить ключи"
+			rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+				tgbotapi.NewKeyboardButton(BtnBalance),
+				tgbotapi.NewKeyboardButton(BtnPnL),
+			))
+			rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+				tgbotapi.NewKeyboardButton(BtnKeys),
+				tgbotapi.NewKeyboardButton(BtnRotateKey),
+			))
 		}
 	}
 
 	msg := tgbotapi.NewMessage(chatID, "Меню:")
 	msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(rows...)
 	h.bot.Send(msg)
+
+	h.mu.Lock()
+	delete(h.cancelMenu, telegramID)
+	h.mu.Unlock()
 }
 
 // Остальные методы (cmdStatus, cmdAdd, processTrigger и т.д.) остаются почти без изменений,
@@ -345,88 +709,807 @@ func (h *Handler) cmdStatus(ctx context.Context, msg *tgbotapi.Message) {
 		return
 	}
 
-	// Получаем задачи пользователя
-	tasks, err := h.taskRepo.GetActiveTasksByUserID(ctx, user.ID)
+	text, keyboard, err := h.renderTaskPage(ctx, user.ID, taskListActive, 0)
 	if err != nil {
 		h.logger.Error("Failed to fetch user tasks", "err", err)
 		h.send(msg.Chat.ID, "Ошибка получения списка задач.")
 		return
 	}
 
-	if len(tasks) == 0 {
-		h.send(msg.Chat.ID, "📭 У вас нет активных задач.")
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ParseMode = "Markdown"
+	reply.ReplyMarkup = keyboard
+	h.bot.Send(reply)
+}
+
+// Списки задач в /status и размер страницы: карточка с ошибкой занимает до нескольких сотен
+// символов, а сообщение Telegram ограничено 4096
+const (
+	taskListActive  = "active"
+	taskListHistory = "history"
+	statusPageSize  = 5
+)
+
+// showTaskPage листает /status: callback "tasks:<список>:<смещение>" перерисовывает то же сообщение
+func (h *Handler) showTaskPage(ctx context.Context, cb *tgbotapi.CallbackQuery, data string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+
+	list, rawOffset, _ := strings.Cut(data, ":")
+	offset, err := strconv.Atoi(rawOffset)
+	if err != nil || offset < 0 {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(cb.Message.Chat.ID, "Ошибка получения профиля.")
 		return
 	}
 
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("📊 **Ваши активные задачи (%d):**\n\n", len(tasks)))
+	text, keyboard, err := h.renderTaskPage(ctx, user.ID, list, offset)
+	if err != nil {
+		h.logger.Error("Failed to fetch user tasks", "err", err)
+		h.send(cb.Message.Chat.ID, "Ошибка получения списка задач.")
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(cb.Message.Chat.ID, cb.Message.MessageID, text, keyboard)
+	edit.ParseMode = "Markdown"
+	h.bot.Send(edit)
+}
+
+// renderTaskPage - текст и кнопки страницы списка задач (активные или история)
+func (h *Handler) renderTaskPage(ctx context.Context, userID int64, list string, offset int) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	filter := domain.TaskFilter{States: domain.ActiveTaskStates, Order: domain.TaskOrderNewest}
+	if list == taskListHistory {
+		filter = domain.TaskFilter{States: domain.HistoryTaskStates, Order: domain.TaskOrderUpdated}
+	} else {
+		list = taskListActive
+	}
+
+	// Лишняя задача показывает, есть ли следующая страница
+	tasks, err := h.taskRepo.ListTasks(ctx, userID, filter, statusPageSize+1, offset)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	hasNext := len(tasks) > statusPageSize
+	if hasNext {
+		tasks = tasks[:statusPageSize]
+	}
 
+	var sb strings.Builder
+	page := offset/statusPageSize + 1
+	switch {
+	case len(tasks) == 0 && list == taskListHistory:
+		sb.WriteString("📭 История задач пуста.")
+	case len(tasks) == 0:
+		sb.WriteString("📭 У вас нет активных задач.")
+	case list == taskListHistory:
+		sb.WriteString(fmt.Sprintf("📜 **История задач** (стр. %d):\n\n", page))
+	default:
+		sb.WriteString(fmt.Sprintf("📊 **Ваши активные задачи** (стр. %d):\n\n", page))
+	}
+	// Позиции на бирже - только у активного списка: в истории задачи уже не стоят на позициях
+	var positions taskPositions
+	if list == taskListActive {
+		positions = h.loadTaskPositions(ctx, tasks)
+	}
 	for _, t := range tasks {
-		// Иконка статуса
-		statusIcon := "🟢"
-		if t.Status == domain.TaskStateFailed {
-			statusIcon = "🔴"
-		} else if t.Status != domain.TaskStateIdle {
-			statusIcon = "🔄" // В процессе роллирования
+		h.writeTaskCard(&sb, t, positions)
+	}
+	if len(tasks) > 0 && list == taskListActive {
+		sb.WriteString("Алерт по премии опциона: /premium <номер задачи> <порог>, 0 - выключить")
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if list == taskListActive {
+		rows = buildTaskKeyboard(tasks, offset)
+	}
+	var nav []tgbotapi.InlineKeyboardButton
+	if offset > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", taskPageCallback(list, max(offset-statusPageSize, 0))))
+	}
+	if hasNext {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("Вперед ▶️", taskPageCallback(list, offset+statusPageSize)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	if list == taskListHistory {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("📊 Активные", taskPageCallback(taskListActive, 0))))
+	} else {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("📜 История", taskPageCallback(taskListHistory, 0))))
+	}
+
+	return sb.String(), tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+func taskPageCallback(list string, offset int) string {
+	return mustCallback(callbackTasks, list, strconv.Itoa(offset))
+}
+
+// writeTaskCard - карточка задачи; текущие цены только у задач, за которыми бот следит
+// formatAgo - грубая длительность для карточки задачи: "5 мин", "2 ч", "3 дн"
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d мин", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d ч", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d дн", int(d.Hours()/24))
+	}
+}
+
+func (h *Handler) writeTaskCard(sb *strings.Builder, t domain.Task, positions taskPositions) {
+	statusIcon := "🟢"
+	switch t.Status {
+	case domain.TaskStateCompleted:
+		statusIcon = "✅"
+	case domain.TaskStateFailed:
+		statusIcon = "🔴"
+	case domain.TaskStatePaused:
+		statusIcon = "⏸"
+	case domain.TaskStateIdle:
+	default:
+		statusIcon = "🔄" // В процессе роллирования
+	}
+
+	sb.WriteString(fmt.Sprintf("%s **%s** (#%d)\n", statusIcon, t.CurrentOptionSymbol, t.ID))
+	if t.Label != "" {
+		sb.WriteString(fmt.Sprintf("├ 🏷 %s\n", t.Label))
+	}
+	sb.WriteString(fmt.Sprintf("├ 🎯 Триггер (%s): `%s`\n", h.priceSource.Label(), t.TriggerPrice.String()))
+	if t.IsActive() {
+		if price, at, ok := h.manager.UnderlyingPrice(t); ok {
+			if age := time.Since(at); age > lastPriceMaxAge {
+				sb.WriteString(fmt.Sprintf("├ 📈 Цена сейчас: `%s` (устарела, %s назад)\n", price.String(), age.Round(time.Second)))
+			} else {
+				sb.WriteString(fmt.Sprintf("├ 📈 Цена сейчас: `%s`\n", price.String()))
+			}
 		}
+		if quote, ok := h.manager.OptionPremium(t); ok {
+			sb.WriteString(fmt.Sprintf("├ 💎 Премия сейчас: `%s`\n", quote.Price.String()))
+		}
+	}
+	if t.PremiumAlertThreshold.IsPositive() {
+		sb.WriteString(fmt.Sprintf("├ 🔔 Алерт премии: `%s`\n", t.PremiumAlertThreshold.String()))
+	}
+	sb.WriteString(fmt.Sprintf("├ 📦 Объем: `%s`\n", t.CurrentQty.String()))
+	positions.writeTaskPosition(sb, t)
+	if t.LastTriggeredAt != nil {
+		sb.WriteString(fmt.Sprintf("├ 🔁 Последний ролл: %s назад, всего роллов: %d\n",
+			formatAgo(time.Since(*t.LastTriggeredAt)), t.RollCount))
+	}
+	if t.Status == domain.TaskStateCompleted || t.Status == domain.TaskStateFailed {
+		sb.WriteString(fmt.Sprintf("├ 🕓 Изменена: %s UTC\n", t.UpdatedAt.UTC().Format("02.01.2006 15:04")))
+	}
+	sb.WriteString(fmt.Sprintf("└ ⚙️ Статус: `%s`\n", t.Status))
 
-		// Формируем карточку задачи
-		sb.WriteString(fmt.Sprintf("%s **%s**\n", statusIcon, t.CurrentOptionSymbol))
-		sb.WriteString(fmt.Sprintf("├ 🎯 Триггер (Index): `%s`\n", t.TriggerPrice.String()))
-		sb.WriteString(fmt.Sprintf("├ 📦 Объем: `%s`\n", t.CurrentQty.String()))
-		sb.WriteString(fmt.Sprintf("└ ⚙️ Статус: `%s`\n", t.Status))
-		
-		if t.LastError != "" {
-			sb.WriteString(fmt.Sprintf("⚠️ Ошибка: %s\n", t.LastError))
+	if t.LastError != "" {
+		sb.WriteString(fmt.Sprintf("⚠️ Ошибка: %s\n", t.LastError))
+	}
+	sb.WriteString("\n")
+}
+
+// buildTaskKeyboard - кнопки управления задачами; задачи посреди ролла без кнопок
+// buildTaskKeyboard - строка кнопок на задачу страницы /status. Задаче посреди ролла доступна только "ℹ️".
+func buildTaskKeyboard(tasks []domain.Task, offset int) [][]tgbotapi.InlineKeyboardButton {
+	view := taskView{offset: offset}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, t := range tasks {
+		details := tgbotapi.NewInlineKeyboardButtonData("ℹ️", taskCallback(callbackTaskDetails, t.ID, view))
+		row := taskActionRow(t, view)
+		if row == nil {
+			details.Text = fmt.Sprintf("ℹ️ #%d", t.ID)
 		}
-		sb.WriteString("\n")
+		rows = append(rows, append(row, details))
 	}
+	return rows
+}
 
-	h.send(msg.Chat.ID, sb.String())
+// cmdPremiumAlert: "/premium 12 150" - предупредить, когда mark price опциона задачи 12 достигнет 150
+func (h *Handler) cmdPremiumAlert(ctx context.Context, msg *tgbotapi.Message) {
+	if !h.checkSubscription(ctx, msg) {
+		return
+	}
+
+	parts := strings.Fields(msg.CommandArguments())
+	if len(parts) != 2 {
+		h.send(msg.Chat.ID, "Формат: /premium <номер задачи> <порог премии>, 0 - выключить алерт.")
+		return
+	}
+	taskID, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "#"), 10, 64)
+	if err != nil {
+		h.send(msg.Chat.ID, "❌ Неверный номер задачи.")
+		return
+	}
+	threshold, err := decimal.NewFromString(parts[1])
+	if err != nil || threshold.IsNegative() {
+		h.send(msg.Chat.ID, "❌ Порог должен быть неотрицательным числом.")
+		return
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	task, err := h.taskRepo.GetTaskByID(ctx, taskID)
+	if err != nil || task == nil || task.UserID != user.ID {
+		h.send(msg.Chat.ID, "❌ Задача не найдена.")
+		return
+	}
+
+	if err := h.taskRepo.UpdatePremiumAlert(ctx, task.ID, threshold); err != nil {
+		h.logger.Error("Failed to update premium alert", "task_id", task.ID, "err", err)
+		h.send(msg.Chat.ID, "Ошибка сохранения алерта.")
+		return
+	}
+	if err := h.manager.ReloadTasks(ctx); err != nil {
+		h.logger.Error("Failed to reload tasks manager", "err", err)
+	}
+
+	if threshold.IsZero() {
+		h.send(msg.Chat.ID, fmt.Sprintf("🔕 Алерт премии для %s выключен.", task.CurrentOptionSymbol))
+		return
+	}
+	h.send(msg.Chat.ID, fmt.Sprintf("🔔 Сообщу, когда премия %s достигнет `%s`.", task.CurrentOptionSymbol, threshold.String()))
 }
 
 func (h *Handler) cmdAdd(ctx context.Context, msg *tgbotapi.Message) {
-    if !h.checkSubscription(ctx, msg) { return }
-    
-    // ... Логика получения позиций ...
-    // ВАЖНО: Вставь сюда логику cmdAdd из старого файла
-    // Но замени h.exchange.GetPositions(...) вызов
-    
-    user, _ := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
-    apiKey, _ := h.keyRepo.GetActiveByUserID(ctx, user.ID)
-    
-    positions, err := h.exchange.GetPositions(ctx, *apiKey)
-    if err != nil {
-        h.send(msg.Chat.ID, "Ошибка получения позиций с биржи: "+err.Error())
-        return
-    }
-    
-    if len(positions) == 0 {
+	if !h.checkSubscription(ctx, msg) {
+		return
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	apiKey, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil || apiKey == nil {
+		h.sendNoActiveKey(msg.Chat.ID, err)
+		return
+	}
+
+	positions, err := h.positions(ctx, *apiKey)
+	if err != nil {
+		h.logger.Error("Failed to fetch positions", "user_id", user.ID, "api_key_id", apiKey.ID, "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения позиций с биржи: "+escapeMarkdown(err.Error()))
+		return
+	}
+
+	if len(positions) == 0 {
 		h.send(msg.Chat.ID, "Нет открытых опционных позиций.")
 		return
 	}
 
-    keyboard := h.buildPositionKeyboard(positions)
-	reply := tgbotapi.NewMessage(msg.Chat.ID, "Выберите позицию для роллирования:")
+	// Страницы листаются по позициям из состояния, без повторного запроса к бирже
+	h.setState(msg.From.ID, &UserState{Step: "awaiting_position", Positions: positions})
+
+	text, keyboard := h.renderPositionPage(positions, 0)
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ParseMode = "Markdown"
 	reply.ReplyMarkup = keyboard
 	h.bot.Send(reply)
 }
 
-// Helpers для callback и state machine остаются теми же
-// ... (handleCallback, processTrigger, processStep из старого файла) ...
+func (h *Handler) cmdBalance(ctx context.Context, msg *tgbotapi.Message) {
+	if !h.checkSubscription(ctx, msg) {
+		return
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+
+	apiKey, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil || apiKey == nil {
+		h.sendNoActiveKey(msg.Chat.ID, err)
+		return
+	}
+
+	balanceCtx, cancel := context.WithTimeout(ctx, balanceTimeout)
+	margin, err := h.exchange.GetMarginInfo(balanceCtx, *apiKey)
+	cancel()
+	if err != nil {
+		h.logger.Error("Failed to fetch margin info", "user_id", user.ID, "api_key_id", apiKey.ID, "err", err)
+		h.send(msg.Chat.ID, balanceErrorText(err))
+		return
+	}
+
+	// Копейки в долларах и сотые доли процента только мешают: отбрасываем, не округляя вверх
+	mmrPercent := margin.MMR.Mul(decimal.NewFromInt(100))
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("💰 **Баланс (Unified, ключ #%d):**\n\n", apiKey.ID))
+	sb.WriteString(fmt.Sprintf("├ Equity: `%s` USD\n", margin.TotalEquity.Truncate(2).StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("├ Маржинальный баланс: `%s` USD\n", margin.TotalMarginBalance.Truncate(2).StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("├ Доступно: `%s` USD\n", margin.AvailableBalance.Truncate(2).StringFixed(2)))
+	if margin.MMR.GreaterThanOrEqual(h.mmrWarn) {
+		sb.WriteString(fmt.Sprintf("└ ⚠️ MMR: `%s%%` - выше порога %s%%, аккаунт ближе к ликвидации\n",
+			mmrPercent.Truncate(2).StringFixed(2), h.mmrWarn.Mul(decimal.NewFromInt(100)).String()))
+	} else {
+		sb.WriteString(fmt.Sprintf("└ MMR: `%s%%`\n", mmrPercent.Truncate(2).StringFixed(2)))
+	}
+
+	h.send(msg.Chat.ID, sb.String())
+}
+
+// Баланс запрашивается, пока пользователь ждет ответа
+const balanceTimeout = 10 * time.Second
+
+// balanceErrorText - причина, по которой баланс не получен, без сырого ответа биржи
+func balanceErrorText(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, domain.ErrExchangeTimeout),
+		errors.Is(err, domain.ErrExchangeUnavailable), errors.Is(err, domain.ErrRateLimited):
+		return "⌛ Bybit сейчас не отвечает. Попробуйте через минуту."
+	case errors.Is(err, domain.ErrInvalidCredentials):
+		return "❌ Bybit не принял API ключ. Замените его кнопкой '" + BtnRotateKey + "'."
+	case errors.Is(err, domain.ErrAccountNotReady):
+		return "⚠️ Ключ не может читать баланс: нужен аккаунт Unified Trading и ключ с правами на аккаунт."
+	}
+	return "❌ Не удалось получить баланс с биржи. Попробуйте позже."
+}
+
+// cmdPnL: реализованный PnL с биржи по символам. Период 7 дней, "/pnl 30" - за 30 дней.
+// Сколько последних ордеров выгружает /orders
+const ordersExportLimit = 500
+
+// cmdOrders выгружает журнал ордеров пользователя в CSV
+func (h *Handler) cmdOrders(ctx context.Context, msg *tgbotapi.Message) {
+	if !h.checkSubscription(ctx, msg) {
+		return
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+
+	records, err := h.orders.ListByUserID(ctx, user.ID, ordersExportLimit)
+	if err != nil {
+		h.logger.Error("Failed to fetch order journal", "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения истории ордеров.")
+		return
+	}
+	if len(records) == 0 {
+		h.send(msg.Chat.ID, "📭 Бот еще не отправлял ордеров по вашим задачам.")
+		return
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"created_at", "task_id", "order_link_id", "symbol", "side", "type", "tif", "reduce_only",
+		"qty", "price", "status", "cum_exec_qty", "avg_price", "exchange_order_id", "error"})
+	for _, o := range records {
+		w.Write([]string{
+			o.CreatedAt.UTC().Format(time.RFC3339), strconv.FormatInt(o.TaskID, 10), o.OrderLinkID, o.Symbol,
+			o.Side, o.OrderType, o.TimeInForce, strconv.FormatBool(o.ReduceOnly), o.Qty.String(), o.Price.String(),
+			o.Status, o.CumExecQty.String(), o.AvgPrice.String(), o.ExchangeOrderID, o.Error,
+		})
+	}
+	w.Flush()
+
+	doc := tgbotapi.NewDocument(msg.Chat.ID, tgbotapi.FileBytes{Name: "orders.csv", Bytes: buf.Bytes()})
+	doc.Caption = fmt.Sprintf("📒 Ордера бота: %d последних", len(records))
+	if _, err := h.bot.Send(doc); err != nil {
+		h.logger.Error("Failed to send order export", "err", err)
+	}
+}
 
-func (h *Handler) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery) {
-	symbol := cb.Data
+func (h *Handler) cmdPnL(ctx context.Context, msg *tgbotapi.Message) {
+	if !h.checkSubscription(ctx, msg) {
+		return
+	}
+
+	days := 7
+	if msg.IsCommand() && strings.TrimSpace(msg.CommandArguments()) == "30" {
+		days = 30
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+
+	apiKey, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil || apiKey == nil {
+		h.sendNoActiveKey(msg.Chat.ID, err)
+		return
+	}
+
+	end := time.Now()
+	records, err := h.exchange.GetClosedPnL(ctx, *apiKey, end.AddDate(0, 0, -days), end)
+	if err != nil {
+		h.logger.Error("Failed to fetch closed pnl", "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения PnL с биржи.")
+		return
+	}
+
+	if len(records) == 0 {
+		h.send(msg.Chat.ID, fmt.Sprintf("📈 За %d дн. закрытых позиций нет.", days))
+		return
+	}
+
+	summaries, total := domain.SummarizeClosedPnL(records)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📈 **Реализованный PnL за %d дн.:**\n\n", days))
+	for _, s := range summaries {
+		icon := "🟢"
+		if s.PnL.IsNegative() {
+			icon = "🔴"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s: `%s` (%d)\n", icon, s.Symbol, s.PnL.StringFixed(2), s.Trades))
+	}
+	sb.WriteString(fmt.Sprintf("\n**Итого:** `%s USDT`\n", total.StringFixed(2)))
+	if days == 7 {
+		sb.WriteString("\nЗа 30 дней: /pnl 30")
+	}
+
+	h.send(msg.Chat.ID, sb.String())
+}
+
+// keyValidationMessage объясняет, почему ключ не принят
+func (h *Handler) keyValidationMessage(err error) string {
+	var perms *usecase.KeyPermissionError
+	if errors.As(err, &perms) {
+		var sb strings.Builder
+		sb.WriteString("❌ Ключу не хватает прав:\n")
+		for _, p := range perms.Missing {
+			sb.WriteString("• " + p + "\n")
+		}
+		sb.WriteString("Создайте ключ Read-Write с правом Options → OptionsTrade и отправьте его снова.")
+		return sb.String()
+	}
+
+	h.logger.Warn("API key validation failed", "err", err)
+	switch {
+	case errors.Is(err, domain.ErrInvalidCredentials):
+		return "❌ Bybit не принял ключ. Проверьте ключ, секрет и сеть (`mainnet`, `testnet`, `demo`) и отправьте снова.\n" +
+			"Ответ Bybit: " + escapeMarkdown(err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, domain.ErrExchangeTimeout):
+		return fmt.Sprintf("⌛ Bybit не ответил за %d с. Отправьте ключи еще раз.", int(keyValidationTimeout.Seconds()))
+	}
+	return "❌ Не удалось проверить ключ на Bybit. Проверьте ключ, секрет и сеть и отправьте снова.\nОшибка: " + escapeMarkdown(err.Error())
+}
+
+// accountDiagnosis проверяет аккаунт ключа и объясняет, что исправить в настройках Bybit
+func (h *Handler) accountDiagnosis(ctx context.Context, apiKey domain.APIKey) string {
+	ctx, cancel := context.WithTimeout(ctx, keyValidationTimeout)
+	defer cancel()
+	err := usecase.ValidateAccount(ctx, h.exchange, apiKey)
+	if err == nil {
+		return "✅ Аккаунт Unified Trading, опционы доступны."
+	}
+
+	var notReady *usecase.AccountNotReadyError
+	if errors.As(err, &notReady) {
+		var sb strings.Builder
+		sb.WriteString("⚠️ Аккаунт не готов к торговле опционами:\n")
+		for _, issue := range notReady.Issues {
+			switch issue {
+			case domain.AccountIssueNotUnified:
+				sb.WriteString("• Аккаунт не Unified Trading. Обновите его до UTA в настройках Bybit.\n")
+			case domain.AccountIssueIsolatedMargin:
+				sb.WriteString("• Включена изолированная маржа. Переключите на Cross (Regular) или Portfolio Margin.\n")
+			}
+		}
+		return sb.String()
+	}
+
+	if errors.Is(err, domain.ErrAccountNotReady) {
+		return "⚠️ У ключа нет нужных прав. Создайте ключ с правами Read-Write на ордера и позиции (Unified Trading)."
+	}
+
+	h.logger.Warn("Account validation failed", "err", err)
+	return "⚠️ Не удалось проверить аккаунт: " + escapeMarkdown(err.Error())
+}
+
+// selectPosition: выбор позиции в /add, дальше - цена триггера
+func (h *Handler) selectPosition(ctx context.Context, cb *tgbotapi.CallbackQuery, symbol string) {
 	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
 
-	h.mu.Lock()
-	h.states[cb.From.ID] = &UserState{
+	h.setState(cb.From.ID, &UserState{
 		Step:       "awaiting_trigger",
 		TempSymbol: symbol,
+	})
+
+	h.ask(cb.Message.Chat.ID, cb.From.ID, fmt.Sprintf("Выбрано: %s\nВведите цену триггера (%s):", symbol, h.priceSource.Label()))
+}
+
+// toggleTaskPause ставит задачу на паузу или возобновляет ее. Перед возобновлением проверяем,
+// не экспирировал ли опцион, пока задача стояла: такую задачу сразу закрываем.
+func (h *Handler) toggleTaskPause(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string, pause bool) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	chatID := cb.Message.Chat.ID
+
+	taskID, view, err := parseTaskCallback(rawID)
+	if err != nil {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(chatID, "Ошибка получения профиля.")
+		return
+	}
+	task, err := h.taskRepo.GetTaskByID(ctx, taskID)
+	if err != nil || task == nil || task.UserID != user.ID {
+		h.send(chatID, "❌ Задача не найдена.")
+		return
 	}
+	// Итог действия - первой строкой перерисованного сообщения, рядом с новым состоянием задачи
+	done := func(notice string) {
+		h.refreshTaskView(ctx, cb, user.ID, task.ID, view, notice)
+	}
+
+	if pause {
+		if task.Status != domain.TaskStateIdle {
+			done(fmt.Sprintf("⚠️ Задачу #%d сейчас нельзя приостановить (статус `%s`).", task.ID, task.Status))
+			return
+		}
+		err := h.taskRepo.WithOptimisticRetry(ctx, task.ID, taskEditAttempts, func(fresh *domain.Task) error {
+			if fresh.Status != domain.TaskStateIdle {
+				return fmt.Errorf("task %d is %s: %w", fresh.ID, fresh.Status, errTaskStateChanged)
+			}
+			return h.taskRepo.PauseTask(ctx, fresh.ID, fresh.Version)
+		})
+		if errors.Is(err, errTaskStateChanged) {
+			done(fmt.Sprintf("⚠️ Задачу #%d сейчас нельзя приостановить: ее статус изменился.", task.ID))
+			return
+		}
+		if err != nil {
+			h.logger.Warn("Failed to pause task", "task_id", task.ID, "err", err)
+			done("Не удалось приостановить задачу, попробуйте еще раз.")
+			return
+		}
+		h.reloadManager(ctx)
+		done(fmt.Sprintf("⏸ Задача #%d (%s) приостановлена.", task.ID, task.CurrentOptionSymbol))
+		return
+	}
+
+	if task.Status != domain.TaskStatePaused {
+		done(fmt.Sprintf("Задача #%d не на паузе.", task.ID))
+		return
+	}
+	if !user.HasAccess(time.Now()) {
+		done("Подписка не активна.")
+		return
+	}
+	// Задачу ставили на паузу вместе с ключом: без рабочего ключа она упадет на первом ролле
+	if key, err := h.keyRepo.GetByID(ctx, task.APIKeyID); err == nil && (key == nil || !key.IsValid) {
+		done(fmt.Sprintf("⛔ Ключ задачи #%d выключен. Добавьте новый ключ той же сети, и задача перейдет на него.", task.ID))
+		return
+	}
+	if expiry, err := domain.ParseExpirationFromSymbol(task.CurrentOptionSymbol); err == nil && time.Now().After(expiry) {
+		if err := h.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateCompleted, task.Version); err != nil {
+			h.logger.Warn("Failed to close expired paused task", "task_id", task.ID, "err", err)
+		}
+		done(fmt.Sprintf("⌛ Опцион %s экспирировал, пока задача стояла на паузе. Задача #%d закрыта.", task.CurrentOptionSymbol, task.ID))
+		return
+	}
+	err = h.taskRepo.WithOptimisticRetry(ctx, task.ID, taskEditAttempts, func(fresh *domain.Task) error {
+		if fresh.Status != domain.TaskStatePaused {
+			return fmt.Errorf("task %d is %s: %w", fresh.ID, fresh.Status, errTaskStateChanged)
+		}
+		return h.taskRepo.ResumeTask(ctx, fresh.ID, fresh.Version)
+	})
+	if errors.Is(err, errTaskStateChanged) {
+		done(fmt.Sprintf("Задача #%d уже не на паузе.", task.ID))
+		return
+	}
+	if err != nil {
+		h.logger.Warn("Failed to resume task", "task_id", task.ID, "err", err)
+		done("Не удалось возобновить задачу, попробуйте еще раз.")
+		return
+	}
+	h.reloadManager(ctx)
+	done(fmt.Sprintf("▶️ Задача #%d (%s) снова отслеживается.", task.ID, task.CurrentOptionSymbol))
+}
+
+// askDeleteTask - шаг подтверждения: удаление необратимо для пользователя. Вопрос заменяет
+// список или карточку, "Отмена" возвращает их.
+func (h *Handler) askDeleteTask(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+
+	taskID, view, err := parseTaskCallback(rawID)
+	if err != nil {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(cb.Message.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	task, err := h.taskRepo.GetTaskByID(ctx, taskID)
+	if err != nil || task == nil || task.UserID != user.ID {
+		h.send(cb.Message.Chat.ID, "❌ Задача не найдена.")
+		return
+	}
+
+	back := taskPageCallback(taskListActive, view.offset)
+	if view.details {
+		back = taskCallback(callbackTaskDetails, task.ID, view)
+	}
+	edit := tgbotapi.NewEditMessageTextAndMarkup(cb.Message.Chat.ID, cb.Message.MessageID,
+		fmt.Sprintf("Удалить задачу #%d (%s, триггер `%s`)? Позиция на бирже останется, бот перестанет ее роллировать.",
+			task.ID, task.CurrentOptionSymbol, task.TriggerPrice.String()),
+		tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Да, удалить", taskCallback(callbackDeleteConfirm, task.ID, view)),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", back),
+		)))
+	edit.ParseMode = "Markdown"
+	h.bot.Send(edit)
+}
+
+// deleteTask удаляет задачу и возвращает на место вопроса список задач (карточки удаленной задачи нет)
+func (h *Handler) deleteTask(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	chatID := cb.Message.Chat.ID
+
+	taskID, view, err := parseTaskCallback(rawID)
+	if err != nil {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(chatID, "Ошибка получения профиля.")
+		return
+	}
+
+	err = h.taskRepo.DeleteTask(ctx, taskID, user.ID)
+	switch {
+	case errors.Is(err, domain.ErrTaskNotFound):
+		h.refreshTaskView(ctx, cb, user.ID, taskID, view, "❌ Задача не найдена.")
+		return
+	case errors.Is(err, domain.ErrTaskMidRoll):
+		h.refreshTaskView(ctx, cb, user.ID, taskID, view,
+			fmt.Sprintf("⏳ Задача #%d сейчас роллируется. Удалить ее можно после завершения ролла.", taskID))
+		return
+	case err != nil:
+		h.logger.Error("Failed to delete task", "task_id", taskID, "err", err)
+		h.refreshTaskView(ctx, cb, user.ID, taskID, view, "Ошибка удаления задачи.")
+		return
+	}
+
+	h.logger.Info("Task deleted by user", "task_id", taskID, "user_id", user.ID)
+	h.reloadManager(ctx)
+	view.details = false
+	h.refreshTaskView(ctx, cb, user.ID, taskID, view, fmt.Sprintf("🗑 Задача #%d удалена.", taskID))
+}
+
+// Попытки сохранить правку пользователя при конфликте версии (см. WithOptimisticRetry)
+const taskEditAttempts = 3
+
+// errTaskStateChanged - задача ушла из статуса, в котором возможна операция, пока ее повторяли
+var errTaskStateChanged = errors.New("task state changed")
+
+// startEditTask начинает правку триггера и шага: "✏️" в /status -> awaiting_edit_trigger -> awaiting_edit_step
+func (h *Handler) startEditTask(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	chatID := cb.Message.Chat.ID
+
+	taskID, _, err := parseTaskCallback(rawID)
+	if err != nil {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(chatID, "Ошибка получения профиля.")
+		return
+	}
+	task, err := h.taskRepo.GetTaskByID(ctx, taskID)
+	if err != nil || task == nil || task.UserID != user.ID {
+		h.send(chatID, "❌ Задача не найдена.")
+		return
+	}
+	if task.IsMidRoll() {
+		h.send(chatID, fmt.Sprintf("⏳ Задача #%d сейчас роллируется. Изменить ее можно после завершения ролла.", task.ID))
+		return
+	}
+
+	h.setState(cb.From.ID, &UserState{
+		Step:       "awaiting_edit_trigger",
+		TempTaskID: task.ID,
+	})
+
+	h.ask(chatID, cb.From.ID, fmt.Sprintf("✏️ Задача #%d (%s)\nТекущий триггер: `%s`\nВведите новую цену триггера (%s) или `-`, чтобы оставить:",
+		task.ID, task.CurrentOptionSymbol, task.TriggerPrice.String(), h.priceSource.Label()))
+}
+
+func (h *Handler) processEditTrigger(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
+	task, err := h.taskRepo.GetTaskByID(ctx, state.TempTaskID)
+	if err != nil || task == nil {
+		h.cancelState(msg.From.ID)
+		h.send(msg.Chat.ID, "❌ Задача не найдена.")
+		return
+	}
+
+	trigger := task.TriggerPrice
+	if text := strings.TrimSpace(msg.Text); text != "-" {
+		trigger, err = decimal.NewFromString(text)
+		if err != nil || !trigger.IsPositive() {
+			h.send(msg.Chat.ID, "Неверная цена. Введите положительное число или `-`.")
+			return
+		}
+	}
+
+	// Триггер уже пробит - ролл запустится сразу после сохранения. Не запрещаем, но предупреждаем.
+	if price, _, ok := h.manager.UnderlyingPrice(*task); ok && task.TriggerBreached(trigger, price) {
+		h.send(msg.Chat.ID, fmt.Sprintf("⚠️ Цена сейчас `%s` уже за триггером `%s`: ролл начнется сразу после сохранения.",
+			price.String(), trigger.String()))
+	}
+
+	h.mu.Lock()
+	state.TempPrice = trigger.String()
+	state.Step = "awaiting_edit_step"
 	h.mu.Unlock()
 
-	h.send(cb.Message.Chat.ID, fmt.Sprintf("Выбрано: %s\nВведите цену триггера (Index Price):", symbol))
+	h.send(msg.Chat.ID, fmt.Sprintf("Текущий шаг страйка: `%s`\nВведите новый шаг или `-`, чтобы оставить:", task.NextStrikeStep.String()))
+}
+
+func (h *Handler) processEditStep(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
+	task, err := h.taskRepo.GetTaskByID(ctx, state.TempTaskID)
+	if err != nil || task == nil {
+		h.cancelState(msg.From.ID)
+		h.send(msg.Chat.ID, "❌ Задача не найдена.")
+		return
+	}
+
+	step := task.NextStrikeStep
+	if text := strings.TrimSpace(msg.Text); text != "-" {
+		step, err = decimal.NewFromString(text)
+		if err != nil || !step.IsPositive() {
+			h.send(msg.Chat.ID, "Неверный шаг. Введите положительное число или `-`.")
+			return
+		}
+	}
+	trigger, _ := decimal.NewFromString(state.TempPrice)
+	h.cancelState(msg.From.ID)
+
+	// Правка параметров не конфликтует по смыслу с тем, что могло поменять задачу, пока пользователь
+	// вводил значения, поэтому при конфликте версии сохраняем поверх свежей копии
+	err = h.taskRepo.WithOptimisticRetry(ctx, task.ID, taskEditAttempts, func(fresh *domain.Task) error {
+		return h.taskRepo.UpdateTaskParams(ctx, fresh.ID, trigger, step, fresh.Version)
+	})
+	switch {
+	case errors.Is(err, domain.ErrTaskNotFound):
+		h.send(msg.Chat.ID, "❌ Задача не найдена.")
+		return
+	case errors.Is(err, domain.ErrTaskMidRoll):
+		h.send(msg.Chat.ID, fmt.Sprintf("⏳ Задача #%d начала роллироваться, изменения не сохранены.", task.ID))
+		return
+	case err != nil:
+		h.logger.Warn("Failed to update task params", "task_id", task.ID, "err", err)
+		h.send(msg.Chat.ID, "Не удалось сохранить изменения, попробуйте еще раз.")
+		return
+	}
+
+	h.logger.Info("Task params updated by user", "task_id", task.ID,
+		"trigger", trigger.String(), "step", step.String())
+	h.reloadManager(ctx)
+	h.send(msg.Chat.ID, fmt.Sprintf("✅ Задача #%d: триггер `%s`, шаг `%s`.", task.ID, trigger.String(), step.String()))
+}
+
+// cancelState закрывает мастер пользователя; false - мастера не было
+func (h *Handler) cancelState(telegramID int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, active := h.states[telegramID]
+	delete(h.states, telegramID)
+	delete(h.expiredStates, telegramID)
+	return active
+}
+
+func (h *Handler) reloadManager(ctx context.Context) {
+	if err := h.manager.ReloadTasks(ctx); err != nil {
+		h.logger.Error("Failed to reload tasks manager", "err", err)
+	}
 }
 
 func (h *Handler) processTrigger(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
@@ -460,34 +1543,46 @@ func (h *Handler) processStep(ctx context.Context, msg *tgbotapi.Message, state
 	}
 
 	// 2. Нормализуем тикер для Linear Stream (добавляем USDT)
-	underlying := sym.BaseCoin
-	if !strings.HasSuffix(underlying, "USDT") {
-		underlying += "USDT"
-	}
+	underlying := sym.Underlying()
 
 	// 3. Подготовка данных (ПОЛУЧАЕМ РЕАЛЬНЫЙ ОБЪЕМ)
-	user, _ := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
-	apiKey, _ := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.cancelState(msg.From.ID)
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	apiKey, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil || apiKey == nil {
+		h.cancelState(msg.From.ID)
+		h.send(msg.Chat.ID, "❌ Нет активного API ключа: добавьте его в «🔑 Мои ключи».")
+		return
+	}
 	trigger, _ := decimal.NewFromString(state.TempPrice)
 
     // Запрашиваем позицию, чтобы узнать объем
     realQty := decimal.NewFromFloat(0.1) // Дефолт на случай ошибки
+    var side domain.Side                 // Пусто - роллер возьмет сторону из позиции
     if pos, err := h.exchange.GetPosition(ctx, *apiKey, state.TempSymbol); err == nil && !pos.Qty.IsZero() {
         realQty = pos.Qty
+        side = domain.Side(pos.Side)
     }
 
 	// 4. Создаем задачу
 	task := &domain.Task{
-		// ...
+		UserID:              user.ID,
+		APIKeyID:            apiKey.ID,
 		CurrentOptionSymbol: state.TempSymbol,
 		UnderlyingSymbol:    underlying,
 		TriggerPrice:        trigger,
 		NextStrikeStep:      step,
 		CurrentQty:          realQty, // <--- ИСПОЛЬЗУЕМ РЕАЛЬНЫЙ ОБЪЕМ
+		TargetSide:          side,
 		Status:              domain.TaskStateIdle,
 	}
 	
 	if err := h.taskRepo.CreateTask(ctx, task); err != nil {
+	    h.logger.Error("Failed to create task", "symbol", task.CurrentOptionSymbol, "err", err)
 	    h.send(msg.Chat.ID, "Ошибка создания задачи.")
 	    return
 	}
@@ -500,17 +1595,20 @@ func (h *Handler) processStep(ctx context.Context, msg *tgbotapi.Message, state
         }
     }()
 	
-	h.mu.Lock()
-    delete(h.states, msg.From.ID)
-    h.mu.Unlock()
+	// Название необязательно: следующим сообщением можно задать его или пропустить
+	h.setState(msg.From.ID, &UserState{Step: "awaiting_task_label", TempTaskID: task.ID})
     
-    h.send(msg.Chat.ID, "✅ Задача создана и мгновенно активирована!")
+    h.send(msg.Chat.ID, fmt.Sprintf("✅ Задача создана и мгновенно активирована!\n🏷 Введите название задачи (до %d символов) или `-`, чтобы пропустить:", taskLabelMaxLen))
 }
 
 
 func (h *Handler) checkSubscription(ctx context.Context, msg *tgbotapi.Message) bool {
     // ... (старая логика)
     user, _ := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+    if user != nil && user.IsBanned {
+        h.send(msg.Chat.ID, "⛔ Доступ заблокирован администратором.")
+        return false
+    }
     if user == nil || time.Now().After(user.ExpiresAt) {
         h.send(msg.Chat.ID, "Подписка не активна.")
         h.showMainMenu(ctx, msg.Chat.ID, msg.From.ID)
@@ -519,18 +1617,6 @@ func (h *Handler) checkSubscription(ctx context.Context, msg *tgbotapi.Message)
     return true
 }
 
-func (h *Handler) buildPositionKeyboard(positions []domain.Position) tgbotapi.InlineKeyboardMarkup {
-	var rows [][]tgbotapi.InlineKeyboardButton
-	for _, p := range positions {
-		btn := tgbotapi.NewInlineKeyboardButtonData(
-			fmt.Sprintf("%s (%s)", p.Symbol, p.Qty),
-			p.Symbol,
-		)
-		rows = append(rows, []tgbotapi.InlineKeyboardButton{btn})
-	}
-	return tgbotapi.NewInlineKeyboardMarkup(rows...)
-}
-
 func (h *Handler) send(chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "Markdown"
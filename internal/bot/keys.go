@@ -0,0 +1,496 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/usecase"
+)
+
+// Длина названия ключа; символы разметки Markdown в названии запрещены, иначе сломается список
+const (
+	keyLabelMaxLen   = 32
+	keyLabelBadChars = "*_`["
+)
+
+// Проверка ключа на бирже идет, пока пользователь ждет ответа бота
+const keyValidationTimeout = 10 * time.Second
+
+// validateKey проверяет новый ключ на Bybit до сохранения: опечатка в ключе или секрете
+// должна выясниться сейчас, а не на первом ролле
+func (h *Handler) validateKey(ctx context.Context, creds domain.APIKey) (domain.APIKeyInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, keyValidationTimeout)
+	defer cancel()
+	return usecase.ValidateAPIKey(ctx, h.exchange, creds)
+}
+
+// deleteCredentialsMessage убирает из чата сообщение с ключом и секретом: история переписки
+// остается на телефоне навсегда. Удаляем при любом исходе проверки ключа.
+func (h *Handler) deleteCredentialsMessage(msg *tgbotapi.Message) {
+	if _, err := h.bot.Request(tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)); err != nil {
+		h.logger.Warn("Failed to delete credentials message", "telegram_id", msg.From.ID, "err", err)
+		h.send(msg.Chat.ID, "⚠️ Не удалось удалить сообщение с ключами. Удалите его из чата вручную.")
+	}
+}
+
+// markPromptSaved заменяет вопрос о ключах маской сохраненного ключа
+func (h *Handler) markPromptSaved(chatID int64, state *UserState, key string) {
+	if state.PromptMessageID == 0 {
+		return
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, state.PromptMessageID, "🔒 Сохранен ключ "+maskKey(key))
+	if _, err := h.bot.Send(edit); err != nil {
+		h.logger.Warn("Failed to replace api key prompt", "err", err)
+	}
+}
+
+// keyPermissionsText - что биржа сообщила о ключе: режим, тип аккаунта и права по группам
+func keyPermissionsText(info domain.APIKeyInfo) string {
+	groups := make([]string, 0, len(info.Permissions))
+	for group, perms := range info.Permissions {
+		if len(perms) > 0 {
+			groups = append(groups, group+": "+strings.Join(perms, ", "))
+		}
+	}
+	sort.Strings(groups)
+
+	mode := "Read-Write"
+	if info.ReadOnly {
+		mode = "Read-Only"
+	}
+	account := "классический"
+	if info.Unified {
+		account = "Unified Trading"
+	}
+	return escapeMarkdown(fmt.Sprintf("🔐 Ключ %s, аккаунт %s.\nПрава: %s", mode, account, strings.Join(groups, "; ")))
+}
+
+// cmdKeys - список ключей пользователя с кнопками управления. Секрет не показываем никогда,
+// ключ - только первые и последние 4 символа.
+func (h *Handler) cmdKeys(ctx context.Context, msg *tgbotapi.Message) {
+	if !h.checkSubscription(ctx, msg) {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+
+	keys, err := h.keyRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to fetch api keys", "user_id", user.ID, "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения ключей.")
+		return
+	}
+	if len(keys) == 0 {
+		h.send(msg.Chat.ID, "📭 Ключей нет. Добавьте ключ через меню.")
+		return
+	}
+
+	var sb strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	sb.WriteString("🔑 Ваши API ключи:\n\n")
+	for _, k := range keys {
+		status := "✅ активен"
+		if !k.IsValid {
+			status = "⛔ выключен"
+		}
+		sb.WriteString(fmt.Sprintf("#%d %s (%s) %s\n", k.ID, k.Label, k.Network(), status))
+		if k.Undecryptable {
+			sb.WriteString("├ ⚠️ Ключ не удалось расшифровать, замените или удалите его\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("├ Ключ: `%s`\n", maskKey(k.Key)))
+		}
+		if !k.ExpiresAt.IsZero() {
+			sb.WriteString(fmt.Sprintf("├ Истекает: %s\n", k.ExpiresAt.Format("02.01.2006")))
+		}
+		if n := len(h.keyTasks(ctx, k.ID)); n > 0 {
+			sb.WriteString(fmt.Sprintf("├ Активных задач: %d\n", n))
+		}
+		sb.WriteString(fmt.Sprintf("└ Добавлен: %s\n\n", k.CreatedAt.Format("02.01.2006")))
+
+		var row []tgbotapi.InlineKeyboardButton
+		if k.IsValid {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("⛔ #%d", k.ID), idCallback(callbackKeyInvalidate, k.ID)))
+		}
+		row = append(row,
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("✏️ #%d", k.ID), idCallback(callbackKeyLabel, k.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🗑 #%d", k.ID), idCallback(callbackKeyDelete, k.ID)),
+		)
+		rows = append(rows, row)
+	}
+	sb.WriteString("⛔ - выключить, ✏️ - переименовать, 🗑 - удалить")
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, sb.String())
+	reply.ParseMode = "Markdown"
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	h.bot.Send(reply)
+}
+
+// sendNoActiveKey: рабочего ключа нет. Ключ, зашифрованный неизвестным ключом шифрования,
+// не восстановить - просим ввести его заново.
+func (h *Handler) sendNoActiveKey(chatID int64, err error) {
+	if errors.Is(err, domain.ErrUnknownKeyVersion) {
+		h.send(chatID, "🔐 Сохраненные API ключи больше не читаются. Введите их заново кнопкой '"+BtnAddKey+"'.")
+		return
+	}
+	h.send(chatID, "⚠️ Нет активных API ключей.")
+}
+
+// maskKey оставляет от ключа первые и последние 4 символа
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "…" + key[len(key)-4:]
+}
+
+// ownKey загружает ключ из callback и проверяет, что он принадлежит пользователю
+func (h *Handler) ownKey(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) (*domain.APIKey, *domain.User, bool) {
+	keyID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(cb.Message.Chat.ID, "Ошибка получения профиля.")
+		return nil, nil, false
+	}
+	key, ok := h.userKey(ctx, cb.Message.Chat.ID, user.ID, keyID)
+	return key, user, ok
+}
+
+// userKey ищет ключ в списке пользователя: в отличие от GetByID, находит и нерасшифрованные записи
+func (h *Handler) userKey(ctx context.Context, chatID, userID, keyID int64) (*domain.APIKey, bool) {
+	keys, err := h.keyRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to fetch api keys", "user_id", userID, "err", err)
+		h.send(chatID, "Ошибка получения ключей.")
+		return nil, false
+	}
+	for i := range keys {
+		if keys[i].ID == keyID {
+			return &keys[i], true
+		}
+	}
+	h.send(chatID, "❌ Ключ не найден.")
+	return nil, false
+}
+
+// keyTasks - незавершенные задачи ключа (включая паузу и ролл). Ошибка только в лог: это подсказка,
+// а не проверка, и без нее действие над ключом не должно ломаться.
+func (h *Handler) keyTasks(ctx context.Context, keyID int64) []domain.Task {
+	tasks, err := h.taskRepo.GetTasksByAPIKeyID(ctx, keyID)
+	if err != nil {
+		h.logger.Warn("Failed to fetch tasks of api key", "api_key_id", keyID, "err", err)
+		return nil
+	}
+	var active []domain.Task
+	for _, t := range tasks {
+		if t.Status != domain.TaskStateCompleted && t.Status != domain.TaskStateFailed {
+			active = append(active, t)
+		}
+	}
+	return active
+}
+
+// writeKeyUsage перечисляет задачи, которые затронет выключение или удаление ключа
+func writeKeyUsage(sb *strings.Builder, tasks []domain.Task) {
+	sb.WriteString(fmt.Sprintf("⚠️ Ключ используется активными задачами: %d\n", len(tasks)))
+	for _, t := range tasks {
+		sb.WriteString(fmt.Sprintf("• #%d %s (%s)\n", t.ID, t.Title(), t.Status))
+	}
+	sb.WriteString("Ожидающие триггер задачи встанут на паузу, пока вы не переведете их на новый ключ того же аккаунта.\n")
+}
+
+// askInvalidateKey: ключ без задач выключаем сразу, с задачами - после подтверждения
+func (h *Handler) askInvalidateKey(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	key, _, ok := h.ownKey(ctx, cb, rawID)
+	if !ok {
+		h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+		return
+	}
+	tasks := h.keyTasks(ctx, key.ID)
+	if len(tasks) == 0 {
+		h.invalidateKey(ctx, cb, rawID)
+		return
+	}
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Выключить ключ #%d (%s)?\n", key.ID, key.Label))
+	writeKeyUsage(&sb, tasks)
+	msg := tgbotapi.NewMessage(cb.Message.Chat.ID, sb.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⛔ Да, выключить", idCallback(callbackKeyInvalidateConfirm, key.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", callbackDeleteCancel),
+	))
+	h.bot.Send(msg)
+}
+
+// confirmInvalidateKey: подтверждение из askInvalidateKey, кнопки убираем от повторного нажатия
+func (h *Handler) confirmInvalidateKey(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewEditMessageReplyMarkup(cb.Message.Chat.ID, cb.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+	h.invalidateKey(ctx, cb, rawID)
+}
+
+func (h *Handler) invalidateKey(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	key, user, ok := h.ownKey(ctx, cb, rawID)
+	if !ok {
+		return
+	}
+
+	paused, err := h.keyRepo.Invalidate(ctx, key.ID)
+	if err != nil {
+		h.logger.Error("Failed to invalidate api key", "api_key_id", key.ID, "err", err)
+		h.send(cb.Message.Chat.ID, "Ошибка выключения ключа.")
+		return
+	}
+	h.logger.Info("API key invalidated by user", "api_key_id", key.ID, "user_id", user.ID, "paused_tasks", len(paused))
+	h.send(cb.Message.Chat.ID, fmt.Sprintf("⛔ Ключ #%d выключен.", key.ID))
+	h.afterKeyDisabled(ctx, cb.Message.Chat.ID, user.ID, key.ID, paused)
+}
+
+// afterKeyDisabled сбрасывает кэш ключей, убирает приостановленные задачи из мониторинга
+// и перечисляет пользователю задачи, затронутые выключением ключа
+func (h *Handler) afterKeyDisabled(ctx context.Context, chatID, userID, keyID int64, paused []domain.Task) {
+	h.manager.InvalidateUserKeys(userID)
+	if len(paused) > 0 {
+		h.reloadManager(ctx)
+	}
+
+	var sb strings.Builder
+	if len(paused) > 0 {
+		sb.WriteString("⏸ Задачи этого ключа приостановлены:\n")
+		for _, t := range paused {
+			sb.WriteString(fmt.Sprintf("• #%d %s\n", t.ID, t.CurrentOptionSymbol))
+		}
+		sb.WriteString("Добавьте новый ключ того же аккаунта Bybit - бот предложит перевести задачи на него, затем возобновите их в /status.\n")
+	}
+
+	// Ролл, начатый до выключения, не ставим на паузу: его нужно довести, а ключ уже не работает
+	tasks, err := h.taskRepo.GetTasksByAPIKeyID(ctx, keyID)
+	if err != nil {
+		h.logger.Warn("Failed to check mid-roll tasks of api key", "api_key_id", keyID, "err", err)
+	}
+	for _, t := range tasks {
+		if t.IsMidRoll() {
+			sb.WriteString(fmt.Sprintf("⚠️ Задача #%d %s посреди ролла (`%s`). Верните ключ или проверьте позицию на бирже.\n",
+				t.ID, t.CurrentOptionSymbol, t.Status))
+		}
+	}
+
+	if sb.Len() > 0 {
+		h.send(chatID, sb.String())
+	}
+}
+
+func (h *Handler) askDeleteKey(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	key, _, ok := h.ownKey(ctx, cb, rawID)
+	if !ok {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Удалить ключ #%d (%s)? Бот больше не сможет им торговать.\n", key.ID, key.Label))
+	if tasks := h.keyTasks(ctx, key.ID); len(tasks) > 0 {
+		writeKeyUsage(&sb, tasks)
+	}
+	msg := tgbotapi.NewMessage(cb.Message.Chat.ID, sb.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🗑 Да, удалить", idCallback(callbackKeyDeleteConfirm, key.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", callbackDeleteCancel),
+	))
+	h.bot.Send(msg)
+}
+
+func (h *Handler) deleteKey(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	chatID := cb.Message.Chat.ID
+	h.bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, cb.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+
+	key, user, ok := h.ownKey(ctx, cb, rawID)
+	if !ok {
+		return
+	}
+	paused, err := h.keyRepo.Delete(ctx, key.ID)
+	if err != nil {
+		h.logger.Error("Failed to delete api key", "api_key_id", key.ID, "err", err)
+		h.send(chatID, "Ошибка удаления ключа.")
+		return
+	}
+	h.logger.Info("API key deleted by user", "api_key_id", key.ID, "user_id", user.ID, "paused_tasks", len(paused))
+	h.send(chatID, fmt.Sprintf("🗑 Ключ #%d удален.", key.ID))
+	h.afterKeyDisabled(ctx, chatID, user.ID, key.ID, paused)
+	h.showMainMenu(ctx, chatID, user.TelegramID)
+}
+
+func (h *Handler) askKeyLabel(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	key, _, ok := h.ownKey(ctx, cb, rawID)
+	if !ok {
+		return
+	}
+
+	h.setState(cb.From.ID, &UserState{Step: "awaiting_key_label", TempKeyID: key.ID})
+	h.ask(cb.Message.Chat.ID, cb.From.ID, fmt.Sprintf("Введите новое название ключа #%d (до %d символов):", key.ID, keyLabelMaxLen))
+}
+
+func (h *Handler) processKeyLabel(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
+	label := strings.TrimSpace(msg.Text)
+	if label == "" || utf8.RuneCountInString(label) > keyLabelMaxLen || strings.ContainsAny(label, keyLabelBadChars) {
+		h.send(msg.Chat.ID, fmt.Sprintf("❌ Название: от 1 до %d символов, без символов разметки.", keyLabelMaxLen))
+		return
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	h.cancelState(msg.From.ID)
+
+	key, ok := h.userKey(ctx, msg.Chat.ID, user.ID, state.TempKeyID)
+	if !ok {
+		return
+	}
+	if err := h.keyRepo.SetLabel(ctx, key.ID, label); err != nil {
+		h.logger.Error("Failed to relabel api key", "api_key_id", key.ID, "err", err)
+		h.send(msg.Chat.ID, "Ошибка сохранения названия.")
+		return
+	}
+	h.send(msg.Chat.ID, fmt.Sprintf("✅ Ключ #%d теперь называется «%s».", key.ID, label))
+}
+
+// offerTaskMove: после добавления ключа предлагает перевести на него задачи прежних ключей той же сети,
+// в том числе выключенных и удаленных. Сам не переводит: прежний ключ может быть рабочим ключом
+// другого аккаунта или субаккаунта.
+func (h *Handler) offerTaskMove(ctx context.Context, chatID, userID int64, newKey *domain.APIKey) {
+	filter := domain.TaskFilter{States: []domain.TaskState{domain.TaskStateIdle, domain.TaskStatePaused}}
+	tasks, err := h.taskRepo.ListTasks(ctx, userID, filter, 0, 0)
+	if err != nil {
+		h.logger.Error("Failed to fetch user tasks", "err", err)
+		return
+	}
+
+	counts := make(map[int64]int)
+	var keyIDs []int64
+	for _, t := range tasks {
+		if t.APIKeyID == newKey.ID {
+			continue
+		}
+		if counts[t.APIKeyID] == 0 {
+			keyIDs = append(keyIDs, t.APIKeyID)
+		}
+		counts[t.APIKeyID]++
+	}
+
+	var sb strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, id := range keyIDs {
+		old, err := h.keyRepo.GetByID(ctx, id)
+		if err != nil || old == nil || old.Network() != newKey.Network() {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("• ключ #%d (%s): задач %d\n", old.ID, old.Label, counts[id]))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("🔁 #%d → #%d", old.ID, newKey.ID),
+			mustCallback(callbackKeyMove, strconv.FormatInt(old.ID, 10), strconv.FormatInt(newKey.ID, 10)),
+		)))
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Оставить как есть", callbackDeleteCancel)))
+	msg := tgbotapi.NewMessage(chatID, "Перевести задачи прежних ключей на новый ключ?\n"+sb.String()+
+		"Переводите, только если это ключи одного аккаунта Bybit: бот сверит UID аккаунта. Задачи посреди ролла останутся на прежнем ключе.")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	h.bot.Send(msg)
+}
+
+// moveKeyTasks - подтверждение из offerTaskMove. Задачи переходят, только если оба ключа от одного
+// аккаунта Bybit: иначе следующий ролл (или восстановление) пошел бы в чужой аккаунт.
+func (h *Handler) moveKeyTasks(ctx context.Context, cb *tgbotapi.CallbackQuery, payload string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	chatID := cb.Message.Chat.ID
+	h.bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, cb.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+
+	rawOld, rawNew, _ := strings.Cut(payload, callbackSep)
+	oldID, errOld := strconv.ParseInt(rawOld, 10, 64)
+	newID, errNew := strconv.ParseInt(rawNew, 10, 64)
+	if errOld != nil || errNew != nil {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(chatID, "Ошибка получения профиля.")
+		return
+	}
+
+	oldKey, errOld := h.keyRepo.GetByID(ctx, oldID)
+	newKey, errNew := h.keyRepo.GetByID(ctx, newID)
+	if errOld != nil || errNew != nil || oldKey == nil || newKey == nil || oldKey.UserID != user.ID || newKey.UserID != user.ID {
+		h.send(chatID, "❌ Ключ не найден.")
+		return
+	}
+	if !newKey.IsValid || oldKey.Network() != newKey.Network() {
+		h.send(chatID, fmt.Sprintf("❌ Ключ #%d выключен или из другой сети. Задачи не переведены.", newKey.ID))
+		return
+	}
+
+	oldUID, errOld := h.keyAccountUID(ctx, *oldKey)
+	newUID, errNew := h.keyAccountUID(ctx, *newKey)
+	if errOld != nil || errNew != nil {
+		h.logger.Warn("Failed to resolve account uid for task move", "old_key_id", oldID, "new_key_id", newID,
+			"old_err", errOld, "new_err", errNew)
+		h.send(chatID, "❌ Не удалось определить аккаунт Bybit одного из ключей. Задачи не переведены.")
+		return
+	}
+	if oldUID != newUID {
+		h.logger.Warn("Refused task move between accounts", "old_key_id", oldID, "new_key_id", newID, "user_id", user.ID)
+		h.send(chatID, fmt.Sprintf("❌ Ключи #%d и #%d от разных аккаунтов Bybit. Задачи не переведены.", oldID, newID))
+		return
+	}
+
+	n, err := h.taskRepo.ReassignTasksToKey(ctx, oldID, newID)
+	if err != nil {
+		h.logger.Error("Failed to reassign tasks to new key", "old_key_id", oldID, "new_key_id", newID, "err", err)
+		h.send(chatID, "⚠️ Не удалось перевести задачи на новый ключ.")
+		return
+	}
+	h.logger.Info("Tasks reassigned to new key", "old_key_id", oldID, "new_key_id", newID, "tasks", n)
+	h.send(chatID, fmt.Sprintf("🔁 Задач переведено на ключ #%d: %d. Приостановленные возобновите в /status.", newID, n))
+	if n > 0 {
+		h.reloadManager(ctx)
+	}
+}
+
+// keyAccountUID - UID аккаунта Bybit ключа: сохраненный при добавлении, а для ключей, добавленных
+// раньше, - с биржи
+func (h *Handler) keyAccountUID(ctx context.Context, key domain.APIKey) (int64, error) {
+	if key.AccountUID != 0 {
+		return key.AccountUID, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, keyValidationTimeout)
+	defer cancel()
+	info, err := h.exchange.GetAPIKeyInfo(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if info.AccountUID == 0 {
+		return 0, fmt.Errorf("no account uid for api key %d", key.ID)
+	}
+	return info.AccountUID, nil
+}
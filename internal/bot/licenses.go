@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Сколько кодов показывает /licenses: сообщение Telegram ограничено 4096 символами
+const licensesListLimit = 40
+
+// licenseErrorText - понятная пользователю причина отказа в активации или отзыве кода
+func licenseErrorText(err error) string {
+	switch {
+	case errors.Is(err, domain.ErrLicenseNotFound):
+		return "Код не найден."
+	case errors.Is(err, domain.ErrLicenseRedeemed):
+		return "Код уже активирован."
+	case errors.Is(err, domain.ErrLicenseRevoked):
+		return "Код отозван."
+	case errors.Is(err, domain.ErrLicenseExpired):
+		return "Срок активации кода истек."
+	case errors.Is(err, domain.ErrLicenseStackLimit):
+		return fmt.Sprintf("Подписку нельзя продлить больше чем на %d дней вперед.", int(domain.MaxSubscriptionAhead.Hours()/24))
+	default:
+		return "Не удалось проверить код."
+	}
+}
+
+// cmdLicensesAdmin - /licenses [all]: по умолчанию только неактивированные коды
+func (h *Handler) cmdLicensesAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	onlyUnredeemed := strings.TrimSpace(msg.CommandArguments()) != "all"
+	licenses, err := h.licRepo.List(ctx, onlyUnredeemed)
+	if err != nil {
+		h.logger.Error("Failed to list licenses", "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения лицензий.")
+		return err
+	}
+	if len(licenses) == 0 {
+		h.send(msg.Chat.ID, "📭 Кодов нет.")
+		return nil
+	}
+
+	now := time.Now()
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🎫 Коды: %d\n", len(licenses)))
+	for i, lic := range licenses {
+		if i == licensesListLimit {
+			sb.WriteString(fmt.Sprintf("\n... и еще %d\n", len(licenses)-licensesListLimit))
+			break
+		}
+		status := "свободен"
+		switch {
+		case lic.IsRedeemed:
+			status = "активирован"
+			if lic.RedeemedAt != nil {
+				status += " " + lic.RedeemedAt.UTC().Format("02.01.2006")
+			}
+		case lic.RevokedAt != nil:
+			status = "отозван"
+		case lic.IsExpired(now):
+			status = "просрочен"
+		case lic.ValidUntil != nil:
+			status += " до " + lic.ValidUntil.UTC().Format("02.01.2006")
+		}
+		sb.WriteString(fmt.Sprintf("\n`%s` %d дн., %s\n", lic.Code, lic.DurationDays, status))
+	}
+	sb.WriteString("\nОтозвать: /revoke <код>")
+	h.send(msg.Chat.ID, sb.String())
+	return nil
+}
+
+// cmdRevokeAdmin - /revoke <код>
+func (h *Handler) cmdRevokeAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	code := strings.TrimSpace(msg.CommandArguments())
+	if code == "" {
+		h.send(msg.Chat.ID, "Использование: /revoke <код>")
+		return errAdminUsage
+	}
+
+	if err := h.licRepo.Revoke(ctx, code); err != nil {
+		h.logger.Warn("Failed to revoke license", "code", code, "err", err)
+		h.send(msg.Chat.ID, "❌ "+licenseErrorText(err))
+		return err
+	}
+	h.logger.Info("License revoked", "code", code)
+	h.send(msg.Chat.ID, fmt.Sprintf("⛔ Код `%s` отозван.", code))
+	return nil
+}
@@ -0,0 +1,196 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// ErrNotifyQueueFull - очередь уведомлений переполнена, сообщение отброшено
+var ErrNotifyQueueFull = errors.New("notification queue is full")
+
+// Telegram ограничивает бота ~30 сообщениями в секунду на все чаты: держимся ниже
+const (
+	notifyInterval      = time.Second / 25
+	notifyAttempts      = 3
+	notifyRetryDelay    = time.Second
+	notifyLookupTimeout = 5 * time.Second
+)
+
+type NotifierConfig struct {
+	Workers   int
+	QueueSize int
+	AdminID   int64 // Telegram ID администратора для NotifyAdmin; 0 - не настроен
+}
+
+type notification struct {
+	userID  int64
+	chatID  int64 // Задан у сообщений администратору: чат без записи в users
+	message string
+}
+
+// Notifier - domain.NotificationService через Telegram. Роллер, менеджер и свипер только
+// кладут сообщение в очередь; отправляют воркеры Run, так что лимиты Telegram и его
+// недоступность не задерживают торговлю.
+type Notifier struct {
+	bot    *tgbotapi.BotAPI
+	users  domain.UserRepository
+	cfg    NotifierConfig
+	queue  chan notification
+	logger *slog.Logger
+}
+
+func NewNotifier(bot *tgbotapi.BotAPI, users domain.UserRepository, cfg NotifierConfig, logger *slog.Logger) *Notifier {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	return &Notifier{
+		bot:    bot,
+		users:  users,
+		cfg:    cfg,
+		queue:  make(chan notification, cfg.QueueSize),
+		logger: logger.With(slog.String("component", "notifier")),
+	}
+}
+
+// NotifyUser ставит сообщение в очередь и сразу возвращается
+func (n *Notifier) NotifyUser(userID int64, message string) error {
+	select {
+	case n.queue <- notification{userID: userID, message: message}:
+		return nil
+	default:
+		return fmt.Errorf("notify user %d: %w", userID, ErrNotifyQueueFull)
+	}
+}
+
+// NotifyAdmin ставит сообщение администратору в очередь; без ADMIN_TELEGRAM_ID ничего не делает
+func (n *Notifier) NotifyAdmin(message string) error {
+	if n.cfg.AdminID == 0 {
+		return nil
+	}
+	select {
+	case n.queue <- notification{chatID: n.cfg.AdminID, message: message}:
+		return nil
+	default:
+		return fmt.Errorf("notify admin: %w", ErrNotifyQueueFull)
+	}
+}
+
+// Run отправляет уведомления до отмены ctx. Неотправленные к остановке сообщения теряются.
+func (n *Notifier) Run(ctx context.Context) {
+	limiter := time.NewTicker(notifyInterval)
+	defer limiter.Stop()
+
+	done := make(chan struct{})
+	for i := 0; i < n.cfg.Workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case note := <-n.queue:
+					n.deliver(ctx, note, limiter.C)
+				}
+			}
+		}()
+	}
+	for i := 0; i < n.cfg.Workers; i++ {
+		<-done
+	}
+	if pending := len(n.queue); pending > 0 {
+		n.logger.Warn("Notifications dropped on shutdown", "count", pending)
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, note notification, limiter <-chan time.Time) {
+	chatID := note.chatID
+	if chatID == 0 {
+		lookupCtx, cancel := context.WithTimeout(ctx, notifyLookupTimeout)
+		user, err := n.users.GetByID(lookupCtx, note.userID)
+		cancel()
+		if err != nil || user == nil {
+			n.logger.Warn("Cannot resolve notification recipient", "user_id", note.userID, "err", err)
+			return
+		}
+		if user.BotBlockedAt != nil {
+			return
+		}
+		chatID = user.TelegramID
+	}
+
+	msg := tgbotapi.NewMessage(chatID, escapeMarkdown(note.message))
+	msg.ParseMode = "Markdown"
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-limiter:
+		}
+
+		_, err := n.bot.Send(msg)
+		if err == nil {
+			return
+		}
+
+		delay := notifyRetryDelay * time.Duration(attempt)
+		var tgErr *tgbotapi.Error
+		if errors.As(err, &tgErr) {
+			switch {
+			case tgErr.RetryAfter > 0:
+				delay = time.Duration(tgErr.RetryAfter) * time.Second
+			case tgErr.Code == 400 && msg.ParseMode != "":
+				// Разметку не разобрали, несмотря на экранирование: шлем как есть
+				msg.Text, msg.ParseMode = note.message, ""
+				delay = 0
+			case tgErr.Code == 403:
+				// Бот заблокирован: не пишем пользователю, пока он сам не вернется (/start)
+				if note.chatID == 0 {
+					n.markBlocked(ctx, note.userID)
+				}
+				return
+			case tgErr.Code == 400:
+				// Чата нет: повтор не поможет
+				n.logger.Warn("Notification rejected by Telegram", "user_id", note.userID, "err", err)
+				return
+			}
+		}
+		if attempt >= notifyAttempts {
+			n.logger.Warn("Failed to send notification", "user_id", note.userID, "attempts", attempt, "err", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (n *Notifier) markBlocked(ctx context.Context, userID int64) {
+	ctx, cancel := context.WithTimeout(ctx, notifyLookupTimeout)
+	defer cancel()
+	if err := n.users.MarkBotBlocked(ctx, userID); err != nil {
+		n.logger.Warn("Failed to mark bot blocked", "user_id", userID, "err", err)
+		return
+	}
+	n.logger.Info("User blocked the bot, notifications stopped", "user_id", userID)
+}
+
+// escapeMarkdown экранирует разметку Markdown Telegram: уведомления - простой текст, а в них
+// попадают символы, ошибки биржи и названия задач
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+var markdownEscaper = strings.NewReplacer("_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`)
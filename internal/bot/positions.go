@@ -0,0 +1,228 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Позиции с биржи нужны /status, карточкам задач и /add. Пользователь листает страницы и жмет
+// кнопки подряд, поэтому ответ биржи по ключу живет несколько секунд.
+const (
+	positionsCacheTTL = 5 * time.Second
+	positionsTimeout  = 5 * time.Second
+)
+
+// Позиций на странице выбора в /add: у продавца опционов бывает несколько десятков ног
+const positionPageSize = 8
+
+type cachedPositions struct {
+	positions []domain.Position
+	fetchedAt time.Time
+}
+
+// positionsCache - последние позиции по ID ключа
+type positionsCache struct {
+	mu      sync.Mutex
+	entries map[int64]cachedPositions
+}
+
+func newPositionsCache() *positionsCache {
+	return &positionsCache{entries: make(map[int64]cachedPositions)}
+}
+
+func (c *positionsCache) get(keyID int64, now time.Time) ([]domain.Position, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[keyID]
+	if !ok || now.Sub(entry.fetchedAt) > positionsCacheTTL {
+		delete(c.entries, keyID)
+		return nil, false
+	}
+	return entry.positions, true
+}
+
+func (c *positionsCache) put(keyID int64, positions []domain.Position, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[keyID] = cachedPositions{positions: positions, fetchedAt: now}
+}
+
+// positions - открытые позиции ключа, из кэша или с биржи
+func (h *Handler) positions(ctx context.Context, key domain.APIKey) ([]domain.Position, error) {
+	if positions, ok := h.positionCache.get(key.ID, time.Now()); ok {
+		return positions, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, positionsTimeout)
+	defer cancel()
+	positions, err := h.exchange.GetPositions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	h.positionCache.put(key.ID, positions, time.Now())
+	return positions, nil
+}
+
+type positionKey struct {
+	apiKeyID int64
+	symbol   string
+}
+
+// taskPositions - позиции, на которых стоят задачи. Ключи, позиции которых получить не удалось,
+// в loaded не попадают: карточка такой задачи просто без строки позиции.
+type taskPositions struct {
+	loaded map[int64]bool
+	open   map[positionKey]domain.Position
+}
+
+// holdsPosition - задача стоит на открытой позиции: в работе или на паузе
+func holdsPosition(t domain.Task) bool {
+	return t.Status != domain.TaskStateCompleted && t.Status != domain.TaskStateFailed
+}
+
+// loadTaskPositions загружает позиции ключей задач, которые стоят на позициях
+func (h *Handler) loadTaskPositions(ctx context.Context, tasks []domain.Task) taskPositions {
+	tp := taskPositions{loaded: make(map[int64]bool), open: make(map[positionKey]domain.Position)}
+	tried := make(map[int64]bool)
+	for _, t := range tasks {
+		if !holdsPosition(t) || tried[t.APIKeyID] {
+			continue
+		}
+		tried[t.APIKeyID] = true
+
+		key, err := h.keyRepo.GetByID(ctx, t.APIKeyID)
+		if err != nil || key == nil || !key.IsValid {
+			continue
+		}
+		positions, err := h.positions(ctx, *key)
+		if err != nil {
+			h.logger.Warn("Failed to fetch positions for status", "api_key_id", key.ID, "err", err)
+			continue
+		}
+		tp.loaded[key.ID] = true
+		for _, p := range positions {
+			tp.open[positionKey{apiKeyID: key.ID, symbol: p.Symbol}] = p
+		}
+	}
+	return tp
+}
+
+// writeTaskPosition - строка карточки о позиции задачи на бирже
+func (tp taskPositions) writeTaskPosition(sb *strings.Builder, t domain.Task) {
+	if !holdsPosition(t) || !tp.loaded[t.APIKeyID] {
+		return
+	}
+	p, ok := tp.open[positionKey{apiKeyID: t.APIKeyID, symbol: t.CurrentOptionSymbol}]
+	switch {
+	case ok:
+		sb.WriteString("├ 💼 " + formatPositionPnL(p) + "\n")
+	case t.Status == domain.TaskStateIdle || t.Status == domain.TaskStatePaused:
+		// Посреди ролла позиции может не быть законно: старая нога закрыта, новая еще не открыта
+		sb.WriteString("├ ⚠️ Позиции на бирже нет: закрыта вручную?\n")
+	}
+}
+
+// formatPositionPnL: "вход `1200`, mark `950`, PnL `+25.00`"
+func formatPositionPnL(p domain.Position) string {
+	pnl := p.UnrealizedPnL.StringFixed(2)
+	if p.UnrealizedPnL.IsPositive() {
+		pnl = "+" + pnl
+	}
+	return fmt.Sprintf("вход `%s`, mark `%s`, PnL `%s`", p.EntryPrice.String(), p.MarkPrice.String(), pnl)
+}
+
+// renderPositionPage - страница выбора позиции в /add. Вход, mark и PnL в тексте: на кнопке
+// помещаются только символ и объем.
+func (h *Handler) renderPositionPage(positions []domain.Position, page int) (string, tgbotapi.InlineKeyboardMarkup) {
+	pages := (len(positions) + positionPageSize - 1) / positionPageSize
+	page = min(max(page, 0), pages-1)
+	from := page * positionPageSize
+	to := min(from+positionPageSize, len(positions))
+
+	var sb strings.Builder
+	sb.WriteString("Выберите позицию для роллирования")
+	if pages > 1 {
+		sb.WriteString(fmt.Sprintf(" (стр. %d из %d, позиций: %d)", page+1, pages, len(positions)))
+	}
+	sb.WriteString(":\n\n")
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, p := range positions[from:to] {
+		sb.WriteString(fmt.Sprintf("• `%s` %s %s: %s\n", p.Symbol, p.Side, p.Qty.String(), formatPositionPnL(p)))
+
+		data, err := encodeCallback(callbackPosition, p.Symbol)
+		if err != nil {
+			h.logger.Warn("Position symbol does not fit into callback data", "symbol", p.Symbol, "err", err)
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s (%s)", p.Symbol, p.Qty), data),
+		))
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", mustCallback(callbackPositionPage, strconv.Itoa(page-1))))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("Вперед ▶️", mustCallback(callbackPositionPage, strconv.Itoa(page+1))))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	return sb.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// showPositionPage листает выбор позиции: "pos:page:<страница>" перерисовывает то же сообщение.
+// Позиции берутся из состояния /add; если оно истекло, запрашиваются заново.
+func (h *Handler) showPositionPage(ctx context.Context, cb *tgbotapi.CallbackQuery, data string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+
+	page, err := strconv.Atoi(data)
+	if err != nil || page < 0 {
+		return
+	}
+
+	var positions []domain.Position
+	h.mu.Lock()
+	if state := h.states[cb.From.ID]; state != nil && state.Step == "awaiting_position" && time.Since(state.UpdatedAt) <= stateTTL {
+		positions = state.Positions
+		state.UpdatedAt = time.Now()
+	}
+	h.mu.Unlock()
+
+	if positions == nil {
+		user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+		if err != nil || user == nil {
+			h.send(cb.Message.Chat.ID, "Ошибка получения профиля.")
+			return
+		}
+		apiKey, err := h.keyRepo.GetActiveByUserID(ctx, user.ID)
+		if err != nil || apiKey == nil {
+			h.sendNoActiveKey(cb.Message.Chat.ID, err)
+			return
+		}
+		if positions, err = h.positions(ctx, *apiKey); err != nil {
+			h.logger.Error("Failed to fetch positions", "user_id", user.ID, "api_key_id", apiKey.ID, "err", err)
+			h.send(cb.Message.Chat.ID, "Ошибка получения позиций с биржи: "+escapeMarkdown(err.Error()))
+			return
+		}
+		if len(positions) == 0 {
+			h.send(cb.Message.Chat.ID, "Нет открытых опционных позиций.")
+			return
+		}
+		h.setState(cb.From.ID, &UserState{Step: "awaiting_position", Positions: positions})
+	}
+
+	text, keyboard := h.renderPositionPage(positions, page)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(cb.Message.Chat.ID, cb.Message.MessageID, text, keyboard)
+	edit.ParseMode = "Markdown"
+	h.bot.Send(edit)
+}
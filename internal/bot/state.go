@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Брошенный посреди мастера ввод не должен через час превратиться в цену триггера или ключ
+const (
+	stateTTL            = 10 * time.Minute
+	stateJanitorPeriod  = time.Minute
+	expiredNoticeMaxAge = 24 * time.Hour // сколько помним, что сессия истекла, чтобы сказать об этом
+)
+
+// BtnCancel - единственная кнопка клавиатуры, пока идет мастер
+const BtnCancel = "❌ Отмена"
+
+// setState начинает шаг мастера; время обновляется при каждом ответе пользователя
+func (h *Handler) setState(telegramID int64, state *UserState) {
+	state.UpdatedAt = time.Now()
+	h.mu.Lock()
+	h.states[telegramID] = state
+	delete(h.expiredStates, telegramID)
+	h.mu.Unlock()
+}
+
+// currentState возвращает состояние пользователя и продлевает его. expired - состояние было,
+// но истекло: сообщение пришло в мастер, который уже закрыт.
+func (h *Handler) currentState(telegramID int64) (state *UserState, expired bool) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state = h.states[telegramID]
+	if state != nil && now.Sub(state.UpdatedAt) > stateTTL {
+		delete(h.states, telegramID)
+		state = nil
+		h.expiredStates[telegramID] = now
+	}
+	if state != nil {
+		state.UpdatedAt = now
+		return state, false
+	}
+	if _, ok := h.expiredStates[telegramID]; ok {
+		delete(h.expiredStates, telegramID)
+		return nil, true
+	}
+	return nil, false
+}
+
+// hasState - мастер еще идет
+func (h *Handler) hasState(telegramID int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.states[telegramID] != nil
+}
+
+// cmdCancel: /cancel и кнопка "❌ Отмена" - выйти из мастера и вернуть меню
+func (h *Handler) cmdCancel(ctx context.Context, msg *tgbotapi.Message) {
+	if h.cancelState(msg.From.ID) {
+		h.send(msg.Chat.ID, "❌ Действие отменено.")
+	} else {
+		h.send(msg.Chat.ID, "Нечего отменять.")
+	}
+	h.showMainMenu(ctx, msg.Chat.ID, msg.From.ID)
+}
+
+// ask задает первый вопрос мастера: клавиатура меню сменяется кнопкой отмены.
+// Возвращает ID вопроса (0, если отправить не удалось).
+func (h *Handler) ask(chatID, telegramID int64, text string) int {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(BtnCancel)))
+	sent, err := h.bot.Send(msg)
+
+	h.mu.Lock()
+	h.cancelMenu[telegramID] = true
+	h.mu.Unlock()
+
+	if err != nil {
+		return 0
+	}
+	return sent.MessageID
+}
+
+// restoreMenu возвращает клавиатуру меню после мастера, если ее еще не вернули
+func (h *Handler) restoreMenu(ctx context.Context, chatID, telegramID int64) {
+	h.mu.RLock()
+	shown := h.cancelMenu[telegramID]
+	h.mu.RUnlock()
+	if shown {
+		h.showMainMenu(ctx, chatID, telegramID)
+	}
+}
+
+// runStateJanitor удаляет брошенные мастера до отмены ctx
+func (h *Handler) runStateJanitor(ctx context.Context) {
+	ticker := time.NewTicker(stateJanitorPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.expireStates(now)
+		}
+	}
+}
+
+// expireStates закрывает состояния без ответа дольше stateTTL и забывает старые отметки об истечении
+func (h *Handler) expireStates(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id, state := range h.states {
+		if now.Sub(state.UpdatedAt) > stateTTL {
+			delete(h.states, id)
+			h.expiredStates[id] = now
+		}
+	}
+	for id, at := range h.expiredStates {
+		if now.Sub(at) > expiredNoticeMaxAge {
+			delete(h.expiredStates, id)
+		}
+	}
+}
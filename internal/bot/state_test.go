@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func newStateHandler() *Handler {
+	return &Handler{
+		states:        make(map[int64]*UserState),
+		expiredStates: make(map[int64]time.Time),
+	}
+}
+
+func TestCancelMidWizard(t *testing.T) {
+	h := newStateHandler()
+	h.setState(1, &UserState{Step: "awaiting_trigger", TempSymbol: "BTC-27DEC24-60000-P"})
+
+	if !h.cancelState(1) {
+		t.Fatal("cancel of an active wizard reported nothing to cancel")
+	}
+	if h.hasState(1) {
+		t.Fatal("state survived cancel")
+	}
+	// Отмена - не истечение: следующее сообщение не должно получить "сессия истекла"
+	if state, expired := h.currentState(1); state != nil || expired {
+		t.Fatalf("after cancel: state %v, expired %v", state, expired)
+	}
+	if h.cancelState(1) {
+		t.Fatal("second cancel reported an active wizard")
+	}
+}
+
+func TestExpireStates(t *testing.T) {
+	h := newStateHandler()
+	now := time.Now()
+	h.setState(1, &UserState{Step: "awaiting_trigger"})
+	h.setState(2, &UserState{Step: "awaiting_keys"})
+	h.states[1].UpdatedAt = now.Add(-stateTTL - time.Second)
+	h.states[2].UpdatedAt = now.Add(-stateTTL + time.Minute)
+	h.expiredStates[3] = now.Add(-expiredNoticeMaxAge - time.Hour)
+
+	h.expireStates(now)
+
+	if h.hasState(1) || !h.hasState(2) {
+		t.Fatalf("after expiry: stale kept %v, fresh kept %v", h.hasState(1), h.hasState(2))
+	}
+	if _, ok := h.expiredStates[3]; ok {
+		t.Fatal("old expiry notice was not forgotten")
+	}
+
+	// Об истечении говорим один раз
+	if state, expired := h.currentState(1); state != nil || !expired {
+		t.Fatalf("first message after expiry: state %v, expired %v", state, expired)
+	}
+	if _, expired := h.currentState(1); expired {
+		t.Fatal("expiry reported twice")
+	}
+	// Новый мастер снимает отметку об истечении
+	h.expiredStates[1] = now
+	h.setState(1, &UserState{Step: "awaiting_position"})
+	if state, expired := h.currentState(1); state == nil || expired {
+		t.Fatalf("new wizard: state %v, expired %v", state, expired)
+	}
+}
+
+func TestCurrentStateExpiresWithoutJanitor(t *testing.T) {
+	h := newStateHandler()
+	h.setState(1, &UserState{Step: "awaiting_step"})
+	h.states[1].UpdatedAt = time.Now().Add(-stateTTL - time.Second)
+
+	// Сообщение пришло раньше, чем janitor успел закрыть мастер
+	if state, expired := h.currentState(1); state != nil || !expired {
+		t.Fatalf("state %v, expired %v", state, expired)
+	}
+}
@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Название задачи попадает в карточки и уведомления с разметкой Markdown: символы разметки вырезаем
+const (
+	taskLabelMaxLen   = 40
+	taskLabelBadChars = "*_`[]"
+)
+
+// sanitizeTaskLabel убирает разметку и управляющие символы, схлопывает пробелы и обрезает до лимита
+func sanitizeTaskLabel(text string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(taskLabelBadChars, r) || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+
+	label := []rune(strings.Join(strings.Fields(cleaned), " "))
+	if len(label) > taskLabelMaxLen {
+		label = label[:taskLabelMaxLen]
+	}
+	return strings.TrimSpace(string(label))
+}
+
+// askTaskLabel: "🏷" в /status - переименовать задачу
+func (h *Handler) askTaskLabel(ctx context.Context, cb *tgbotapi.CallbackQuery, rawID string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	chatID := cb.Message.Chat.ID
+
+	taskID, _, err := parseTaskCallback(rawID)
+	if err != nil {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(chatID, "Ошибка получения профиля.")
+		return
+	}
+	task, err := h.taskRepo.GetTaskByID(ctx, taskID)
+	if err != nil || task == nil || task.UserID != user.ID {
+		h.send(chatID, "❌ Задача не найдена.")
+		return
+	}
+
+	h.setState(cb.From.ID, &UserState{Step: "awaiting_task_label", TempTaskID: task.ID})
+
+	current := "не задано"
+	if task.Label != "" {
+		current = "«" + task.Label + "»"
+	}
+	h.ask(chatID, cb.From.ID, fmt.Sprintf("🏷 Задача #%d (%s), название %s.\nВведите новое название (до %d символов) или `-`, чтобы убрать:",
+		task.ID, task.CurrentOptionSymbol, current, taskLabelMaxLen))
+}
+
+// processTaskLabel сохраняет название задачи: и после создания задачи, и при переименовании
+func (h *Handler) processTaskLabel(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
+	var label string
+	if text := strings.TrimSpace(msg.Text); text != "-" {
+		label = sanitizeTaskLabel(text)
+		if label == "" {
+			h.send(msg.Chat.ID, fmt.Sprintf("❌ Название: до %d символов, символы разметки не сохраняются. Введите его еще раз или `-`.", taskLabelMaxLen))
+			return
+		}
+	}
+
+	user, err := h.userRepo.GetByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		h.send(msg.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	h.cancelState(msg.From.ID)
+
+	err = h.taskRepo.UpdateTaskLabel(ctx, state.TempTaskID, user.ID, label)
+	switch {
+	case errors.Is(err, domain.ErrTaskNotFound):
+		h.send(msg.Chat.ID, "❌ Задача не найдена.")
+		return
+	case err != nil:
+		h.logger.Error("Failed to update task label", "task_id", state.TempTaskID, "err", err)
+		h.send(msg.Chat.ID, "Ошибка сохранения названия.")
+		return
+	}
+
+	// Manager хранит копии задач: перечитываем, чтобы уведомления шли с новым названием
+	h.reloadManager(ctx)
+	if label == "" {
+		h.send(msg.Chat.ID, fmt.Sprintf("🏷 У задачи #%d нет названия.", state.TempTaskID))
+		return
+	}
+	h.send(msg.Chat.ID, fmt.Sprintf("🏷 Задача #%d теперь называется «%s».", state.TempTaskID, label))
+}
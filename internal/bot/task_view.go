@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Событий в карточке задачи: журнал длинный, в карточке нужен только последний контекст
+const taskDetailsEvents = 5
+
+// taskView - откуда нажата кнопка над задачей: страница /status (смещение) или карточка "ℹ️".
+// Вид пишется в callback после ID ("task:pause:12:5", "task:pause:12:d5"), чтобы после действия
+// перерисовать то же сообщение. У кнопок старых сообщений вида нет - это первая страница.
+type taskView struct {
+	details bool
+	offset  int
+}
+
+func (v taskView) String() string {
+	if v.details {
+		return "d" + strconv.Itoa(v.offset)
+	}
+	return strconv.Itoa(v.offset)
+}
+
+// taskCallback - callback-данные кнопки над задачей: маршрут, ID и вид
+func taskCallback(route string, id int64, view taskView) string {
+	return mustCallback(route, strconv.FormatInt(id, 10), view.String())
+}
+
+// parseTaskCallback разбирает "<ID>[:<вид>]" после префикса
+func parseTaskCallback(data string) (int64, taskView, error) {
+	rawID, rawView, _ := strings.Cut(data, ":")
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return 0, taskView{}, fmt.Errorf("invalid task id %q: %w", rawID, err)
+	}
+
+	var view taskView
+	rawView, view.details = strings.CutPrefix(rawView, "d")
+	if rawView != "" {
+		if view.offset, err = strconv.Atoi(rawView); err != nil || view.offset < 0 {
+			return 0, taskView{}, fmt.Errorf("invalid task view %q", data)
+		}
+	}
+	return id, view, nil
+}
+
+// showTaskDetails: "ℹ️" в /status - карточка задачи с параметрами и последними событиями
+func (h *Handler) showTaskDetails(ctx context.Context, cb *tgbotapi.CallbackQuery, data string) {
+	h.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+
+	taskID, view, err := parseTaskCallback(data)
+	if err != nil {
+		return
+	}
+	user, err := h.userRepo.GetByTelegramID(ctx, cb.From.ID)
+	if err != nil || user == nil {
+		h.send(cb.Message.Chat.ID, "Ошибка получения профиля.")
+		return
+	}
+	view.details = true
+	h.refreshTaskView(ctx, cb, user.ID, taskID, view, "")
+}
+
+// refreshTaskView перерисовывает сообщение, с которого пришла кнопка: карточку задачи или страницу
+// /status. notice - итог действия, первой строкой. Если задачи больше нет, показываем список.
+func (h *Handler) refreshTaskView(ctx context.Context, cb *tgbotapi.CallbackQuery, userID, taskID int64, view taskView, notice string) {
+	var text string
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	var err error
+	if view.details {
+		text, keyboard, err = h.renderTaskDetails(ctx, userID, taskID, view)
+		if errors.Is(err, errTaskGone) {
+			view.details = false
+		}
+	}
+	if !view.details {
+		text, keyboard, err = h.renderTaskPage(ctx, userID, taskListActive, view.offset)
+	}
+	if err != nil {
+		h.logger.Error("Failed to render task view", "task_id", taskID, "err", err)
+		if notice != "" {
+			h.send(cb.Message.Chat.ID, notice)
+		}
+		return
+	}
+	if notice != "" {
+		text = notice + "\n\n" + text
+	}
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(cb.Message.Chat.ID, cb.Message.MessageID, text, keyboard)
+	edit.ParseMode = "Markdown"
+	h.bot.Send(edit)
+}
+
+// errTaskGone - задача удалена или чужая: карточку не показываем
+var errTaskGone = errors.New("task not found")
+
+func (h *Handler) renderTaskDetails(ctx context.Context, userID, taskID int64, view taskView) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	task, err := h.taskRepo.GetTaskByID(ctx, taskID)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	if task == nil || task.UserID != userID {
+		return "", tgbotapi.InlineKeyboardMarkup{}, errTaskGone
+	}
+
+	var sb strings.Builder
+	sb.WriteString("ℹ️ **Задача**\n\n")
+	h.writeTaskCard(&sb, *task, h.loadTaskPositions(ctx, []domain.Task{*task}))
+
+	sb.WriteString(fmt.Sprintf("📍 Базовый актив: `%s`\n", task.UnderlyingSymbol))
+	sb.WriteString(fmt.Sprintf("📏 Шаг страйка: `%s`\n", task.NextStrikeStep.String()))
+	side := "по позиции"
+	if task.TargetSide != "" {
+		side = string(task.TargetSide)
+	}
+	sb.WriteString(fmt.Sprintf("↕️ Сторона: %s\n", side))
+	if key, err := h.keyRepo.GetByID(ctx, task.APIKeyID); err == nil && key != nil {
+		sb.WriteString(fmt.Sprintf("🔑 Ключ: #%d %s (%s)\n", key.ID, key.Label, key.Network()))
+	}
+	sb.WriteString(fmt.Sprintf("🕓 Создана: %s UTC\n", task.CreatedAt.UTC().Format("02.01.2006 15:04")))
+
+	events, err := h.taskRepo.ListTaskEvents(ctx, task.ID, taskDetailsEvents)
+	if err != nil {
+		h.logger.Warn("Failed to list task events", "task_id", task.ID, "err", err)
+	}
+	if len(events) > 0 {
+		sb.WriteString("\nПоследние события:\n")
+		for _, e := range events {
+			sb.WriteString("• " + formatTaskEvent(e) + "\n")
+		}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if actions := taskActionRow(*task, view); len(actions) > 0 {
+		rows = append(rows, actions)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔄 Обновить", taskCallback(callbackTaskDetails, task.ID, view)),
+		tgbotapi.NewInlineKeyboardButtonData("◀️ К списку", taskPageCallback(taskListActive, view.offset)),
+	))
+	return sb.String(), tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+// taskActionRow - кнопки действий над задачей; у задачи посреди ролла их нет
+func taskActionRow(t domain.Task, view taskView) []tgbotapi.InlineKeyboardButton {
+	var toggle tgbotapi.InlineKeyboardButton
+	switch t.Status {
+	case domain.TaskStateIdle:
+		toggle = tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("⏸ #%d", t.ID), taskCallback(callbackPause, t.ID, view))
+	case domain.TaskStatePaused:
+		toggle = tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("▶️ #%d", t.ID), taskCallback(callbackResume, t.ID, view))
+	default:
+		return nil
+	}
+	return tgbotapi.NewInlineKeyboardRow(
+		toggle,
+		tgbotapi.NewInlineKeyboardButtonData("✏️", taskCallback(callbackEdit, t.ID, view)),
+		tgbotapi.NewInlineKeyboardButtonData("🏷", taskCallback(callbackTaskLabel, t.ID, view)),
+		tgbotapi.NewInlineKeyboardButtonData("🗑", taskCallback(callbackDelete, t.ID, view)),
+	)
+}
+
+// formatTaskEvent - строка журнала: "02.01 15:04 ролл A → B, 0.1"
+func formatTaskEvent(e domain.TaskEvent) string {
+	at := e.CreatedAt.UTC().Format("02.01 15:04")
+	switch e.Type {
+	case domain.TaskEventRolled:
+		return fmt.Sprintf("%s 🔁 ролл %s → %s, `%s`", at, e.FromSymbol, e.ToSymbol, e.Qty.String())
+	case domain.TaskEventCompleted:
+		return fmt.Sprintf("%s ✅ завершена: %s", at, e.Details)
+	case domain.TaskEventPaused:
+		return fmt.Sprintf("%s ⏸ пауза: %s", at, e.Details)
+	case domain.TaskEventRollReset:
+		return fmt.Sprintf("%s ↩️ ролл сброшен: %s", at, e.Details)
+	default:
+		return fmt.Sprintf("%s %s %s", at, e.Type, e.Details)
+	}
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+const (
+	usersPageSize       = 20
+	expiringDefaultDays = 3
+	expiringMaxDays     = 90
+)
+
+// cmdUsersAdmin - /users [all|active|expired|banned] [страница]
+func (h *Handler) cmdUsersAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	filter := domain.UserFilterAll
+	page := 1
+	for _, arg := range strings.Fields(msg.CommandArguments()) {
+		if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+			page = n
+			continue
+		}
+		switch f := domain.UserFilter(strings.ToLower(arg)); f {
+		case domain.UserFilterAll, domain.UserFilterActive, domain.UserFilterExpired, domain.UserFilterBanned:
+			filter = f
+		default:
+			h.send(msg.Chat.ID, "Использование: /users [all|active|expired|banned] [страница]")
+			return errAdminUsage
+		}
+	}
+
+	// Берем на одного больше, чтобы понять, есть ли следующая страница
+	users, err := h.userRepo.List(ctx, filter, usersPageSize+1, (page-1)*usersPageSize)
+	if err != nil {
+		h.logger.Error("Failed to list users", "filter", filter, "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения пользователей.")
+		return err
+	}
+	active, err := h.userRepo.CountActive(ctx)
+	if err != nil {
+		h.logger.Error("Failed to count active users", "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения пользователей.")
+		return err
+	}
+
+	hasNext := len(users) > usersPageSize
+	if hasNext {
+		users = users[:usersPageSize]
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("👥 Пользователи (%s), страница %d. Активных подписок: %d\n", filter, page, active))
+	if len(users) == 0 {
+		sb.WriteString("\nНикого нет.")
+	}
+	writeUserRows(&sb, users)
+	if hasNext {
+		sb.WriteString(fmt.Sprintf("\nДальше: /users %s %d", filter, page+1))
+	}
+
+	// Имена пользователей произвольные, поэтому без Markdown
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+	return nil
+}
+
+// cmdExpiringAdmin - /expiring [дней]: подписки, которые скоро закончатся
+func (h *Handler) cmdExpiringAdmin(ctx context.Context, msg *tgbotapi.Message) error {
+	days := expiringDefaultDays
+	if arg := strings.TrimSpace(msg.CommandArguments()); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 || n > expiringMaxDays {
+			h.send(msg.Chat.ID, fmt.Sprintf("Использование: /expiring [дней, от 1 до %d]", expiringMaxDays))
+			return errAdminUsage
+		}
+		days = n
+	}
+
+	users, err := h.userRepo.GetExpiringWithin(ctx, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		h.logger.Error("Failed to fetch expiring users", "days", days, "err", err)
+		h.send(msg.Chat.ID, "Ошибка получения пользователей.")
+		return err
+	}
+	if len(users) == 0 {
+		h.send(msg.Chat.ID, fmt.Sprintf("✅ В ближайшие %d дн. подписки не истекают.", days))
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("⏳ Истекают в ближайшие %d дн.: %d\n", days, len(users)))
+	writeUserRows(&sb, users)
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+	return nil
+}
+
+func writeUserRows(sb *strings.Builder, users []domain.UserSummary) {
+	for _, u := range users {
+		name := "-"
+		if u.Username != "" {
+			name = "@" + u.Username
+		}
+		banned := ""
+		if u.IsBanned {
+			banned = ", 🚫 бан"
+		}
+		if u.BotBlockedAt != nil {
+			banned += ", 🔕 бот заблокирован"
+		}
+		sb.WriteString(fmt.Sprintf("\n#%d %s (tg %d)\n  до %s UTC, задач %d%s\n",
+			u.ID, name, u.TelegramID, u.ExpiresAt.UTC().Format("02.01.2006 15:04"), u.ActiveTasks, banned))
+	}
+}
+
+// cmdBanAdmin - /ban <telegram_id> и /unban <telegram_id>
+func (h *Handler) cmdBanAdmin(ctx context.Context, msg *tgbotapi.Message, banned bool) error {
+	telegramID, err := strconv.ParseInt(strings.TrimSpace(msg.CommandArguments()), 10, 64)
+	if err != nil {
+		h.send(msg.Chat.ID, fmt.Sprintf("Использование: /%s <telegram id>", msg.Command()))
+		return errAdminUsage
+	}
+
+	paused, err := h.userRepo.SetBanned(ctx, telegramID, banned)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		h.send(msg.Chat.ID, "❌ Пользователь не найден.")
+		return err
+	}
+	if err != nil {
+		h.logger.Error("Failed to set banned", "telegram_id", telegramID, "banned", banned, "err", err)
+		h.send(msg.Chat.ID, "Ошибка изменения бана.")
+		return err
+	}
+	h.logger.Info("User ban changed", "telegram_id", telegramID, "banned", banned, "paused_tasks", len(paused))
+
+	if !banned {
+		h.send(msg.Chat.ID, fmt.Sprintf("✅ Пользователь %d разбанен. Приостановленные задачи он возобновит сам.", telegramID))
+		return nil
+	}
+
+	// Мониторинг держит задачи пользователя до следующей сверки
+	h.reloadManager(ctx)
+	h.send(msg.Chat.ID, fmt.Sprintf("🚫 Пользователь %d забанен, приостановлено задач: %d.", telegramID, len(paused)))
+	return nil
+}
@@ -4,39 +4,168 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
 	Env          string
 	BybitTestnet bool
-	Bybit        BybitConfig
-	Database     DatabaseConfig
-	Crypto       CryptoConfig
-	Telegram     TelegramConfig
+	// FakeExchange - биржа в памяти вместо Bybit (по умолчанию в ENV=local)
+	FakeExchange bool
+	// EnforceSubscriptions - не роллировать задачи пользователей с истекшей подпиской
+	// (false - для собственной установки на одного пользователя)
+	EnforceSubscriptions bool
+	Bybit                BybitConfig
+	Database             DatabaseConfig
+	Crypto               CryptoConfig
+	Telegram             TelegramConfig
+	Execution            ExecutionConfig
+	Ticks                TickConfig
+	Worker               WorkerConfig
 }
 
 type BybitConfig struct {
-	BaseURL string
-	Timeout time.Duration
+	BaseURL      string
+	Environment  string // mainnet, testnet или demo
+	Timeout      time.Duration
+	OrderTimeout time.Duration
+	RecvWindow   time.Duration
+
+	// Лимиты запросов в секунду по классам эндпоинтов
+	MarketRPS  float64
+	AccountRPS float64
+	TradeRPS   float64
+
+	// Повторы идемпотентных запросов при временных сбоях
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// Повторы при отказе по лимиту (retCode 10006, HTTP 403)
+	RateLimitRetries int
+	RateLimitMaxWait time.Duration
+
+	// Цена базового актива для триггера: index (по умолчанию), mark или last
+	TriggerPriceSource string
+
+	// Бюджет одного запроса к бирже целиком, с повторами
+	MarketDeadline  time.Duration
+	AccountDeadline time.Duration
+	TradeDeadline   time.Duration
+
+	// Исходящий прокси (http, https, socks5); *_MAINNET/_TESTNET/_DEMO переопределяют общий
+	ProxyURL     string
+	ProxyMainnet string
+	ProxyTestnet string
+	ProxyDemo    string
+
+	// Пул HTTP-соединений
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	HTTP2               bool
+
+	// Адреса WebSocket вместо стандартных (bytick.com, локальный replay); пусто - по умолчанию
+	WSLinearURL         string
+	WSLinearURLTestnet  string
+	WSOptionURL         string
+	WSOptionURLTestnet  string
+	WSPrivateURL        string
+	WSPrivateURLTestnet string
+	WSPrivateURLDemo    string
+
+	// Без тиков по символу дольше StaleTimeout стрим переподключается; 0 - выключено
+	StaleTimeout time.Duration
+
+	// Схлопывание тиков: отбрасывать повторы цены и ограничить частоту событий по символу (0 - без ограничения)
+	DedupeTicks       bool
+	MaxTicksPerSecond int
+
+	// Запасной источник цены для триггера (пусто - выключен, binance - стрим Binance Futures).
+	// Переключение по символу срабатывает от сторожа тишины, поэтому нужен StaleTimeout > 0.
+	PriceFallback    string
+	BinanceStreamURL string
+
+	// Debug - логировать запросы к Bybit (секреты маскируются)
+	Debug bool
+}
+
+// ExecutionConfig - способ исполнения ордеров ролла
+type ExecutionConfig struct {
+	Mode                    string // ioc или chase
+	ChaseInterval           time.Duration
+	ChaseStepPercent        float64
+	ChaseMaxDistancePercent float64
+	// MMR аккаунта в процентах, с которого ролл не начинается, а Leg 2 ждет, пока маржа освободится
+	MaxAccountMMRPercent float64
+}
+
+// WorkerConfig - пул воркеров, выполняющих роллы
+type WorkerConfig struct {
+	Count             int
+	QueueSize         int
+	ReconcileInterval time.Duration // Сверка кэша задач Manager с БД
+	DrainTimeout      time.Duration // Ожидание начатых роллов при остановке
+	PollInterval      time.Duration // Опрос цен по REST при падении стрима; 0 - выключен
+	FailureLimit      int           // Ошибок ролла подряд за FailureWindow до остановки задачи
+	FailureWindow     time.Duration
+}
+
+// TickConfig - запись тиков для разбора инцидентов и их воспроизведение в локальном режиме
+type TickConfig struct {
+	RecordDir     string // Пусто - запись выключена
+	BufferSize    int
+	FlushInterval time.Duration
+	Retention     time.Duration
+
+	ReplayFile  string  // NDJSON-запись, которую проигрывать вместо фейкового стрима (только FAKE_EXCHANGE)
+	ReplaySpeed float64 // 1 - в реальном времени
 }
 
 type DatabaseConfig struct {
+	// Driver - postgres или sqlite (локальная разработка без Postgres, файл Path)
+	Driver string
+	Path   string
+
 	Host     string
 	Port     int
 	User     string
 	Password string
 	DBName   string
 	SSLMode  string
+	// AutoMigrate - накатывать вшитые миграции при старте бота
+	AutoMigrate bool
+
+	// Ожидание базы при старте
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+	ConnectTimeout  time.Duration
+
+	// Лимиты на один запрос к базе
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Порог медленного запроса для лога
+	SlowQueryThreshold time.Duration
+	// Предел, сколько ролл держит лок задачи
+	TaskLockMaxHold time.Duration
 }
 
 type CryptoConfig struct {
 	EncryptionKey string
+	// KeyVersion - версия EncryptionKey, пишется в api_keys.key_version
+	KeyVersion int
 }
 
 type TelegramConfig struct {
 	BotToken string
 	AdminID  int64
+
+	// Уведомления из роллера и воркеров: отправители очереди и ее размер
+	NotifyWorkers   int
+	NotifyQueueSize int
+
+	// MMR аккаунта в процентах, с которого "💰 Баланс" показывается с предупреждением
+	MMRWarnPercent float64
 }
 
 func (d *DatabaseConfig) ConnectString() string {
@@ -50,6 +179,14 @@ func LoadConfig() (*Config, error) {
 	env := getEnv("ENV", "local")
 	testnet := getEnvBool("BYBIT_TESTNET", true)
 
+	// BYBIT_ENV приоритетнее старого флага BYBIT_TESTNET
+	defaultEnv := "mainnet"
+	if testnet {
+		defaultEnv = "testnet"
+	}
+	bybitEnv := getEnv("BYBIT_ENV", defaultEnv)
+	testnet = bybitEnv == "testnet"
+
 	timeoutStr := getEnv("BYBIT_TIMEOUT_SECONDS", "5")
 	timeoutSec, _ := strconv.Atoi(timeoutStr)
 	if timeoutSec == 0 {
@@ -57,34 +194,185 @@ func LoadConfig() (*Config, error) {
 	}
 
 	bybitConfig := BybitConfig{
-		Timeout: time.Duration(timeoutSec) * time.Second,
+		Environment:  bybitEnv,
+		Timeout:      time.Duration(timeoutSec) * time.Second,
+		OrderTimeout: time.Duration(getEnvInt("BYBIT_ORDER_TIMEOUT_SECONDS", 10)) * time.Second,
+		RecvWindow:   time.Duration(getEnvInt("BYBIT_RECV_WINDOW_MS", 5000)) * time.Millisecond,
+
+		MarketRPS:  getEnvFloat("BYBIT_MARKET_RPS", 20),
+		AccountRPS: getEnvFloat("BYBIT_ACCOUNT_RPS", 10),
+		TradeRPS:   getEnvFloat("BYBIT_TRADE_RPS", 10),
+
+		RetryAttempts:  getEnvInt("BYBIT_RETRY_ATTEMPTS", 3),
+		RetryBaseDelay: time.Duration(getEnvInt("BYBIT_RETRY_BASE_DELAY_MS", 200)) * time.Millisecond,
+		RetryMaxDelay:  time.Duration(getEnvInt("BYBIT_RETRY_MAX_DELAY_MS", 2000)) * time.Millisecond,
+
+		RateLimitRetries: getEnvInt("BYBIT_RATE_LIMIT_RETRIES", 3),
+		RateLimitMaxWait: time.Duration(getEnvInt("BYBIT_RATE_LIMIT_MAX_WAIT_MS", 10000)) * time.Millisecond,
+
+		TriggerPriceSource: getEnv("TRIGGER_PRICE_SOURCE", "index"),
+
+		MarketDeadline:  time.Duration(getEnvInt("BYBIT_MARKET_DEADLINE_MS", 3000)) * time.Millisecond,
+		AccountDeadline: time.Duration(getEnvInt("BYBIT_ACCOUNT_DEADLINE_MS", 5000)) * time.Millisecond,
+		TradeDeadline:   time.Duration(getEnvInt("BYBIT_TRADE_DEADLINE_MS", 10000)) * time.Millisecond,
+
+		ProxyURL:     getEnv("BYBIT_PROXY_URL", ""),
+		ProxyMainnet: getEnv("BYBIT_PROXY_URL_MAINNET", ""),
+		ProxyTestnet: getEnv("BYBIT_PROXY_URL_TESTNET", ""),
+		ProxyDemo:    getEnv("BYBIT_PROXY_URL_DEMO", ""),
+
+		MaxIdleConnsPerHost: getEnvInt("BYBIT_MAX_IDLE_CONNS_PER_HOST", 32),
+		IdleConnTimeout:     time.Duration(getEnvInt("BYBIT_IDLE_CONN_TIMEOUT_SECONDS", 90)) * time.Second,
+		HTTP2:               getEnvBool("BYBIT_HTTP2", true),
+
+		WSLinearURL:         getEnv("BYBIT_WS_LINEAR_URL", ""),
+		WSLinearURLTestnet:  getEnv("BYBIT_WS_LINEAR_URL_TESTNET", ""),
+		WSOptionURL:         getEnv("BYBIT_WS_OPTION_URL", ""),
+		WSOptionURLTestnet:  getEnv("BYBIT_WS_OPTION_URL_TESTNET", ""),
+		WSPrivateURL:        getEnv("BYBIT_WS_PRIVATE_URL", ""),
+		WSPrivateURLTestnet: getEnv("BYBIT_WS_PRIVATE_URL_TESTNET", ""),
+		WSPrivateURLDemo:    getEnv("BYBIT_WS_PRIVATE_URL_DEMO", ""),
+
+		StaleTimeout: time.Duration(getEnvInt("BYBIT_WS_STALE_SECONDS", 30)) * time.Second,
+
+		DedupeTicks:       getEnvBool("BYBIT_WS_DEDUPE_TICKS", true),
+		MaxTicksPerSecond: getEnvInt("BYBIT_WS_MAX_TICKS_PER_SECOND", 0),
+
+		PriceFallback:    getEnv("PRICE_FALLBACK", ""),
+		BinanceStreamURL: getEnv("BINANCE_WS_URL", "wss://fstream.binance.com/ws"),
+
+		Debug: getEnvBool("BYBIT_DEBUG", false),
 	}
 
 	dbConfig := DatabaseConfig{
+		Driver: getEnv("DB_DRIVER", "postgres"),
+		Path:   getEnv("DB_PATH", "roller.db"),
+
 		Host:     getEnv("DB_HOST", "localhost"),
 		Port:     getEnvInt("DB_PORT", 5432),
 		User:     getEnv("DB_USER", "bybit_roller"),
 		Password: getEnv("DB_PASSWORD", "secret_password"),
 		DBName:   getEnv("DB_NAME", "bybit_roller"),
 		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+
+		AutoMigrate: getEnvBool("DB_AUTO_MIGRATE", true),
+
+		ConnectAttempts: getEnvInt("DB_CONNECT_ATTEMPTS", 10),
+		ConnectBackoff:  time.Duration(getEnvInt("DB_CONNECT_BACKOFF_MS", 1000)) * time.Millisecond,
+		ConnectTimeout:  time.Duration(getEnvInt("DB_CONNECT_TIMEOUT_SECONDS", 60)) * time.Second,
+
+		ReadTimeout:  time.Duration(getEnvInt("DB_READ_TIMEOUT_MS", 3000)) * time.Millisecond,
+		WriteTimeout: time.Duration(getEnvInt("DB_WRITE_TIMEOUT_MS", 5000)) * time.Millisecond,
+
+		SlowQueryThreshold: time.Duration(getEnvInt("DB_SLOW_QUERY_MS", 500)) * time.Millisecond,
+		TaskLockMaxHold:    time.Duration(getEnvInt("DB_TASK_LOCK_MAX_HOLD_MINUTES", 15)) * time.Minute,
 	}
 
 	cryptoConfig := CryptoConfig{
 		EncryptionKey: getEnv("ENCRYPTION_KEY", ""),
+		KeyVersion:    getEnvInt("ENCRYPTION_KEY_VERSION", 1),
 	}
 
 	telegramConfig := TelegramConfig{
 		BotToken: getEnv("TELEGRAM_BOT_TOKEN", ""),
 		AdminID:  getEnvInt64("ADMIN_TELEGRAM_ID", 0),
+
+		NotifyWorkers:   getEnvInt("TELEGRAM_NOTIFY_WORKERS", 2),
+		NotifyQueueSize: getEnvInt("TELEGRAM_NOTIFY_QUEUE", 1000),
+
+		MMRWarnPercent: getEnvFloat("BALANCE_MMR_WARN_PERCENT", 50),
+	}
+
+	switch bybitConfig.TriggerPriceSource {
+	case "index", "mark", "last":
+	default:
+		return nil, fmt.Errorf("invalid TRIGGER_PRICE_SOURCE %q: expected index, mark or last", bybitConfig.TriggerPriceSource)
+	}
+
+	executionConfig := ExecutionConfig{
+		Mode:                    getEnv("EXECUTION_MODE", "ioc"),
+		ChaseInterval:           time.Duration(getEnvInt("CHASE_INTERVAL_SECONDS", 3)) * time.Second,
+		ChaseStepPercent:        getEnvFloat("CHASE_STEP_PERCENT", 2),
+		ChaseMaxDistancePercent: getEnvFloat("CHASE_MAX_DISTANCE_PERCENT", 10),
+		MaxAccountMMRPercent:    getEnvFloat("ROLL_MAX_ACCOUNT_MMR_PERCENT", 90),
+	}
+
+	for name, value := range map[string]string{
+		"BYBIT_WS_LINEAR_URL":          bybitConfig.WSLinearURL,
+		"BYBIT_WS_LINEAR_URL_TESTNET":  bybitConfig.WSLinearURLTestnet,
+		"BYBIT_WS_OPTION_URL":          bybitConfig.WSOptionURL,
+		"BYBIT_WS_OPTION_URL_TESTNET":  bybitConfig.WSOptionURLTestnet,
+		"BYBIT_WS_PRIVATE_URL":         bybitConfig.WSPrivateURL,
+		"BYBIT_WS_PRIVATE_URL_TESTNET": bybitConfig.WSPrivateURLTestnet,
+		"BYBIT_WS_PRIVATE_URL_DEMO":    bybitConfig.WSPrivateURLDemo,
+	} {
+		if value != "" && !strings.HasPrefix(value, "ws://") && !strings.HasPrefix(value, "wss://") {
+			return nil, fmt.Errorf("invalid %s %q: expected ws:// or wss:// URL", name, value)
+		}
+	}
+
+	if bybitConfig.PriceFallback != "" && bybitConfig.PriceFallback != "binance" {
+		return nil, fmt.Errorf("invalid PRICE_FALLBACK %q: expected empty or binance", bybitConfig.PriceFallback)
+	}
+
+	if mmr := telegramConfig.MMRWarnPercent; mmr <= 0 || mmr > 100 {
+		return nil, fmt.Errorf("invalid BALANCE_MMR_WARN_PERCENT %v: expected (0, 100]", mmr)
+	}
+
+	if executionConfig.Mode != "ioc" && executionConfig.Mode != "chase" {
+		return nil, fmt.Errorf("invalid EXECUTION_MODE %q: expected ioc or chase", executionConfig.Mode)
+	}
+	if mmr := executionConfig.MaxAccountMMRPercent; mmr <= 0 || mmr > 100 {
+		return nil, fmt.Errorf("invalid ROLL_MAX_ACCOUNT_MMR_PERCENT %v: expected (0, 100]", mmr)
+	}
+
+	tickConfig := TickConfig{
+		RecordDir:     getEnv("TICK_RECORD_DIR", ""),
+		BufferSize:    getEnvInt("TICK_RECORD_BUFFER", 10000),
+		FlushInterval: time.Duration(getEnvInt("TICK_RECORD_FLUSH_SECONDS", 5)) * time.Second,
+		Retention:     time.Duration(getEnvInt("TICK_RECORD_RETENTION_DAYS", 7)) * 24 * time.Hour,
+		ReplayFile:    getEnv("TICK_REPLAY_FILE", ""),
+		ReplaySpeed:   getEnvFloat("TICK_REPLAY_SPEED", 1),
+	}
+
+	workerConfig := WorkerConfig{
+		Count:     getEnvInt("WORKER_COUNT", 5),
+		QueueSize: getEnvInt("JOB_QUEUE_SIZE", 100),
+
+		ReconcileInterval: time.Duration(getEnvInt("TASK_RECONCILE_SECONDS", 60)) * time.Second,
+		DrainTimeout:      time.Duration(getEnvInt("WORKER_DRAIN_SECONDS", 30)) * time.Second,
+		PollInterval:      time.Duration(getEnvInt("REST_FALLBACK_POLL_SECONDS", 5)) * time.Second,
+		FailureLimit:      getEnvInt("TASK_FAILURE_LIMIT", 5),
+		FailureWindow:     time.Duration(getEnvInt("TASK_FAILURE_WINDOW_MINUTES", 10)) * time.Minute,
+	}
+	if workerConfig.Count < 1 || workerConfig.Count > 100 {
+		return nil, fmt.Errorf("invalid WORKER_COUNT %d: expected 1..100", workerConfig.Count)
+	}
+	if workerConfig.QueueSize < 1 {
+		return nil, fmt.Errorf("invalid JOB_QUEUE_SIZE %d: must be positive", workerConfig.QueueSize)
+	}
+	if workerConfig.FailureLimit < 1 {
+		return nil, fmt.Errorf("invalid TASK_FAILURE_LIMIT %d: must be positive", workerConfig.FailureLimit)
+	}
+	if workerConfig.FailureWindow <= 0 {
+		return nil, fmt.Errorf("invalid TASK_FAILURE_WINDOW_MINUTES: must be positive")
+	}
+	if workerConfig.PollInterval < 0 {
+		return nil, fmt.Errorf("invalid REST_FALLBACK_POLL_SECONDS %v: must not be negative", workerConfig.PollInterval)
 	}
 
 	return &Config{
-		Env:          env,
-		BybitTestnet: testnet,
-		Bybit:        bybitConfig,
-		Database:     dbConfig,
-		Crypto:       cryptoConfig,
-		Telegram:     telegramConfig,
+		Env:                  env,
+		BybitTestnet:         testnet,
+		FakeExchange:         getEnvBool("FAKE_EXCHANGE", env == "local"),
+		EnforceSubscriptions: getEnvBool("SUBSCRIPTION_ENFORCEMENT", true),
+		Bybit:                bybitConfig,
+		Database:             dbConfig,
+		Crypto:               cryptoConfig,
+		Telegram:             telegramConfig,
+		Execution:            executionConfig,
+		Ticks:                tickConfig,
+		Worker:               workerConfig,
 	}, nil
 }
 
@@ -123,4 +411,14 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		v, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return v
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,77 @@
+package domain
+
+import "time"
+
+// Режимы маржи UTA
+const (
+	MarginModeRegular   = "REGULAR_MARGIN"
+	MarginModeIsolated  = "ISOLATED_MARGIN"
+	MarginModePortfolio = "PORTFOLIO_MARGIN"
+)
+
+// unifiedMarginStatus: 1 - классический аккаунт, 2+ - Unified Trading (разные поколения UTA)
+const unifiedStatusClassic = 1
+
+// AccountInfo - настройки аккаунта из /v5/account/info
+type AccountInfo struct {
+	UnifiedMarginStatus int
+	MarginMode          string
+}
+
+// AccountIssue - причина, по которой аккаунт не может торговать опционами через бота
+type AccountIssue string
+
+const (
+	AccountIssueNotUnified     AccountIssue = "not_unified"
+	AccountIssueIsolatedMargin AccountIssue = "isolated_margin"
+)
+
+// Issues возвращает пустой список, если аккаунт готов к торговле опционами
+func (a AccountInfo) Issues() []AccountIssue {
+	var issues []AccountIssue
+	if a.UnifiedMarginStatus <= unifiedStatusClassic {
+		issues = append(issues, AccountIssueNotUnified)
+	}
+	// В изолированной марже UTA опционы недоступны
+	if a.MarginMode == MarginModeIsolated {
+		issues = append(issues, AccountIssueIsolatedMargin)
+	}
+	return issues
+}
+
+// Права ключа, без которых бот не может ролловать опционы
+const (
+	PermissionGroupOptions = "Options"
+	PermissionOptionsTrade = "OptionsTrade"
+)
+
+// APIKeyInfo - свойства ключа из /v5/user/query-api
+type APIKeyInfo struct {
+	ReadOnly    bool
+	Permissions map[string][]string // группа -> права, например "Options": ["OptionsTrade"]
+	ExpiresAt   time.Time           // нулевое значение - бессрочный ключ (привязан к IP)
+	Unified     bool
+	AccountUID  int64 // UID аккаунта (субаккаунта) Bybit, которому принадлежит ключ
+}
+
+// HasPermission проверяет право в группе
+func (k APIKeyInfo) HasPermission(group, permission string) bool {
+	for _, p := range k.Permissions[group] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingPermissions возвращает недостающие права в виде "Группа:Право" (пусто - ключ подходит)
+func (k APIKeyInfo) MissingPermissions() []string {
+	var missing []string
+	if k.ReadOnly {
+		missing = append(missing, "Read-Write")
+	}
+	if !k.HasPermission(PermissionGroupOptions, PermissionOptionsTrade) {
+		missing = append(missing, PermissionGroupOptions+":"+PermissionOptionsTrade)
+	}
+	return missing
+}
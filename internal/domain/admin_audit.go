@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// AdminAuditEntry - команда администратора в боте. Details - аргументы команды как есть,
+// Result - итог выполнения: "ok" или текст ошибки.
+type AdminAuditEntry struct {
+	ID              int64
+	AdminTelegramID int64
+	Action          string
+	Details         string
+	Result          string
+	CreatedAt       time.Time
+}
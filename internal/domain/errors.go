@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+// Ошибки биржи, на которые завязана логика ретраев и статусов задач.
+// Адаптеры биржи должны отдавать их через errors.Is.
+var (
+	ErrInsufficientMargin   = errors.New("insufficient margin")
+	ErrDuplicateOrderLinkID = errors.New("duplicate order link id")
+	ErrRateLimited          = errors.New("rate limited")
+	ErrInvalidSymbol        = errors.New("invalid symbol")
+	ErrExchangeUnavailable  = errors.New("exchange temporarily unavailable")
+	// ErrInvalidCredentials - биржа не приняла ключ или подпись: опечатка в ключе, секрете или сети
+	ErrInvalidCredentials = errors.New("invalid api credentials")
+	// ErrAccountNotReady - аккаунт или ключ не позволяют торговать опционами (не UTA, нет прав)
+	ErrAccountNotReady = errors.New("account not ready for options trading")
+	// ErrExchangeTimeout - биржа не уложилась в бюджет операции (в отличие от context.Canceled при остановке бота)
+	ErrExchangeTimeout = errors.New("exchange operation timed out")
+	// ErrOrderNotFound - биржа не знает ордера с таким orderLinkId
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrDatabaseTimeout - запрос к базе не уложился в лимит операции
+	ErrDatabaseTimeout = errors.New("database operation timed out")
+	// ErrAccountMarginHigh - MMR аккаунта у границы ликвидации: ролл или Leg 2 отложены
+	ErrAccountMarginHigh = errors.New("account maintenance margin too high")
+)
+
+// Ошибки операций пользователя над задачами
+var (
+	// ErrTaskLocked - задачу сейчас выполняет другой экземпляр бота
+	ErrTaskLocked = errors.New("task is locked by another instance")
+	// ErrTaskLockLost - лок задачи потерян (соединение с базой оборвалось) или держался дольше предела:
+	// ролл прерван, задачу доведет восстановление
+	ErrTaskLockLost = errors.New("task lock lost")
+	// ErrTaskNotFound - задачи нет, она удалена или принадлежит другому пользователю
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskMidRoll - задача посреди ролла, ее нельзя удалять или менять до завершения
+	ErrTaskMidRoll = errors.New("task is in the middle of a roll")
+	// ErrVersionConflict - задачу изменили после чтения (optimistic locking по version)
+	ErrVersionConflict = errors.New("task version conflict")
+	// ErrUserNotFound - пользователя с таким telegram_id нет
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// ErrUnknownKeyVersion - API ключ зашифрован ключом шифрования, которого нет в конфигурации;
+// пользователю нужно ввести ключи заново
+var ErrUnknownKeyVersion = errors.New("api key encrypted with unknown key version")
+
+// Ошибки активации и отзыва лицензий
+var (
+	ErrLicenseNotFound = errors.New("license not found")
+	ErrLicenseRedeemed = errors.New("license already redeemed")
+	ErrLicenseRevoked  = errors.New("license revoked")
+	ErrLicenseExpired  = errors.New("license expired")
+	// ErrLicenseStackLimit - подписка после активации ушла бы дальше MaxSubscriptionAhead
+	ErrLicenseStackLimit = errors.New("subscription stacking limit exceeded")
+)
+
+// IsTransient - ошибка временная, задачу можно повторить позже, а не переводить в FAILED
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrExchangeUnavailable) ||
+		errors.Is(err, ErrExchangeTimeout) ||
+		errors.Is(err, ErrDatabaseTimeout) ||
+		// Leg 2 отложена по марже: повторим, когда маржа освободится
+		errors.Is(err, ErrAccountMarginHigh) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
@@ -10,14 +10,67 @@ import (
 type TaskRepository interface {
 	CreateTask(ctx context.Context, task *Task) error
 	GetTaskByID(ctx context.Context, id int64) (*Task, error)
+	// GetActiveTasks - задачи для мониторинга; задачи забаненных и пользователей
+	// с истекшей подпиской сюда не попадают
 	GetActiveTasks(ctx context.Context) ([]Task, error)
 	GetActiveTasksByUserID(ctx context.Context, userID int64) ([]Task, error)
+	GetTasksByState(ctx context.Context, states ...TaskState) ([]Task, error)
+	// ListTasks - страница задач пользователя; limit <= 0 - без ограничения
+	ListTasks(ctx context.Context, userID int64, filter TaskFilter, limit, offset int) ([]Task, error)
+	// GetTasksByAPIKeyID - неудаленные задачи ключа в любом статусе, по возрастанию ID
+	GetTasksByAPIKeyID(ctx context.Context, apiKeyID int64) ([]Task, error)
+	// ListTaskEvents - последние события задачи, новые первыми
+	ListTaskEvents(ctx context.Context, taskID int64, limit int) ([]TaskEvent, error)
+	// PauseTasksOfExpiredUsers ставит на паузу IDLE задачи пользователей с истекшей подпиской и возвращает их
+	PauseTasksOfExpiredUsers(ctx context.Context) ([]Task, error)
+	// BulkPauseByUser ставит на паузу IDLE задачи пользователей и возвращает их
+	BulkPauseByUser(ctx context.Context, userIDs []int64, reason string) ([]Task, error)
+	// BulkComplete завершает задачи из ids, все еще стоящие в статусе from, и возвращает ID завершенных
+	BulkComplete(ctx context.Context, ids []int64, from TaskState, reason string) ([]int64, error)
+	// ReassignTasksToKey переводит задачи в IDLE и PAUSED на другой ключ того же пользователя; возвращает их число
+	ReassignTasksToKey(ctx context.Context, oldKeyID, newKeyID int64) (int64, error)
 
 	UpdateTaskState(ctx context.Context, id int64, newState TaskState, version int64) error
 	UpdateTaskSymbol(ctx context.Context, id int64, newSymbol string, newQty decimal.Decimal, version int64) error
+	// FinalizeRoll одной транзакцией переводит задачу на новый символ (IDLE) и пишет событие ролла
+	FinalizeRoll(ctx context.Context, p FinalizeRollParams) error
+	// UpdateTaskStateWithEvent одной транзакцией меняет статус задачи и пишет событие с причиной
+	UpdateTaskStateWithEvent(ctx context.Context, id int64, newState TaskState, version int64, event TaskEventType, details string) error
+	UpdatePremiumAlert(ctx context.Context, id int64, threshold decimal.Decimal) error
+	// UpdateTaskLabel меняет название задачи пользователя: ErrTaskNotFound
+	UpdateTaskLabel(ctx context.Context, id, userID int64, label string) error
+	// UpdateTaskParams меняет триггер и шаг страйка задачи в IDLE или PAUSED: ErrTaskNotFound, ErrTaskMidRoll
+	UpdateTaskParams(ctx context.Context, id int64, trigger, step decimal.Decimal, version int64) error
+	// PauseTask ставит на паузу задачу в IDLE, ResumeTask возвращает PAUSED в IDLE
+	PauseTask(ctx context.Context, id int64, version int64) error
+	ResumeTask(ctx context.Context, id int64, version int64) error
+	// DeleteTask мягко удаляет задачу пользователя: ErrTaskNotFound, ErrTaskMidRoll
+	DeleteTask(ctx context.Context, id int64, userID int64) error
 	
 	SaveError(ctx context.Context, id int64, errMessage string) error
-	RegisterError(ctx context.Context, id int64, err error) error
+	// RegisterError пишет ошибку ролла задачи версии version: временная - IDLE, иначе FAILED
+	RegisterError(ctx context.Context, id int64, version int64, err error) error
+
+	// WithOptimisticRetry перечитывает задачу и вызывает fn, пока fn возвращает ErrVersionConflict
+	// (не больше attempts раз). Только для некритичных правок пользователя: триггер, шаг, пауза.
+	// Роллер его не использует: конфликт версии там значит, что задачей занялся другой ролл,
+	// и повтор отправил бы ордера второй раз.
+	WithOptimisticRetry(ctx context.Context, taskID int64, attempts int, fn func(task *Task) error) error
+
+	// WithTaskLock выполняет fn, пока держит межпроцессный лок задачи; лок занят - ErrTaskLocked, fn не вызывается
+	WithTaskLock(ctx context.Context, id int64, fn func(ctx context.Context) error) error
+}
+
+// OrderRepository - журнал ордеров, отправленных на биржу
+type OrderRepository interface {
+	// Record пишет ордер перед отправкой (статус OrderJournalPending); повтор того же OrderLinkID не дублирует строку
+	Record(ctx context.Context, rec *OrderRecord) error
+	MarkSent(ctx context.Context, orderLinkID, exchangeOrderID string) error
+	MarkFailed(ctx context.Context, orderLinkID string, errMessage string) error
+	// UpdateFromExchange переносит в журнал состояние ордера на бирже (статус, цена, исполнение)
+	UpdateFromExchange(ctx context.Context, order Order) error
+	GetByOrderLinkID(ctx context.Context, orderLinkID string) (*OrderRecord, error)
+	ListByUserID(ctx context.Context, userID int64, limit int) ([]OrderRecord, error)
 }
 
 type APIKeyRepository interface {
@@ -27,32 +80,91 @@ type APIKeyRepository interface {
     // ДОБАВЛЯЕМ (эти методы используются в боте):
     Create(ctx context.Context, apiKey *APIKey) error
     GetActiveByUserID(ctx context.Context, userID int64) (*APIKey, error)
+
+	// Update заменяет ключ и секрет записи на месте (ротация): задачи продолжают ссылаться на тот же ID.
+	// Пока задача ключа посреди ролла - ErrTaskMidRoll.
+	Update(ctx context.Context, id int64, newKey, newSecret string, expiresAt time.Time, accountUID int64) error
+
+	// GetByUserID - все неудаленные ключи пользователя; нерасшифрованные помечены Undecryptable
+	GetByUserID(ctx context.Context, userID int64) ([]APIKey, error)
+	// Invalidate и Delete выключают ключ и в той же транзакции ставят на паузу его задачи в IDLE;
+	// возвращают приостановленные задачи
+	Invalidate(ctx context.Context, id int64) ([]Task, error)
+	Delete(ctx context.Context, id int64) ([]Task, error)
+	SetLabel(ctx context.Context, id int64, label string) error
 }
 
 // ДОБАВЛЯЕМ НОВЫЙ ИНТЕРФЕЙС (его не было, а бот его использует)
 type LicenseRepository interface {
-    Generate(ctx context.Context, durationDays int) (*LicenseKey, error)
-    Redeem(ctx context.Context, code string, userID int64) error
+    // Generate: validUntil nil - код можно активировать бессрочно
+    Generate(ctx context.Context, durationDays int, validUntil *time.Time) (*LicenseKey, error)
+    // Redeem продлевает подписку и возвращает новую дату окончания.
+    // Ошибки: ErrLicenseNotFound, ErrLicenseRedeemed, ErrLicenseRevoked, ErrLicenseExpired, ErrLicenseStackLimit
+    Redeem(ctx context.Context, code string, userID int64) (time.Time, error)
+    List(ctx context.Context, onlyUnredeemed bool) ([]LicenseKey, error)
+    Revoke(ctx context.Context, code string) error
+}
+
+// AdminAuditRepository - журнал команд администратора
+type AdminAuditRepository interface {
+	Record(ctx context.Context, entry *AdminAuditEntry) error
 }
 
 type ExchangeAdapter interface {
 	GetIndexPrice(ctx context.Context, symbol string) (decimal.Decimal, error)
 	GetMarkPrice(ctx context.Context, symbol string) (decimal.Decimal, error)
+	// GetUnderlyingPrice - цена базового актива по полю source его тикера (index, mark или last)
+	GetUnderlyingPrice(ctx context.Context, symbol string, source PriceSource) (decimal.Decimal, error)
+	GetOrderbook(ctx context.Context, symbol string, depth int) (Orderbook, error)
 	GetPosition(ctx context.Context, creds APIKey, symbol string) (Position, error)
 	GetPositions(ctx context.Context, creds APIKey) ([]Position, error) // <--- Убедитесь, что этот тоже тут
+	GetMarginInfo(ctx context.Context, creds APIKey) (MarginInfo, error)
+	GetAccountInfo(ctx context.Context, creds APIKey) (AccountInfo, error)
+	GetAPIKeyInfo(ctx context.Context, creds APIKey) (APIKeyInfo, error)
+	GetClosedPnL(ctx context.Context, creds APIKey, startTime, endTime time.Time) ([]ClosedPnL, error)
 	PlaceOrder(ctx context.Context, creds APIKey, req OrderRequest) (string, error)
+	PlaceBatchOrders(ctx context.Context, creds APIKey, reqs []OrderRequest) ([]BatchOrderResult, error)
+	AmendOrder(ctx context.Context, creds APIKey, symbol, orderLinkID string, newPrice, newQty decimal.Decimal) error
+	CancelOrder(ctx context.Context, creds APIKey, symbol, orderLinkID string) error
+	GetOrder(ctx context.Context, creds APIKey, symbol, orderLinkID string) (Order, error)
 	GetOptionStrikes(ctx context.Context, baseCoin string, expiryDate string) ([]decimal.Decimal, error)
+	GetOptionExpiries(ctx context.Context, baseCoin string) ([]OptionExpiry, error)
+	GetOptionChain(ctx context.Context, baseCoin string, expiryDate string) ([]OptionQuote, error)
+	GetDeliveryPrice(ctx context.Context, baseCoin, symbol string) (decimal.Decimal, error)
+}
+
+// QueryStatsSource отдает счетчики запросов к базе для админки
+type QueryStatsSource interface {
+	QueryStats() []QueryStat
 }
 
+// NotificationService доставляет пользователю сообщение (простой текст). userID - внутренний ID
+// пользователя, не Telegram. Не блокирует: отправка идет в фоне, ошибка - только если сообщение не принято.
 type NotificationService interface {
 	NotifyUser(userID int64, message string) error
+	// NotifyAdmin - то же для администратора бота; без настроенного администратора сообщение отбрасывается
+	NotifyAdmin(message string) error
 }
 
 type UserRepository interface {
-	Create(ctx context.Context, user *User) error
+	// GetOrCreate создает пользователя или обновляет username существующего; user заполняется сохраненной строкой
+	GetOrCreate(ctx context.Context, user *User) error
 	GetByTelegramID(ctx context.Context, telegramID int64) (*User, error)
-	UpdateSubscription(ctx context.Context, telegramID int64, expiresAt time.Time) error
+	GetByID(ctx context.Context, id int64) (*User, error)
+	// MarkBotBlocked отмечает, что пользователь заблокировал бота; отметку снимает GetOrCreate (/start)
+	MarkBotBlocked(ctx context.Context, id int64) error
+	// ExtendSubscription продлевает подписку в транзакции; ErrUserNotFound, ErrLicenseStackLimit
+	ExtendSubscription(ctx context.Context, telegramID int64, days int) (time.Time, error)
 	IsActive(ctx context.Context, telegramID int64) (bool, error)
+	// SetBanned в одной транзакции с баном ставит на паузу IDLE задачи пользователя и возвращает их.
+	// Разбан задачи не возобновляет: пользователь делает это сам.
+	SetBanned(ctx context.Context, telegramID int64, banned bool) ([]Task, error)
+
+	// Админка
+	List(ctx context.Context, filter UserFilter, limit, offset int) ([]UserSummary, error)
+	CountActive(ctx context.Context) (int, error)
+	// GetExpiringWithin - действующие подписки, истекающие в ближайшие d, раньше истекающие первыми
+	GetExpiringWithin(ctx context.Context, d time.Duration) ([]UserSummary, error)
 }
 
 type MarketProvider interface {
@@ -61,6 +173,25 @@ type MarketProvider interface {
 }
 
 type MarketStreamer interface {
-    Subscribe(symbols []string) (<-chan PriceUpdateEvent, error)
+	// Subscribe запускает стрим; отмена ctx останавливает его так же, как Close
+	Subscribe(ctx context.Context, symbols []string) (<-chan PriceUpdateEvent, error)
+	// SubscribeSymbol - отдельный канал одного потребителя по одному символу; unsubscribe закрывает его
+	SubscribeSymbol(symbol string) (ch <-chan PriceUpdateEvent, unsubscribe func(), err error)
 	AddSubscriptions(symbols []string) error
-}
\ No newline at end of file
+	RemoveSubscriptions(symbols []string) error
+	// GetLastPrice - последняя цена символа из стрима без REST; по времени тика вызывающий решает, не устарела ли она
+	GetLastPrice(symbol string) (decimal.Decimal, time.Time, bool)
+	// Status - соединения стрима: аптайм, последний RTT пинга
+	Status() StreamStatus
+	// Health - предупреждения о молчащих символах; канал не закрывается
+	Health() <-chan StreamHealthEvent
+	// Close останавливает стрим и закрывает канал событий
+	Close() error
+}
+// AccountStreamer - приватные обновления ордеров и позиций по API ключам
+type AccountStreamer interface {
+	Watch(ctx context.Context, creds APIKey) error
+	Unwatch(apiKeyID int64)
+	OrderUpdates() <-chan OrderUpdateEvent
+	PositionUpdates() <-chan PositionUpdateEvent
+}
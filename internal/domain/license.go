@@ -9,6 +9,30 @@ type LicenseKey struct {
 	IsRedeemed   bool
 	RedeemedBy   *int64
 	RedeemedAt   *time.Time
+	RevokedAt    *time.Time
+	ValidUntil   *time.Time // nil - код можно активировать бессрочно
 	CreatedBy    string
 	CreatedAt    time.Time
-}
\ No newline at end of file
+}
+
+// MaxSubscriptionAhead - насколько вперед можно накопить подписку активациями кодов
+const MaxSubscriptionAhead = 400 * 24 * time.Hour
+
+// ExtendSubscription - новая дата окончания подписки: срок кода добавляется к оставшейся
+// подписке, а для истекшей - от текущего момента
+func ExtendSubscription(current, now time.Time, durationDays int) (time.Time, error) {
+	from := now
+	if current.After(now) {
+		from = current
+	}
+	expiresAt := from.Add(time.Duration(durationDays) * 24 * time.Hour)
+	if expiresAt.Sub(now) > MaxSubscriptionAhead {
+		return time.Time{}, ErrLicenseStackLimit
+	}
+	return expiresAt, nil
+}
+
+// IsExpired - срок активации кода прошел
+func (l *LicenseKey) IsExpired(now time.Time) bool {
+	return l.ValidUntil != nil && !now.Before(*l.ValidUntil)
+}
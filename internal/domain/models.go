@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -33,6 +34,8 @@ const (
 	TaskStateLeg2Opening   TaskState = "LEG2_OPENING"
 	TaskStateCompleted     TaskState = "COMPLETED"
 	TaskStateFailed        TaskState = "FAILED"
+	// Пользователь приостановил задачу: триггер не проверяется, настройки сохранены
+	TaskStatePaused TaskState = "PAUSED"
 )
 
 // --- Aggregates ---
@@ -47,27 +50,63 @@ type Task struct {
 	CurrentQty          decimal.Decimal
 	TriggerPrice        decimal.Decimal
 	NextStrikeStep      decimal.Decimal
+
+	// Порог mark price текущего опциона для раннего предупреждения; 0 - выключен
+	PremiumAlertThreshold decimal.Decimal
+
 	Status              TaskState
 	Version             int64
 	LastError           string
+	// Время и число завершенных роллов; LastTriggeredAt nil - задача еще не роллировалась
+	LastTriggeredAt     *time.Time
+	RollCount           int
+	// Label - название задачи от пользователя, пусто - не задано
+	Label               string
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 }
 
+// Title - символ опциона и название задачи, если оно задано: для карточек и уведомлений
+func (t *Task) Title() string {
+	if t.Label == "" {
+		return t.CurrentOptionSymbol
+	}
+	return fmt.Sprintf("%s «%s»", t.CurrentOptionSymbol, t.Label)
+}
+
 func (t *Task) IsCallOption() bool {
 	return strings.HasSuffix(t.CurrentOptionSymbol, "-C")
 }
 
+// IsActive: задача еще отслеживается (не завершена, не упала и не на паузе)
+func (t *Task) IsActive() bool {
+	return t.Status != TaskStateCompleted && t.Status != TaskStateFailed && t.Status != TaskStatePaused
+}
+
+// NeedsRecovery: ролл был прерван посередине (падение, рестарт) и должен быть доведен
+// независимо от цены
+func (t *Task) NeedsRecovery() bool {
+	return t.Status == TaskStateRollInitiated || t.Status == TaskStateLeg1Closed
+}
+
 func (t *Task) ShouldRoll(currentUnderlyingPrice decimal.Decimal) bool {
 	if t.Status != TaskStateIdle {
 		return false
 	}
+	return t.TriggerBreached(t.TriggerPrice, currentUnderlyingPrice)
+}
 
+// TriggerBreached: цена уже за триггером (для колла - выше, для пута - ниже), независимо от статуса
+func (t *Task) TriggerBreached(trigger, currentUnderlyingPrice decimal.Decimal) bool {
 	if t.IsCallOption() {
-		return currentUnderlyingPrice.GreaterThanOrEqual(t.TriggerPrice)
-	} else {
-		return currentUnderlyingPrice.LessThanOrEqual(t.TriggerPrice)
+		return currentUnderlyingPrice.GreaterThanOrEqual(trigger)
 	}
+	return currentUnderlyingPrice.LessThanOrEqual(trigger)
+}
+
+// IsMidRoll: ролл начат и не завершен, задачу нельзя удалять или редактировать
+func (t *Task) IsMidRoll() bool {
+	return t.NeedsRecovery() || t.Status == TaskStateLeg2Opening
 }
 
 // --- Entities & Value Objects ---
@@ -79,6 +118,14 @@ type User struct {
 	ExpiresAt  time.Time
 	IsBanned   bool
 	CreatedAt  time.Time
+
+	// BotBlockedAt - пользователь заблокировал бота: сообщения ему не доходят (nil - доходят)
+	BotBlockedAt *time.Time
+}
+
+// HasAccess - подписка действует и пользователь не забанен
+func (u *User) HasAccess(now time.Time) bool {
+	return !u.IsBanned && now.Before(u.ExpiresAt)
 }
 
 type APIKey struct {
@@ -88,7 +135,16 @@ type APIKey struct {
 	Secret    string
 	Label     string
 	IsValid   bool
+	IsTestnet bool // Ключ testnet: ордера и рыночные данные задачи идут в testnet
+	IsDemo    bool // Ключ демо-торговли Bybit (api-demo.bybit.com)
+	ExpiresAt time.Time // Срок действия ключа на Bybit; нулевое - бессрочный
 	CreatedAt time.Time
+
+	// AccountUID - UID аккаунта Bybit из проверки ключа; 0 - ключ сохранен до появления колонки
+	AccountUID int64
+
+	// Undecryptable: запись из списка ключей не расшифровалась (Key и Secret пустые)
+	Undecryptable bool
 }
 
 type Position struct {
@@ -103,9 +159,37 @@ type Position struct {
 type MarginInfo struct {
 	TotalEquity        decimal.Decimal
 	TotalMarginBalance decimal.Decimal
+	AvailableBalance   decimal.Decimal // свободная маржа под новые ордера
 	MMR                decimal.Decimal
 }
 
+// OptionQuote - котировка одного страйка из цепочки опционов
+type OptionQuote struct {
+	Symbol    string
+	Strike    decimal.Decimal
+	Side      string // C or P
+	MarkPrice decimal.Decimal
+	BidPrice  decimal.Decimal
+	AskPrice  decimal.Decimal
+	MarkIV    decimal.Decimal
+	Delta     decimal.Decimal
+	Gamma     decimal.Decimal
+	Vega      decimal.Decimal
+}
+
+type OrderbookLevel struct {
+	Price decimal.Decimal
+	Size  decimal.Decimal
+}
+
+// Orderbook - стакан опциона: Bids по убыванию цены, Asks по возрастанию
+type Orderbook struct {
+	Symbol string
+	Bids   []OrderbookLevel
+	Asks   []OrderbookLevel
+	Time   time.Time
+}
+
 type OrderRequest struct {
 	Symbol      string
 	Side        string
@@ -117,17 +201,209 @@ type OrderRequest struct {
 	TimeInForce string
 }
 
+// Статусы ордера Bybit
+const (
+	OrderStatusNew             = "New"
+	OrderStatusPartiallyFilled = "PartiallyFilled"
+	OrderStatusFilled          = "Filled"
+	OrderStatusCancelled       = "Cancelled"
+	OrderStatusRejected        = "Rejected"
+)
+
+// Order - состояние ордера на бирже
+type Order struct {
+	OrderID     string
+	OrderLinkID string
+	Symbol      string
+	Side        string
+	Status      string
+	Price       decimal.Decimal
+	Qty         decimal.Decimal
+	CumExecQty  decimal.Decimal
+	AvgPrice    decimal.Decimal
+}
+
+func (o Order) IsFilled() bool {
+	return o.Status == OrderStatusFilled
+}
+
+// IsActive - ордер еще стоит в стакане и его можно двигать
+func (o Order) IsActive() bool {
+	return o.Status == OrderStatusNew || o.Status == OrderStatusPartiallyFilled
+}
+
+// RemainingQty - неисполненный остаток
+func (o Order) RemainingQty() decimal.Decimal {
+	return o.Qty.Sub(o.CumExecQty)
+}
+
+// Статусы журнала ордеров в дополнение к статусам Bybit
+const (
+	OrderJournalPending = "Pending" // записан, ответа биржи еще нет
+	OrderJournalError   = "Error"   // биржа отклонила запрос или он не дошел
+)
+
+// OrderRecord - запись журнала ордеров: что и когда бот отправил на биржу по задаче
+type OrderRecord struct {
+	ID              int64
+	TaskID          int64
+	OrderLinkID     string
+	Symbol          string
+	Side            string
+	OrderType       string
+	Qty             decimal.Decimal
+	Price           decimal.Decimal
+	TimeInForce     string
+	ReduceOnly      bool
+	Status          string // OrderJournalPending, OrderJournalError или статус ордера Bybit
+	ExchangeOrderID string
+	CumExecQty      decimal.Decimal
+	AvgPrice        decimal.Decimal
+	Error           string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// BatchOrderResult - результат одного ордера из пакетного запроса.
+// Err != nil означает, что именно этот ордер отклонен, остальные могли пройти.
+type BatchOrderResult struct {
+	OrderLinkID string
+	OrderID     string
+	Err         error
+}
+
 // PriceUpdate представляет собой актуальную цену для конкретного базового актива
 type PriceUpdate struct {
     Symbol string          // Например, "ETH"
-    Price  decimal.Decimal // Индексная цена
+    Price  decimal.Decimal // Цена триггера (индекс или mark, см. PriceSource)
     Time   time.Time
 }
 
+// PriceSource - поле тикера базового актива, которым проверяется триггер.
+// По умолчанию индекс: по нему Bybit рассчитывает поставку опционов.
+// В волатильность index, mark и last могут заметно расходиться.
+type PriceSource string
+
+const (
+	PriceSourceIndex PriceSource = "index"
+	PriceSourceMark  PriceSource = "mark"
+	PriceSourceLast  PriceSource = "last"
+)
+
+func ParsePriceSource(s string) (PriceSource, error) {
+	switch source := PriceSource(s); source {
+	case PriceSourceIndex, PriceSourceMark, PriceSourceLast:
+		return source, nil
+	case "":
+		return PriceSourceIndex, nil
+	default:
+		return "", fmt.Errorf("unknown price source %q: expected index, mark or last", s)
+	}
+}
+
+// Label - название цены для пользователя
+func (p PriceSource) Label() string {
+	switch p {
+	case PriceSourceMark:
+		return "Mark Price"
+	case PriceSourceLast:
+		return "Last Price"
+	default:
+		return "Index Price"
+	}
+}
+
 // PriceUpdateEvent представляет событие обновления цены для MarketStreamer
 type PriceUpdateEvent struct {
     Symbol string          // Например, "ETH"
-    Price  decimal.Decimal // Индексная цена
+    Price  decimal.Decimal // Цена триггера: поле тикера по PriceSource (для опционов - mark)
     Time   time.Time
-    Source string          // Источник данных (например, "bybit-ws")
-}
\ No newline at end of file
+    Source string          // Источник данных и поле, например "bybit-linear-ws:index"
+
+    // Заполняются только для опционных тикеров
+    Bid   decimal.Decimal
+    Ask   decimal.Decimal
+    Delta decimal.Decimal
+}
+
+type StreamHealthKind string
+
+const (
+	// StreamSilent - по символам давно не было тиков, соединение пересоздается
+	StreamSilent StreamHealthKind = "silent"
+	// StreamRejected - биржа отклонила подписку (например, опечатка в символе), тиков не будет
+	StreamRejected StreamHealthKind = "rejected"
+	// StreamDown - соединение с биржей не удается восстановить, цен по символам нет
+	StreamDown StreamHealthKind = "down"
+	// StreamRecovered - после StreamDown соединение восстановлено и тики снова идут
+	StreamRecovered StreamHealthKind = "recovered"
+)
+
+// StreamHealthEvent - предупреждение стрима о символах, по которым не приходят цены
+type StreamHealthEvent struct {
+	Kind    StreamHealthKind
+	Source  string
+	Symbols []string
+	Silence time.Duration // StreamSilent: сколько молчал самый тихий символ; StreamDown: сколько нет соединения
+	Reason  string        // StreamRejected: ответ биржи; StreamDown: последняя ошибка
+	Time    time.Time
+}
+
+// StreamStatus - состояние соединений рыночного стрима для админки
+type StreamStatus struct {
+	Source      string
+	Connections []ConnectionStatus
+	Stats       StreamStats
+}
+
+// StreamStats - счетчики стрима с момента запуска
+type StreamStats struct {
+	MessagesReceived  int64 // Все сообщения из WebSocket, включая понги и ответы на подписку
+	EventsPublished   int64 // Тики, доставленные подписчикам
+	EventsDropped     int64 // Тики, вытесненные более свежими, пока подписчик не успевал читать
+	Reconnects        int64
+	SubscribeFailures int64
+}
+
+// ConnectionStatus - одно WebSocket-соединение стрима
+type ConnectionStatus struct {
+	Connected bool
+	Uptime    time.Duration
+	LastRTT   time.Duration // 0, если понга еще не было
+	Symbols   int
+}
+
+// QueryStat - счетчики одного запроса к базе с момента запуска; Name - метод репозитория, из которого он идет
+type QueryStat struct {
+	Name   string
+	Count  int64
+	Errors int64
+	Slow   int64 // Дольше порога медленных запросов
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// OrderUpdateEvent - обновление ордера из приватного стрима
+type OrderUpdateEvent struct {
+	APIKeyID    int64
+	Symbol      string
+	OrderID     string
+	OrderLinkID string
+	Side        string
+	Status      string // New, PartiallyFilled, Filled, Cancelled, Rejected
+	Qty         decimal.Decimal
+	CumExecQty  decimal.Decimal
+	AvgPrice    decimal.Decimal
+	Time        time.Time
+}
+
+// PositionUpdateEvent - обновление позиции из приватного стрима (Qty = 0 - позиция закрыта)
+type PositionUpdateEvent struct {
+	APIKeyID   int64
+	Symbol     string
+	Side       string
+	Qty        decimal.Decimal
+	EntryPrice decimal.Decimal
+	MarkPrice  decimal.Decimal
+	Time       time.Time
+}
@@ -0,0 +1,34 @@
+package domain
+
+import "context"
+
+type testnetCtxKey struct{}
+
+// WithKeyNetwork помечает контекст сетью ключа, чтобы публичные запросы
+// (цены, страйки) шли в тот же контур, что и ордера по этому ключу
+func WithKeyNetwork(ctx context.Context, key APIKey) context.Context {
+	return context.WithValue(ctx, testnetCtxKey{}, key.IsTestnet)
+}
+
+// WithNetwork - то же для публичных запросов без ключа (например, опрос цен при падении стрима)
+func WithNetwork(ctx context.Context, testnet bool) context.Context {
+	return context.WithValue(ctx, testnetCtxKey{}, testnet)
+}
+
+// TestnetFromContext: ok = false, если сеть ключа в контексте не задана
+func TestnetFromContext(ctx context.Context) (testnet bool, ok bool) {
+	testnet, ok = ctx.Value(testnetCtxKey{}).(bool)
+	return testnet, ok
+}
+
+// Network - сеть ключа для пользователя: mainnet, testnet или demo
+func (k APIKey) Network() string {
+	switch {
+	case k.IsTestnet:
+		return "testnet"
+	case k.IsDemo:
+		return "demo"
+	default:
+		return "mainnet"
+	}
+}
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ClosedPnL - запись реализованного PnL с биржи (закрытие позиции)
+type ClosedPnL struct {
+	Symbol        string
+	OrderID       string
+	Side          string
+	Qty           decimal.Decimal
+	AvgEntryPrice decimal.Decimal
+	AvgExitPrice  decimal.Decimal
+	ClosedPnL     decimal.Decimal
+	CreatedAt     time.Time
+}
+
+// PnLSummary - реализованный PnL по одному символу за период
+type PnLSummary struct {
+	Symbol string
+	PnL    decimal.Decimal
+	Trades int
+}
+
+// SummarizeClosedPnL группирует записи по символу; результат отсортирован от худшего PnL к лучшему
+func SummarizeClosedPnL(records []ClosedPnL) ([]PnLSummary, decimal.Decimal) {
+	bySymbol := make(map[string]*PnLSummary)
+	total := decimal.Zero
+
+	for _, r := range records {
+		summary, ok := bySymbol[r.Symbol]
+		if !ok {
+			summary = &PnLSummary{Symbol: r.Symbol}
+			bySymbol[r.Symbol] = summary
+		}
+		summary.PnL = summary.PnL.Add(r.ClosedPnL)
+		summary.Trades++
+		total = total.Add(r.ClosedPnL)
+	}
+
+	result := make([]PnLSummary, 0, len(bySymbol))
+	for _, summary := range bySymbol {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].PnL.Equal(result[j].PnL) {
+			return result[i].PnL.LessThan(result[j].PnL)
+		}
+		return result[i].Symbol < result[j].Symbol
+	})
+
+	return result, total
+}
@@ -41,18 +41,77 @@ func ParseOptionSymbol(symbol string) (OptionSymbol, error) {
 	}, nil
 }
 
+// Underlying - тикер линейного перпетуала базового актива, по которому следим за ценой (BTCUSDT)
+func (o OptionSymbol) Underlying() string {
+	if strings.HasSuffix(o.BaseCoin, "USDT") {
+		return o.BaseCoin
+	}
+	return o.BaseCoin + "USDT"
+}
+
+func (o OptionSymbol) IsCall() bool {
+	return strings.EqualFold(o.Side, "C")
+}
+
+// IntrinsicValue - внутренняя стоимость опциона при цене поставки (0, если OTM)
+func (o OptionSymbol) IntrinsicValue(deliveryPrice decimal.Decimal) decimal.Decimal {
+	var value decimal.Decimal
+	if o.IsCall() {
+		value = deliveryPrice.Sub(o.Strike)
+	} else {
+		value = o.Strike.Sub(deliveryPrice)
+	}
+	if value.IsNegative() {
+		return decimal.Zero
+	}
+	return value
+}
+
 // ParseExpirationFromSymbol - оставляет старую логику для совместимости
 func ParseExpirationFromSymbol(symbol string) (time.Time, error) {
 	os, err := ParseOptionSymbol(symbol)
 	if err != nil {
 		return time.Time{}, err
 	}
-	// Layout: 02Jan06
-	t, err := time.Parse("02Jan06", os.Expiry)
+	t, err := time.Parse(expiryLayout, os.Expiry)
 	if err != nil {
 		return time.Time{}, err
 	}
-	return t.Add(8 * time.Hour), nil
+	return t.Add(expiryHourUTC * time.Hour), nil
+}
+
+// Bybit пишет день без ведущего нуля: 2JAN26, 26DEC25
+const (
+	expiryLayout  = "2Jan06"
+	expiryHourUTC = 8
+)
+
+// OptionExpiry - доступная дата экспирации: момент поставки и фрагмент тикера ("26DEC25")
+type OptionExpiry struct {
+	Time time.Time
+	Code string
+}
+
+// FormatExpiryCode переводит время поставки в формат тикера Bybit
+func FormatExpiryCode(t time.Time) string {
+	return strings.ToUpper(t.UTC().Format(expiryLayout))
+}
+
+// NextExpiryAfter выбирает ближайшую экспирацию строго позже текущей.
+// expiries должны быть отсортированы по возрастанию.
+func NextExpiryAfter(current string, expiries []OptionExpiry) (OptionExpiry, error) {
+	t, err := time.Parse(expiryLayout, current)
+	if err != nil {
+		return OptionExpiry{}, fmt.Errorf("invalid expiry %s: %w", current, err)
+	}
+	currentTime := t.Add(expiryHourUTC * time.Hour)
+
+	for _, e := range expiries {
+		if e.Time.After(currentTime) {
+			return e, nil
+		}
+	}
+	return OptionExpiry{}, fmt.Errorf("no expiry after %s", current)
 }
 
 // FindNextStrike выбирает следующий страйк из доступного списка
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// TaskEventType - тип записи в журнале событий задачи
+type TaskEventType string
+
+const (
+	TaskEventRolled    TaskEventType = "ROLLED"     // Leg2 открыта, задача перешла на новый символ
+	TaskEventCompleted TaskEventType = "COMPLETED"  // задача завершена (опцион экспирировал, позицию закрыли вне бота); details - причина
+	TaskEventPaused    TaskEventType = "PAUSED"     // задача поставлена на паузу системой (бан, подписка); details - причина
+	TaskEventRollReset TaskEventType = "ROLL_RESET" // прерванный ролл не дошел до биржи, задача снова ждет триггер; details - причина
+)
+
+// TaskEvent - строка журнала событий задачи
+type TaskEvent struct {
+	ID         int64
+	TaskID     int64
+	Type       TaskEventType
+	FromSymbol string
+	ToSymbol   string
+	Qty        decimal.Decimal
+	Details    string
+	CreatedAt  time.Time
+}
+
+// FinalizeRollParams - итог ролла для TaskRepository.FinalizeRoll
+type FinalizeRollParams struct {
+	TaskID     int64
+	Version    int64 // версия задачи, с которой шел ролл
+	FromSymbol string
+	ToSymbol   string
+	Qty        decimal.Decimal
+}
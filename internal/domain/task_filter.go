@@ -0,0 +1,24 @@
+package domain
+
+// TaskOrder - порядок задач в списке пользователя
+type TaskOrder string
+
+const (
+	TaskOrderNewest  TaskOrder = "newest"  // по созданию, новые первыми (по умолчанию)
+	TaskOrderOldest  TaskOrder = "oldest"  // по созданию, старые первыми
+	TaskOrderUpdated TaskOrder = "updated" // по последнему изменению, свежие первыми
+)
+
+// Наборы статусов для списка задач пользователя
+var (
+	// ActiveTaskStates - задачи, за которыми бот следит или может снова следить (пауза)
+	ActiveTaskStates = []TaskState{TaskStateIdle, TaskStateRollInitiated, TaskStateLeg1Closed, TaskStateLeg2Opening, TaskStatePaused}
+	// HistoryTaskStates - завершенные и упавшие задачи
+	HistoryTaskStates = []TaskState{TaskStateCompleted, TaskStateFailed}
+)
+
+// TaskFilter - выборка задач пользователя
+type TaskFilter struct {
+	States []TaskState // пусто - любые статусы
+	Order  TaskOrder   // пусто - TaskOrderNewest
+}
@@ -0,0 +1,19 @@
+package domain
+
+// UserFilter - выборка пользователей для админки
+type UserFilter string
+
+const (
+	UserFilterAll     UserFilter = "all"
+	UserFilterActive  UserFilter = "active"  // подписка действует и пользователь не забанен
+	UserFilterExpired UserFilter = "expired" // подписка истекла
+	UserFilterBanned  UserFilter = "banned"
+	// UserFilterReachable - не забанен и не заблокировал бота: получатели рассылки
+	UserFilterReachable UserFilter = "reachable"
+)
+
+// UserSummary - пользователь с числом его активных задач (для списков в админке)
+type UserSummary struct {
+	User
+	ActiveTasks int
+}
@@ -0,0 +1,397 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// USDⓈ-M Futures: mark и index price раз в секунду
+	DefaultStreamURL = "wss://fstream.binance.com/ws"
+
+	sourceBinanceWS = "binance-futures-ws"
+
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// MarkPriceStream - запасной источник цены базового актива: стрим <symbol>@markPrice@1s Binance.
+// Исполнение всегда идет на Bybit, отсюда берутся только цены для триггера.
+type MarkPriceStream struct {
+	url    string
+	dialer *websocket.Dialer
+	// index -> поле "i", mark и last -> "p" (в этом стриме нет last price)
+	priceSource domain.PriceSource
+	logger      *slog.Logger
+
+	conn    *websocket.Conn
+	reqID   int64
+	symbols map[string]bool
+	started bool
+	mu      sync.Mutex
+
+	connectedAt time.Time
+	lastPrices  map[string]domain.PriceUpdateEvent
+	pricesMu    sync.RWMutex
+
+	// Подписчики (под subMu)
+	all      map[chan domain.PriceUpdateEvent]struct{}
+	bySymbol map[string]map[chan domain.PriceUpdateEvent]struct{}
+	closed   bool
+	subMu    sync.RWMutex
+
+	stats domain.StreamStats // Под subMu
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewMarkPriceStream(url string, priceSource domain.PriceSource, dialer *websocket.Dialer) *MarkPriceStream {
+	if url == "" {
+		url = DefaultStreamURL
+	}
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return &MarkPriceStream{
+		url:         url,
+		dialer:      dialer,
+		priceSource: priceSource,
+		logger:      slog.Default().With("component", "binance_stream"),
+		symbols:     make(map[string]bool),
+		lastPrices:  make(map[string]domain.PriceUpdateEvent),
+		all:         make(map[chan domain.PriceUpdateEvent]struct{}),
+		bySymbol:    make(map[string]map[chan domain.PriceUpdateEvent]struct{}),
+		stop:        make(chan struct{}),
+	}
+}
+
+func (s *MarkPriceStream) Subscribe(ctx context.Context, symbols []string) (<-chan domain.PriceUpdateEvent, error) {
+	ch := make(chan domain.PriceUpdateEvent, 100)
+	s.subMu.Lock()
+	if s.closed {
+		s.subMu.Unlock()
+		return nil, errors.New("binance stream is closed")
+	}
+	s.all[ch] = struct{}{}
+	s.subMu.Unlock()
+
+	if err := s.AddSubscriptions(symbols); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if !s.started {
+		s.started = true
+		s.wg.Add(1)
+		go s.maintainConnection(ctx)
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.Close()
+			case <-s.stop:
+			}
+		}()
+	}
+	s.mu.Unlock()
+	return ch, nil
+}
+
+// SubscribeSymbol: тики идут, пока стрим запущен через Subscribe
+func (s *MarkPriceStream) SubscribeSymbol(symbol string) (<-chan domain.PriceUpdateEvent, func(), error) {
+	ch := make(chan domain.PriceUpdateEvent, 100)
+	s.subMu.Lock()
+	if s.closed {
+		s.subMu.Unlock()
+		return nil, nil, errors.New("binance stream is closed")
+	}
+	if s.bySymbol[symbol] == nil {
+		s.bySymbol[symbol] = make(map[chan domain.PriceUpdateEvent]struct{})
+	}
+	s.bySymbol[symbol][ch] = struct{}{}
+	s.subMu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.subMu.Lock()
+			defer s.subMu.Unlock()
+			if _, ok := s.bySymbol[symbol][ch]; ok {
+				delete(s.bySymbol[symbol], ch)
+				if len(s.bySymbol[symbol]) == 0 {
+					delete(s.bySymbol, symbol)
+				}
+				close(ch)
+			}
+		})
+	}
+	return ch, unsubscribe, s.AddSubscriptions([]string{symbol})
+}
+
+func (s *MarkPriceStream) AddSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var added []string
+	for _, symbol := range symbols {
+		if !s.symbols[symbol] {
+			s.symbols[symbol] = true
+			added = append(added, symbol)
+		}
+	}
+	return s.sendLocked("SUBSCRIBE", added)
+}
+
+func (s *MarkPriceStream) RemoveSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed []string
+	for _, symbol := range symbols {
+		if s.symbols[symbol] {
+			delete(s.symbols, symbol)
+			removed = append(removed, symbol)
+		}
+	}
+
+	s.pricesMu.Lock()
+	for _, symbol := range removed {
+		delete(s.lastPrices, symbol)
+	}
+	s.pricesMu.Unlock()
+
+	return s.sendLocked("UNSUBSCRIBE", removed)
+}
+
+func (s *MarkPriceStream) GetLastPrice(symbol string) (decimal.Decimal, time.Time, bool) {
+	s.pricesMu.RLock()
+	defer s.pricesMu.RUnlock()
+	event, ok := s.lastPrices[symbol]
+	return event.Price, event.Time, ok
+}
+
+func (s *MarkPriceStream) Status() domain.StreamStatus {
+	s.mu.Lock()
+	conn := domain.ConnectionStatus{Connected: s.conn != nil, Symbols: len(s.symbols)}
+	if conn.Connected {
+		conn.Uptime = time.Since(s.connectedAt)
+	}
+	s.mu.Unlock()
+
+	s.subMu.RLock()
+	stats := s.stats
+	s.subMu.RUnlock()
+
+	return domain.StreamStatus{Source: sourceBinanceWS, Connections: []domain.ConnectionStatus{conn}, Stats: stats}
+}
+
+// Health: сторожа тишины у запасного источника нет - его молчание видно по GetLastPrice
+func (s *MarkPriceStream) Health() <-chan domain.StreamHealthEvent {
+	return nil
+}
+
+func (s *MarkPriceStream) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+	})
+	s.wg.Wait()
+
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	if !s.closed {
+		s.closed = true
+		for ch := range s.all {
+			close(ch)
+		}
+		for _, subs := range s.bySymbol {
+			for ch := range subs {
+				close(ch)
+			}
+		}
+		s.all = nil
+		s.bySymbol = nil
+	}
+	return nil
+}
+
+func (s *MarkPriceStream) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *MarkPriceStream) maintainConnection(ctx context.Context) {
+	defer s.wg.Done()
+
+	delay := reconnectMinDelay
+	for {
+		started := time.Now()
+		err := s.connectAndListen(ctx)
+		if s.stopped() {
+			return
+		}
+		if err != nil {
+			s.logger.Error("Binance connection lost or failed", "err", err)
+		}
+		s.subMu.Lock()
+		s.stats.Reconnects++
+		s.subMu.Unlock()
+
+		if time.Since(started) > time.Minute {
+			delay = reconnectMinDelay
+		}
+		s.logger.Info("Reconnecting to Binance", "delay", delay)
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+func (s *MarkPriceStream) connectAndListen(ctx context.Context) error {
+	conn, _, err := s.dialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.stopped() {
+		s.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	s.conn = conn
+	s.connectedAt = time.Now()
+	symbols := make([]string, 0, len(s.symbols))
+	for symbol := range s.symbols {
+		symbols = append(symbols, symbol)
+	}
+	err = s.sendLocked("SUBSCRIBE", symbols)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		s.mu.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+
+	// Пинги шлет Binance, понги отвечает обработчик gorilla по умолчанию
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("read error: %w", err)
+		}
+		s.subMu.Lock()
+		s.stats.MessagesReceived++
+		s.subMu.Unlock()
+
+		event, ok := parseMarkPrice(message, s.priceSource)
+		if !ok {
+			continue
+		}
+
+		s.pricesMu.Lock()
+		s.lastPrices[event.Symbol] = event
+		s.pricesMu.Unlock()
+
+		s.publish(event)
+	}
+}
+
+func (s *MarkPriceStream) publish(event domain.PriceUpdateEvent) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	send := func(ch chan domain.PriceUpdateEvent) {
+		select {
+		case ch <- event:
+			s.stats.EventsPublished++
+		default:
+			s.stats.EventsDropped++
+		}
+	}
+	for ch := range s.all {
+		send(ch)
+	}
+	for ch := range s.bySymbol[event.Symbol] {
+		send(ch)
+	}
+}
+
+// sendLocked - SUBSCRIBE/UNSUBSCRIBE по стримам markPrice; без соединения - no-op (под mu)
+func (s *MarkPriceStream) sendLocked(method string, symbols []string) error {
+	if len(symbols) == 0 || s.conn == nil {
+		return nil
+	}
+
+	params := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		params[i] = strings.ToLower(symbol) + "@markPrice@1s"
+	}
+
+	s.reqID++
+	s.logger.Info("Sending Binance subscription request", "method", method, "streams", params)
+	return s.conn.WriteJSON(map[string]interface{}{
+		"method": method,
+		"params": params,
+		"id":     s.reqID,
+	})
+}
+
+func parseMarkPrice(message []byte, priceSource domain.PriceSource) (domain.PriceUpdateEvent, bool) {
+	var event wsMarkPriceEvent
+	if err := json.Unmarshal(message, &event); err != nil || event.EventType != "markPriceUpdate" {
+		return domain.PriceUpdateEvent{}, false
+	}
+
+	price, field := event.IndexPrice, domain.PriceSourceIndex
+	if priceSource == domain.PriceSourceMark || priceSource == domain.PriceSourceLast {
+		price, field = event.MarkPrice, domain.PriceSourceMark
+	}
+	if price.IsZero() {
+		return domain.PriceUpdateEvent{}, false
+	}
+
+	return domain.PriceUpdateEvent{
+		Symbol: event.Symbol,
+		Price:  price,
+		Time:   time.Now(),
+		Source: sourceBinanceWS + ":" + string(field),
+	}, true
+}
+
+// wsMarkPriceEvent - сообщение стрима <symbol>@markPrice
+type wsMarkPriceEvent struct {
+	EventType  string          `json:"e"`
+	Symbol     string          `json:"s"`
+	MarkPrice  decimal.Decimal `json:"p"`
+	IndexPrice decimal.Decimal `json:"i"`
+}
@@ -0,0 +1,47 @@
+package bybit
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 60 * time.Second
+	// Соединение, прожившее столько, считается здоровым: следующий реконнект снова с минимальной паузы
+	reconnectStableAfter = time.Minute
+)
+
+// reconnectBackoff - экспоненциальная пауза между переподключениями WebSocket с jitter,
+// чтобы после сбоя Bybit все инстансы не переподключались синхронно
+type reconnectBackoff struct {
+	min         time.Duration
+	max         time.Duration
+	stableAfter time.Duration
+	attempt     int
+}
+
+func newReconnectBackoff() *reconnectBackoff {
+	return &reconnectBackoff{
+		min:         reconnectMinDelay,
+		max:         reconnectMaxDelay,
+		stableAfter: reconnectStableAfter,
+	}
+}
+
+// next возвращает номер попытки и паузу перед ней; uptime - сколько прожило упавшее соединение
+func (b *reconnectBackoff) next(uptime time.Duration) (int, time.Duration) {
+	if uptime >= b.stableAfter {
+		b.attempt = 0
+	}
+	b.attempt++
+
+	delay := b.min << (b.attempt - 1)
+	if delay > b.max || delay <= 0 {
+		delay = b.max
+	}
+
+	// Equal jitter: половина паузы фиксирована, половина случайна
+	half := delay / 2
+	return b.attempt, half + time.Duration(rand.Int64N(int64(half)+1))
+}
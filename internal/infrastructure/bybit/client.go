@@ -7,9 +7,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,31 +25,209 @@ import (
 const (
 	MainnetBaseURL = "https://api.bybit.com"
 	TestnetBaseURL = "https://api-testnet.bybit.com"
-	RecvWindow     = "5000"
+
+	DefaultRecvWindow = 5 * time.Second
+
+	maxInstrumentPages = 20
+
+	// closed-pnl принимает окно не длиннее 7 дней, длинные периоды режем на части
+	closedPnLWindow   = 7 * 24 * time.Hour
+	maxClosedPnLPages = 50
 )
 
+type ClientConfig struct {
+	Environment Environment
+	Timeout    time.Duration
+	RecvWindow time.Duration
+	// OrderTimeout - таймаут торговых запросов, им допустимо ждать дольше, чем тикерам
+	OrderTimeout time.Duration
+	RateLimits   RateLimits
+	Retry        RetryPolicy
+	Transport    TransportConfig
+	Deadlines    OperationDeadlines
+	Proxies      *Proxies // nil - без прокси
+
+	// Debug включает логирование запросов на уровне debug (ключи и подписи маскируются)
+	Debug  bool
+	Logger *slog.Logger
+}
+
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
+	env          Environment
+	httpClient   *http.Client
+	recvWindow   string
+	timeout      time.Duration
+	orderTimeout time.Duration
+	limiter      *rateLimiter
+	retry        RetryPolicy
+	deadlines    OperationDeadlines
+	proxies      *Proxies
+	latency      map[endpointClass]*latencyHistogram
+	debug        bool
+	logger       *slog.Logger
 }
 
-// NewClient теперь принимает timeout явно
-func NewClient(isTestnet bool, timeout time.Duration) *Client {
-	url := MainnetBaseURL
-	if isTestnet {
-		url = TestnetBaseURL
+func NewClient(cfg ClientConfig) *Client {
+	env := cfg.Environment
+	if env == "" {
+		env = EnvMainnet
+	}
+
+	recvWindow := cfg.RecvWindow
+	if recvWindow <= 0 {
+		recvWindow = DefaultRecvWindow
+	}
+
+	orderTimeout := cfg.OrderTimeout
+	if orderTimeout <= 0 {
+		orderTimeout = cfg.Timeout
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
 	}
+
 	return &Client{
-		baseURL:    url,
-		httpClient: &http.Client{Timeout: timeout},
+		env: env,
+		// Таймаут задается на каждую попытку через контекст, см. requestTimeout.
+		// Клиент один на процесс и безопасен для конкурентного использования.
+		httpClient:   &http.Client{Transport: newTransport(cfg.Transport, cfg.Proxies)},
+		recvWindow:   strconv.FormatInt(recvWindow.Milliseconds(), 10),
+		timeout:      cfg.Timeout,
+		orderTimeout: orderTimeout,
+		limiter:      newRateLimiter(cfg.RateLimits),
+		retry:        cfg.Retry,
+		deadlines:    cfg.Deadlines,
+		proxies:      cfg.Proxies,
+		latency: map[endpointClass]*latencyHistogram{
+			classMarket:  newLatencyHistogram(),
+			classAccount: newLatencyHistogram(),
+			classTrade:   newLatencyHistogram(),
+		},
+		debug:        cfg.Debug,
+		logger:       logger.With("component", "bybit_client"),
+	}
+}
+
+type requestTimeoutKey struct{}
+
+// WithRequestTimeout переопределяет таймаут HTTP-запросов клиента для вызовов с этим контекстом
+func WithRequestTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+func (c *Client) requestTimeout(ctx context.Context, endpoint string) time.Duration {
+	if override, ok := ctx.Value(requestTimeoutKey{}).(time.Duration); ok && override > 0 {
+		return override
+	}
+	if classifyEndpoint(endpoint) == classTrade {
+		return c.orderTimeout
+	}
+	return c.timeout
+}
+
+// OperationDeadlines - бюджет одного запроса клиента целиком: все попытки, ожидание лимитов и паузы
+// между повторами. Накладывается поверх контекста вызывающего; 0 - без ограничения.
+type OperationDeadlines struct {
+	Market  time.Duration
+	Account time.Duration
+	Trade   time.Duration
+}
+
+func DefaultOperationDeadlines() OperationDeadlines {
+	return OperationDeadlines{
+		Market:  3 * time.Second,
+		Account: 5 * time.Second,
+		Trade:   10 * time.Second,
+	}
+}
+
+func (d OperationDeadlines) forClass(class endpointClass) time.Duration {
+	switch class {
+	case classTrade:
+		return d.Trade
+	case classAccount:
+		return d.Account
+	default:
+		return d.Market
+	}
+}
+
+// withOperationDeadline ограничивает запрос бюджетом его класса. Причина отмены по бюджету -
+// domain.ErrExchangeTimeout, поэтому через context.Cause она отличима от остановки бота.
+func (c *Client) withOperationDeadline(ctx context.Context, endpoint string) (context.Context, context.CancelFunc) {
+	class := classifyEndpoint(endpoint)
+	timeout := c.deadlines.forClass(class)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	cause := fmt.Errorf("%w: %s %s exceeded %s", domain.ErrExchangeTimeout, class, endpoint, timeout)
+	return context.WithTimeoutCause(ctx, timeout, cause)
+}
+
+// contextError: при отмене контекста важнее причина, чем ошибка последней попытки
+func contextError(ctx context.Context, last error) error {
+	cause := context.Cause(ctx)
+	if last == nil || errors.Is(last, cause) {
+		return cause
+	}
+	return fmt.Errorf("%w (last attempt: %v)", cause, last)
+}
+
+// withAttemptTimeout ограничивает одну попытку запроса; дедлайн вызывающего остается в силе
+func (c *Client) withAttemptTimeout(ctx context.Context, endpoint string) (context.Context, context.CancelFunc) {
+	timeout := c.requestTimeout(ctx, endpoint)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// LatencyStats возвращает гистограмму задержек HTTP-попыток по классу эндпоинта ("market", "account", "trade")
+func (c *Client) LatencyStats(class string) LatencyStats {
+	h, ok := c.latency[endpointClass(class)]
+	if !ok {
+		return LatencyStats{}
+	}
+	return h.Stats()
+}
+
+// RateLimitStats возвращает счетчики клиентского rate limiter
+func (c *Client) RateLimitStats() RateLimitStats {
+	return c.limiter.Stats()
+}
+
+// CheckConnectivity - самопроверка при старте: биржа доступна (и через прокси, если он задан).
+// Ошибка называет прокси, чтобы неверная настройка не выглядела как сбой роллов.
+func (c *Client) CheckConnectivity(ctx context.Context) error {
+	var resp BaseResponse[json.RawMessage]
+	err := c.sendPublicRequest(ctx, "GET", "/v5/market/time", nil, &resp)
+	if err == nil {
+		return nil
 	}
+
+	// Публичные данные демо идут с mainnet
+	env := c.env
+	if env == EnvDemo {
+		env = EnvMainnet
+	}
+	if proxy := c.proxies.For(env); proxy != nil {
+		return fmt.Errorf("bybit %s unreachable via proxy %s: %w", env, proxy.Redacted(), err)
+	}
+	return fmt.Errorf("bybit %s unreachable: %w", c.env, err)
 }
 
 // --- Implementation of ExchangeAdapter ---
 
-// GetIndexPrice возвращает цену. 
+// GetIndexPrice возвращает индексную цену базового актива из линейного тикера (не mark).
 // ВАЖНО: Больше не модифицирует symbol. Логика "BTC" -> "BTCUSDT" вынесена в domain.
 func (c *Client) GetIndexPrice(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	return c.GetUnderlyingPrice(ctx, symbol, domain.PriceSourceIndex)
+}
+
+// GetUnderlyingPrice возвращает поле source линейного тикера базового актива - то же, что дает стрим
+func (c *Client) GetUnderlyingPrice(ctx context.Context, symbol string, source domain.PriceSource) (decimal.Decimal, error) {
 	params := map[string]string{
 		"category": "linear",
 		"symbol":   symbol, // Используем как есть
@@ -56,10 +239,21 @@ func (c *Client) GetIndexPrice(ctx context.Context, symbol string) (decimal.Deci
 	}
 
 	if len(resp.Result.List) == 0 {
-		return decimal.Zero, fmt.Errorf("index price not found for %s", symbol)
+		return decimal.Zero, fmt.Errorf("%s price not found for %s", source, symbol)
 	}
 
-	return resp.Result.List[0].MarkPrice, nil
+	ticker := resp.Result.List[0]
+	price := ticker.IndexPrice
+	switch source {
+	case domain.PriceSourceMark:
+		price = ticker.MarkPrice
+	case domain.PriceSourceLast:
+		price = ticker.LastPrice
+	}
+	if price.IsZero() {
+		return decimal.Zero, fmt.Errorf("%s price is empty for %s", source, symbol)
+	}
+	return price, nil
 }
 
 func (c *Client) GetMarkPrice(ctx context.Context, symbol string) (decimal.Decimal, error) {
@@ -80,34 +274,36 @@ func (c *Client) GetMarkPrice(ctx context.Context, symbol string) (decimal.Decim
 	return resp.Result.List[0].MarkPrice, nil
 }
 
-func (c *Client) GetOptionStrikes(ctx context.Context, baseCoin string, expiryDate string) ([]decimal.Decimal, error) {
-	// Endpoint: /v5/market/instruments-info
-	// category=option, baseCoin=ETH (например), limit=1000
-	
-	// В Go HTTP клиенте params передаются через query string
-	url := fmt.Sprintf("%s/v5/market/instruments-info?category=option&baseCoin=%s&status=Trading&limit=1000", c.baseURL, baseCoin)
+func (c *Client) GetOrderbook(ctx context.Context, symbol string, depth int) (domain.Orderbook, error) {
+	params := map[string]string{
+		"category": "option",
+		"symbol":   symbol,
+		"limit":    strconv.Itoa(depth),
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
+	var resp BaseResponse[OrderbookResponse]
+	if err := c.sendPublicRequest(ctx, "GET", "/v5/market/orderbook", params, &resp); err != nil {
+		return domain.Orderbook{}, err
 	}
 
-	// Публичный эндпоинт, подпись не нужна, но хедеры не помешают
-	resp, err := c.httpClient.Do(req)
+	bids, err := parseOrderbookLevels(resp.Result.Bids)
 	if err != nil {
-		return nil, err
+		return domain.Orderbook{}, fmt.Errorf("orderbook %s bids: %w", symbol, err)
 	}
-	defer resp.Body.Close()
-
-	var result InstrumentInfoResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	asks, err := parseOrderbookLevels(resp.Result.Asks)
+	if err != nil {
+		return domain.Orderbook{}, fmt.Errorf("orderbook %s asks: %w", symbol, err)
 	}
 
-	if result.RetCode != 0 {
-		return nil, fmt.Errorf("bybit api error: %d %s", result.RetCode, result.RetMsg)
-	}
+	return domain.Orderbook{
+		Symbol: resp.Result.Symbol,
+		Bids:   bids,
+		Asks:   asks,
+		Time:   time.UnixMilli(resp.Result.Ts),
+	}, nil
+}
 
+func (c *Client) GetOptionStrikes(ctx context.Context, baseCoin string, expiryDate string) ([]decimal.Decimal, error) {
 	// Фильтруем и собираем уникальные страйки
 	strikeSet := make(map[string]decimal.Decimal)
 	
@@ -117,13 +313,19 @@ func (c *Client) GetOptionStrikes(ctx context.Context, baseCoin string, expiryDa
 	
 	targetSubstr := fmt.Sprintf("-%s-", expiryDate) // "-30JAN24-"
 
-	for _, item := range result.Result.List {
-		if strings.Contains(item.Symbol, targetSubstr) {
-			s, err := decimal.NewFromString(item.StrikePrice)
-			if err == nil {
-				strikeSet[s.String()] = s
+	// instruments-info не умеет фильтровать по экспирации, поэтому читаем все страницы
+	err := c.forEachInstrumentPage(ctx, baseCoin, func(page *InstrumentInfoResponse) {
+		for _, item := range page.Result.List {
+			if strings.Contains(item.Symbol, targetSubstr) {
+				s, err := decimal.NewFromString(item.StrikePrice)
+				if err == nil {
+					strikeSet[s.String()] = s
+				}
 			}
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var strikes []decimal.Decimal
@@ -138,6 +340,181 @@ func (c *Client) GetOptionStrikes(ctx context.Context, baseCoin string, expiryDa
 	return strikes, nil
 }
 
+// GetOptionChain возвращает котировки и греки всех страйков одной экспирации
+func (c *Client) GetOptionChain(ctx context.Context, baseCoin string, expiryDate string) ([]domain.OptionQuote, error) {
+	params := map[string]string{
+		"category": "option",
+		"baseCoin": baseCoin,
+		"expDate":  expiryDate,
+	}
+
+	var resp BaseResponse[OptionTickerResponse]
+	if err := c.sendPublicRequest(ctx, "GET", "/v5/market/tickers", params, &resp); err != nil {
+		return nil, err
+	}
+
+	var quotes []domain.OptionQuote
+	for _, raw := range resp.Result.List {
+		sym, err := domain.ParseOptionSymbol(raw.Symbol)
+		if err != nil || sym.Expiry != expiryDate {
+			continue
+		}
+
+		q := domain.OptionQuote{
+			Symbol: raw.Symbol,
+			Strike: sym.Strike,
+			Side:   sym.Side,
+		}
+
+		fields := []struct {
+			name  string
+			value string
+			dst   *decimal.Decimal
+		}{
+			{"markPrice", raw.MarkPrice, &q.MarkPrice},
+			{"bid1Price", raw.Bid1Price, &q.BidPrice},
+			{"ask1Price", raw.Ask1Price, &q.AskPrice},
+			{"markIv", raw.MarkIv, &q.MarkIV},
+			{"delta", raw.Delta, &q.Delta},
+			{"gamma", raw.Gamma, &q.Gamma},
+			{"vega", raw.Vega, &q.Vega},
+		}
+		for _, f := range fields {
+			v, err := parseDecimalField(f.name, f.value)
+			if err != nil {
+				return nil, fmt.Errorf("option chain %s: %w", raw.Symbol, err)
+			}
+			*f.dst = v
+		}
+
+		quotes = append(quotes, q)
+	}
+
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("no option quotes found for %s %s", baseCoin, expiryDate)
+	}
+
+	sort.Slice(quotes, func(i, j int) bool {
+		return quotes[i].Strike.LessThan(quotes[j].Strike)
+	})
+
+	return quotes, nil
+}
+
+// GetOptionExpiries возвращает уникальные даты экспирации по возрастанию
+func (c *Client) GetOptionExpiries(ctx context.Context, baseCoin string) ([]domain.OptionExpiry, error) {
+	seen := make(map[int64]bool)
+	var expiries []domain.OptionExpiry
+	var parseErr error
+
+	err := c.forEachInstrumentPage(ctx, baseCoin, func(page *InstrumentInfoResponse) {
+		for _, item := range page.Result.List {
+			ms, err := strconv.ParseInt(item.DeliveryTime, 10, 64)
+			if err != nil {
+				parseErr = fmt.Errorf("invalid deliveryTime %q for %s: %w", item.DeliveryTime, item.Symbol, err)
+				continue
+			}
+			if seen[ms] {
+				continue
+			}
+			seen[ms] = true
+
+			t := time.UnixMilli(ms).UTC()
+			expiries = append(expiries, domain.OptionExpiry{
+				Time: t,
+				Code: domain.FormatExpiryCode(t),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(expiries) == 0 {
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		return nil, fmt.Errorf("no expiries found for %s", baseCoin)
+	}
+
+	sort.Slice(expiries, func(i, j int) bool {
+		return expiries[i].Time.Before(expiries[j].Time)
+	})
+
+	return expiries, nil
+}
+
+// forEachInstrumentPage проходит курсорную пагинацию /v5/market/instruments-info.
+// У BTC цепочка опционов по всем экспирациям регулярно больше 1000 инструментов.
+func (c *Client) forEachInstrumentPage(ctx context.Context, baseCoin string, fn func(page *InstrumentInfoResponse)) error {
+	cursor := ""
+	for page := 0; page < maxInstrumentPages; page++ {
+		if ctx.Err() != nil {
+			return contextError(ctx, nil)
+		}
+
+		params := map[string]string{
+			"category": "option",
+			"baseCoin": baseCoin,
+			"status":   "Trading",
+			"limit":    "1000",
+		}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result InstrumentInfoResponse
+		if err := c.sendPublicRequest(ctx, "GET", "/v5/market/instruments-info", params, &result); err != nil {
+			return err
+		}
+
+		fn(&result)
+
+		cursor = result.Result.NextPageCursor
+		if cursor == "" || len(result.Result.List) == 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("instruments-info for %s exceeded %d pages", baseCoin, maxInstrumentPages)
+}
+
+// GetDeliveryPrice возвращает цену поставки экспирировавшего опциона.
+// Цена общая для всей экспирации, поэтому если по самому символу записи нет,
+// берем любую запись той же базовой монеты с той же датой экспирации.
+func (c *Client) GetDeliveryPrice(ctx context.Context, baseCoin, symbol string) (decimal.Decimal, error) {
+	target, err := domain.ParseOptionSymbol(symbol)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	params := map[string]string{
+		"category": "option",
+		"baseCoin": baseCoin,
+		"symbol":   symbol,
+	}
+
+	var resp BaseResponse[DeliveryPriceResponse]
+	if err := c.sendPublicRequest(ctx, "GET", "/v5/market/delivery-price", params, &resp); err != nil {
+		return decimal.Zero, err
+	}
+
+	for _, item := range resp.Result.List {
+		if item.Symbol == symbol {
+			return parseDecimalField("deliveryPrice", item.DeliveryPrice)
+		}
+	}
+
+	for _, item := range resp.Result.List {
+		sym, err := domain.ParseOptionSymbol(item.Symbol)
+		if err == nil && sym.Expiry == target.Expiry {
+			return parseDecimalField("deliveryPrice", item.DeliveryPrice)
+		}
+	}
+
+	return decimal.Zero, fmt.Errorf("delivery price not found for %s", symbol)
+}
+
 func (c *Client) GetPosition(ctx context.Context, creds domain.APIKey, symbol string) (domain.Position, error) {
 	params := map[string]string{
 		"category": "option",
@@ -196,9 +573,312 @@ func (c *Client) GetPositions(ctx context.Context, creds domain.APIKey) ([]domai
     return positions, nil
 }
 
+func (c *Client) GetMarginInfo(ctx context.Context, creds domain.APIKey) (domain.MarginInfo, error) {
+	params := map[string]string{
+		"accountType": "UNIFIED",
+	}
+
+	var resp BaseResponse[WalletBalanceResponse]
+	if err := c.sendPrivateRequest(ctx, creds, "GET", "/v5/account/wallet-balance", params, nil, &resp); err != nil {
+		return domain.MarginInfo{}, err
+	}
+
+	if len(resp.Result.List) == 0 {
+		return domain.MarginInfo{}, fmt.Errorf("wallet balance not found for UNIFIED account")
+	}
+
+	raw := resp.Result.List[0]
+
+	totalEquity, err := parseDecimalField("totalEquity", raw.TotalEquity)
+	if err != nil {
+		return domain.MarginInfo{}, err
+	}
+	totalMargin, err := parseDecimalField("totalMarginBalance", raw.TotalMarginBalance)
+	if err != nil {
+		return domain.MarginInfo{}, err
+	}
+	available, err := parseDecimalField("totalAvailableBalance", raw.TotalAvailable)
+	if err != nil {
+		return domain.MarginInfo{}, err
+	}
+	mmr, err := parseDecimalField("accountMMRate", raw.AccountMMRate)
+	if err != nil {
+		return domain.MarginInfo{}, err
+	}
+
+	return domain.MarginInfo{
+		TotalEquity:        totalEquity,
+		TotalMarginBalance: totalMargin,
+		AvailableBalance:   available,
+		MMR:                mmr,
+	}, nil
+}
+
+func (c *Client) GetAccountInfo(ctx context.Context, creds domain.APIKey) (domain.AccountInfo, error) {
+	var resp BaseResponse[AccountInfoResponse]
+	if err := c.sendPrivateRequest(ctx, creds, "GET", "/v5/account/info", nil, nil, &resp); err != nil {
+		return domain.AccountInfo{}, err
+	}
+
+	return domain.AccountInfo{
+		UnifiedMarginStatus: resp.Result.UnifiedMarginStatus,
+		MarginMode:          resp.Result.MarginMode,
+	}, nil
+}
+
+// GetAPIKeyInfo возвращает права и срок действия ключа, которым подписан запрос
+func (c *Client) GetAPIKeyInfo(ctx context.Context, creds domain.APIKey) (domain.APIKeyInfo, error) {
+	var resp BaseResponse[APIKeyInfoResponse]
+	if err := c.sendPrivateRequest(ctx, creds, "GET", "/v5/user/query-api", nil, nil, &resp); err != nil {
+		return domain.APIKeyInfo{}, err
+	}
+
+	info := domain.APIKeyInfo{
+		ReadOnly:    resp.Result.ReadOnly == 1,
+		Permissions: resp.Result.Permissions,
+		Unified:     resp.Result.Uta == 1,
+		AccountUID:  resp.Result.UserID,
+	}
+	// У бессрочных ключей expiredAt пустой
+	if resp.Result.ExpiredAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, resp.Result.ExpiredAt)
+		if err != nil {
+			return domain.APIKeyInfo{}, fmt.Errorf("parse api key expiredAt %q: %w", resp.Result.ExpiredAt, err)
+		}
+		info.ExpiresAt = expiresAt
+	}
+
+	return info, nil
+}
+
+// GetClosedPnL возвращает реализованный PnL за период: окнами по 7 дней, внутри окна - по курсору
+func (c *Client) GetClosedPnL(ctx context.Context, creds domain.APIKey, startTime, endTime time.Time) ([]domain.ClosedPnL, error) {
+	if !endTime.After(startTime) {
+		return nil, fmt.Errorf("invalid closed pnl period: %s - %s", startTime, endTime)
+	}
+
+	var records []domain.ClosedPnL
+	for windowStart := startTime; windowStart.Before(endTime); windowStart = windowStart.Add(closedPnLWindow) {
+		windowEnd := windowStart.Add(closedPnLWindow)
+		if windowEnd.After(endTime) {
+			windowEnd = endTime
+		}
+
+		page, err := c.fetchClosedPnLWindow(ctx, creds, windowStart, windowEnd)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, page...)
+	}
+
+	return records, nil
+}
+
+func (c *Client) fetchClosedPnLWindow(ctx context.Context, creds domain.APIKey, start, end time.Time) ([]domain.ClosedPnL, error) {
+	var records []domain.ClosedPnL
+	cursor := ""
+	for page := 0; page < maxClosedPnLPages; page++ {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx, nil)
+		}
+
+		params := map[string]string{
+			"category":  "option",
+			"startTime": strconv.FormatInt(start.UnixMilli(), 10),
+			"endTime":   strconv.FormatInt(end.UnixMilli(), 10),
+			"limit":     "100",
+		}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var resp BaseResponse[ClosedPnLResponse]
+		if err := c.sendPrivateRequest(ctx, creds, "GET", "/v5/position/closed-pnl", params, nil, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, item := range resp.Result.List {
+			record := domain.ClosedPnL{
+				Symbol:    item.Symbol,
+				OrderID:   item.OrderID,
+				Side:      item.Side,
+				CreatedAt: parseMillis(item.CreatedTime),
+			}
+
+			var err error
+			if record.Qty, err = parseDecimalField("qty", item.Qty); err != nil {
+				return nil, err
+			}
+			if record.AvgEntryPrice, err = parseDecimalField("avgEntryPrice", item.AvgEntryPrice); err != nil {
+				return nil, err
+			}
+			if record.AvgExitPrice, err = parseDecimalField("avgExitPrice", item.AvgExitPrice); err != nil {
+				return nil, err
+			}
+			if record.ClosedPnL, err = parseDecimalField("closedPnl", item.ClosedPnl); err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+
+		cursor = resp.Result.NextPageCursor
+		if cursor == "" || len(resp.Result.List) == 0 {
+			return records, nil
+		}
+	}
+
+	return nil, fmt.Errorf("closed-pnl exceeded %d pages for window %s", maxClosedPnLPages, start.Format(time.DateOnly))
+}
+
 func (c *Client) PlaceOrder(ctx context.Context, creds domain.APIKey, req domain.OrderRequest) (string, error) {
+	bodyParams := orderParams(req)
+	bodyParams["category"] = "option"
+
+	var resp BaseResponse[PlaceOrderResponse]
+	var reconcile func() error
+	if req.OrderLinkID != "" {
+		// Ответ мог потеряться, когда биржа уже приняла ордер: тогда повтор получит отказ-дубль
+		// orderLinkId, и ордер берем с биржи по нему же
+		reconcile = func() error {
+			order, err := c.GetOrder(ctx, creds, req.Symbol, req.OrderLinkID)
+			if err != nil {
+				return fmt.Errorf("reconcile order %s after retry: %w", req.OrderLinkID, err)
+			}
+			resp.Result.OrderID = order.OrderID
+			return nil
+		}
+	}
+	if err := c.sendPrivate(ctx, creds, "POST", "/v5/order/create", nil, bodyParams, &resp, reconcile); err != nil {
+		return "", err
+	}
+
+	return resp.Result.OrderID, nil
+}
+
+// PlaceBatchOrders отправляет несколько ордеров одним запросом.
+// Ошибка возвращается только если отклонен весь запрос; судьба каждого ордера - в его BatchOrderResult.
+func (c *Client) PlaceBatchOrders(ctx context.Context, creds domain.APIKey, reqs []domain.OrderRequest) ([]domain.BatchOrderResult, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	items := make([]map[string]interface{}, len(reqs))
+	for i, req := range reqs {
+		items[i] = orderParams(req)
+	}
+
+	bodyParams := map[string]interface{}{
+		"category": "option",
+		"request":  items,
+	}
+
+	var resp BatchOrderResponse
+	if err := c.sendPrivateRequest(ctx, creds, "POST", "/v5/order/create-batch", nil, bodyParams, &resp); err != nil {
+		return nil, err
+	}
+
+	// Сопоставляем ответы с запросами по orderLinkId, а по индексу - только если его нет
+	orderIDs := make(map[string]string, len(resp.Result.List))
+	for _, item := range resp.Result.List {
+		if item.OrderLinkID != "" {
+			orderIDs[item.OrderLinkID] = item.OrderID
+		}
+	}
+
+	results := make([]domain.BatchOrderResult, len(reqs))
+	for i, req := range reqs {
+		res := domain.BatchOrderResult{OrderLinkID: req.OrderLinkID}
+
+		if i < len(resp.RetExtInfo.List) && resp.RetExtInfo.List[i].Code != 0 {
+			ext := resp.RetExtInfo.List[i]
+			res.Err = &APIError{RetCode: ext.Code, RetMsg: ext.Msg}
+		} else if id, ok := orderIDs[req.OrderLinkID]; ok && req.OrderLinkID != "" {
+			res.OrderID = id
+		} else if i < len(resp.Result.List) && resp.Result.List[i].OrderID != "" {
+			res.OrderID = resp.Result.List[i].OrderID
+		} else {
+			res.Err = fmt.Errorf("no result for order %q in batch response", req.OrderLinkID)
+		}
+
+		results[i] = res
+	}
+
+	return results, nil
+}
+
+// AmendOrder двигает цену (и при необходимости объем) стоящего ордера без cancel/replace.
+// Нулевой newQty означает "объем не менять".
+func (c *Client) AmendOrder(ctx context.Context, creds domain.APIKey, symbol, orderLinkID string, newPrice, newQty decimal.Decimal) error {
+	bodyParams := map[string]interface{}{
+		"category":    "option",
+		"symbol":      symbol,
+		"orderLinkId": orderLinkID,
+		"price":       newPrice.String(),
+	}
+	if newQty.IsPositive() {
+		bodyParams["qty"] = newQty.String()
+	}
+
+	var resp BaseResponse[PlaceOrderResponse]
+	return c.sendPrivateRequest(ctx, creds, "POST", "/v5/order/amend", nil, bodyParams, &resp)
+}
+
+func (c *Client) CancelOrder(ctx context.Context, creds domain.APIKey, symbol, orderLinkID string) error {
 	bodyParams := map[string]interface{}{
 		"category":    "option",
+		"symbol":      symbol,
+		"orderLinkId": orderLinkID,
+	}
+
+	var resp BaseResponse[PlaceOrderResponse]
+	return c.sendPrivateRequest(ctx, creds, "POST", "/v5/order/cancel", nil, bodyParams, &resp)
+}
+
+// GetOrder возвращает состояние ордера по orderLinkId (активные и недавно закрытые)
+func (c *Client) GetOrder(ctx context.Context, creds domain.APIKey, symbol, orderLinkID string) (domain.Order, error) {
+	params := map[string]string{
+		"category":    "option",
+		"symbol":      symbol,
+		"orderLinkId": orderLinkID,
+	}
+
+	var resp BaseResponse[OrderListResponse]
+	if err := c.sendPrivateRequest(ctx, creds, "GET", "/v5/order/realtime", params, nil, &resp); err != nil {
+		return domain.Order{}, err
+	}
+
+	if len(resp.Result.List) == 0 {
+		return domain.Order{}, fmt.Errorf("order %s: %w", orderLinkID, domain.ErrOrderNotFound)
+	}
+
+	item := resp.Result.List[0]
+	order := domain.Order{
+		OrderID:     item.OrderID,
+		OrderLinkID: item.OrderLinkID,
+		Symbol:      item.Symbol,
+		Side:        item.Side,
+		Status:      item.OrderStatus,
+	}
+
+	var err error
+	if order.Price, err = parseDecimalField("price", item.Price); err != nil {
+		return domain.Order{}, err
+	}
+	if order.Qty, err = parseDecimalField("qty", item.Qty); err != nil {
+		return domain.Order{}, err
+	}
+	if order.CumExecQty, err = parseDecimalField("cumExecQty", item.CumExecQty); err != nil {
+		return domain.Order{}, err
+	}
+	if order.AvgPrice, err = parseDecimalField("avgPrice", item.AvgPrice); err != nil {
+		return domain.Order{}, err
+	}
+
+	return order, nil
+}
+
+func orderParams(req domain.OrderRequest) map[string]interface{} {
+	params := map[string]interface{}{
 		"symbol":      req.Symbol,
 		"side":        req.Side,
 		"orderType":   req.OrderType,
@@ -207,65 +887,68 @@ func (c *Client) PlaceOrder(ctx context.Context, creds domain.APIKey, req domain
 	}
 
 	if req.OrderType == "Limit" {
-		bodyParams["price"] = req.Price.String()
+		params["price"] = req.Price.String()
 	}
 	if req.ReduceOnly {
-		bodyParams["reduceOnly"] = true
+		params["reduceOnly"] = true
 	}
 	if req.TimeInForce != "" {
-		bodyParams["timeInForce"] = req.TimeInForce
-	}
-
-	var resp BaseResponse[PlaceOrderResponse]
-	if err := c.sendPrivateRequest(ctx, creds, "POST", "/v5/order/create", nil, bodyParams, &resp); err != nil {
-		return "", err
+		params["timeInForce"] = req.TimeInForce
 	}
-
-	return resp.Result.OrderID, nil
+	return params
 }
 
 // --- Private Helpers ---
 
-func (c *Client) sendPublicRequest(ctx context.Context, method, endpoint string, params map[string]string, result interface{}) error {
-	var queryString string
-	if len(params) > 0 {
-		var parts []string
-		for k, v := range params {
-			parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+// publicURL: рыночные данные берем из сети ключа, если вызывающий ее указал
+func (c *Client) publicURL(ctx context.Context) string {
+	if testnet, ok := domain.TestnetFromContext(ctx); ok {
+		if testnet {
+			return EnvTestnet.publicRESTURL()
 		}
-		queryString = strings.Join(parts, "&")
+		return EnvMainnet.publicRESTURL()
 	}
+	return c.env.publicRESTURL()
+}
+
+func (c *Client) sendPublicRequest(ctx context.Context, method, endpoint string, params map[string]string, result interface{}) error {
+	queryString := encodeQuery(params)
 
-	fullURL := c.baseURL + endpoint
+	fullURL := c.publicURL(ctx) + endpoint
 	if queryString != "" {
 		fullURL += "?" + queryString
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, fullURL, nil)
-	if err != nil {
-		return err
-	}
+	ctx, cancel := c.withOperationDeadline(ctx, endpoint)
+	defer cancel()
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	return c.withRetry(ctx, method == "GET", func() error {
+		if err := c.limiter.Wait(ctx, endpoint, ""); err != nil {
+			return err
+		}
+
+		attemptCtx, cancel := c.withAttemptTimeout(ctx, endpoint)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(attemptCtx, method, fullURL, nil)
+		if err != nil {
+			return err
+		}
 
-	return c.decodeResponse(resp.Body, result)
+		return c.execute(req, endpoint, "", "", result)
+	})
 }
 
 func (c *Client) sendPrivateRequest(ctx context.Context, creds domain.APIKey, method, endpoint string, queryParams map[string]string, bodyParams map[string]interface{}, result interface{}) error {
-	ts := fmt.Sprintf("%d", time.Now().UnixMilli())
-	
-	var queryString string
-	if len(queryParams) > 0 {
-		var parts []string
-		for k, v := range queryParams {
-			parts = append(parts, fmt.Sprintf("%s=%s", k, v))
-		}
-		queryString = strings.Join(parts, "&")
-	}
+	return c.sendPrivate(ctx, creds, method, endpoint, queryParams, bodyParams, result, nil)
+}
+
+// sendPrivate повторяет GET, а POST - только с reconcile: повтор, отклоненный как дубль orderLinkId,
+// значит, что прошлая попытка дошла до биржи, и reconcile сверяет результат по orderLinkId.
+// Amend и cancel без сверки не повторяются: их дубль биржа не распознает.
+func (c *Client) sendPrivate(ctx context.Context, creds domain.APIKey, method, endpoint string, queryParams map[string]string, bodyParams map[string]interface{}, result interface{}, reconcile func() error) error {
+	// Подписываем ровно ту строку, что уйдет в URL
+	queryString := encodeQuery(queryParams)
 
 	var bodyString string
 	if method == "POST" && bodyParams != nil {
@@ -276,49 +959,149 @@ func (c *Client) sendPrivateRequest(ctx context.Context, creds domain.APIKey, me
 		bodyString = string(jsonBytes)
 	}
 
-	var payload string
-	if method == "GET" {
-		payload = ts + creds.Key + RecvWindow + queryString
-	} else {
-		payload = ts + creds.Key + RecvWindow + bodyString
+	fullURL := keyEnvironment(creds).privateRESTURL() + endpoint
+	if queryString != "" {
+		fullURL += "?" + queryString
 	}
 
-	signature := generateSignature(payload, creds.Secret)
+	idempotent := method == "GET" || reconcile != nil
 
-	fullURL := c.baseURL + endpoint
-	if queryString != "" {
-		fullURL += "?" + queryString
+	ctx, cancel := c.withOperationDeadline(ctx, endpoint)
+	defer cancel()
+
+	// Прошлая попытка могла дойти до биржи (обрыв, таймаут, 5xx); отказ по лимиту биржа не исполняла
+	maybeSent := false
+	return c.withRetry(ctx, idempotent, func() error {
+		// Ждем бюджет до подписи, чтобы timestamp не устарел за время ожидания
+		if err := c.limiter.Wait(ctx, endpoint, creds.Key); err != nil {
+			return err
+		}
+
+		// Каждая попытка подписывается заново со свежим timestamp
+		ts := fmt.Sprintf("%d", time.Now().UnixMilli())
+
+		var payload string
+		if method == "GET" {
+			payload = ts + creds.Key + c.recvWindow + queryString
+		} else {
+			payload = ts + creds.Key + c.recvWindow + bodyString
+		}
+
+		signature := generateSignature(payload, creds.Secret)
+
+		var reqBody io.Reader
+		if bodyString != "" {
+			reqBody = bytes.NewBufferString(bodyString)
+		}
+
+		attemptCtx, cancel := c.withAttemptTimeout(ctx, endpoint)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(attemptCtx, method, fullURL, reqBody)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-BAPI-API-KEY", creds.Key)
+		req.Header.Set("X-BAPI-SIGN", signature)
+		req.Header.Set("X-BAPI-TIMESTAMP", ts)
+		req.Header.Set("X-BAPI-RECV-WINDOW", c.recvWindow)
+
+		err = c.execute(req, endpoint, creds.Key, bodyString, result)
+		if maybeSent && reconcile != nil && errors.Is(err, domain.ErrDuplicateOrderLinkID) {
+			return reconcile()
+		}
+		maybeSent = maybeSent || isRetryable(err)
+		return err
+	})
+}
+
+// encodeQuery: url.Values.Encode экранирует значения и сортирует ключи,
+// поэтому подписанная строка детерминирована и совпадает с тем, что восстановит Bybit
+func encodeQuery(params map[string]string) string {
+	values := make(url.Values, len(params))
+	for k, v := range params {
+		values.Set(k, v)
 	}
+	return values.Encode()
+}
 
-	var reqBody io.Reader
-	if bodyString != "" {
-		reqBody = bytes.NewBufferString(bodyString)
+// execute выполняет одну попытку запроса и помечает временные сбои как retryable
+func (c *Client) execute(req *http.Request, endpoint, apiKey, body string, result interface{}) error {
+	start := time.Now()
+	err := c.do(req, endpoint, apiKey, result)
+	latency := time.Since(start)
+
+	c.latency[classifyEndpoint(endpoint)].Observe(latency)
+	if c.debug {
+		c.logExchange(req, endpoint, body, latency, err)
 	}
+	return err
+}
 
-	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
-	if err != nil {
-		return err
+// logExchange пишет запрос в debug-лог только через Redact* хелперы
+func (c *Client) logExchange(req *http.Request, endpoint, body string, latency time.Duration, err error) {
+	attrs := []any{
+		slog.String("method", req.Method),
+		slog.String("endpoint", endpoint),
+		slog.String("query", RedactQuery(req.URL.RawQuery)),
+		slog.String("body", RedactBody(body)),
+		slog.Any("headers", RedactHeaders(req.Header)),
+		slog.Duration("latency", latency),
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-BAPI-API-KEY", creds.Key)
-	req.Header.Set("X-BAPI-SIGN", signature)
-	req.Header.Set("X-BAPI-TIMESTAMP", ts)
-	req.Header.Set("X-BAPI-RECV-WINDOW", RecvWindow)
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		attrs = append(attrs, slog.Int("ret_code", 0))
+	case errors.As(err, &apiErr):
+		attrs = append(attrs, slog.Int("ret_code", apiErr.RetCode), slog.String("ret_msg", apiErr.RetMsg))
+	default:
+		attrs = append(attrs, slog.String("err", err.Error()))
+	}
 
+	c.logger.Debug("bybit request", attrs...)
+}
+
+func (c *Client) do(req *http.Request, endpoint, apiKey string, result interface{}) error {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		// Таймаут попытки тоже повторяем; отмену контекста вызывающего отсекает withRetry
+		return retryable(err)
 	}
-	defer resp.Body.Close()
+	defer func() {
+		// Дочитываем тело, иначе соединение не вернется в пул
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	c.limiter.Observe(endpoint, apiKey, resp.Header)
 
-	return c.decodeResponse(resp.Body, result)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return retryable(fmt.Errorf("bybit http error: %s", resp.Status))
+	}
+
+	// 403 - бан по частоте запросов (IP rate limit), тело при этом не JSON
+	if resp.StatusCode == http.StatusForbidden {
+		return &rateLimitedError{
+			err:     fmt.Errorf("bybit http error: %s", resp.Status),
+			resetAt: limitResetTime(resp.Header),
+		}
+	}
+
+	err = c.decodeResponse(resp.Body, result)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetCode == RetCodeRateLimited {
+		return &rateLimitedError{err: err, resetAt: limitResetTime(resp.Header)}
+	}
+	return err
 }
 
 func (c *Client) decodeResponse(body io.Reader, result interface{}) error {
 	respBytes, err := io.ReadAll(body)
 	if err != nil {
-		return err
+		return retryable(err)
 	}
 
 	var base BaseResponse[interface{}]
@@ -327,12 +1110,47 @@ func (c *Client) decodeResponse(body io.Reader, result interface{}) error {
 	}
 
 	if base.RetCode != 0 {
-		return fmt.Errorf("bybit api error: [%d] %s", base.RetCode, base.RetMsg)
+		apiErr := &APIError{RetCode: base.RetCode, RetMsg: base.RetMsg}
+		if transientRetCodes[base.RetCode] {
+			return retryable(apiErr)
+		}
+		return apiErr
 	}
 
 	return json.Unmarshal(respBytes, result)
 }
 
+func parseOrderbookLevels(raw [][]string) ([]domain.OrderbookLevel, error) {
+	levels := make([]domain.OrderbookLevel, 0, len(raw))
+	for _, pair := range raw {
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid level %v", pair)
+		}
+		price, err := decimal.NewFromString(pair[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %q: %w", pair[0], err)
+		}
+		size, err := decimal.NewFromString(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %w", pair[1], err)
+		}
+		levels = append(levels, domain.OrderbookLevel{Price: price, Size: size})
+	}
+	return levels, nil
+}
+
+// parseDecimalField: пустая строка означает ноль, мусор в поле - ошибка, а не тихий ноль
+func parseDecimalField(field, value string) (decimal.Decimal, error) {
+	if value == "" {
+		return decimal.Zero, nil
+	}
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid %s value %q: %w", field, value, err)
+	}
+	return d, nil
+}
+
 func generateSignature(payload, secret string) string {
 	h := hmac.New(sha256.New, []byte(secret))
 	h.Write([]byte(payload))
@@ -0,0 +1,563 @@
+package bybit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// redirectTransport отправляет запросы клиента на тестовый сервер вместо api.bybit.com
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper // nil - http.DefaultTransport
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func newTestClient(t *testing.T, retry RetryPolicy, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	c := NewClient(ClientConfig{Retry: retry, Logger: testLogger()})
+	c.httpClient = &http.Client{Transport: redirectTransport{target: target}}
+	return c
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+var testCreds = domain.APIKey{Key: "test-key", Secret: "test-secret"}
+
+func TestGetMarginInfoZeroBalances(t *testing.T) {
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		// У пустого UTA-аккаунта Bybit отдает "" вместо "0"
+		w.Write([]byte(`{"retCode":0,"retMsg":"OK","result":{"list":[{"accountType":"UNIFIED",
+			"totalEquity":"0","totalMarginBalance":"","totalAvailableBalance":"","accountMMRate":""}]}}`))
+	})
+
+	info, err := c.GetMarginInfo(context.Background(), testCreds)
+	if err != nil {
+		t.Fatalf("GetMarginInfo: %v", err)
+	}
+	if !info.TotalEquity.IsZero() || !info.TotalMarginBalance.IsZero() || !info.AvailableBalance.IsZero() || !info.MMR.IsZero() {
+		t.Fatalf("want zero balances, got %+v", info)
+	}
+}
+
+func TestGetMarginInfoMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"bad decimal", `{"retCode":0,"result":{"list":[{"totalEquity":"12.5","accountMMRate":"n/a"}]}}`, "accountMMRate"},
+		{"empty list", `{"retCode":0,"result":{"list":[]}}`, "wallet balance not found"},
+		{"not json", `<html>bad gateway</html>`, "failed to parse response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			})
+
+			info, err := c.GetMarginInfo(context.Background(), testCreds)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q (info %+v)", err, tt.want, info)
+			}
+		})
+	}
+}
+
+func TestGetUnderlyingPrice(t *testing.T) {
+	const ticker = `{"retCode":0,"result":{"list":[{"symbol":"BTCUSDT","indexPrice":"60000.5","markPrice":"60010","lastPrice":"60020.1"}]}}`
+	tests := []struct {
+		source  domain.PriceSource
+		body    string
+		want    string
+		wantErr string
+	}{
+		{domain.PriceSourceIndex, ticker, "60000.5", ""},
+		{domain.PriceSourceMark, ticker, "60010", ""},
+		{domain.PriceSourceLast, ticker, "60020.1", ""},
+		{domain.PriceSourceLast, `{"retCode":0,"result":{"list":[{"symbol":"BTCUSDT","indexPrice":"60000.5","markPrice":"60010","lastPrice":"0"}]}}`, "", "last price is empty"},
+		{domain.PriceSourceMark, `{"retCode":0,"result":{"list":[]}}`, "", "mark price not found"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.source)+"/"+tt.want, func(t *testing.T) {
+			c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("category") != "linear" || r.URL.Query().Get("symbol") != "BTCUSDT" {
+					t.Errorf("query %s, want linear BTCUSDT", r.URL.RawQuery)
+				}
+				w.Write([]byte(tt.body))
+			})
+
+			price, err := c.GetUnderlyingPrice(context.Background(), "BTCUSDT", tt.source)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetUnderlyingPrice: %v", err)
+			}
+			if price.String() != tt.want {
+				t.Fatalf("price %s, want %s", price, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSignature(t *testing.T) {
+	// Вектор посчитан независимо: HMAC-SHA256(secret, ts + key + recvWindow + query)
+	payload := "1700000000000" + "test-key" + "5000" + "category=option&orderLinkId=close-7-v3&symbol=BTC-27DEC24-60000-P"
+	want := "b800c9e62fbcf457e3be4ffd46f83a8e8ff90ae44e6431c6b4613cbdedffc74a"
+	if got := generateSignature(payload, "test-secret"); got != want {
+		t.Fatalf("signature %s, want %s", got, want)
+	}
+}
+
+func TestEncodeQueryCanonical(t *testing.T) {
+	got := encodeQuery(map[string]string{"symbol": "BTC-27DEC24-60000-P", "category": "option", "cursor": "a=1&b 2"})
+	want := "category=option&cursor=a%3D1%26b+2&symbol=BTC-27DEC24-60000-P"
+	if got != want {
+		t.Fatalf("query %q, want %q", got, want)
+	}
+}
+
+// checkSignature пересчитывает подпись так, как это делает Bybit: по заголовкам и сырой строке запроса или телу
+func checkSignature(t *testing.T, r *http.Request, signed string) {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(testCreds.Secret))
+	mac.Write([]byte(r.Header.Get("X-BAPI-TIMESTAMP") + r.Header.Get("X-BAPI-API-KEY") + r.Header.Get("X-BAPI-RECV-WINDOW") + signed))
+	if want := hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-BAPI-SIGN") != want {
+		t.Errorf("X-BAPI-SIGN %s, want %s for %q", r.Header.Get("X-BAPI-SIGN"), want, signed)
+	}
+	if r.Header.Get("X-BAPI-API-KEY") != testCreds.Key || r.Header.Get("X-BAPI-RECV-WINDOW") == "" {
+		t.Errorf("auth headers %v", r.Header)
+	}
+}
+
+func TestPrivateGetSignsQuery(t *testing.T) {
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		if want := "category=option&orderLinkId=close-7-v3&symbol=BTC-27DEC24-60000-P"; r.URL.RawQuery != want {
+			t.Errorf("query %q, want %q", r.URL.RawQuery, want)
+		}
+		checkSignature(t, r, r.URL.RawQuery)
+		w.Write([]byte(`{"retCode":0,"result":{"list":[{"orderLinkId":"close-7-v3","orderStatus":"Filled","qty":"0.1","cumExecQty":"0.1"}]}}`))
+	})
+
+	order, err := c.GetOrder(context.Background(), testCreds, "BTC-27DEC24-60000-P", "close-7-v3")
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if !order.IsFilled() {
+		t.Fatalf("order %+v", order)
+	}
+}
+
+func TestPrivatePostSignsBody(t *testing.T) {
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.RawQuery != "" {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		checkSignature(t, r, string(body))
+		w.Write([]byte(`{"retCode":0,"result":{"orderId":"42"}}`))
+	})
+
+	id, err := c.PlaceOrder(context.Background(), testCreds, domain.OrderRequest{
+		Symbol: "BTC-27DEC24-60000-P", Side: domain.SideBuy, OrderType: "Market", OrderLinkID: "close-7-v3",
+	})
+	if err != nil || id != "42" {
+		t.Fatalf("PlaceOrder = %q, %v", id, err)
+	}
+}
+
+func TestGetOptionStrikesFollowsCursor(t *testing.T) {
+	pages := map[string]string{
+		"": `{"retCode":0,"result":{"nextPageCursor":"page2","list":[
+			{"symbol":"BTC-27DEC24-60000-P","strikePrice":"60000"},
+			{"symbol":"BTC-28DEC24-61000-P","strikePrice":"61000"}]}}`,
+		"page2": `{"retCode":0,"result":{"nextPageCursor":"","list":[
+			{"symbol":"BTC-27DEC24-58000-P","strikePrice":"58000"},
+			{"symbol":"BTC-27DEC24-60000-C","strikePrice":"60000"}]}}`,
+	}
+	var cursors []string
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("baseCoin") != "BTC" || q.Get("category") != "option" {
+			t.Errorf("query %q", r.URL.RawQuery)
+		}
+		cursor := q.Get("cursor")
+		cursors = append(cursors, cursor)
+		w.Write([]byte(pages[cursor]))
+	})
+
+	strikes, err := c.GetOptionStrikes(context.Background(), "BTC", "27DEC24")
+	if err != nil {
+		t.Fatalf("GetOptionStrikes: %v", err)
+	}
+	if !slices.Equal(cursors, []string{"", "page2"}) {
+		t.Fatalf("cursors %q, want first page and page2", cursors)
+	}
+	slices.SortFunc(strikes, decimal.Decimal.Cmp)
+	if len(strikes) != 2 || !strikes[0].Equal(decimal.NewFromInt(58000)) || !strikes[1].Equal(decimal.NewFromInt(60000)) {
+		t.Fatalf("strikes %v, want 58000 and 60000 of 27DEC24", strikes)
+	}
+}
+
+func TestPlaceBatchOrders(t *testing.T) {
+	reqs := []domain.OrderRequest{
+		{Symbol: "BTC-27DEC24-60000-P", Side: domain.SideBuy, OrderType: "Market", Qty: decimal.RequireFromString("0.1"), OrderLinkID: "close-7-v3", ReduceOnly: true},
+		{Symbol: "BTC-3JAN25-58000-P", Side: domain.SideSell, OrderType: "Limit", Qty: decimal.RequireFromString("0.1"), Price: decimal.NewFromInt(900), OrderLinkID: "open-7-v3"},
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantIDs []string // OrderID по ордерам; "" - ордер отклонен
+		wantErr []string // Текст ошибки ордера; "" - ошибки нет
+	}{
+		{
+			name: "all success",
+			body: `{"retCode":0,"retMsg":"OK","result":{"list":[
+				{"category":"option","symbol":"BTC-27DEC24-60000-P","orderId":"id-close","orderLinkId":"close-7-v3"},
+				{"category":"option","symbol":"BTC-3JAN25-58000-P","orderId":"id-open","orderLinkId":"open-7-v3"}]},
+				"retExtInfo":{"list":[{"code":0,"msg":"OK"},{"code":0,"msg":"OK"}]}}`,
+			wantIDs: []string{"id-close", "id-open"},
+			wantErr: []string{"", ""},
+		},
+		{
+			name: "mixed",
+			// Bybit оставляет для отклоненного ордера пустую запись в result.list
+			body: `{"retCode":0,"retMsg":"OK","result":{"list":[
+				{"category":"option","symbol":"BTC-27DEC24-60000-P","orderId":"id-close","orderLinkId":"close-7-v3"},
+				{"category":"","symbol":"","orderId":"","orderLinkId":""}]},
+				"retExtInfo":{"list":[{"code":0,"msg":"OK"},{"code":110007,"msg":"Insufficient available balance"}]}}`,
+			wantIDs: []string{"id-close", ""},
+			wantErr: []string{"", "Insufficient available balance"},
+		},
+		{
+			name: "all fail",
+			body: `{"retCode":0,"retMsg":"OK","result":{"list":[
+				{"category":"","symbol":"","orderId":"","orderLinkId":""},
+				{"category":"","symbol":"","orderId":"","orderLinkId":""}]},
+				"retExtInfo":{"list":[{"code":110017,"msg":"Reduce-only order has same side with current position"},{"code":170213,"msg":"Order does not exist"}]}}`,
+			wantIDs: []string{"", ""},
+			wantErr: []string{"Reduce-only", "Order does not exist"},
+		},
+		{
+			name: "results out of order",
+			body: `{"retCode":0,"retMsg":"OK","result":{"list":[
+				{"category":"option","symbol":"BTC-3JAN25-58000-P","orderId":"id-open","orderLinkId":"open-7-v3"},
+				{"category":"option","symbol":"BTC-27DEC24-60000-P","orderId":"id-close","orderLinkId":"close-7-v3"}]},
+				"retExtInfo":{"list":[{"code":0,"msg":"OK"},{"code":0,"msg":"OK"}]}}`,
+			wantIDs: []string{"id-close", "id-open"},
+			wantErr: []string{"", ""},
+		},
+		{
+			name:    "missing result",
+			body:    `{"retCode":0,"retMsg":"OK","result":{"list":[{"orderId":"id-close","orderLinkId":"close-7-v3"}]},"retExtInfo":{"list":[{"code":0,"msg":"OK"}]}}`,
+			wantIDs: []string{"id-close", ""},
+			wantErr: []string{"", "no result for order"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v5/order/create-batch" {
+					t.Errorf("path %s", r.URL.Path)
+				}
+				var body struct {
+					Category string                   `json:"category"`
+					Request  []map[string]interface{} `json:"request"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("decode body: %v", err)
+				}
+				if body.Category != "option" || len(body.Request) != 2 || body.Request[0]["orderLinkId"] != "close-7-v3" ||
+					body.Request[0]["reduceOnly"] != true || body.Request[1]["price"] != "900" {
+					t.Errorf("batch request %+v", body)
+				}
+				w.Write([]byte(tt.body))
+			})
+
+			results, err := c.PlaceBatchOrders(context.Background(), testCreds, reqs)
+			if err != nil {
+				t.Fatalf("PlaceBatchOrders: %v", err)
+			}
+			if len(results) != len(reqs) {
+				t.Fatalf("got %d results, want %d", len(results), len(reqs))
+			}
+			for i, res := range results {
+				if res.OrderLinkID != reqs[i].OrderLinkID || res.OrderID != tt.wantIDs[i] {
+					t.Errorf("result %d = %+v, want order %q", i, res, tt.wantIDs[i])
+				}
+				if tt.wantErr[i] == "" {
+					if res.Err != nil {
+						t.Errorf("result %d: unexpected error %v", i, res.Err)
+					}
+				} else if res.Err == nil || !strings.Contains(res.Err.Error(), tt.wantErr[i]) {
+					t.Errorf("result %d: err = %v, want %q", i, res.Err, tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetOptionExpiriesAcrossYearBoundary(t *testing.T) {
+	delivery := func(year int, month time.Month, day int) int64 {
+		return time.Date(year, month, day, 8, 0, 0, 0, time.UTC).UnixMilli()
+	}
+	// Страницы вразнобой и с повторами: экспирация на каждый страйк
+	body := fmt.Sprintf(`{"retCode":0,"result":{"nextPageCursor":"","list":[
+		{"symbol":"BTC-2JAN26-90000-P","deliveryTime":"%d"},
+		{"symbol":"BTC-26DEC25-90000-P","deliveryTime":"%d"},
+		{"symbol":"BTC-26DEC25-95000-P","deliveryTime":"%d"},
+		{"symbol":"BTC-30JAN26-90000-P","deliveryTime":"%d"}]}}`,
+		delivery(2026, time.January, 2), delivery(2025, time.December, 26), delivery(2025, time.December, 26), delivery(2026, time.January, 30))
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+
+	expiries, err := c.GetOptionExpiries(context.Background(), "BTC")
+	if err != nil {
+		t.Fatalf("GetOptionExpiries: %v", err)
+	}
+	var codes []string
+	for _, e := range expiries {
+		codes = append(codes, e.Code)
+	}
+	if !slices.Equal(codes, []string{"26DEC25", "2JAN26", "30JAN26"}) {
+		t.Fatalf("expiries %v, want sorted 26DEC25, 2JAN26, 30JAN26", codes)
+	}
+
+	tests := []struct {
+		current string
+		want    string
+	}{
+		{"26DEC25", "2JAN26"}, // Через границу года
+		{"2JAN26", "30JAN26"},
+		{"19DEC25", "26DEC25"},
+	}
+	for _, tt := range tests {
+		next, err := domain.NextExpiryAfter(tt.current, expiries)
+		if err != nil || next.Code != tt.want {
+			t.Errorf("NextExpiryAfter(%s) = %q, %v; want %s", tt.current, next.Code, err, tt.want)
+		}
+		if parsed, err := domain.ParseExpirationFromSymbol("BTC-" + next.Code + "-90000-P"); err != nil || !parsed.Equal(next.Time) {
+			t.Errorf("%s parses to %v, %v; want %v", next.Code, parsed, err, next.Time)
+		}
+	}
+	if _, err := domain.NextExpiryAfter("30JAN26", expiries); err == nil {
+		t.Error("NextExpiryAfter(last expiry): want error")
+	}
+}
+
+func TestParseDecimalField(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "0", false},
+		{"0", "0", false},
+		{"1250.5", "1250.5", false},
+		{"-0.25", "-0.25", false},
+		{"n/a", "", true},
+		{" ", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseDecimalField("markPrice", tt.value)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "markPrice") {
+				t.Errorf("parseDecimalField(%q): err = %v, want an error naming the field", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("parseDecimalField(%q) = %s, %v; want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestGetOptionChainEmptyFields(t *testing.T) {
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		// Неликвидный страйк: ни бида, ни аска, греки не посчитаны
+		w.Write([]byte(`{"retCode":0,"result":{"list":[
+			{"symbol":"BTC-27DEC24-62000-P","bid1Price":"","ask1Price":"","markPrice":"","markIv":"","delta":"","gamma":"","vega":""},
+			{"symbol":"BTC-27DEC24-60000-P","bid1Price":"1200","ask1Price":"","markPrice":"1250","markIv":"0.55","delta":"-0.42","gamma":"","vega":"35.1"}]}}`))
+	})
+
+	quotes, err := c.GetOptionChain(context.Background(), "BTC", "27DEC24")
+	if err != nil {
+		t.Fatalf("GetOptionChain: %v", err)
+	}
+	if len(quotes) != 2 {
+		t.Fatalf("got %d quotes, want 2", len(quotes))
+	}
+	liquid, illiquid := quotes[0], quotes[1]
+	if liquid.BidPrice.String() != "1200" || !liquid.AskPrice.IsZero() || liquid.Delta.String() != "-0.42" || !liquid.Gamma.IsZero() {
+		t.Errorf("quote %+v", liquid)
+	}
+	for _, v := range []decimal.Decimal{illiquid.MarkPrice, illiquid.BidPrice, illiquid.AskPrice, illiquid.MarkIV, illiquid.Delta, illiquid.Gamma, illiquid.Vega} {
+		if !v.IsZero() {
+			t.Fatalf("empty fields must parse as zero, got %+v", illiquid)
+		}
+	}
+}
+
+func TestGetOptionChainMalformedField(t *testing.T) {
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"retCode":0,"result":{"list":[{"symbol":"BTC-27DEC24-60000-P","markPrice":"1250","delta":"NaN?"}]}}`))
+	})
+
+	if _, err := c.GetOptionChain(context.Background(), "BTC", "27DEC24"); err == nil || !strings.Contains(err.Error(), "delta") {
+		t.Fatalf("err = %v, want invalid delta", err)
+	}
+}
+
+func TestDebugLogRedactsSecrets(t *testing.T) {
+	var signatures []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get("X-BAPI-SIGN"))
+		w.Write([]byte(`{"retCode":0,"result":{"orderId":"42","list":[{"orderLinkId":"close-7-v3","orderStatus":"Filled"}]}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	target, _ := url.Parse(srv.URL)
+	c := NewClient(ClientConfig{Debug: true, Logger: logger})
+	c.httpClient = &http.Client{Transport: redirectTransport{target: target}}
+
+	creds := domain.APIKey{Key: "k3y-AbCdEf123456", Secret: "s3cret-ZyXwVu987654"}
+	if _, err := c.GetOrder(context.Background(), creds, "BTC-27DEC24-60000-P", "close-7-v3"); err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if _, err := c.PlaceOrder(context.Background(), creds, domain.OrderRequest{
+		Symbol: "BTC-27DEC24-60000-P", Side: domain.SideBuy, OrderType: "Market", OrderLinkID: "close-7-v3",
+	}); err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+
+	out := logs.String()
+	if strings.Count(out, "bybit request") != 2 {
+		t.Fatalf("want two debug entries, got:\n%s", out)
+	}
+	for _, endpoint := range []string{"/v5/order/realtime", "/v5/order/create"} {
+		if !strings.Contains(out, endpoint) {
+			t.Errorf("debug log has no %s entry", endpoint)
+		}
+	}
+	secrets := append([]string{creds.Key, creds.Secret}, signatures...)
+	for _, secret := range secrets {
+		if secret != "" && strings.Contains(out, secret) {
+			t.Errorf("debug log leaks %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, redactedValue) {
+		t.Errorf("debug log has no redacted headers:\n%s", out)
+	}
+}
+
+func TestRedactHelpers(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"query", RedactQuery("api_key=abc&symbol=BTCUSDT&sign=deadbeef"), "api_key=%5BREDACTED%5D&sign=%5BREDACTED%5D&symbol=BTCUSDT"},
+		{"empty query", RedactQuery(""), ""},
+		{"nested body", RedactBody(`{"apiKey":"abc","request":[{"symbol":"BTCUSDT","signature":"x"}],"auth":{"secret":"y"}}`),
+			`{"apiKey":"[REDACTED]","auth":{"secret":"[REDACTED]"},"request":[{"signature":"[REDACTED]","symbol":"BTCUSDT"}]}`},
+		{"unparsable body", RedactBody(`api_secret=plain`), redactedValue},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+
+	headers := RedactHeaders(http.Header{"X-Bapi-Api-Key": {"abc"}, "X-Bapi-Sign": {"def"}, "Content-Type": {"application/json"}})
+	if headers["X-Bapi-Api-Key"] != redactedValue || headers["X-Bapi-Sign"] != redactedValue || headers["Content-Type"] != "application/json" {
+		t.Errorf("headers %v", headers)
+	}
+}
+
+func TestGetClosedPnLFollowsCursor(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * 24 * time.Hour) // Два окна по 7 дней
+	secondWindow := strconv.FormatInt(start.Add(closedPnLWindow).UnixMilli(), 10)
+
+	record := func(orderID, pnl string) string {
+		return `{"symbol":"BTC-7MAR25-80000-P","orderId":"` + orderID + `","side":"Buy","qty":"0.1","avgEntryPrice":"900","avgExitPrice":"300","closedPnl":"` + pnl + `","createdTime":"1741000000000"}`
+	}
+	pages := map[string]string{
+		"first:":    `{"retCode":0,"result":{"nextPageCursor":"p2","list":[` + record("o-1", "60") + `,` + record("o-2", "-5") + `]}}`,
+		"first:p2":  `{"retCode":0,"result":{"nextPageCursor":"p3","list":[` + record("o-3", "12.5") + `]}}`,
+		"first:p3":  `{"retCode":0,"result":{"nextPageCursor":"","list":[` + record("o-4", "0") + `]}}`,
+		"second:":   `{"retCode":0,"result":{"nextPageCursor":"q2","list":[` + record("o-5", "7") + `]}}`,
+		"second:q2": `{"retCode":0,"result":{"nextPageCursor":"q3","list":[]}}`, // Пустая страница завершает окно
+	}
+	var requests []string
+	c := newTestClient(t, RetryPolicy{}, func(w http.ResponseWriter, r *http.Request) {
+		checkSignature(t, r, r.URL.RawQuery)
+		q := r.URL.Query()
+		window := "first"
+		if q.Get("startTime") == secondWindow {
+			window = "second"
+		}
+		key := window + ":" + q.Get("cursor")
+		requests = append(requests, key)
+		w.Write([]byte(pages[key]))
+	})
+
+	records, err := c.GetClosedPnL(context.Background(), testCreds, start, end)
+	if err != nil {
+		t.Fatalf("GetClosedPnL: %v", err)
+	}
+	if want := []string{"first:", "first:p2", "first:p3", "second:", "second:q2"}; !slices.Equal(requests, want) {
+		t.Fatalf("requests %q, want %q", requests, want)
+	}
+	var ids []string
+	total := decimal.Zero
+	for _, r := range records {
+		ids = append(ids, r.OrderID)
+		total = total.Add(r.ClosedPnL)
+	}
+	if !slices.Equal(ids, []string{"o-1", "o-2", "o-3", "o-4", "o-5"}) {
+		t.Fatalf("records %v, want all pages of both windows", ids)
+	}
+	if !total.Equal(decimal.RequireFromString("74.5")) {
+		t.Fatalf("total pnl %s, want 74.5", total)
+	}
+}
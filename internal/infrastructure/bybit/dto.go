@@ -11,15 +11,38 @@ type BaseResponse[T any] struct {
 
 // --- DTOs для конкретных эндпоинтов ---
 
-// TickerResponse - для получения цены (GetMarkPrice)
+// TickerResponse - для получения цены (GetIndexPrice, GetMarkPrice)
 type TickerResponse struct {
 	List []struct {
-		Symbol    string          `json:"symbol"`
-		MarkPrice decimal.Decimal `json:"markPrice"`
-		LastPrice decimal.Decimal `json:"lastPrice"`
+		Symbol     string          `json:"symbol"`
+		IndexPrice decimal.Decimal `json:"indexPrice"`
+		MarkPrice  decimal.Decimal `json:"markPrice"`
+		LastPrice  decimal.Decimal `json:"lastPrice"`
 	} `json:"list"`
 }
 
+// OptionTickerResponse - тикеры опционов с греками. Пустые поля приходят как ""
+type OptionTickerResponse struct {
+	List []struct {
+		Symbol    string `json:"symbol"`
+		Bid1Price string `json:"bid1Price"`
+		Ask1Price string `json:"ask1Price"`
+		MarkPrice string `json:"markPrice"`
+		MarkIv    string `json:"markIv"`
+		Delta     string `json:"delta"`
+		Gamma     string `json:"gamma"`
+		Vega      string `json:"vega"`
+	} `json:"list"`
+}
+
+// OrderbookResponse - стакан (GetOrderbook). Уровни приходят парами строк [price, size]
+type OrderbookResponse struct {
+	Symbol string     `json:"s"`
+	Bids   [][]string `json:"b"`
+	Asks   [][]string `json:"a"`
+	Ts     int64      `json:"ts"`
+}
+
 // PositionResponse - для получения позиций (GetPosition)
 type PositionResponse struct {
 	List []struct {
@@ -33,11 +56,14 @@ type PositionResponse struct {
 }
 
 // WalletBalanceResponse - для маржи (GetMarginInfo)
+// Поля приходят строками: у пустого UTA-аккаунта Bybit может вернуть "" вместо "0"
 type WalletBalanceResponse struct {
 	List []struct {
-		TotalEquity        decimal.Decimal `json:"totalEquity"`
-		TotalMarginBalance decimal.Decimal `json:"totalMarginBalance"`
-		AccountMMRate      decimal.Decimal `json:"accountMMRate"` // MMR аккаунта
+		AccountType        string `json:"accountType"`
+		TotalEquity        string `json:"totalEquity"`
+		TotalMarginBalance string `json:"totalMarginBalance"`
+		TotalAvailable     string `json:"totalAvailableBalance"`
+		AccountMMRate      string `json:"accountMMRate"` // MMR аккаунта
 	} `json:"list"`
 }
 
@@ -47,6 +73,39 @@ type PlaceOrderResponse struct {
 	OrderLinkID string `json:"orderLinkId"`
 }
 
+// OrderListResponse - ответ /v5/order/realtime
+type OrderListResponse struct {
+	List []struct {
+		OrderID     string `json:"orderId"`
+		OrderLinkID string `json:"orderLinkId"`
+		Symbol      string `json:"symbol"`
+		Side        string `json:"side"`
+		OrderStatus string `json:"orderStatus"`
+		Price       string `json:"price"`
+		Qty         string `json:"qty"`
+		CumExecQty  string `json:"cumExecQty"`
+		AvgPrice    string `json:"avgPrice"`
+	} `json:"list"`
+}
+
+// BatchOrderResponse - ответ /v5/order/create-batch.
+// retExtInfo.list идет в том же порядке, что и ордера в запросе.
+type BatchOrderResponse struct {
+	Result struct {
+		List []struct {
+			Symbol      string `json:"symbol"`
+			OrderID     string `json:"orderId"`
+			OrderLinkID string `json:"orderLinkId"`
+		} `json:"list"`
+	} `json:"result"`
+	RetExtInfo struct {
+		List []struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		} `json:"list"`
+	} `json:"retExtInfo"`
+}
+
 type InstrumentInfoResponse struct {
 	RetCode int    `json:"retCode"`
 	RetMsg  string `json:"retMsg"`
@@ -61,5 +120,46 @@ type InstrumentInfoResponse struct {
 			ActivationDate  string `json:"activationDate"`
 			DeliveryTime    string `json:"deliveryTime"`
 		} `json:"list"`
+		NextPageCursor string `json:"nextPageCursor"`
 	} `json:"result"`
-}
\ No newline at end of file
+}
+
+// DeliveryPriceResponse - ответ /v5/market/delivery-price
+type DeliveryPriceResponse struct {
+	List []struct {
+		Symbol        string `json:"symbol"`
+		DeliveryPrice string `json:"deliveryPrice"`
+		DeliveryTime  string `json:"deliveryTime"`
+	} `json:"list"`
+	NextPageCursor string `json:"nextPageCursor"`
+}
+
+// ClosedPnLResponse - ответ /v5/position/closed-pnl
+type ClosedPnLResponse struct {
+	List []struct {
+		Symbol        string `json:"symbol"`
+		OrderID       string `json:"orderId"`
+		Side          string `json:"side"`
+		Qty           string `json:"qty"`
+		AvgEntryPrice string `json:"avgEntryPrice"`
+		AvgExitPrice  string `json:"avgExitPrice"`
+		ClosedPnl     string `json:"closedPnl"`
+		CreatedTime   string `json:"createdTime"`
+	} `json:"list"`
+	NextPageCursor string `json:"nextPageCursor"`
+}
+
+// APIKeyInfoResponse - ответ /v5/user/query-api
+type APIKeyInfoResponse struct {
+	ReadOnly    int                 `json:"readOnly"`
+	Permissions map[string][]string `json:"permissions"`
+	ExpiredAt   string              `json:"expiredAt"`
+	Uta         int                 `json:"uta"`
+	UserID      int64               `json:"userID"`
+}
+
+// AccountInfoResponse - ответ /v5/account/info
+type AccountInfoResponse struct {
+	UnifiedMarginStatus int    `json:"unifiedMarginStatus"`
+	MarginMode          string `json:"marginMode"`
+}
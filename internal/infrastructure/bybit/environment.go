@@ -0,0 +1,134 @@
+package bybit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Environment - контур Bybit, с которым работает клиент
+type Environment string
+
+const (
+	EnvMainnet Environment = "mainnet"
+	EnvTestnet Environment = "testnet"
+	// EnvDemo - демо-торговля: реальные рыночные данные mainnet, бумажные средства
+	EnvDemo Environment = "demo"
+)
+
+const (
+	DemoBaseURL    = "https://api-demo.bybit.com"
+	DemoPrivateURL = "wss://stream-demo.bybit.com/v5/private"
+)
+
+func ParseEnvironment(value string) (Environment, error) {
+	switch env := Environment(strings.ToLower(strings.TrimSpace(value))); env {
+	case EnvMainnet, EnvTestnet, EnvDemo:
+		return env, nil
+	default:
+		return "", fmt.Errorf("unknown bybit environment %q", value)
+	}
+}
+
+// publicRESTURL: у демо своих рыночных данных нет, берем mainnet
+func (e Environment) publicRESTURL() string {
+	if e == EnvTestnet {
+		return TestnetBaseURL
+	}
+	return MainnetBaseURL
+}
+
+func (e Environment) privateRESTURL() string {
+	switch e {
+	case EnvTestnet:
+		return TestnetBaseURL
+	case EnvDemo:
+		return DemoBaseURL
+	default:
+		return MainnetBaseURL
+	}
+}
+
+func (e Environment) linearStreamURL() string {
+	if e == EnvTestnet {
+		return TestnetLinearParams
+	}
+	return MainnetLinearParams
+}
+
+func (e Environment) optionStreamURL() string {
+	if e == EnvTestnet {
+		return TestnetOptionParams
+	}
+	return MainnetOptionParams
+}
+
+func (e Environment) privateStreamURL() string {
+	switch e {
+	case EnvTestnet:
+		return TestnetPrivateURL
+	case EnvDemo:
+		return DemoPrivateURL
+	default:
+		return MainnetPrivateURL
+	}
+}
+
+// StreamURLs - адреса WebSocket вместо стандартных: альтернативный домен (bytick.com)
+// или локальный replay-сервер. Пустое поле - адрес Bybit по умолчанию.
+type StreamURLs struct {
+	MainnetLinear  string
+	TestnetLinear  string
+	MainnetOption  string
+	TestnetOption  string
+	MainnetPrivate string
+	TestnetPrivate string
+	DemoPrivate    string
+}
+
+func (u StreamURLs) linear(env Environment) string {
+	override := u.MainnetLinear
+	if env == EnvTestnet {
+		override = u.TestnetLinear
+	}
+	return orDefault(override, env.linearStreamURL())
+}
+
+func (u StreamURLs) option(env Environment) string {
+	override := u.MainnetOption
+	if env == EnvTestnet {
+		override = u.TestnetOption
+	}
+	return orDefault(override, env.optionStreamURL())
+}
+
+func (u StreamURLs) private(env Environment) string {
+	override := u.MainnetPrivate
+	switch env {
+	case EnvTestnet:
+		override = u.TestnetPrivate
+	case EnvDemo:
+		override = u.DemoPrivate
+	}
+	return orDefault(override, env.privateStreamURL())
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// keyEnvironment: приватные запросы маршрутизируются по флагам ключа, а не по контуру процесса
+func keyEnvironment(creds domain.APIKey) Environment {
+	switch {
+	case creds.IsDemo:
+		return EnvDemo
+	case creds.IsTestnet:
+		return EnvTestnet
+	default:
+		return EnvMainnet
+	}
+}
@@ -0,0 +1,54 @@
+package bybit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+const (
+	RetCodeParamsError        = 10001
+	RetCodeInvalidAPIKey      = 10003
+	RetCodeInvalidSign        = 10004
+	RetCodePermissionDenied   = 10005
+	RetCodeRateLimited        = 10006
+	RetCodeInsufficientMargin = 110007
+	RetCodeDuplicateLinkID    = 110072
+	RetCodeInvalidSymbol      = 170121
+)
+
+// APIError - ответ Bybit с retCode != 0.
+// Сопоставляется с доменными ошибками через errors.Is (domain.ErrRateLimited и т.д.).
+type APIError struct {
+	RetCode int
+	RetMsg  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("bybit api error: [%d] %s", e.RetCode, e.RetMsg)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case domain.ErrInsufficientMargin:
+		return e.RetCode == RetCodeInsufficientMargin
+	case domain.ErrDuplicateOrderLinkID:
+		return e.RetCode == RetCodeDuplicateLinkID
+	case domain.ErrRateLimited:
+		return e.RetCode == RetCodeRateLimited
+	case domain.ErrInvalidSymbol:
+		// Для опционов Bybit отдает невалидный символ как общий params error
+		return e.RetCode == RetCodeInvalidSymbol ||
+			(e.RetCode == RetCodeParamsError && strings.Contains(strings.ToLower(e.RetMsg), "symbol"))
+	case domain.ErrInvalidCredentials:
+		return e.RetCode == RetCodeInvalidAPIKey || e.RetCode == RetCodeInvalidSign
+	case domain.ErrAccountNotReady:
+		// Ключ без прав на опционы или классический аккаунт
+		return e.RetCode == RetCodePermissionDenied ||
+			strings.Contains(strings.ToLower(e.RetMsg), "unified")
+	case domain.ErrExchangeUnavailable:
+		return transientRetCodes[e.RetCode]
+	}
+	return false
+}
@@ -3,9 +3,12 @@ package bybit
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,236 +20,798 @@ const (
 	// Public Linear Stream (USDT Perpetual) - самый надежный источник Index/Mark Price
 	MainnetLinearParams = "wss://stream.bybit.com/v5/public/linear"
 	TestnetLinearParams = "wss://stream-testnet.bybit.com/v5/public/linear"
-	
-	reconnectDelay = 5 * time.Second
-	pingInterval   = 20 * time.Second
+
+	// Public Option Stream - тикеры опционов (mark, bid1/ask1, греки)
+	MainnetOptionParams = "wss://stream.bybit.com/v5/public/option"
+	TestnetOptionParams = "wss://stream-testnet.bybit.com/v5/public/option"
+
+	sourceLinearWS = "bybit-linear-ws"
+	sourceOptionWS = "bybit-option-ws"
+
+	pingInterval = 20 * time.Second
+
+	// Предупреждение о потерянных тиках - не чаще раза в минуту и только при заметных потерях
+	dropWarnThreshold = 100
+	dropWarnInterval  = time.Minute
+	// Столько пингов подряд без понга - соединение мертво
+	maxMissedPongs = 2
+	// Bybit ограничивает число топиков на соединение и args в одном запросе
+	maxTopicsPerConn  = 10
+	maxArgsPerRequest = 10
+
+	// Ликвидные перпетуалы тикают чаще раза в секунду; 30с тишины - подписка мертва
+	defaultStaleTimeout = 30 * time.Second
 )
 
+// MarketStream - пул WebSocket-соединений к публичному стриму. Символы раскладываются по шардам
+// (не больше maxTopicsPerConn на соединение), события всех шардов раздаются подписчикам:
+// по всем символам (Subscribe) или по одному (SubscribeSymbol).
 type MarketStream struct {
-	url      string
-	logger   *slog.Logger
-	conn     *websocket.Conn
-	mu       sync.Mutex
+	url    string
+	source string
+	dialer *websocket.Dialer
+	// Поле линейного тикера, публикуемое как цена триггера
+	priceSource domain.PriceSource
+	logger      *slog.Logger
+
+	// Сторож тишины: пинги проходят и по соединению с умершей подпиской,
+	// поэтому каждый шард смотрит на время последнего сообщения по своим символам
+	staleTimeout time.Duration
+	health       chan domain.StreamHealthEvent
+	// Как часто шард пингует биржу
+	pingInterval time.Duration
+
+	// Последний тик по символу для GetLastPrice
+	lastPrices map[string]domain.PriceUpdateEvent
+	// Собранный тикер линейного символа (под pricesMu): снапшот с наложенными дельтами
+	tickers  map[string]linearTicker
+	pricesMu sync.RWMutex
+
+	// Схлопывание тиков (под pricesMu): Bybit шлет тикер много раз в секунду с той же ценой,
+	// а каждый тик в Manager - это проход по всем задачам
+	dedupe      bool
+	minInterval time.Duration        // 0 - без ограничения частоты
+	lastSent    map[string]time.Time // Когда символ последний раз ушел подписчикам
+	throttled   map[string]bool      // Символы с неотправленным свежим тиком
+
+	// Шарды и раскладка символов по ним
+	shards      []*streamShard
+	shardOf     map[string]*streamShard
+	nextShardID int
+	explicit    map[string]bool // Символы, запрошенные через Subscribe/AddSubscriptions
+	ctx         context.Context // Контекст Start: родитель соединений шардов; nil до старта
+	mu          sync.Mutex
+
+	// Подписчики (под subMu; порядок замков: mu, затем subMu): all получают все символы, bySymbol - свой
+	all      map[*conflatingQueue]struct{}
+	bySymbol map[string]map[*conflatingQueue]struct{}
+	closed   bool
+	subMu    sync.RWMutex
+
+	stats streamCounters
+	// Пауза между переподключениями шарда; у каждого шарда свой экземпляр
+	newBackoff func() *reconnectBackoff
+
 	stopChan chan struct{}
-	
-	// Храним список активных подписок для автоматического реконнекта
-	activeSubs []string 
-	subsMu     sync.RWMutex
+	stopOnce sync.Once
+	// Горутины шардов: maintainConnection, heartbeat, watchdog. Close ждет их завершения
+	wg sync.WaitGroup
 }
 
-func NewMarketStream(isTestnet bool) *MarketStream {
-	url := MainnetLinearParams
-	if isTestnet {
-		url = TestnetLinearParams
+func newMarketStream(url, source string, dialer *websocket.Dialer, component string) *MarketStream {
+	return &MarketStream{
+		url:          url,
+		source:       source,
+		dialer:       dialer,
+		logger:       slog.Default().With("component", component),
+		health:       make(chan domain.StreamHealthEvent, 10),
+		pingInterval: pingInterval,
+		lastPrices:   make(map[string]domain.PriceUpdateEvent),
+		tickers:      make(map[string]linearTicker),
+		dedupe:       true,
+		lastSent:     make(map[string]time.Time),
+		throttled:    make(map[string]bool),
+		shardOf:      make(map[string]*streamShard),
+		explicit:     make(map[string]bool),
+		all:          make(map[*conflatingQueue]struct{}),
+		bySymbol:     make(map[string]map[*conflatingQueue]struct{}),
+		newBackoff:   newReconnectBackoff,
+		stopChan:     make(chan struct{}),
 	}
+}
 
-	return &MarketStream{
-		url:      url,
-		logger:   slog.Default().With("component", "market_stream"),
-		stopChan: make(chan struct{}),
-		activeSubs: make([]string, 0),
+func NewMarketStream(env Environment, urls StreamURLs, priceSource domain.PriceSource, proxies *Proxies) *MarketStream {
+	if priceSource == "" {
+		priceSource = domain.PriceSourceIndex
 	}
+	s := newMarketStream(urls.linear(env), sourceLinearWS, proxies.dialer(env), "market_stream")
+	s.priceSource = priceSource
+	s.staleTimeout = defaultStaleTimeout
+	return s
 }
 
-// Subscribe сохраняет символы и запускает процесс чтения
-func (s *MarketStream) Subscribe(symbols []string) (<-chan domain.PriceUpdateEvent, error) {
-	out := make(chan domain.PriceUpdateEvent, 100)
-	
-	// Сохраняем начальные символы
-	s.subsMu.Lock()
-	s.activeSubs = symbols
-	s.subsMu.Unlock()
+// NewOptionStream - тот же стрим, но по опционным символам (ETH-28MAR25-3000-P).
+// Неликвидные опционы могут молчать подолгу: сторож тишины по умолчанию выключен.
+func NewOptionStream(env Environment, urls StreamURLs, proxies *Proxies) *MarketStream {
+	return newMarketStream(urls.option(env), sourceOptionWS, proxies.dialer(env), "option_stream")
+}
 
-	go s.maintainConnection(out)
+// streamCounters - атомарные счетчики пула; шарды пишут в них без замков
+type streamCounters struct {
+	received          atomic.Int64
+	published         atomic.Int64
+	dropped           atomic.Int64
+	reconnects        atomic.Int64
+	subscribeFailures atomic.Int64
 
-	return out, nil
+	lastDropWarn  atomic.Int64 // UnixNano последнего предупреждения о потерях
+	droppedAtWarn atomic.Int64 // dropped на момент последнего предупреждения
 }
 
-// AddSubscriptions добавляет новые символы "на лету" без разрыва соединения
-func (s *MarketStream) AddSubscriptions(symbols []string) error {
-	s.subsMu.Lock()
-	// Простая дедупликация
-	var newSubs []string
-	for _, newSym := range symbols {
-		exists := false
-		for _, oldSym := range s.activeSubs {
-			if newSym == oldSym {
-				exists = true
-				break
-			}
-		}
-		if !exists {
-			s.activeSubs = append(s.activeSubs, newSym)
-			newSubs = append(newSubs, newSym)
-		}
+// SetStaleTimeout задает окно тишины, после которого шард переподключается; 0 выключает сторожа.
+// Вызывать до Start/Subscribe.
+func (s *MarketStream) SetStaleTimeout(timeout time.Duration) {
+	s.staleTimeout = timeout
+}
+
+// SetCoalescing настраивает схлопывание тиков: dedupe отбрасывает тики без изменения цены,
+// maxPerSecond > 0 ограничивает частоту событий по символу (отдается всегда самый свежий тик).
+// Вызывать до Start/Subscribe.
+func (s *MarketStream) SetCoalescing(dedupe bool, maxPerSecond int) {
+	s.dedupe = dedupe
+	s.minInterval = 0
+	if maxPerSecond > 0 {
+		s.minInterval = time.Second / time.Duration(maxPerSecond)
 	}
-	s.subsMu.Unlock()
+}
 
-	if len(newSubs) == 0 {
-		return nil
+func (s *MarketStream) GetLastPrice(symbol string) (decimal.Decimal, time.Time, bool) {
+	s.pricesMu.RLock()
+	defer s.pricesMu.RUnlock()
+	event, ok := s.lastPrices[symbol]
+	return event.Price, event.Time, ok
+}
+
+// Stats - счетчики для подбора буферов и пула воркеров
+func (s *MarketStream) Stats() domain.StreamStats {
+	return domain.StreamStats{
+		MessagesReceived:  s.stats.received.Load(),
+		EventsPublished:   s.stats.published.Load(),
+		EventsDropped:     s.stats.dropped.Load(),
+		Reconnects:        s.stats.reconnects.Load(),
+		SubscribeFailures: s.stats.subscribeFailures.Load(),
 	}
+}
 
-	// Если соединение активно, отправляем команду подписки немедленно
+func (s *MarketStream) Status() domain.StreamStatus {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.conn != nil {
-		return s.sendSubscribe(newSubs)
+	shards := append([]*streamShard(nil), s.shards...)
+	s.mu.Unlock()
+
+	status := domain.StreamStatus{Source: s.source, Stats: s.Stats()}
+	for _, shard := range shards {
+		status.Connections = append(status.Connections, shard.status())
 	}
-	return nil
+	return status
+}
+
+func (s *MarketStream) Health() <-chan domain.StreamHealthEvent {
+	return s.health
 }
 
-func (s *MarketStream) maintainConnection(out chan<- domain.PriceUpdateEvent) {
+// CheckConnectivity - самопроверка адреса до подписок: отдельное соединение, ping и ожидание pong
+func (s *MarketStream) CheckConnectivity(ctx context.Context) error {
+	conn, _, err := s.dialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("stream %s unreachable: %w", s.url, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	if err := conn.WriteJSON(map[string]string{"op": "ping"}); err != nil {
+		return fmt.Errorf("stream %s ping failed: %w", s.url, err)
+	}
+
 	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("stream %s did not answer ping: %w", s.url, err)
+		}
+		var resp wsOpResponse
+		if json.Unmarshal(message, &resp) != nil {
+			continue
+		}
+		// Линейный стрим отвечает op:"ping" + ret_msg:"pong", опционный - op:"pong"
+		if resp.Op == "pong" || (resp.Op == "ping" && resp.RetMsg == "pong") {
+			return nil
+		}
+	}
+}
+
+// Start запускает соединения шардов. Отмена ctx рвет соединения, останавливает пинги
+// и циклы чтения и закрывает каналы всех потребителей. Повторный вызов - no-op.
+func (s *MarketStream) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped() {
+		return fmt.Errorf("market stream %s is closed", s.source)
+	}
+	if s.ctx != nil {
+		return nil
+	}
+	s.ctx = ctx
+
+	for _, shard := range s.shards {
+		s.startLocked(shard)
+	}
+	if s.minInterval > 0 {
+		s.wg.Add(1)
+		go s.flushThrottled()
+	}
+
+	// Не в wg: Close ждет wg и сам вызывается отсюда
+	go func() {
 		select {
+		case <-ctx.Done():
+			s.Close()
 		case <-s.stopChan:
-			return
-		default:
-			// Берем текущий список всех подписок для восстановления сессии
-			s.subsMu.RLock()
-			subs := s.activeSubs
-			s.subsMu.RUnlock()
-
-			if err := s.connectAndListen(subs, out); err != nil {
-				s.logger.Error("Connection lost or failed", "err", err)
-			}
-			
-			s.logger.Info("Reconnecting in 5 seconds...")
-			time.Sleep(reconnectDelay)
 		}
+	}()
+	return nil
+}
+
+// Subscribe - совместимая обертка над пабсабом: запускает стрим и возвращает канал
+// со всеми символами стрима, включая добавленные позже через AddSubscriptions.
+func (s *MarketStream) Subscribe(ctx context.Context, symbols []string) (<-chan domain.PriceUpdateEvent, error) {
+	if err := s.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	s.subMu.Lock()
+	if s.closed {
+		s.subMu.Unlock()
+		return nil, fmt.Errorf("market stream %s is closed", s.source)
+	}
+	q := s.newQueue()
+	s.all[q] = struct{}{}
+	s.subMu.Unlock()
+
+	if err := s.AddSubscriptions(symbols); err != nil {
+		return nil, err
 	}
+	return q.out, nil
 }
 
-func (s *MarketStream) connectAndListen(symbols []string, out chan<- domain.PriceUpdateEvent) error {
-	s.logger.Info("Connecting to Bybit Linear Stream...", "url", s.url)
+// SubscribeSymbol дает потребителю собственный канал по одному символу: медленный потребитель
+// не задерживает других. unsubscribe закрывает канал и, если символ больше никому не нужен,
+// отписывает его на бирже. Каналы начинают получать тики после Start.
+func (s *MarketStream) SubscribeSymbol(symbol string) (<-chan domain.PriceUpdateEvent, func(), error) {
+	s.subMu.Lock()
+	if s.closed {
+		s.subMu.Unlock()
+		return nil, nil, fmt.Errorf("market stream %s is closed", s.source)
+	}
+	q := s.newQueue()
+	if s.bySymbol[symbol] == nil {
+		s.bySymbol[symbol] = make(map[*conflatingQueue]struct{})
+	}
+	s.bySymbol[symbol][q] = struct{}{}
+	s.subMu.Unlock()
 
-	conn, _, err := websocket.DefaultDialer.Dial(s.url, nil)
-	if err != nil {
-		return err
+	s.mu.Lock()
+	added := s.assignLocked([]string{symbol})
+	s.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() { s.unsubscribeSymbol(symbol, q) })
 	}
-	
+	return q.out, unsubscribe, s.sendAdded(added)
+}
+
+func (s *MarketStream) unsubscribeSymbol(symbol string, q *conflatingQueue) {
+	s.subMu.Lock()
+	if _, ok := s.bySymbol[symbol][q]; ok {
+		// После Close очереди уже закрыты и удалены
+		delete(s.bySymbol[symbol], q)
+		if len(s.bySymbol[symbol]) == 0 {
+			delete(s.bySymbol, symbol)
+		}
+		q.close()
+	}
+	s.subMu.Unlock()
+
+	if err := s.release([]string{symbol}); err != nil {
+		s.logger.Warn("Failed to unsubscribe symbol", "symbol", symbol, "err", err)
+	}
+}
+
+// Close останавливает все шарды и ждет выхода их горутин.
+// Каналы потребителей закрываются один раз - после выхода всех циклов чтения
+// (очередь подписчика закрывает свой канал сразу после остановки).
+func (s *MarketStream) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+
 	s.mu.Lock()
-	s.conn = conn
+	for _, shard := range s.shards {
+		shard.close()
+	}
 	s.mu.Unlock()
 
-	defer func() {
-		s.mu.Lock()
-		if s.conn != nil {
-			s.conn.Close()
-			s.conn = nil
+	s.wg.Wait()
+
+	s.subMu.Lock()
+	if !s.closed {
+		s.closed = true
+		for q := range s.all {
+			q.close()
 		}
-		s.mu.Unlock()
-	}()
+		for _, subs := range s.bySymbol {
+			for q := range subs {
+				q.close()
+			}
+		}
+		s.all = nil
+		s.bySymbol = nil
+	}
+	s.subMu.Unlock()
+	return nil
+}
+
+func (s *MarketStream) stopped() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// AddSubscriptions добавляет новые символы "на лету": дозаполняет неполные шарды,
+// при нехватке места открывает новые. Существующие соединения не рвутся.
+func (s *MarketStream) AddSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	for _, sym := range symbols {
+		s.explicit[sym] = true
+	}
+	added := s.assignLocked(symbols)
+	s.mu.Unlock()
 
-	// Сразу подписываемся на все накопленные символы
-	if len(symbols) > 0 {
-		if err := s.sendSubscribe(symbols); err != nil {
-			return err
+	return s.sendAdded(added)
+}
+
+// sendAdded подписывает шарды на новые символы; без соединения они подпишутся при (ре)коннекте шарда
+func (s *MarketStream) sendAdded(added map[*streamShard][]string) error {
+	var errs []error
+	for shard, syms := range added {
+		if err := shard.sendSubscribe(syms); err != nil {
+			s.stats.subscribeFailures.Add(1)
+			errs = append(errs, fmt.Errorf("shard %d: %w", shard.id, err))
 		}
 	}
+	return errors.Join(errs...)
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go s.heartbeat(ctx)
+// RemoveSubscriptions снимает символы, добавленные через AddSubscriptions. На бирже символ
+// отписывается, только если на него нет SubscribeSymbol; опустевшие шарды закрываются.
+// Тики, уже находящиеся в пути, еще могут прийти - потребитель должен игнорировать неизвестные символы.
+func (s *MarketStream) RemoveSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	for _, sym := range symbols {
+		delete(s.explicit, sym)
+	}
+	s.mu.Unlock()
 
-	// Цикл чтения
-	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			return fmt.Errorf("read error: %w", err)
+	return s.release(symbols)
+}
+
+// release отписывает символы, которые больше никому не нужны, без разрыва соединений
+func (s *MarketStream) release(symbols []string) error {
+	s.mu.Lock()
+	s.subMu.RLock()
+	var unused []string
+	for _, sym := range symbols {
+		if !s.explicit[sym] && len(s.bySymbol[sym]) == 0 {
+			unused = append(unused, sym)
+		}
+	}
+	s.subMu.RUnlock()
+	removed := s.unassignLocked(unused)
+	s.mu.Unlock()
+
+	var errs []error
+	for shard, syms := range removed {
+		if err := shard.sendOp("unsubscribe", syms); err != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %w", shard.id, err))
 		}
+	}
+
+	s.mu.Lock()
+	s.closeEmptyLocked()
+	s.mu.Unlock()
+	return errors.Join(errs...)
+}
 
-		var rawMsg map[string]interface{}
-		if err := json.Unmarshal(message, &rawMsg); err != nil {
+// assignLocked раскладывает новые символы по шардам, возвращает добавленное по шардам (под mu)
+func (s *MarketStream) assignLocked(symbols []string) map[*streamShard][]string {
+	added := make(map[*streamShard][]string)
+	for _, sym := range symbols {
+		if _, ok := s.shardOf[sym]; ok {
 			continue
 		}
 
-		// Игнорируем ответы на ping/subscribe
-		if _, ok := rawMsg["op"]; ok {
-			continue 
+		var target *streamShard
+		for _, shard := range s.shards {
+			if shard.size()+len(added[shard]) < maxTopicsPerConn {
+				target = shard
+				break
+			}
 		}
+		if target == nil {
+			target = newStreamShard(s.nextShardID, s)
+			s.nextShardID++
+			s.shards = append(s.shards, target)
+			if s.ctx != nil {
+				defer s.startLocked(target)
+			}
+		}
+
+		s.shardOf[sym] = target
+		added[target] = append(added[target], sym)
+	}
 
-		var event WsTickerEvent
-		if err := json.Unmarshal(message, &event); err != nil {
+	for shard, syms := range added {
+		shard.addSubs(syms)
+	}
+	return added
+}
+
+// unassignLocked убирает символы из шардов и кэша цен, возвращает удаленное по шардам (под mu)
+func (s *MarketStream) unassignLocked(symbols []string) map[*streamShard][]string {
+	removed := make(map[*streamShard][]string)
+	for _, sym := range symbols {
+		shard, ok := s.shardOf[sym]
+		if !ok {
 			continue
 		}
+		delete(s.shardOf, sym)
+		removed[shard] = append(removed[shard], sym)
+	}
 
-		// Linear Ticker Data Processing
-		if event.Topic != "" && len(event.Data) > 0 {
-			data := event.Data[0]
-			
-			// Используем MarkPrice как наиболее надежный источник для триггера
-			price := data.MarkPrice
-			if price.IsZero() {
-				price = data.LastPrice
-			}
+	for shard, syms := range removed {
+		shard.dropSubs(syms)
+	}
 
-			// Формируем событие. 
-			// ВАЖНО: Symbol здесь будет "BTCUSDT". Менеджер должен ожидать именно это.
-			updateEvent := domain.PriceUpdateEvent{
-				Symbol: data.Symbol,
-				Price:  price,
-				Time:   time.Now(),
-				Source: "bybit-linear-ws",
-			}
+	// Без подписки цена перестанет обновляться - не отдаем ее как последнюю
+	s.pricesMu.Lock()
+	for _, syms := range removed {
+		for _, sym := range syms {
+			delete(s.lastPrices, sym)
+			delete(s.tickers, sym)
+			delete(s.lastSent, sym)
+			delete(s.throttled, sym)
+		}
+	}
+	s.pricesMu.Unlock()
 
-			select {
-			case out <- updateEvent:
-			default:
-				// Если канал переполнен, пропускаем устаревший тик
-			}
+	return removed
+}
+
+// startLocked запускает соединение шарда, если стрим еще не закрыт (под mu)
+func (s *MarketStream) startLocked(shard *streamShard) {
+	if s.stopped() {
+		return
+	}
+	s.wg.Add(1)
+	go shard.maintainConnection()
+}
+
+// closeEmptyLocked закрывает шарды без символов (под mu)
+func (s *MarketStream) closeEmptyLocked() {
+	kept := s.shards[:0]
+	for _, shard := range s.shards {
+		if shard.size() > 0 {
+			kept = append(kept, shard)
+			continue
 		}
+		shard.close()
+		s.logger.Info("Closed empty stream shard", "shard", shard.id)
 	}
+	s.shards = kept
 }
 
-func (s *MarketStream) sendSubscribe(symbols []string) error {
-	if len(symbols) == 0 {
-		return nil
+// rejectSymbols убирает отклоненные биржей символы, чтобы сторож и реконнект их не трогали
+func (s *MarketStream) rejectSymbols(symbols []string, reason string) {
+	s.mu.Lock()
+	s.unassignLocked(symbols)
+	s.closeEmptyLocked()
+	s.mu.Unlock()
+
+	s.emitHealth(domain.StreamHealthEvent{Kind: domain.StreamRejected, Source: s.source, Symbols: symbols, Reason: reason, Time: time.Now()})
+}
+
+func (s *MarketStream) emitHealth(event domain.StreamHealthEvent) {
+	select {
+	case s.health <- event:
+	default:
+		// Никто не читает предупреждения - достаточно лога
 	}
-	
-	args := make([]string, len(symbols))
-	for i, sym := range symbols {
-		// Подписка на тикеры фьючерсов
-		args[i] = "tickers." + sym 
+}
+
+// publish разбирает тикер из любого шарда и отправляет в общий канал
+func (s *MarketStream) publish(message []byte) {
+	var updateEvent domain.PriceUpdateEvent
+	var ok bool
+	if s.source == sourceOptionWS {
+		updateEvent, ok = parseOptionTicker(message)
+	} else {
+		updateEvent, ok = s.applyLinearTicker(message)
+	}
+	if !ok {
+		return
 	}
 
-	req := map[string]interface{}{
-		"op":   "subscribe",
-		"args": args,
+	s.pricesMu.Lock()
+	prev, seen := s.lastPrices[updateEvent.Symbol]
+	s.lastPrices[updateEvent.Symbol] = updateEvent
+	if s.dedupe && seen && sameQuote(prev, updateEvent) {
+		// Время в кэше обновили - GetLastPrice видит, что цена свежая
+		s.pricesMu.Unlock()
+		return
 	}
-	
-	s.logger.Info("Sending subscription request", "topics", args)
+	if s.minInterval > 0 {
+		if time.Since(s.lastSent[updateEvent.Symbol]) < s.minInterval {
+			// Отправит flushThrottled - уже с самым свежим значением из lastPrices
+			s.throttled[updateEvent.Symbol] = true
+			s.pricesMu.Unlock()
+			return
+		}
+		s.lastSent[updateEvent.Symbol] = time.Now()
+	}
+	s.pricesMu.Unlock()
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.conn.WriteJSON(req)
+	s.send(updateEvent)
+}
+
+// send раздает тик в очереди подписчиков, не блокируя шард. Для цен важен последний тик,
+// поэтому при отставании потребителя вытесняется старый непрочитанный тик того же символа.
+func (s *MarketStream) send(event domain.PriceUpdateEvent) {
+	s.subMu.RLock()
+	defer s.subMu.RUnlock()
+
+	for q := range s.all {
+		q.push(event)
+	}
+	for q := range s.bySymbol[event.Symbol] {
+		q.push(event)
+	}
+}
+
+func (s *MarketStream) newQueue() *conflatingQueue {
+	return newConflatingQueue(func() { s.stats.published.Add(1) }, s.noteDrop)
+}
+
+// noteDrop считает вытесненный тик и не чаще раза в dropWarnInterval предупреждает,
+// если с прошлого предупреждения потеряно не меньше dropWarnThreshold
+func (s *MarketStream) noteDrop() {
+	total := s.stats.dropped.Add(1)
+
+	now := time.Now().UnixNano()
+	last := s.stats.lastDropWarn.Load()
+	if total-s.stats.droppedAtWarn.Load() < dropWarnThreshold || now-last < int64(dropWarnInterval) {
+		return
+	}
+	if !s.stats.lastDropWarn.CompareAndSwap(last, now) {
+		return
+	}
+	since := total - s.stats.droppedAtWarn.Swap(total)
+	s.logger.Warn("⚠️ Market stream is dropping events, consumers are too slow", "dropped", since, "total_dropped", total)
 }
 
-func (s *MarketStream) heartbeat(ctx context.Context) {
-	ticker := time.NewTicker(pingInterval)
+// flushThrottled раз в minInterval отправляет последние тики символов, придержанных ограничением частоты
+func (s *MarketStream) flushThrottled() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.minInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ctx.Done():
+		case <-s.stopChan:
 			return
-		case <-ticker.C:
-			s.mu.Lock()
-			if s.conn != nil {
-				if err := s.conn.WriteJSON(map[string]string{"op": "ping"}); err != nil {
-					s.logger.Error("Ping failed", "err", err)
+		case now := <-ticker.C:
+			s.pricesMu.Lock()
+			var events []domain.PriceUpdateEvent
+			for sym := range s.throttled {
+				if now.Sub(s.lastSent[sym]) < s.minInterval {
+					continue
 				}
+				events = append(events, s.lastPrices[sym])
+				s.lastSent[sym] = now
+				delete(s.throttled, sym)
+			}
+			s.pricesMu.Unlock()
+
+			for _, event := range events {
+				s.send(event)
 			}
-			s.mu.Unlock()
 		}
 	}
 }
 
-// WsTickerEvent соответствует структуре сообщения из Linear Stream
+// sameQuote - тик ничего не меняет для потребителя
+func sameQuote(a, b domain.PriceUpdateEvent) bool {
+	return a.Price.Equal(b.Price) && a.Bid.Equal(b.Bid) && a.Ask.Equal(b.Ask)
+}
+
+// applyLinearTicker накладывает сообщение на собранный тикер символа: снапшот заменяет его
+// целиком, дельта - только пришедшие поля. Дельта без выбранной цены - не новый тик,
+// она лишь подтверждает, что цена актуальна.
+func (s *MarketStream) applyLinearTicker(message []byte) (domain.PriceUpdateEvent, bool) {
+	update, snapshot, ok := parseLinearTicker(message)
+	if !ok {
+		return domain.PriceUpdateEvent{}, false
+	}
+
+	s.pricesMu.Lock()
+	defer s.pricesMu.Unlock()
+
+	ticker := s.tickers[update.Symbol]
+	if snapshot {
+		ticker = linearTicker{}
+	}
+	ticker.apply(update)
+	s.tickers[update.Symbol] = ticker
+
+	price := ticker.price(s.priceSource)
+	if price.IsZero() {
+		// Снапшота еще не было, а дельты без цены: публиковать нечего
+		return domain.PriceUpdateEvent{}, false
+	}
+	if !snapshot && !update.has(s.priceSource) {
+		if last, seen := s.lastPrices[update.Symbol]; seen {
+			last.Time = time.Now()
+			s.lastPrices[update.Symbol] = last
+		}
+		return domain.PriceUpdateEvent{}, false
+	}
+
+	// ВАЖНО: Symbol здесь будет "BTCUSDT". Менеджер должен ожидать именно это.
+	return domain.PriceUpdateEvent{
+		Symbol: update.Symbol,
+		Price:  price,
+		Time:   time.Now(),
+		Source: sourceLinearWS + ":" + string(s.priceSource),
+	}, true
+}
+
+// linearTicker - собранное состояние тикера; нулевое значение - цены еще не было
+type linearTicker struct {
+	last, mark, index decimal.Decimal
+}
+
+func (t *linearTicker) apply(update wsLinearTickerData) {
+	if update.LastPrice.Valid {
+		t.last = update.LastPrice.Decimal
+	}
+	if update.MarkPrice.Valid {
+		t.mark = update.MarkPrice.Decimal
+	}
+	if update.IndexPrice.Valid {
+		t.index = update.IndexPrice.Decimal
+	}
+}
+
+// price - выбранное поле. Источники не смешиваем: без нужной цены тик не публикуется.
+func (t linearTicker) price(priceSource domain.PriceSource) decimal.Decimal {
+	switch priceSource {
+	case domain.PriceSourceMark:
+		return t.mark
+	case domain.PriceSourceLast:
+		return t.last
+	default:
+		return t.index
+	}
+}
+
+func (d wsLinearTickerData) has(priceSource domain.PriceSource) bool {
+	switch priceSource {
+	case domain.PriceSourceMark:
+		return d.MarkPrice.Valid
+	case domain.PriceSourceLast:
+		return d.LastPrice.Valid
+	default:
+		return d.IndexPrice.Valid
+	}
+}
+
+// parseLinearTicker разбирает сообщение линейного тикера. В v5 data - объект,
+// но принимаем и массив из одного элемента.
+func parseLinearTicker(message []byte) (wsLinearTickerData, bool, bool) {
+	var event WsTickerEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		return wsLinearTickerData{}, false, false
+	}
+	if event.Topic == "" || len(event.Data) == 0 {
+		return wsLinearTickerData{}, false, false
+	}
+
+	var data wsLinearTickerData
+	if event.Data[0] == '[' {
+		var list []wsLinearTickerData
+		if err := json.Unmarshal(event.Data, &list); err != nil || len(list) == 0 {
+			return wsLinearTickerData{}, false, false
+		}
+		data = list[0]
+	} else if err := json.Unmarshal(event.Data, &data); err != nil {
+		return wsLinearTickerData{}, false, false
+	}
+
+	if data.Symbol == "" {
+		data.Symbol = strings.TrimPrefix(event.Topic, "tickers.")
+	}
+	return data, event.Type != "delta", true
+}
+
+// parseOptionTicker: в опционном стриме data - объект, а не массив
+func parseOptionTicker(message []byte) (domain.PriceUpdateEvent, bool) {
+	var event WsOptionTickerEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		return domain.PriceUpdateEvent{}, false
+	}
+	if event.Topic == "" || event.Data.Symbol == "" {
+		return domain.PriceUpdateEvent{}, false
+	}
+
+	data := event.Data
+	return domain.PriceUpdateEvent{
+		Symbol: data.Symbol,
+		Price:  data.MarkPrice,
+		Time:   time.Now(),
+		Source: sourceOptionWS,
+		Bid:    data.BidPrice,
+		Ask:    data.AskPrice,
+		Delta:  data.Delta,
+	}, true
+}
+
+// wsOpResponse - ответ на ping/subscribe/unsubscribe
+type wsOpResponse struct {
+	Success bool   `json:"success"`
+	RetMsg  string `json:"ret_msg"`
+	ReqID   string `json:"req_id"`
+	Op      string `json:"op"`
+}
+
+// WsTickerEvent соответствует структуре сообщения из Linear Stream.
+// Type: "snapshot" - все поля, "delta" - только изменившиеся.
 type WsTickerEvent struct {
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// wsLinearTickerData - поля тикера; Valid=false - поля нет в дельте
+type wsLinearTickerData struct {
+	Symbol     string              `json:"symbol"`
+	LastPrice  decimal.NullDecimal `json:"lastPrice"`
+	MarkPrice  decimal.NullDecimal `json:"markPrice"`
+	IndexPrice decimal.NullDecimal `json:"indexPrice"`
+}
+
+// WsOptionTickerEvent соответствует структуре сообщения из Option Stream
+type WsOptionTickerEvent struct {
 	Topic string `json:"topic"`
-	Data  []struct {
+	Data  struct {
 		Symbol    string          `json:"symbol"`
-		LastPrice decimal.Decimal `json:"lastPrice"`
 		MarkPrice decimal.Decimal `json:"markPrice"`
+		BidPrice  decimal.Decimal `json:"bidPrice"`
+		AskPrice  decimal.Decimal `json:"askPrice"`
+		Delta     decimal.Decimal `json:"delta"`
 	} `json:"data"`
-}
\ No newline at end of file
+}
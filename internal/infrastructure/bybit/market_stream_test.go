@@ -0,0 +1,805 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// fakeWSServer - публичный стрим Bybit для тестов: подтверждает подписки, отвечает на пинги
+// и рассылает сообщения, которые подает тест
+type fakeWSServer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu    sync.Mutex // Сериализует запись: gorilla не допускает конкурентных писателей
+	conns []*websocket.Conn
+
+	connected  chan struct{} // Сигнал о каждом новом соединении
+	subscribed chan []string // args каждого subscribe
+	closed     chan struct{} // Сигнал о каждом соединении, закрытом клиентом
+
+	noPong atomic.Bool // Сервер перестает отвечать на пинги
+}
+
+func newFakeWSServer(t *testing.T) *fakeWSServer {
+	t.Helper()
+	f := &fakeWSServer{
+		t:          t,
+		connected:  make(chan struct{}, 64),
+		subscribed: make(chan []string, 64),
+		closed:     make(chan struct{}, 64),
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(func() {
+		f.dropAll()
+		f.srv.Close()
+	})
+	return f
+}
+
+func (f *fakeWSServer) url() string {
+	return "ws" + strings.TrimPrefix(f.srv.URL, "http")
+}
+
+func (f *fakeWSServer) serve(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	f.conns = append(f.conns, conn)
+	f.mu.Unlock()
+	f.connected <- struct{}{}
+
+	defer func() { f.closed <- struct{}{} }()
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			Op    string   `json:"op"`
+			Args  []string `json:"args"`
+			ReqID string   `json:"req_id"`
+		}
+		if json.Unmarshal(message, &req) != nil {
+			continue
+		}
+		switch req.Op {
+		case "subscribe":
+			f.write(conn, map[string]any{"success": true, "op": "subscribe", "req_id": req.ReqID})
+			f.subscribed <- req.Args
+		case "ping":
+			if f.noPong.Load() {
+				continue
+			}
+			f.write(conn, map[string]any{"success": true, "op": "ping", "ret_msg": "pong"})
+		}
+	}
+}
+
+func (f *fakeWSServer) write(conn *websocket.Conn, v any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	conn.WriteJSON(v)
+}
+
+// send пишет сообщение в последнее открытое соединение
+func (f *fakeWSServer) send(message string) {
+	f.t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.conns) == 0 {
+		f.t.Fatal("no stream connection")
+	}
+	if err := f.conns[len(f.conns)-1].WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		f.t.Fatalf("write tick: %v", err)
+	}
+}
+
+// dropAll рвет все соединения со стороны сервера
+func (f *fakeWSServer) dropAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+// waitSubscribed ждет подписку на символы от клиента
+func (f *fakeWSServer) waitSubscribed(symbols ...string) {
+	f.t.Helper()
+	want := make([]string, len(symbols))
+	for i, sym := range symbols {
+		want[i] = "tickers." + sym
+	}
+	select {
+	case args := <-f.subscribed:
+		if strings.Join(args, ",") != strings.Join(want, ",") {
+			f.t.Fatalf("subscribe args = %v, want %v", args, want)
+		}
+	case <-time.After(2 * time.Second):
+		f.t.Fatalf("no subscribe for %v", symbols)
+	}
+}
+
+func newTestStream(t *testing.T, url string) *MarketStream {
+	t.Helper()
+	s := newMarketStream(url, sourceLinearWS, websocket.DefaultDialer, "test_stream")
+	s.priceSource = domain.PriceSourceIndex
+	s.logger = testLogger()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func tickerMessage(kind, data string) string {
+	return `{"topic":"tickers.BTCUSDT","type":"` + kind + `","data":` + data + `}`
+}
+
+func recvEvent(t *testing.T, events <-chan domain.PriceUpdateEvent) domain.PriceUpdateEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no price event")
+	}
+	return domain.PriceUpdateEvent{}
+}
+
+func TestMarketStreamCloseStopsGoroutines(t *testing.T) {
+	srv := newFakeWSServer(t)
+	s := newTestStream(t, srv.url())
+	s.SetStaleTimeout(time.Minute) // С watchdog у шарда три горутины: чтение, пинг и сторож
+
+	events, err := s.Subscribe(context.Background(), []string{"BTCUSDT"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	srv.waitSubscribed("BTCUSDT")
+	srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60000"}`))
+	if event := recvEvent(t, events); event.Price.String() != "60000" {
+		t.Fatalf("price = %s, want 60000", event.Price)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not return: shard goroutines still running")
+	}
+
+	// Close дождался wg: повторное ожидание не блокируется
+	waited := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("stream goroutines outlived Close")
+	}
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("events channel still delivers after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("events channel not closed after Close")
+	}
+	select {
+	case <-srv.closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed on the server side")
+	}
+
+	if _, err := s.Subscribe(context.Background(), []string{"ETHUSDT"}); err == nil {
+		t.Fatal("Subscribe after Close: want error")
+	}
+}
+
+func TestReconnectBackoffProgression(t *testing.T) {
+	b := &reconnectBackoff{min: time.Second, max: 8 * time.Second, stableAfter: time.Minute}
+
+	tests := []struct {
+		uptime      time.Duration
+		wantAttempt int
+		wantBase    time.Duration // Пауза до jitter: фактическая - в [base/2, base]
+	}{
+		{0, 1, time.Second},
+		{time.Second, 2, 2 * time.Second},
+		{0, 3, 4 * time.Second},
+		{0, 4, 8 * time.Second},
+		{0, 5, 8 * time.Second}, // Потолок max
+		{time.Minute, 1, time.Second},
+		{0, 2, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		attempt, delay := b.next(tt.uptime)
+		if attempt != tt.wantAttempt {
+			t.Fatalf("uptime %v: attempt = %d, want %d", tt.uptime, attempt, tt.wantAttempt)
+		}
+		if delay < tt.wantBase/2 || delay > tt.wantBase {
+			t.Fatalf("attempt %d: delay %v outside [%v, %v]", attempt, delay, tt.wantBase/2, tt.wantBase)
+		}
+	}
+}
+
+func TestMarketStreamReconnectBackoff(t *testing.T) {
+	s := newTestStream(t, "ws://bybit.invalid/v5/public/linear")
+	const minDelay = 40 * time.Millisecond
+	s.newBackoff = func() *reconnectBackoff {
+		return &reconnectBackoff{min: minDelay, max: 4 * minDelay, stableAfter: time.Minute}
+	}
+
+	// Dialer, у которого Bybit лежит: запоминаем время каждой попытки
+	dials := make(chan time.Time, 16)
+	s.dialer = &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			select {
+			case dials <- time.Now():
+			default:
+			}
+			return nil, errors.New("connection refused")
+		},
+	}
+
+	if _, err := s.Subscribe(context.Background(), []string{"BTCUSDT"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	var at []time.Time
+	for len(at) < 5 {
+		select {
+		case dial := <-dials:
+			at = append(at, dial)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d dial attempts", len(at))
+		}
+	}
+
+	// Пауза перед попыткой n - в [base/2, base], base = min*2^(n-1) до потолка max
+	bases := []time.Duration{minDelay, 2 * minDelay, 4 * minDelay, 4 * minDelay}
+	for i, base := range bases {
+		gap := at[i+1].Sub(at[i])
+		if gap < base/2 {
+			t.Fatalf("gap before attempt %d = %v, want at least %v", i+1, gap, base/2)
+		}
+		// Запас сверху на планировщик
+		if gap > base+100*time.Millisecond {
+			t.Fatalf("gap before attempt %d = %v, want at most %v", i+1, gap, base)
+		}
+	}
+	if got := s.Stats().Reconnects; got < 4 {
+		t.Fatalf("Reconnects = %d, want at least 4", got)
+	}
+}
+
+// fastReconnect укорачивает паузу реконнекта, чтобы тесты не ждали секундами
+func fastReconnect(s *MarketStream) {
+	s.newBackoff = func() *reconnectBackoff {
+		return &reconnectBackoff{min: 10 * time.Millisecond, max: 10 * time.Millisecond, stableAfter: time.Minute}
+	}
+}
+
+func TestMarketStreamSilenceForcesReconnect(t *testing.T) {
+	srv := newFakeWSServer(t)
+	s := newTestStream(t, srv.url())
+	fastReconnect(s)
+	s.SetStaleTimeout(150 * time.Millisecond)
+
+	events, err := s.Subscribe(context.Background(), []string{"BTCUSDT"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	srv.waitSubscribed("BTCUSDT")
+	srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60000"}`))
+	recvEvent(t, events)
+
+	// Сервер жив и отвечает на пинги, но тиков больше не шлет
+	select {
+	case event := <-s.Health():
+		if event.Kind != domain.StreamSilent {
+			t.Fatalf("health event = %s, want %s", event.Kind, domain.StreamSilent)
+		}
+		if len(event.Symbols) != 1 || event.Symbols[0] != "BTCUSDT" {
+			t.Fatalf("silent symbols = %v, want [BTCUSDT]", event.Symbols)
+		}
+		if event.Silence < 150*time.Millisecond {
+			t.Fatalf("silence = %v, want above the stale timeout", event.Silence)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no StreamSilent event after ticks stopped")
+	}
+
+	// Сторож порвал соединение: шард переподключается и заново подписывается
+	select {
+	case <-srv.closed:
+	case <-time.After(time.Second):
+		t.Fatal("silent connection not closed")
+	}
+	srv.waitSubscribed("BTCUSDT")
+	srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60100"}`))
+	if event := recvEvent(t, events); event.Price.String() != "60100" {
+		t.Fatalf("price after reconnect = %s, want 60100", event.Price)
+	}
+	if got := s.Stats().Reconnects; got < 1 {
+		t.Fatalf("Reconnects = %d, want at least 1", got)
+	}
+}
+
+// waitReceived ждет, пока шард прочитает n сообщений (ответы на op тоже считаются)
+func waitReceived(t *testing.T, s *MarketStream, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Stats().MessagesReceived < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d messages, want %d", s.Stats().MessagesReceived, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMarketStreamCoalescing(t *testing.T) {
+	t.Run("dedupe", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		s := newTestStream(t, srv.url())
+		s.SetCoalescing(true, 0)
+
+		events, err := s.Subscribe(context.Background(), []string{"BTCUSDT"})
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		srv.waitSubscribed("BTCUSDT")
+		srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60000"}`))
+		recvEvent(t, events)
+		_, firstAt, _ := s.GetLastPrice("BTCUSDT")
+
+		for range 3 {
+			srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60000"}`))
+		}
+		srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60100"}`))
+
+		// Повторы цены не доходят до подписчика: следующее событие - новая цена
+		if event := recvEvent(t, events); event.Price.String() != "60100" {
+			t.Fatalf("price = %s, want 60100", event.Price)
+		}
+		waitReceived(t, s, 6) // Ответ на subscribe и пять тиков
+		if got := s.Stats().EventsPublished; got != 2 {
+			t.Fatalf("EventsPublished = %d, want 2", got)
+		}
+		if _, lastAt, _ := s.GetLastPrice("BTCUSDT"); !lastAt.After(firstAt) {
+			t.Fatal("duplicate ticks did not refresh the last price time")
+		}
+	})
+
+	t.Run("per-second cap", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		s := newTestStream(t, srv.url())
+		s.SetCoalescing(false, 5) // Не чаще раза в 200мс
+
+		events, err := s.Subscribe(context.Background(), []string{"BTCUSDT"})
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		srv.waitSubscribed("BTCUSDT")
+		srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60000"}`))
+		recvEvent(t, events)
+		firstAt := time.Now()
+
+		for i := 1; i <= 9; i++ {
+			srv.send(tickerMessage("snapshot", fmt.Sprintf(`{"symbol":"BTCUSDT","indexPrice":"%d"}`, 60000+i)))
+		}
+		waitReceived(t, s, 11)
+
+		// Придержанные тики уходят одним событием и с самой свежей ценой
+		event := recvEvent(t, events)
+		if event.Price.String() != "60009" {
+			t.Fatalf("throttled price = %s, want the latest 60009", event.Price)
+		}
+		if elapsed := time.Since(firstAt); elapsed < 150*time.Millisecond {
+			t.Fatalf("second event after %v, want the 200ms cap", elapsed)
+		}
+		select {
+		case event := <-events:
+			t.Fatalf("unexpected event %s after the throttled flush", event.Price)
+		case <-time.After(300 * time.Millisecond):
+		}
+		if got := s.Stats().EventsPublished; got != 2 {
+			t.Fatalf("EventsPublished = %d, want 2", got)
+		}
+	})
+}
+
+func TestMarketStreamContextCancelClosesChannel(t *testing.T) {
+	srv := newFakeWSServer(t)
+	s := newTestStream(t, srv.url())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := s.Subscribe(ctx, []string{"BTCUSDT"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	srv.waitSubscribed("BTCUSDT")
+
+	cancel()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("events channel not closed after context cancel")
+		}
+	}
+}
+
+func TestMarketStreamStaleTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		staleTimeout  time.Duration
+		wantReconnect bool
+	}{
+		{"watchdog off", 0, false},
+		{"watchdog on", 100 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeWSServer(t)
+			s := newTestStream(t, srv.url())
+			fastReconnect(s)
+			s.SetStaleTimeout(tt.staleTimeout)
+
+			if _, err := s.Subscribe(context.Background(), []string{"BTCUSDT"}); err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			srv.waitSubscribed("BTCUSDT")
+			<-srv.connected
+
+			// После подписки сервер не шлет ни одного тика
+			select {
+			case <-srv.connected:
+				if !tt.wantReconnect {
+					t.Fatal("reconnected on silence with the watchdog off")
+				}
+			case <-time.After(500 * time.Millisecond):
+				if tt.wantReconnect {
+					t.Fatal("no reconnect after the stale timeout")
+				}
+			}
+		})
+	}
+}
+
+func TestMarketStreamPongMonitoring(t *testing.T) {
+	srv := newFakeWSServer(t)
+	s := newTestStream(t, srv.url())
+	fastReconnect(s)
+	s.pingInterval = 30 * time.Millisecond
+
+	if _, err := s.Subscribe(context.Background(), []string{"BTCUSDT"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	srv.waitSubscribed("BTCUSDT")
+	<-srv.connected
+
+	// Понги идут: в статусе соединения видны RTT и аптайм
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status := s.Status()
+		if len(status.Connections) == 1 && status.Connections[0].LastRTT > 0 {
+			conn := status.Connections[0]
+			if !conn.Connected || conn.Uptime <= 0 || conn.Symbols != 1 {
+				t.Fatalf("connection status = %+v", conn)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no RTT in status: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Сервер перестал отвечать: после maxMissedPongs пингов шард рвет соединение и переподключается
+	srv.noPong.Store(true)
+	select {
+	case <-srv.closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection without pongs was not closed")
+	}
+	select {
+	case <-srv.connected:
+	case <-time.After(time.Second):
+		t.Fatal("no reconnect after missed pongs")
+	}
+	srv.waitSubscribed("BTCUSDT")
+}
+
+func TestMarketStreamCheckConnectivity(t *testing.T) {
+	reachable := newFakeWSServer(t)
+	silent := newFakeWSServer(t)
+	silent.noPong.Store(true)
+
+	// Адрес, на котором никто не слушает
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	unreachable := "ws://" + ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{"reachable", reachable.url(), ""},
+		{"unreachable", unreachable, "unreachable"},
+		{"no pong", silent.url(), "did not answer ping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStream(t, tt.url)
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+
+			err := s.CheckConnectivity(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckConnectivity: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("CheckConnectivity error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConflatingQueueDeliversLatestUnderBackpressure(t *testing.T) {
+	var evicted atomic.Int64
+	q := newConflatingQueue(func() {}, func() { evicted.Add(1) })
+	defer q.close()
+
+	// Потребитель не читает, пока идут тики
+	for i := 1; i <= 100; i++ {
+		q.push(domain.PriceUpdateEvent{Symbol: "BTCUSDT", Price: decimal.NewFromInt(int64(60000 + i))})
+	}
+	q.push(domain.PriceUpdateEvent{Symbol: "ETHUSDT", Price: decimal.NewFromInt(3000)})
+
+	got := map[string]string{}
+	for range 2 {
+		event := recvEvent(t, q.out)
+		got[event.Symbol] = event.Price.String()
+	}
+	if got["BTCUSDT"] != "60100" || got["ETHUSDT"] != "3000" {
+		t.Fatalf("delivered %v, want the latest tick of each symbol", got)
+	}
+	select {
+	case event := <-q.out:
+		t.Fatalf("stale tick %s %s delivered", event.Symbol, event.Price)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if evicted.Load() < 98 {
+		t.Fatalf("evicted = %d, want stale ticks evicted", evicted.Load())
+	}
+}
+
+func TestApplyLinearTicker(t *testing.T) {
+	const (
+		snapshot       = `{"topic":"tickers.BTCUSDT","type":"snapshot","data":{"symbol":"BTCUSDT","lastPrice":"60010","markPrice":"60005","indexPrice":"60000"}}`
+		snapshotArray  = `{"topic":"tickers.BTCUSDT","type":"snapshot","data":[{"symbol":"BTCUSDT","lastPrice":"60010","markPrice":"60005","indexPrice":"60000"}]}`
+		deltaNoPrice   = `{"topic":"tickers.BTCUSDT","type":"delta","data":{"symbol":"BTCUSDT","volume24h":"1234.5"}}`
+		deltaLastOnly  = `{"topic":"tickers.BTCUSDT","type":"delta","data":{"symbol":"BTCUSDT","lastPrice":"60020"}}`
+		deltaWithPrice = `{"topic":"tickers.BTCUSDT","type":"delta","data":{"symbol":"BTCUSDT","indexPrice":"60100"}}`
+		deltaArray     = `{"topic":"tickers.BTCUSDT","type":"delta","data":[{"symbol":"BTCUSDT","indexPrice":"60200"}]}`
+		deltaNoSymbol  = `{"topic":"tickers.BTCUSDT","type":"delta","data":{"markPrice":"60300"}}`
+	)
+
+	tests := []struct {
+		name     string
+		source   domain.PriceSource
+		messages []string
+		want     []string // Опубликованная цена по каждому сообщению; "" - сообщение не публикуется
+	}{
+		{"snapshot", domain.PriceSourceIndex, []string{snapshot}, []string{"60000"}},
+		{"snapshot as array", domain.PriceSourceIndex, []string{snapshotArray}, []string{"60000"}},
+		{"delta without price", domain.PriceSourceIndex, []string{snapshot, deltaNoPrice}, []string{"60000", ""}},
+		{"delta with other price", domain.PriceSourceIndex, []string{snapshot, deltaLastOnly}, []string{"60000", ""}},
+		{"delta with price", domain.PriceSourceIndex, []string{snapshot, deltaNoPrice, deltaWithPrice}, []string{"60000", "", "60100"}},
+		{"delta as array", domain.PriceSourceIndex, []string{snapshot, deltaArray}, []string{"60000", "60200"}},
+		{"delta before snapshot", domain.PriceSourceIndex, []string{deltaNoPrice, deltaLastOnly, snapshot}, []string{"", "", "60000"}},
+		{"last price source", domain.PriceSourceLast, []string{snapshot, deltaWithPrice, deltaLastOnly}, []string{"60010", "", "60020"}},
+		{"symbol from topic", domain.PriceSourceMark, []string{snapshot, deltaNoSymbol}, []string{"60005", "60300"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStream(t, "ws://unused")
+			s.priceSource = tt.source
+
+			for i, message := range tt.messages {
+				event, ok := s.applyLinearTicker([]byte(message))
+				got := ""
+				if ok {
+					got = event.Price.String()
+					if event.Symbol != "BTCUSDT" {
+						t.Fatalf("message %d: symbol = %q, want BTCUSDT", i, event.Symbol)
+					}
+				}
+				if got != tt.want[i] {
+					t.Fatalf("message %d: published %q, want %q", i, got, tt.want[i])
+				}
+				if ok {
+					// Как publish: GetLastPrice видит последний опубликованный тик
+					s.lastPrices[event.Symbol] = event
+				}
+			}
+		})
+	}
+}
+
+func TestLinearTickerTriggersBySource(t *testing.T) {
+	// Поля тикера разведены: триггер между ними срабатывает только по выбранному источнику
+	const ticker = `{"topic":"tickers.BTCUSDT","type":"snapshot","data":{"symbol":"BTCUSDT","lastPrice":"60100","markPrice":"60000","indexPrice":"59900"}}`
+	const (
+		call = "BTC-27DEC24-62000-C"
+		put  = "BTC-27DEC24-58000-P"
+	)
+
+	tests := []struct {
+		name    string
+		source  domain.PriceSource
+		symbol  string
+		trigger int64
+		want    bool
+	}{
+		{"index call below trigger", domain.PriceSourceIndex, call, 59950, false},
+		{"index call above trigger", domain.PriceSourceIndex, call, 59850, true},
+		{"index put below trigger", domain.PriceSourceIndex, put, 59950, true},
+		{"index put above trigger", domain.PriceSourceIndex, put, 59850, false},
+		{"mark call below trigger", domain.PriceSourceMark, call, 60050, false},
+		{"mark call above trigger", domain.PriceSourceMark, call, 59950, true},
+		{"mark put below trigger", domain.PriceSourceMark, put, 60050, true},
+		{"mark put above trigger", domain.PriceSourceMark, put, 59950, false},
+		{"last call below trigger", domain.PriceSourceLast, call, 60150, false},
+		{"last call above trigger", domain.PriceSourceLast, call, 60050, true},
+		{"last put below trigger", domain.PriceSourceLast, put, 60150, true},
+		{"last put above trigger", domain.PriceSourceLast, put, 60050, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStream(t, "ws://unused")
+			s.priceSource = tt.source
+
+			event, ok := s.applyLinearTicker([]byte(ticker))
+			if !ok {
+				t.Fatal("snapshot not published")
+			}
+			task := domain.Task{Status: domain.TaskStateIdle, CurrentOptionSymbol: tt.symbol, TriggerPrice: decimal.NewFromInt(tt.trigger)}
+			if got := task.ShouldRoll(event.Price); got != tt.want {
+				t.Fatalf("ShouldRoll(%s) = %v, want %v", event.Price, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeltaWithoutPriceRefreshesLastPrice(t *testing.T) {
+	srv := newFakeWSServer(t)
+	s := newTestStream(t, srv.url())
+
+	events, err := s.Subscribe(context.Background(), []string{"BTCUSDT"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	srv.waitSubscribed("BTCUSDT")
+	srv.send(tickerMessage("snapshot", `{"symbol":"BTCUSDT","indexPrice":"60000"}`))
+	recvEvent(t, events)
+	_, snapshotAt, _ := s.GetLastPrice("BTCUSDT")
+
+	srv.send(tickerMessage("delta", `{"symbol":"BTCUSDT","volume24h":"1234.5"}`))
+	waitReceived(t, s, 3)
+
+	// Цена не обнулилась, а время подтвердило ее свежесть
+	price, at, ok := s.GetLastPrice("BTCUSDT")
+	if !ok || price.String() != "60000" {
+		t.Fatalf("last price = %s (ok=%v), want 60000", price, ok)
+	}
+	if !at.After(snapshotAt) {
+		t.Fatal("delta did not refresh the last price time")
+	}
+	if got := s.Stats().EventsPublished; got != 1 {
+		t.Fatalf("EventsPublished = %d, want 1", got)
+	}
+}
+
+// BenchmarkTickCoalescing: каждый тик, дошедший до подписчика, стоит Manager прохода по всем задачам.
+// scans/tick - доля тиков ленты, на которые пришлось делать этот проход.
+func BenchmarkTickCoalescing(b *testing.B) {
+	// Лента тикера: цена меняется раз в 10 сообщений
+	feed := make([][]byte, 1000)
+	for i := range feed {
+		feed[i] = []byte(tickerMessage("snapshot", fmt.Sprintf(`{"symbol":"BTCUSDT","indexPrice":"%d"}`, 60000+i/10)))
+	}
+	// Триггеры 500 задач
+	triggers := make([]decimal.Decimal, 500)
+	for i := range triggers {
+		triggers[i] = decimal.NewFromInt(int64(59000 + i*4))
+	}
+	// Маркер идет в очередь мимо схлопывания: все, что прошло publish, подписчик прочитает раньше него.
+	// Символы маркеров чередуются: повтор еще не убранного из очереди маркера встал бы на его старое место.
+	markers := []domain.PriceUpdateEvent{{Symbol: "MARKER-A"}, {Symbol: "MARKER-B"}}
+
+	tests := []struct {
+		name         string
+		dedupe       bool
+		maxPerSecond int
+	}{
+		{"off", false, 0},
+		{"dedupe", true, 0},
+		{"dedupe and cap", true, 10},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			s := newMarketStream("ws://unused", sourceLinearWS, websocket.DefaultDialer, "bench_stream")
+			s.logger = testLogger()
+			s.priceSource = domain.PriceSourceIndex
+			s.SetCoalescing(tt.dedupe, tt.maxPerSecond)
+			defer s.Close()
+
+			events, err := s.Subscribe(context.Background(), nil)
+			if err != nil {
+				b.Fatalf("Subscribe: %v", err)
+			}
+
+			scans, triggered := 0, 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				marker := markers[i%2]
+				s.publish(feed[i%len(feed)])
+				s.send(marker)
+				for event := range events {
+					if event.Symbol == marker.Symbol {
+						break
+					}
+					// Проход Manager: сравнение цены с триггером каждой задачи
+					for _, trigger := range triggers {
+						if event.Price.LessThanOrEqual(trigger) {
+							triggered++
+						}
+					}
+					scans++
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(scans)/float64(b.N), "scans/tick")
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+const (
+	MainnetPrivateURL = "wss://stream.bybit.com/v5/private"
+	TestnetPrivateURL = "wss://stream-testnet.bybit.com/v5/private"
+
+	privateAuthTTL = 10 * time.Second
+)
+
+var errPrivateAuthRejected = errors.New("private stream auth rejected")
+
+// PrivateStream держит по одному приватному соединению на API ключ (в сети этого ключа)
+// и сводит обновления ордеров и позиций всех ключей в два канала.
+type PrivateStream struct {
+	logger  *slog.Logger
+	proxies *Proxies
+	urls    StreamURLs
+
+	mu    sync.Mutex
+	conns map[int64]context.CancelFunc
+
+	orders    chan domain.OrderUpdateEvent
+	positions chan domain.PositionUpdateEvent
+}
+
+func NewPrivateStream(proxies *Proxies, urls StreamURLs) *PrivateStream {
+	return &PrivateStream{
+		logger:    slog.Default().With("component", "private_stream"),
+		proxies:   proxies,
+		urls:      urls,
+		conns:     make(map[int64]context.CancelFunc),
+		orders:    make(chan domain.OrderUpdateEvent, 100),
+		positions: make(chan domain.PositionUpdateEvent, 100),
+	}
+}
+
+func (s *PrivateStream) OrderUpdates() <-chan domain.OrderUpdateEvent {
+	return s.orders
+}
+
+func (s *PrivateStream) PositionUpdates() <-chan domain.PositionUpdateEvent {
+	return s.positions
+}
+
+// Watch запускает соединение для ключа, если оно еще не запущено
+func (s *PrivateStream) Watch(ctx context.Context, creds domain.APIKey) error {
+	if creds.Key == "" || creds.Secret == "" {
+		return fmt.Errorf("api key %d has no credentials", creds.ID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conns[creds.ID]; ok {
+		return nil
+	}
+
+	connCtx, cancel := context.WithCancel(ctx)
+	s.conns[creds.ID] = cancel
+
+	go s.maintainConnection(connCtx, creds)
+	return nil
+}
+
+// Unwatch закрывает соединение ключа (ключ удален или инвалидирован)
+func (s *PrivateStream) Unwatch(apiKeyID int64) {
+	s.mu.Lock()
+	cancel, ok := s.conns[apiKeyID]
+	delete(s.conns, apiKeyID)
+	s.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (s *PrivateStream) maintainConnection(ctx context.Context, creds domain.APIKey) {
+	log := s.logger.With(slog.Int64("api_key_id", creds.ID))
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, creds.ID)
+		s.mu.Unlock()
+	}()
+
+	backoff := newReconnectBackoff()
+	for {
+		started := time.Now()
+		err := s.connectAndListen(ctx, creds, log)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errPrivateAuthRejected) {
+			// Ключ отвергнут биржей: переподключение не поможет
+			log.Error("Private stream auth rejected, giving up", "err", err)
+			return
+		}
+
+		attempt, delay := backoff.next(time.Since(started))
+		log.Error("Private connection lost or failed", "err", err, "attempt", attempt, "retry_in", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *PrivateStream) connectAndListen(ctx context.Context, creds domain.APIKey, log *slog.Logger) error {
+	env := keyEnvironment(creds)
+	conn, _, err := s.proxies.dialer(env).DialContext(ctx, s.urls.private(env), nil)
+	if err != nil {
+		return err
+	}
+
+	var writeMu sync.Mutex
+	write := func(v interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(v)
+	}
+
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Закрываем соединение при отмене, чтобы разблокировать ReadMessage
+	go func() {
+		<-connCtx.Done()
+		conn.Close()
+	}()
+
+	if err := write(privateAuthRequest(creds)); err != nil {
+		return err
+	}
+
+	authenticated := false
+
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-connCtx.Done():
+				return
+			case <-ticker.C:
+				if err := write(map[string]string{"op": "ping"}); err != nil {
+					log.Error("Private ping failed", "err", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("read error: %w", err)
+		}
+
+		var msg wsPrivateMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			continue
+		}
+
+		switch {
+		case msg.Op == "auth":
+			if !msg.Success {
+				return fmt.Errorf("%w: %s", errPrivateAuthRejected, msg.RetMsg)
+			}
+			authenticated = true
+			log.Info("Private stream authenticated, subscribing")
+			if err := write(map[string]interface{}{
+				"op":   "subscribe",
+				"args": []string{"order.option", "position.option"},
+			}); err != nil {
+				return err
+			}
+
+		case msg.Op == "subscribe":
+			if !msg.Success {
+				return fmt.Errorf("private subscribe failed: %s", msg.RetMsg)
+			}
+
+		case msg.Op != "":
+			// pong и прочие служебные ответы
+
+		case !authenticated:
+			// до авторизации данные не принимаем
+
+		case msg.Topic == "order" || msg.Topic == "order.option":
+			s.publishOrders(creds.ID, msg.Data, log)
+
+		case msg.Topic == "position" || msg.Topic == "position.option":
+			s.publishPositions(creds.ID, msg.Data, log)
+		}
+	}
+}
+
+func (s *PrivateStream) publishOrders(apiKeyID int64, data json.RawMessage, log *slog.Logger) {
+	var items []wsOrderData
+	if err := json.Unmarshal(data, &items); err != nil {
+		log.Warn("Failed to decode order update", "err", err)
+		return
+	}
+
+	for _, item := range items {
+		event, err := item.toDomain(apiKeyID)
+		if err != nil {
+			log.Warn("Invalid order update", "order_link_id", item.OrderLinkID, "err", err)
+			continue
+		}
+
+		select {
+		case s.orders <- event:
+		default:
+			log.Warn("Order update channel full, dropping event", "order_link_id", item.OrderLinkID)
+		}
+	}
+}
+
+func (s *PrivateStream) publishPositions(apiKeyID int64, data json.RawMessage, log *slog.Logger) {
+	var items []wsPositionData
+	if err := json.Unmarshal(data, &items); err != nil {
+		log.Warn("Failed to decode position update", "err", err)
+		return
+	}
+
+	for _, item := range items {
+		event, err := item.toDomain(apiKeyID)
+		if err != nil {
+			log.Warn("Invalid position update", "symbol", item.Symbol, "err", err)
+			continue
+		}
+
+		select {
+		case s.positions <- event:
+		default:
+			log.Warn("Position update channel full, dropping event", "symbol", item.Symbol)
+		}
+	}
+}
+
+// privateAuthRequest: подпись HMAC_SHA256(secret, "GET/realtime" + expires)
+func privateAuthRequest(creds domain.APIKey) map[string]interface{} {
+	expires := time.Now().Add(privateAuthTTL).UnixMilli()
+	signature := generateSignature(fmt.Sprintf("GET/realtime%d", expires), creds.Secret)
+
+	return map[string]interface{}{
+		"op":   "auth",
+		"args": []interface{}{creds.Key, expires, signature},
+	}
+}
+
+type wsPrivateMessage struct {
+	Op      string          `json:"op"`
+	Success bool            `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	Topic   string          `json:"topic"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type wsOrderData struct {
+	Symbol      string `json:"symbol"`
+	OrderID     string `json:"orderId"`
+	OrderLinkID string `json:"orderLinkId"`
+	Side        string `json:"side"`
+	OrderStatus string `json:"orderStatus"`
+	Qty         string `json:"qty"`
+	CumExecQty  string `json:"cumExecQty"`
+	AvgPrice    string `json:"avgPrice"`
+	UpdatedTime string `json:"updatedTime"`
+}
+
+func (d wsOrderData) toDomain(apiKeyID int64) (domain.OrderUpdateEvent, error) {
+	qty, err := parseDecimalField("qty", d.Qty)
+	if err != nil {
+		return domain.OrderUpdateEvent{}, err
+	}
+	cumExecQty, err := parseDecimalField("cumExecQty", d.CumExecQty)
+	if err != nil {
+		return domain.OrderUpdateEvent{}, err
+	}
+	avgPrice, err := parseDecimalField("avgPrice", d.AvgPrice)
+	if err != nil {
+		return domain.OrderUpdateEvent{}, err
+	}
+
+	return domain.OrderUpdateEvent{
+		APIKeyID:    apiKeyID,
+		Symbol:      d.Symbol,
+		OrderID:     d.OrderID,
+		OrderLinkID: d.OrderLinkID,
+		Side:        d.Side,
+		Status:      d.OrderStatus,
+		Qty:         qty,
+		CumExecQty:  cumExecQty,
+		AvgPrice:    avgPrice,
+		Time:        parseMillis(d.UpdatedTime),
+	}, nil
+}
+
+type wsPositionData struct {
+	Symbol      string `json:"symbol"`
+	Side        string `json:"side"`
+	Size        string `json:"size"`
+	EntryPrice  string `json:"entryPrice"`
+	MarkPrice   string `json:"markPrice"`
+	UpdatedTime string `json:"updatedTime"`
+}
+
+func (d wsPositionData) toDomain(apiKeyID int64) (domain.PositionUpdateEvent, error) {
+	size, err := parseDecimalField("size", d.Size)
+	if err != nil {
+		return domain.PositionUpdateEvent{}, err
+	}
+	entryPrice, err := parseDecimalField("entryPrice", d.EntryPrice)
+	if err != nil {
+		return domain.PositionUpdateEvent{}, err
+	}
+	markPrice, err := parseDecimalField("markPrice", d.MarkPrice)
+	if err != nil {
+		return domain.PositionUpdateEvent{}, err
+	}
+
+	return domain.PositionUpdateEvent{
+		APIKeyID:   apiKeyID,
+		Symbol:     d.Symbol,
+		Side:       d.Side,
+		Qty:        size,
+		EntryPrice: entryPrice,
+		MarkPrice:  markPrice,
+		Time:       parseMillis(d.UpdatedTime),
+	}, nil
+}
+
+func parseMillis(value string) time.Time {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms == 0 {
+		return time.Now()
+	}
+	return time.UnixMilli(ms)
+}
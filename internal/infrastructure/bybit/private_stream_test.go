@@ -0,0 +1,282 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// fakePrivateServer - приватный стрим Bybit: проверяет подпись auth, подтверждает подписку
+// и рассылает сообщения, которые подает тест
+type fakePrivateServer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	rejectAuth bool
+
+	mu    sync.Mutex
+	conns []*websocket.Conn
+
+	subscribed chan []string // args каждого subscribe
+	connected  chan struct{}
+}
+
+func newFakePrivateServer(t *testing.T, rejectAuth bool) *fakePrivateServer {
+	t.Helper()
+	f := &fakePrivateServer{
+		t:          t,
+		rejectAuth: rejectAuth,
+		subscribed: make(chan []string, 16),
+		connected:  make(chan struct{}, 16),
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(func() {
+		f.drop()
+		f.srv.Close()
+	})
+	return f
+}
+
+func (f *fakePrivateServer) url() string {
+	return "ws" + strings.TrimPrefix(f.srv.URL, "http")
+}
+
+func (f *fakePrivateServer) serve(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	f.conns = append(f.conns, conn)
+	f.mu.Unlock()
+	f.connected <- struct{}{}
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			Op   string            `json:"op"`
+			Args []json.RawMessage `json:"args"`
+		}
+		if json.Unmarshal(message, &req) != nil {
+			continue
+		}
+		switch req.Op {
+		case "auth":
+			ok := !f.rejectAuth && validAuth(req.Args)
+			reply := map[string]any{"op": "auth", "success": ok}
+			if !ok {
+				reply["ret_msg"] = "Invalid apikey"
+			}
+			f.write(conn, reply)
+		case "subscribe":
+			var topics []string
+			for _, arg := range req.Args {
+				var topic string
+				json.Unmarshal(arg, &topic)
+				topics = append(topics, topic)
+			}
+			f.write(conn, map[string]any{"op": "subscribe", "success": true})
+			f.subscribed <- topics
+		case "ping":
+			f.write(conn, map[string]any{"op": "pong", "success": true})
+		}
+	}
+}
+
+// validAuth проверяет args auth: ключ, expires и HMAC_SHA256(secret, "GET/realtime" + expires)
+func validAuth(args []json.RawMessage) bool {
+	if len(args) != 3 {
+		return false
+	}
+	var key, signature string
+	var expires int64
+	if json.Unmarshal(args[0], &key) != nil || json.Unmarshal(args[1], &expires) != nil || json.Unmarshal(args[2], &signature) != nil {
+		return false
+	}
+	return key == testCreds.Key && signature == generateSignature(fmt.Sprintf("GET/realtime%d", expires), testCreds.Secret)
+}
+
+func (f *fakePrivateServer) write(conn *websocket.Conn, v any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	conn.WriteJSON(v)
+}
+
+// send пишет сообщение в последнее соединение
+func (f *fakePrivateServer) send(message string) {
+	f.t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.conns) == 0 {
+		f.t.Fatal("no private connection")
+	}
+	if err := f.conns[len(f.conns)-1].WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		f.t.Fatalf("write update: %v", err)
+	}
+}
+
+func (f *fakePrivateServer) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakePrivateServer) waitSubscribed() {
+	f.t.Helper()
+	select {
+	case topics := <-f.subscribed:
+		if strings.Join(topics, ",") != "order.option,position.option" {
+			f.t.Fatalf("subscribed to %v", topics)
+		}
+	case <-time.After(3 * time.Second):
+		f.t.Fatal("private stream did not subscribe")
+	}
+}
+
+func newTestPrivateStream(t *testing.T, url string) (*PrivateStream, domain.APIKey) {
+	t.Helper()
+	s := NewPrivateStream(nil, StreamURLs{MainnetPrivate: url})
+	s.logger = testLogger()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	creds := testCreds
+	creds.ID = 7
+	if err := s.Watch(ctx, creds); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	return s, creds
+}
+
+const (
+	orderUpdateFixture = `{"topic":"order.option","data":[
+		{"symbol":"BTC-26DEC25-60000-P","orderId":"o-1","orderLinkId":"roll-1-close","side":"Buy","orderStatus":"Filled",
+		 "qty":"0.5","cumExecQty":"0.5","avgPrice":"1250","updatedTime":"1766000000000"},
+		{"symbol":"BTC-26DEC25-60000-P","orderId":"o-bad","orderLinkId":"roll-1-bad","side":"Buy","orderStatus":"New",
+		 "qty":"n/a","cumExecQty":"0","avgPrice":"0","updatedTime":"1766000000000"},
+		{"symbol":"BTC-2JAN26-58000-P","orderId":"o-2","orderLinkId":"roll-1-open","side":"Sell","orderStatus":"PartiallyFilled",
+		 "qty":"0.5","cumExecQty":"0.2","avgPrice":"","updatedTime":"1766000000500"}]}`
+	positionUpdateFixture = `{"topic":"position.option","data":[
+		{"symbol":"BTC-26DEC25-60000-P","side":"","size":"0","entryPrice":"0","markPrice":"1240","updatedTime":"1766000000000"}]}`
+)
+
+func recvOrder(t *testing.T, s *PrivateStream) domain.OrderUpdateEvent {
+	t.Helper()
+	select {
+	case event := <-s.OrderUpdates():
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatal("no order update")
+	}
+	return domain.OrderUpdateEvent{}
+}
+
+func TestPrivateStreamOrderAndPositionUpdates(t *testing.T) {
+	srv := newFakePrivateServer(t, false)
+	s, creds := newTestPrivateStream(t, srv.url())
+	srv.waitSubscribed()
+
+	srv.send(orderUpdateFixture)
+	srv.send(positionUpdateFixture)
+
+	// Ордер с битым qty пропускается, остальные доходят по порядку
+	wantOrders := []struct {
+		linkID, status, cumExecQty, avgPrice string
+	}{
+		{"roll-1-close", "Filled", "0.5", "1250"},
+		{"roll-1-open", "PartiallyFilled", "0.2", "0"},
+	}
+	for _, want := range wantOrders {
+		got := recvOrder(t, s)
+		if got.APIKeyID != creds.ID || got.OrderLinkID != want.linkID || got.Status != want.status ||
+			got.CumExecQty.String() != want.cumExecQty || got.AvgPrice.String() != want.avgPrice {
+			t.Fatalf("order update = %+v, want %+v", got, want)
+		}
+	}
+	select {
+	case got := <-s.PositionUpdates():
+		// Нулевой размер - позиция закрыта (например, вручную)
+		if got.APIKeyID != creds.ID || got.Symbol != "BTC-26DEC25-60000-P" || !got.Qty.IsZero() || got.MarkPrice.String() != "1240" {
+			t.Fatalf("position update = %+v", got)
+		}
+		if !got.Time.Equal(time.UnixMilli(1766000000000)) {
+			t.Fatalf("position time = %v, want the exchange updatedTime", got.Time)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no position update")
+	}
+	select {
+	case got := <-s.OrderUpdates():
+		t.Fatalf("unexpected order update %+v", got)
+	default:
+	}
+}
+
+func TestPrivateStreamResubscribesAfterDrop(t *testing.T) {
+	srv := newFakePrivateServer(t, false)
+	s, _ := newTestPrivateStream(t, srv.url())
+	srv.waitSubscribed()
+	<-srv.connected
+
+	// Биржа порвала соединение: ключ заново авторизуется и подписывается
+	srv.drop()
+	select {
+	case <-srv.connected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("private stream did not reconnect")
+	}
+	srv.waitSubscribed()
+
+	srv.send(orderUpdateFixture)
+	if got := recvOrder(t, s); got.OrderLinkID != "roll-1-close" {
+		t.Fatalf("order update after reconnect = %+v", got)
+	}
+}
+
+func TestPrivateStreamAuthRejected(t *testing.T) {
+	srv := newFakePrivateServer(t, true)
+	s, creds := newTestPrivateStream(t, srv.url())
+	<-srv.connected
+
+	// Отвергнутый ключ не переподключается и освобождает слот: Watch запустит его заново
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.Lock()
+		_, watching := s.conns[creds.ID]
+		s.mu.Unlock()
+		if !watching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rejected key is still watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-srv.connected:
+		t.Fatal("reconnected with a rejected key")
+	case <-time.After(1500 * time.Millisecond):
+	}
+	select {
+	case <-srv.subscribed:
+		t.Fatal("subscribed without auth")
+	default:
+	}
+}
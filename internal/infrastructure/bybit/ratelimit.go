@@ -0,0 +1,222 @@
+package bybit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type endpointClass string
+
+const (
+	classMarket  endpointClass = "market"
+	classAccount endpointClass = "account"
+	classTrade   endpointClass = "trade"
+)
+
+// RateLimits - лимиты запросов в секунду по классам эндпоинтов.
+// Приватные классы считаются отдельно для каждого API ключа (лимиты Bybit per UID).
+type RateLimits struct {
+	MarketRPS  float64
+	AccountRPS float64
+	TradeRPS   float64
+}
+
+// RateLimitStats - счетчики для будущих метрик
+type RateLimitStats struct {
+	Requests       uint64
+	Throttled      uint64
+	ThrottledTime  time.Duration
+	HeaderBackoffs uint64
+}
+
+type rateLimiter struct {
+	limits RateLimits
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+
+	requests       atomic.Uint64
+	throttled      atomic.Uint64
+	throttledNanos atomic.Int64
+	headerBackoffs atomic.Uint64
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits:  limits,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func classifyEndpoint(endpoint string) endpointClass {
+	switch {
+	case strings.HasPrefix(endpoint, "/v5/market"):
+		return classMarket
+	case strings.HasPrefix(endpoint, "/v5/order"):
+		return classTrade
+	default:
+		return classAccount
+	}
+}
+
+func (l *rateLimiter) rps(class endpointClass) float64 {
+	switch class {
+	case classMarket:
+		return l.limits.MarketRPS
+	case classTrade:
+		return l.limits.TradeRPS
+	default:
+		return l.limits.AccountRPS
+	}
+}
+
+func (l *rateLimiter) bucket(class endpointClass, apiKey string) *tokenBucket {
+	name := string(class)
+	if apiKey != "" {
+		name += ":" + apiKey
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[name]
+	if !ok {
+		b = newTokenBucket(l.rps(class))
+		l.buckets[name] = b
+	}
+	return b
+}
+
+// Wait блокируется, пока запрос не уложится в бюджет, либо до отмены контекста
+func (l *rateLimiter) Wait(ctx context.Context, endpoint, apiKey string) error {
+	l.requests.Add(1)
+
+	waited, err := l.bucket(classifyEndpoint(endpoint), apiKey).wait(ctx)
+	if waited > 0 {
+		l.throttled.Add(1)
+		l.throttledNanos.Add(int64(waited))
+	}
+	return err
+}
+
+// Observe учитывает заголовки X-Bapi-Limit-Status / X-Bapi-Limit-Reset-Timestamp:
+// если биржа сообщает, что бюджет исчерпан, бакет замораживается до момента сброса.
+func (l *rateLimiter) Observe(endpoint, apiKey string, header http.Header) {
+	status := header.Get("X-Bapi-Limit-Status")
+	if status == "" {
+		return
+	}
+
+	remaining, err := strconv.Atoi(status)
+	if err != nil || remaining > 0 {
+		return
+	}
+
+	resetAt := limitResetTime(header)
+	if resetAt.IsZero() {
+		return
+	}
+
+	l.headerBackoffs.Add(1)
+	l.bucket(classifyEndpoint(endpoint), apiKey).blockUntil(resetAt)
+}
+
+// limitResetTime - момент сброса лимита из X-Bapi-Limit-Reset-Timestamp, zero если заголовка нет
+func limitResetTime(header http.Header) time.Time {
+	resetMs, err := strconv.ParseInt(header.Get("X-Bapi-Limit-Reset-Timestamp"), 10, 64)
+	if err != nil || resetMs <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(resetMs)
+}
+
+func (l *rateLimiter) Stats() RateLimitStats {
+	return RateLimitStats{
+		Requests:       l.requests.Load(),
+		Throttled:      l.throttled.Load(),
+		ThrottledTime:  time.Duration(l.throttledNanos.Load()),
+		HeaderBackoffs: l.headerBackoffs.Load(),
+	}
+}
+
+type tokenBucket struct {
+	mu           sync.Mutex
+	rate         float64
+	burst        float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func newTokenBucket(rps float64) *tokenBucket {
+	burst := rps
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rps,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) blockUntil(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t.After(b.blockedUntil) {
+		b.blockedUntil = t
+	}
+}
+
+// reserve забирает токен, если он есть, иначе возвращает время ожидания
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) wait(ctx context.Context) (time.Duration, error) {
+	var waited time.Duration
+	for {
+		delay := b.reserve()
+		if delay <= 0 {
+			return waited, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		case <-timer.C:
+			waited += delay
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package bybit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeyParts - подстроки имен полей, значения которых не должны попасть в логи
+var sensitiveKeyParts = []string{"secret", "sign", "api-key", "api_key", "apikey", "password", "token"}
+
+func isSensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(k, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeaders возвращает копию заголовков без ключа и подписи
+func RedactHeaders(header http.Header) map[string]string {
+	out := make(map[string]string, len(header))
+	for name, values := range header {
+		if isSensitiveKey(name) {
+			out[name] = redactedValue
+			continue
+		}
+		out[name] = strings.Join(values, ",")
+	}
+	return out
+}
+
+// RedactQuery маскирует чувствительные параметры в query string
+func RedactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redactedValue
+	}
+	for key := range values {
+		if isSensitiveKey(key) {
+			values[key] = []string{redactedValue}
+		}
+	}
+	return values.Encode()
+}
+
+// RedactBody маскирует чувствительные поля в JSON на любой вложенности.
+// Тело, которое не удалось разобрать, целиком не логируем.
+func RedactBody(body string) string {
+	if body == "" {
+		return ""
+	}
+	var payload interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		return redactedValue
+	}
+	redacted, err := json.Marshal(redactJSON(payload))
+	if err != nil {
+		return redactedValue
+	}
+	return string(redacted)
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, inner := range value {
+			if isSensitiveKey(key) {
+				value[key] = redactedValue
+				continue
+			}
+			value[key] = redactJSON(inner)
+		}
+		return value
+	case []interface{}:
+		for i, inner := range value {
+			value[i] = redactJSON(inner)
+		}
+		return value
+	default:
+		return v
+	}
+}
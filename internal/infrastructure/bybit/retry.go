@@ -0,0 +1,155 @@
+package bybit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// RetryPolicy - повторы для идемпотентных запросов (GET и создание ордера со сверкой по orderLinkId).
+// MaxAttempts включает первую попытку, 1 = без повторов.
+// Отказы по лимиту (retCode 10006, HTTP 403) повторяются отдельно, до RateLimitRetries раз:
+// такой запрос биржа не исполняла, поэтому повтор безопасен и для POST.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	RateLimitRetries int
+	// MaxRateLimitWait - если до сброса лимита дольше, не ждем и отдаем ErrRateLimited
+	MaxRateLimitWait time.Duration
+}
+
+// retCode, которые Bybit возвращает при временных сбоях на своей стороне
+var transientRetCodes = map[int]bool{
+	10000: true, // Server Timeout
+	10002: true, // Request time exceeds recv window (повтор идет с новым timestamp)
+	10016: true, // Internal server error
+	10429: true, // System level frequency protection
+}
+
+// retryableError помечает ошибку, после которой запрос можно безопасно повторить
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Is: если повторы не помогли, вызывающий видит domain.ErrExchangeUnavailable
+func (e *retryableError) Is(target error) bool {
+	return target == domain.ErrExchangeUnavailable
+}
+
+// rateLimitedError - биржа отклонила запрос по лимиту; resetAt из заголовка, если он был
+type rateLimitedError struct {
+	err     error
+	resetAt time.Time
+}
+
+func (e *rateLimitedError) Error() string { return e.err.Error() }
+func (e *rateLimitedError) Unwrap() error { return e.err }
+
+func (e *rateLimitedError) Is(target error) bool {
+	return target == domain.ErrRateLimited
+}
+
+func retryable(err error) error {
+	return &retryableError{err: err}
+}
+
+func isRetryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// Больше сдвигать незачем: BaseDelay << 30 уже дольше любого дедлайна операции
+const maxBackoffShift = 30
+
+// backoff: экспоненциальная задержка с full jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	shift := min(max(attempt-1, 0), maxBackoffShift)
+	delay := p.BaseDelay << shift
+	if delay>>shift != p.BaseDelay {
+		// Переполнение int64 дало бы ноль или отрицательную паузу, а rand.Int64N на них паникует
+		delay = math.MaxInt64
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return time.Duration(rand.Int64N(int64(delay)) + 1)
+}
+
+func (c *Client) withRetry(ctx context.Context, idempotent bool, attempt func() error) error {
+	maxAttempts := c.retry.MaxAttempts
+	if maxAttempts < 1 || !idempotent {
+		maxAttempts = 1
+	}
+
+	n, limited := 1, 0
+	for {
+		if ctx.Err() != nil {
+			return contextError(ctx, nil)
+		}
+
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		// Отмена или исчерпанный бюджет операции: повторять бессмысленно
+		if ctx.Err() != nil {
+			return contextError(ctx, err)
+		}
+
+		var delay time.Duration
+		var rl *rateLimitedError
+		if errors.As(err, &rl) {
+			limited++
+			if limited > c.retry.RateLimitRetries {
+				return err
+			}
+			delay = c.rateLimitDelay(rl, limited)
+			if c.retry.MaxRateLimitWait > 0 && delay > c.retry.MaxRateLimitWait {
+				return err
+			}
+		} else {
+			if n >= maxAttempts || !isRetryable(err) {
+				return err
+			}
+			delay = c.retry.backoff(n)
+			n++
+		}
+
+		// Не ждем паузу, которая все равно упрется в дедлайн
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return contextError(ctx, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// rateLimitDelay: ждем до сброса из заголовка, без него - обычный backoff
+func (c *Client) rateLimitDelay(rl *rateLimitedError, attempt int) time.Duration {
+	if !rl.resetAt.IsZero() {
+		if delay := time.Until(rl.resetAt); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return c.retry.backoff(attempt)
+}
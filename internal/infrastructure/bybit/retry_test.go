@@ -0,0 +1,194 @@
+package bybit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		max    time.Duration
+	}{
+		{"capped", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}, 2 * time.Second},
+		{"no cap", RetryPolicy{BaseDelay: 100 * time.Millisecond}, time.Duration(1<<63 - 1)},
+		{"huge base, no cap", RetryPolicy{BaseDelay: time.Hour * 24 * 365}, time.Duration(1<<63 - 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, attempt := range []int{0, 1, 2, 10, 40, 63, 64, 100, 1000} {
+				if d := tt.policy.backoff(attempt); d <= 0 || d > tt.max {
+					t.Fatalf("backoff(%d) = %s, want (0, %s]", attempt, d, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	ok := `{"retCode":0,"result":{"list":[{"orderLinkId":"close-7-v3","orderStatus":"New"}]}}`
+	tests := []struct {
+		name      string
+		fail      func(w http.ResponseWriter)
+		failures  int32
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"http 502 twice", func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) }, 2, 3, false},
+		{"retCode 10016 twice", func(w http.ResponseWriter) { w.Write([]byte(`{"retCode":10016,"retMsg":"Internal error"}`)) }, 2, 3, false},
+		{"attempts exhausted", func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }, 5, 3, true},
+		{"not retryable", func(w http.ResponseWriter) { w.Write([]byte(`{"retCode":10001,"retMsg":"params error"}`)) }, 5, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := newTestClient(t, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+				// Каждая попытка подписана заново
+				checkSignature(t, r, r.URL.RawQuery)
+				if calls.Add(1) <= tt.failures {
+					tt.fail(w)
+					return
+				}
+				w.Write([]byte(ok))
+			})
+
+			_, err := c.GetOrder(context.Background(), testCreds, "BTC-27DEC24-60000-P", "close-7-v3")
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("calls %d, want %d (err %v)", got, tt.wantCalls, err)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			// Исчерпанные повторы вызывающий видит как недоступность биржи, отказ по параметрам - нет
+			if err != nil && errors.Is(err, domain.ErrExchangeUnavailable) != (tt.wantCalls > 1) {
+				t.Fatalf("err = %v: unexpected ErrExchangeUnavailable match", err)
+			}
+		})
+	}
+}
+
+func TestRetryAbortsOnCancel(t *testing.T) {
+	errShutdown := errors.New("shutting down")
+	tests := []struct {
+		name      string
+		cancel    func(context.CancelCauseFunc)
+		early     bool // Отменить до первой попытки
+		wantCalls int32
+		wantErr   error
+	}{
+		{"cancel during backoff", func(cancel context.CancelCauseFunc) { cancel(nil) }, false, 1, context.Canceled},
+		{"cancel with cause", func(cancel context.CancelCauseFunc) { cancel(errShutdown) }, false, 1, errShutdown},
+		{"cancelled before the call", func(cancel context.CancelCauseFunc) { cancel(nil) }, true, 0, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+
+			var calls atomic.Int32
+			// Пауза между попытками до часа: без отмены тест бы завис
+			c := newTestClient(t, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(http.StatusBadGateway)
+				// Клиент уходит в паузу после ответа; отменяем, пока он ждет
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					tt.cancel(cancel)
+				}()
+			})
+			if tt.early {
+				tt.cancel(cancel)
+			}
+
+			start := time.Now()
+			_, err := c.GetOrder(ctx, testCreds, "BTC-27DEC24-60000-P", "close-7-v3")
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("GetOrder returned after %v, want prompt abort", elapsed)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("calls %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryOnlyReconciledOrderCreate(t *testing.T) {
+	const duplicate = `{"retCode":110072,"retMsg":"OrderLinkedID is duplicate"}`
+	tests := []struct {
+		name       string
+		call       func(c *Client) (string, error)
+		replies    []string // ответы create/amend/cancel по порядку; "502" - обрыв на стороне биржи
+		wantCalls  int32    // запросов к create/amend/cancel
+		wantLookup bool     // ордер сверен через /v5/order/realtime
+		wantID     string
+		wantErr    error
+	}{
+		{"create retried and reconciled", placeOrder, []string{"502", duplicate}, 2, true, "id-42", nil},
+		{"create retried, accepted", placeOrder, []string{"502", `{"retCode":0,"result":{"orderId":"id-7"}}`}, 2, false, "id-7", nil},
+		// Дубль с первой попытки - ордер остался от прошлого ролла, решает вызывающий
+		{"duplicate on first attempt", placeOrder, []string{duplicate}, 1, false, "", domain.ErrDuplicateOrderLinkID},
+		{"amend not retried", func(c *Client) (string, error) {
+			return "", c.AmendOrder(context.Background(), testCreds, "BTC-27DEC24-60000-P", "close-7-v3", decimal.NewFromInt(1200), decimal.Zero)
+		}, []string{"502"}, 1, false, "", domain.ErrExchangeUnavailable},
+		{"cancel not retried", func(c *Client) (string, error) {
+			return "", c.CancelOrder(context.Background(), testCreds, "BTC-27DEC24-60000-P", "close-7-v3")
+		}, []string{"502"}, 1, false, "", domain.ErrExchangeUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, lookups atomic.Int32
+			c := newTestClient(t, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v5/order/realtime" {
+					lookups.Add(1)
+					w.Write([]byte(`{"retCode":0,"result":{"list":[{"orderId":"id-42","orderLinkId":"close-7-v3","orderStatus":"New"}]}}`))
+					return
+				}
+				n := int(calls.Add(1))
+				if n > len(tt.replies) {
+					t.Errorf("unexpected attempt %d to %s", n, r.URL.Path)
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				if reply := tt.replies[n-1]; reply == "502" {
+					w.WriteHeader(http.StatusBadGateway)
+				} else {
+					w.Write([]byte(reply))
+				}
+			})
+
+			id, err := tt.call(c)
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("calls %d, want %d (err %v)", got, tt.wantCalls, err)
+			}
+			if got := lookups.Load() > 0; got != tt.wantLookup {
+				t.Fatalf("order lookup %v, want %v", got, tt.wantLookup)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || id != tt.wantID {
+				t.Fatalf("order id %q, err %v; want %q", id, err, tt.wantID)
+			}
+		})
+	}
+}
+
+func placeOrder(c *Client) (string, error) {
+	return c.PlaceOrder(context.Background(), testCreds, domain.OrderRequest{
+		Symbol: "BTC-27DEC24-60000-P", Side: domain.SideBuy, OrderType: "Market", Qty: decimal.NewFromFloat(0.1), OrderLinkID: "close-7-v3",
+	})
+}
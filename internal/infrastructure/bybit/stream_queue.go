@@ -0,0 +1,106 @@
+package bybit
+
+import (
+	"sync"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// conflatingQueue - очередь подписчика с вытеснением по символу: в очереди не больше одного тика
+// на символ, новый тик заменяет еще не прочитанный. Медленный потребитель получает самую свежую
+// цену и при этом не теряет тики других символов.
+type conflatingQueue struct {
+	out chan domain.PriceUpdateEvent
+
+	mu      sync.Mutex
+	pending map[string]queuedEvent
+	order   []string // Символы в порядке первого поступления
+	seq     uint64
+	notify  chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	onDeliver func()
+	onEvict   func()
+}
+
+type queuedEvent struct {
+	event domain.PriceUpdateEvent
+	seq   uint64
+}
+
+func newConflatingQueue(onDeliver, onEvict func()) *conflatingQueue {
+	q := &conflatingQueue{
+		out:       make(chan domain.PriceUpdateEvent),
+		pending:   make(map[string]queuedEvent),
+		notify:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		onDeliver: onDeliver,
+		onEvict:   onEvict,
+	}
+	go q.run()
+	return q
+}
+
+// push ставит тик в очередь, вытесняя непрочитанный тик того же символа. Не блокируется.
+func (q *conflatingQueue) push(event domain.PriceUpdateEvent) {
+	q.mu.Lock()
+	q.seq++
+	if _, queued := q.pending[event.Symbol]; queued {
+		q.onEvict()
+	} else {
+		q.order = append(q.order, event.Symbol)
+	}
+	q.pending[event.Symbol] = queuedEvent{event: event, seq: q.seq}
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close останавливает доставку; канал out закрывается горутиной run
+func (q *conflatingQueue) close() {
+	q.closeOnce.Do(func() { close(q.done) })
+}
+
+func (q *conflatingQueue) run() {
+	defer close(q.out)
+
+	for {
+		q.mu.Lock()
+		var head queuedEvent
+		hasHead := len(q.order) > 0
+		if hasHead {
+			head = q.pending[q.order[0]]
+		}
+		q.mu.Unlock()
+
+		if !hasHead {
+			select {
+			case <-q.notify:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+
+		select {
+		case q.out <- head.event:
+			q.onDeliver()
+			q.mu.Lock()
+			// Пока ждали потребителя, тик мог смениться - тогда доставим и новый
+			if current := q.pending[head.event.Symbol]; current.seq == head.seq {
+				delete(q.pending, head.event.Symbol)
+				q.order = q.order[1:]
+			}
+			q.mu.Unlock()
+		case <-q.notify:
+			// Пришел более свежий тик: перечитываем голову очереди
+		case <-q.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Сколько шард может не подключаться, прежде чем сообщить о падении стрима (StreamDown):
+// короткие реконнекты не повод переходить на запасные источники цен
+const streamDownAfter = 30 * time.Second
+
+// streamShard - одно WebSocket-соединение пула MarketStream со своей частью символов.
+// Реконнект, пинг и сторож тишины у каждого шарда свои: сбой одного соединения не роняет остальные.
+type streamShard struct {
+	id     int
+	pool   *MarketStream
+	logger *slog.Logger
+
+	conn     *websocket.Conn
+	mu       sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// Символы шарда для подписки при реконнекте
+	subs   []string
+	subsMu sync.RWMutex
+
+	// Время последнего сообщения по символу для сторожа тишины
+	lastTick map[string]time.Time
+	tickMu   sync.Mutex
+
+	// Подписки, ждущие ответа биржи, по req_id (под mu)
+	reqSeq  uint64
+	pending map[string][]string
+
+	// Пинг-понг текущего соединения (под mu): пинг проходит и по мертвому соединению,
+	// пока TCP не отвалится по таймауту, поэтому ждем ответа биржи
+	connectedAt  time.Time
+	pingSentAt   time.Time
+	awaitingPong bool
+	missedPongs  int
+	lastRTT      time.Duration
+
+	// Падение соединения (только из горутины maintainConnection): с какого момента нет тиков
+	// и отправлено ли StreamDown
+	failingSince time.Time
+	down         bool
+}
+
+func newStreamShard(id int, pool *MarketStream) *streamShard {
+	return &streamShard{
+		id:       id,
+		pool:     pool,
+		logger:   pool.logger.With("shard", id),
+		stop:     make(chan struct{}),
+		lastTick: make(map[string]time.Time),
+		pending:  make(map[string][]string),
+	}
+}
+
+func (s *streamShard) size() int {
+	s.subsMu.RLock()
+	defer s.subsMu.RUnlock()
+	return len(s.subs)
+}
+
+// addSubs добавляет символы в шард; отсчет тишины для них идет с момента подписки
+func (s *streamShard) addSubs(symbols []string) {
+	s.subsMu.Lock()
+	s.subs = append(s.subs, symbols...)
+	s.subsMu.Unlock()
+
+	s.markTicks(symbols, time.Now())
+}
+
+// dropSubs убирает символы из шарда
+func (s *streamShard) dropSubs(symbols []string) {
+	remove := make(map[string]bool, len(symbols))
+	for _, sym := range symbols {
+		remove[sym] = true
+	}
+
+	s.subsMu.Lock()
+	// Новый слайс: старый мог быть передан в connectAndListen
+	kept := make([]string, 0, len(s.subs))
+	for _, sym := range s.subs {
+		if !remove[sym] {
+			kept = append(kept, sym)
+		}
+	}
+	s.subs = kept
+	s.subsMu.Unlock()
+
+	s.tickMu.Lock()
+	for _, sym := range symbols {
+		delete(s.lastTick, sym)
+	}
+	s.tickMu.Unlock()
+}
+
+// close останавливает реконнекты шарда и рвет соединение; горутину ждет пул
+func (s *streamShard) close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+
+		s.mu.Lock()
+		if s.conn != nil {
+			// Разблокирует ReadMessage в connectAndListen
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+	})
+}
+
+func (s *streamShard) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *streamShard) maintainConnection() {
+	defer s.pool.wg.Done()
+
+	backoff := s.pool.newBackoff()
+	for {
+		started := time.Now()
+		err := s.connectAndListen()
+		if s.stopped() {
+			return
+		}
+		if err != nil {
+			s.logger.Error("Connection lost or failed", "err", err)
+		}
+		s.reportDown(err)
+
+		s.pool.stats.reconnects.Add(1)
+		attempt, delay := backoff.next(time.Since(started))
+		s.logger.Info("Reconnecting", "attempt", attempt, "delay", delay)
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *streamShard) connectAndListen() error {
+	s.logger.Info("Connecting to Bybit Stream...", "url", s.pool.url)
+
+	// ctx соединения: отмена контекста Subscribe прерывает и Dial, и пинги со сторожем
+	ctx, cancel := context.WithCancel(s.pool.ctx)
+	defer cancel()
+
+	conn, _, err := s.pool.dialer.DialContext(ctx, s.pool.url, nil)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.stopped() {
+		// close пришел во время Dial и не видел этого соединения
+		s.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	s.conn = conn
+	// Ответы на запросы прошлого соединения уже не придут
+	s.pending = make(map[string][]string)
+	s.connectedAt = time.Now()
+	s.awaitingPong = false
+	s.missedPongs = 0
+	s.lastRTT = 0
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		s.connectedAt = time.Time{}
+		s.mu.Unlock()
+	}()
+
+	// Список берем после установки conn: символы, добавленные во время Dial, не потеряются
+	s.subsMu.RLock()
+	symbols := s.subs
+	s.subsMu.RUnlock()
+
+	if err := s.sendSubscribe(symbols); err != nil {
+		return err
+	}
+	s.markTicks(symbols, time.Now())
+
+	s.pool.wg.Add(1)
+	go func() {
+		defer s.pool.wg.Done()
+		s.heartbeat(ctx, conn)
+	}()
+	if s.pool.staleTimeout > 0 {
+		s.pool.wg.Add(1)
+		go func() {
+			defer s.pool.wg.Done()
+			s.watchdog(ctx, conn)
+		}()
+	}
+
+	// Цикл чтения
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("read error: %w", err)
+		}
+		s.pool.stats.received.Add(1)
+
+		var rawMsg map[string]interface{}
+		if err := json.Unmarshal(message, &rawMsg); err != nil {
+			continue
+		}
+
+		// Ответы на ping/subscribe: отказ в подписке иначе выглядел бы как рабочая подписка без тиков
+		if _, ok := rawMsg["op"]; ok {
+			s.handleOpResponse(message)
+			continue
+		}
+
+		// Любое сообщение по топику - признак живой подписки, даже если цены в нем нет
+		if topic, ok := rawMsg["topic"].(string); ok {
+			s.markTicks([]string{strings.TrimPrefix(topic, "tickers.")}, time.Now())
+			s.reportUp()
+		}
+
+		s.pool.publish(message)
+	}
+}
+
+func (s *streamShard) sendSubscribe(symbols []string) error {
+	return s.sendOp("subscribe", symbols)
+}
+
+// sendOp отправляет subscribe/unsubscribe по тикерам пачками до maxArgsPerRequest; без соединения - no-op
+func (s *streamShard) sendOp(op string, symbols []string) error {
+	for start := 0; start < len(symbols); start += maxArgsPerRequest {
+		end := min(start+maxArgsPerRequest, len(symbols))
+		if err := s.sendOpBatch(op, symbols[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *streamShard) sendOpBatch(op string, symbols []string) error {
+	args := make([]string, len(symbols))
+	for i, sym := range symbols {
+		// Топик одинаковый для фьючерсов и опционов
+		args[i] = "tickers." + sym
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+
+	s.reqSeq++
+	reqID := fmt.Sprintf("%d-%s-%d", s.id, op, s.reqSeq)
+	req := map[string]interface{}{
+		"op":     op,
+		"args":   args,
+		"req_id": reqID,
+	}
+	if op == "subscribe" {
+		s.pending[reqID] = symbols
+	}
+
+	s.logger.Info("Sending subscription request", "op", op, "topics", args, "req_id", reqID)
+	return s.conn.WriteJSON(req)
+}
+
+// handleOpResponse разбирает понг и ответ на subscribe. Bybit отклоняет подписку целиком,
+// поэтому виновника ищем по ret_msg, а если он не назван - переподписываемся по одному символу.
+func (s *streamShard) handleOpResponse(message []byte) {
+	var resp wsOpResponse
+	if err := json.Unmarshal(message, &resp); err != nil {
+		return
+	}
+	// Линейный стрим отвечает op:"ping" + ret_msg:"pong", опционный - op:"pong"
+	if resp.Op == "pong" || (resp.Op == "ping" && resp.RetMsg == "pong") {
+		s.handlePong()
+		return
+	}
+	if resp.Op != "subscribe" {
+		return
+	}
+
+	s.mu.Lock()
+	symbols := s.pending[resp.ReqID]
+	delete(s.pending, resp.ReqID)
+	s.mu.Unlock()
+
+	if resp.Success || strings.Contains(resp.RetMsg, "already subscribed") {
+		return
+	}
+	s.logger.Error("Subscription rejected", "symbols", symbols, "reason", resp.RetMsg, "req_id", resp.ReqID)
+	s.pool.stats.subscribeFailures.Add(1)
+
+	var bad, rest []string
+	for _, sym := range symbols {
+		if strings.Contains(resp.RetMsg, "tickers."+sym) {
+			bad = append(bad, sym)
+		} else {
+			rest = append(rest, sym)
+		}
+	}
+
+	switch {
+	case len(bad) > 0:
+		s.pool.rejectSymbols(bad, resp.RetMsg)
+		if err := s.sendSubscribe(rest); err != nil {
+			s.logger.Error("Failed to resubscribe valid symbols", "symbols", rest, "err", err)
+		}
+	case len(symbols) > 1:
+		for _, sym := range symbols {
+			if err := s.sendSubscribe([]string{sym}); err != nil {
+				s.logger.Error("Failed to resubscribe symbol", "symbol", sym, "err", err)
+			}
+		}
+	case len(symbols) == 1:
+		s.pool.rejectSymbols(symbols, resp.RetMsg)
+	}
+}
+
+// heartbeat шлет пинг раз в pool.pingInterval и рвет соединение после maxMissedPongs пингов подряд без ответа
+func (s *streamShard) heartbeat(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(s.pool.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.awaitingPong {
+				s.missedPongs++
+			}
+			if s.missedPongs >= maxMissedPongs {
+				missed := s.missedPongs
+				s.mu.Unlock()
+
+				s.logger.Warn("⚠️ No pong from stream, forcing reconnect", "missed", missed)
+				// ReadMessage в connectAndListen получит ошибку, и maintainConnection переподключится
+				conn.Close()
+				return
+			}
+
+			if s.conn != nil {
+				if err := s.conn.WriteJSON(map[string]string{"op": "ping"}); err != nil {
+					s.logger.Error("Ping failed", "err", err)
+				} else {
+					s.pingSentAt = time.Now()
+					s.awaitingPong = true
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *streamShard) handlePong() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.awaitingPong {
+		return
+	}
+	s.lastRTT = time.Since(s.pingSentAt)
+	s.awaitingPong = false
+	s.missedPongs = 0
+}
+
+func (s *streamShard) status() domain.ConnectionStatus {
+	symbols := s.size()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := domain.ConnectionStatus{
+		Connected: s.conn != nil,
+		LastRTT:   s.lastRTT,
+		Symbols:   symbols,
+	}
+	if status.Connected {
+		status.Uptime = time.Since(s.connectedAt)
+	}
+	return status
+}
+
+// watchdog рвет соединение, если хоть один символ шарда молчит дольше staleTimeout.
+// ReadMessage в connectAndListen получит ошибку, и maintainConnection переподключится.
+func (s *streamShard) watchdog(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(s.pool.staleTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stale, silence := s.staleSymbols(now)
+			if len(stale) == 0 {
+				continue
+			}
+
+			s.logger.Warn("⚠️ No ticks from stream, forcing reconnect", "symbols", stale, "silence", silence)
+			s.pool.emitHealth(domain.StreamHealthEvent{Kind: domain.StreamSilent, Source: s.pool.source, Symbols: stale, Silence: silence, Time: now})
+
+			s.mu.Lock()
+			conn.Close()
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// reportDown отмечает обрыв и сообщает о падении стрима, если тиков нет дольше streamDownAfter
+func (s *streamShard) reportDown(err error) {
+	now := time.Now()
+	if s.failingSince.IsZero() {
+		s.failingSince = now
+	}
+	if s.down || now.Sub(s.failingSince) < streamDownAfter {
+		return
+	}
+	s.down = true
+
+	s.subsMu.RLock()
+	symbols := s.subs
+	s.subsMu.RUnlock()
+
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+	downFor := now.Sub(s.failingSince)
+	s.logger.Error("🚨 Stream is down", "symbols", symbols, "down_for", downFor.Round(time.Second), "err", err)
+	s.pool.emitHealth(domain.StreamHealthEvent{Kind: domain.StreamDown, Source: s.pool.source, Symbols: symbols, Silence: downFor, Reason: reason, Time: now})
+}
+
+// reportUp - пришел тик: сбрасывает отсчет падения и сообщает о восстановлении после StreamDown
+func (s *streamShard) reportUp() {
+	if s.failingSince.IsZero() {
+		return
+	}
+	downFor := time.Since(s.failingSince)
+	s.failingSince = time.Time{}
+	if !s.down {
+		return
+	}
+	s.down = false
+
+	s.subsMu.RLock()
+	symbols := s.subs
+	s.subsMu.RUnlock()
+
+	s.logger.Info("✅ Stream recovered", "symbols", symbols, "was_down", downFor.Round(time.Second))
+	s.pool.emitHealth(domain.StreamHealthEvent{Kind: domain.StreamRecovered, Source: s.pool.source, Symbols: symbols, Time: time.Now()})
+}
+
+// markTicks отмечает время последнего сообщения по символам
+func (s *streamShard) markTicks(symbols []string, at time.Time) {
+	s.tickMu.Lock()
+	defer s.tickMu.Unlock()
+	for _, sym := range symbols {
+		s.lastTick[sym] = at
+	}
+}
+
+// staleSymbols возвращает символы шарда без тиков дольше staleTimeout и максимальную тишину
+func (s *streamShard) staleSymbols(now time.Time) ([]string, time.Duration) {
+	s.subsMu.RLock()
+	subs := s.subs
+	s.subsMu.RUnlock()
+
+	s.tickMu.Lock()
+	defer s.tickMu.Unlock()
+
+	var stale []string
+	var maxSilence time.Duration
+	for _, sym := range subs {
+		last, ok := s.lastTick[sym]
+		if !ok {
+			continue
+		}
+		if silence := now.Sub(last); silence > s.pool.staleTimeout {
+			stale = append(stale, sym)
+			if silence > maxSilence {
+				maxSilence = silence
+			}
+		}
+	}
+	return stale, maxSilence
+}
@@ -0,0 +1,119 @@
+package bybit
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// TransportConfig - настройки пула соединений. Воркеры бьют в один хост,
+// поэтому держим теплые соединения, чтобы не платить за TLS-рукопожатие в момент ролла.
+type TransportConfig struct {
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	EnableHTTP2         bool
+}
+
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+		EnableHTTP2:         true,
+	}
+}
+
+func newTransport(cfg TransportConfig, proxies *Proxies) *http.Transport {
+	defaults := DefaultTransportConfig()
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+
+	return &http.Transport{
+		Proxy: proxies.httpProxy,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          cfg.MaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     cfg.EnableHTTP2,
+	}
+}
+
+// Верхние границы бакетов гистограммы задержек; последний бакет - все, что дольше
+var latencyBounds = []time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// LatencyBucket - число запросов с задержкой <= UpperBound (0 - бакет "больше последней границы")
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+type LatencyStats struct {
+	Count   uint64
+	Total   time.Duration
+	Buckets []LatencyBucket
+}
+
+func (s LatencyStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// latencyHistogram - lock-free гистограмма, пишется из всех воркеров
+type latencyHistogram struct {
+	buckets []atomic.Uint64
+	count   atomic.Uint64
+	total   atomic.Int64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{buckets: make([]atomic.Uint64, len(latencyBounds)+1)}
+}
+
+func (h *latencyHistogram) Observe(d time.Duration) {
+	idx := len(latencyBounds)
+	for i, bound := range latencyBounds {
+		if d <= bound {
+			idx = i
+			break
+		}
+	}
+	h.buckets[idx].Add(1)
+	h.count.Add(1)
+	h.total.Add(int64(d))
+}
+
+func (h *latencyHistogram) Stats() LatencyStats {
+	stats := LatencyStats{
+		Count:   h.count.Load(),
+		Total:   time.Duration(h.total.Load()),
+		Buckets: make([]LatencyBucket, len(h.buckets)),
+	}
+	for i := range h.buckets {
+		var bound time.Duration
+		if i < len(latencyBounds) {
+			bound = latencyBounds[i]
+		}
+		stats.Buckets[i] = LatencyBucket{UpperBound: bound, Count: h.buckets[i].Load()}
+	}
+	return stats
+}
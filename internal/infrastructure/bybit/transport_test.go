@@ -0,0 +1,56 @@
+package bybit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// BenchmarkPlaceOrderConnection сравнивает ордер по новому TLS-соединению (cold)
+// и по соединению из пула (warm) на локальном сервере
+func BenchmarkPlaceOrderConnection(b *testing.B) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"retCode":0,"result":{"orderId":"42"}}`))
+	}))
+	defer srv.Close()
+
+	target, _ := url.Parse(srv.URL)
+	order := domain.OrderRequest{Symbol: "BTC-27DEC24-60000-P", Side: domain.SideBuy, OrderType: "Market", OrderLinkID: "close-7-v3"}
+
+	newBenchClient := func() (*Client, *http.Transport) {
+		transport := newTransport(DefaultTransportConfig(), nil)
+		transport.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		c := NewClient(ClientConfig{Logger: testLogger()})
+		c.httpClient = &http.Client{Transport: redirectTransport{target: target, base: transport}}
+		return c, transport
+	}
+
+	b.Run("cold", func(b *testing.B) {
+		c, transport := newBenchClient()
+		for i := 0; i < b.N; i++ {
+			// Каждый ордер платит за TCP и TLS-рукопожатие
+			transport.CloseIdleConnections()
+			if _, err := c.PlaceOrder(context.Background(), testCreds, order); err != nil {
+				b.Fatalf("PlaceOrder: %v", err)
+			}
+		}
+	})
+
+	b.Run("warm", func(b *testing.B) {
+		c, transport := newBenchClient()
+		defer transport.CloseIdleConnections()
+		if _, err := c.PlaceOrder(context.Background(), testCreds, order); err != nil {
+			b.Fatalf("PlaceOrder: %v", err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.PlaceOrder(context.Background(), testCreds, order); err != nil {
+				b.Fatalf("PlaceOrder: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package crypto
+
+import "fmt"
+
+// Keyring - ключи шифрования по версиям. Новые записи шифруются текущим ключом,
+// старые расшифровываются ключом той версии, которой были зашифрованы.
+type Keyring struct {
+	current int
+	keys    map[int]*Encryptor
+}
+
+func NewKeyring(currentVersion int, current *Encryptor) (*Keyring, error) {
+	if currentVersion <= 0 {
+		return nil, fmt.Errorf("invalid key version %d", currentVersion)
+	}
+	return &Keyring{
+		current: currentVersion,
+		keys:    map[int]*Encryptor{currentVersion: current},
+	}, nil
+}
+
+// Add регистрирует ключ прежней версии, чтобы читать зашифрованные им записи
+func (k *Keyring) Add(version int, enc *Encryptor) {
+	k.keys[version] = enc
+}
+
+// Current - версия и ключ для новых записей
+func (k *Keyring) Current() (int, *Encryptor) {
+	return k.current, k.keys[k.current]
+}
+
+func (k *Keyring) Get(version int) (*Encryptor, bool) {
+	enc, ok := k.keys[version]
+	return enc, ok
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+type AdminAuditRepository struct {
+	db *DB
+}
+
+func NewAdminAuditRepository(db *DB) *AdminAuditRepository {
+	return &AdminAuditRepository{db: db}
+}
+
+func (r *AdminAuditRepository) Record(ctx context.Context, entry *domain.AdminAuditEntry) error {
+	query := `
+		INSERT INTO admin_audit_log (admin_telegram_id, action, details, result, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRowContext(ctx, query, entry.AdminTelegramID, entry.Action, nullString(entry.Details), nullString(entry.Result)).
+		Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record admin action %s: %w", entry.Action, err)
+	}
+	return nil
+}
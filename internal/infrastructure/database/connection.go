@@ -1,23 +1,51 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
 )
 
 type Config struct {
+	// Driver: postgres (по умолчанию) или sqlite. Для SQLite из остальных полей нужны только Path и лимиты
+	Driver Driver
+	// Path - файл базы SQLite; пусто или ":memory:" - база в памяти, живет до остановки процесса
+	Path string
+
 	Host     string
 	Port     int
 	User     string
 	Password string
 	DBName   string
 	SSLMode  string
+	// MaxOpenConns: каждый идущий ролл держит соединение под лок задачи; 0 - 25
+	MaxOpenConns int
+
+	// Ожидание базы при старте (Postgres в docker-compose поднимается позже бота):
+	// ConnectAttempts попыток с удваивающейся паузой от ConnectBackoff, но не дольше ConnectTimeout.
+	// Нули - 10 попыток, 1с, 60с.
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+	ConnectTimeout  time.Duration
+
+	// Лимиты на один запрос: SELECT и остальное (запись, транзакция целиком). Нули - 3с и 5с.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Запросы дольше порога пишутся в лог (warn); ноль - 500мс
+	SlowQueryThreshold time.Duration
+	// Дольше лок задачи не держится: ролл прерывается, его доводит восстановление; ноль - 15 минут
+	TaskLockMaxHold time.Duration
 }
 
 func (c *Config) ConnectString() string {
+	if c.Driver == DriverSQLite {
+		return sqliteDSN(c.Path)
+	}
 	return fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode,
@@ -26,23 +54,126 @@ func (c *Config) ConnectString() string {
 
 type DB struct {
 	*sql.DB
+	driver       Driver
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	slowQuery    time.Duration
+	lockMaxHold  time.Duration
+	stats        queryStats
+	logger       *slog.Logger
+	taskLocks    sqliteTaskLocks
 }
 
-func NewConnection(cfg Config) (*DB, error) {
-	db, err := sql.Open("postgres", cfg.ConnectString())
+// Потолок паузы между попытками подключения
+const maxConnectBackoff = 10 * time.Second
+
+func NewConnection(ctx context.Context, cfg Config, logger *slog.Logger) (*DB, error) {
+	if cfg.Driver == "" {
+		cfg.Driver = DriverPostgres
+	}
+	if cfg.Driver != DriverPostgres && cfg.Driver != DriverSQLite {
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+
+	db, err := sql.Open(string(cfg.Driver), cfg.ConnectString())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open connection: %w", err)
 	}
 
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	if cfg.Driver == DriverSQLite {
+		// Одно соединение навсегда: база в памяти живет, пока оно открыто, а писатель у SQLite и так один
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+	} else {
+		maxOpen := cfg.MaxOpenConns
+		if maxOpen <= 0 {
+			maxOpen = 25
+		}
+		db.SetMaxOpenConns(maxOpen)
+		db.SetMaxIdleConns(5)
+		db.SetConnMaxLifetime(5 * time.Minute)
+	}
+
+	conn := &DB{
+		DB:           db,
+		driver:       cfg.Driver,
+		readTimeout:  cfg.ReadTimeout,
+		writeTimeout: cfg.WriteTimeout,
+		slowQuery:    cfg.SlowQueryThreshold,
+		lockMaxHold:  cfg.TaskLockMaxHold,
+		stats:        queryStats{stats: make(map[string]*domain.QueryStat)},
+		logger:       logger.With("component", "database"),
+		taskLocks:    sqliteTaskLocks{locked: make(map[int64]bool)},
+	}
+	if conn.readTimeout <= 0 {
+		conn.readTimeout = 3 * time.Second
+	}
+	if conn.writeTimeout <= 0 {
+		conn.writeTimeout = 5 * time.Second
+	}
+	if conn.slowQuery <= 0 {
+		conn.slowQuery = 500 * time.Millisecond
+	}
+	if conn.lockMaxHold <= 0 {
+		conn.lockMaxHold = 15 * time.Minute
+	}
+	if err := conn.waitReady(ctx, cfg, logger); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// waitReady пингует базу, пока она не ответит, не кончатся попытки или не выйдет ConnectTimeout
+func (db *DB) waitReady(ctx context.Context, cfg Config, logger *slog.Logger) error {
+	attempts := cfg.ConnectAttempts
+	if attempts <= 0 {
+		attempts = 10
+	}
+	backoff := cfg.ConnectBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		err := db.Health(ctx)
+		if err == nil {
+			if attempt > 1 {
+				logger.Info("Database is ready", "attempt", attempt)
+			}
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("failed to ping database after %d attempts: %w", attempt, err)
+		}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+		logger.Warn("Database not ready, retrying",
+			"attempt", attempt, "max_attempts", attempts, "retry_in", backoff.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not ready within %s: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
 	}
+}
+
+// Health - база отвечает на ping
+func (db *DB) Health(ctx context.Context) error {
+	return db.PingContext(ctx)
+}
 
-	return &DB{db}, nil
+// Driver - СУБД соединения
+func (db *DB) Driver() Driver {
+	return db.driver
 }
 
 func (db *DB) Close() error {
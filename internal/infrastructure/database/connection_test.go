@@ -0,0 +1,171 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer - буфер лога, в который пишут из нескольких горутин
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// freeAddr - свободный локальный адрес: пока на нем никто не слушает, подключение отвергается
+func freeAddr(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+	return addr
+}
+
+// listenPostgresAfter начинает слушать addr через delay и отвечает как Postgres без пароля:
+// startup -> AuthenticationOk + ReadyForQuery, любой простой запрос (ping pq - ";") -> пустой результат
+func listenPostgresAfter(t *testing.T, addr *net.TCPAddr, delay time.Duration) {
+	t.Helper()
+	listening := make(chan net.Listener, 1)
+	time.AfterFunc(delay, func() {
+		ln, err := net.Listen("tcp", addr.String())
+		if err != nil {
+			t.Errorf("delayed listen: %v", err)
+			listening <- nil
+			return
+		}
+		listening <- ln
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go servePostgres(conn)
+		}
+	})
+	t.Cleanup(func() {
+		if ln := <-listening; ln != nil {
+			ln.Close()
+		}
+	})
+}
+
+func servePostgres(conn net.Conn) {
+	defer conn.Close()
+
+	// StartupMessage: длина и тело без типа
+	var size int32
+	if binary.Read(conn, binary.BigEndian, &size) != nil {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(size-4)); err != nil {
+		return
+	}
+	authOK := []byte{'R', 0, 0, 0, 8, 0, 0, 0, 0}
+	ready := []byte{'Z', 0, 0, 0, 5, 'I'}
+	if _, err := conn.Write(append(authOK, ready...)); err != nil {
+		return
+	}
+
+	for {
+		var kind byte
+		if binary.Read(conn, binary.BigEndian, &kind) != nil {
+			return
+		}
+		if binary.Read(conn, binary.BigEndian, &size) != nil {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, conn, int64(size-4)); err != nil {
+			return
+		}
+		switch kind {
+		case 'Q':
+			emptyQuery := []byte{'I', 0, 0, 0, 4}
+			if _, err := conn.Write(append(emptyQuery, ready...)); err != nil {
+				return
+			}
+		case 'X':
+			return
+		}
+	}
+}
+
+func TestNewConnectionWaitsForDelayedDatabase(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	tests := []struct {
+		name     string
+		attempts int
+		timeout  time.Duration
+		wantErr  string
+	}{
+		// База поднялась позже бота: несколько неудачных ping, затем подключение
+		{"database comes up", 20, 5 * time.Second, ""},
+		{"attempts run out", 2, 5 * time.Second, "after 2 attempts"},
+		{"timeout runs out", 20, 100 * time.Millisecond, "not ready within"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			listenPostgresAfter(t, addr, delay)
+
+			var logs lockedBuffer
+			cfg := Config{
+				Host: addr.IP.String(), Port: addr.Port, User: "roller", DBName: "roller", SSLMode: "disable",
+				ConnectAttempts: tt.attempts,
+				ConnectBackoff:  50 * time.Millisecond,
+				ConnectTimeout:  tt.timeout,
+			}
+			start := time.Now()
+			db, err := NewConnection(context.Background(), cfg, slog.New(slog.NewTextHandler(&logs, nil)))
+			elapsed := time.Since(start)
+
+			if !strings.Contains(logs.String(), "Database not ready, retrying") {
+				t.Fatalf("no retry logged:\n%s", logs.String())
+			}
+			if tt.wantErr != "" {
+				if err == nil {
+					db.Close()
+					t.Fatal("connected before the database was up")
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewConnection: %v", err)
+			}
+			defer db.Close()
+			if elapsed < delay {
+				t.Fatalf("connected after %s, before the listener started", elapsed)
+			}
+			if !strings.Contains(logs.String(), "Database is ready") {
+				t.Fatalf("readiness not logged:\n%s", logs.String())
+			}
+			if err := db.Health(context.Background()); err != nil {
+				t.Fatalf("Health after connect: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Package dbtest - база SQLite в памяти со схемой для тестов репозиториев, роллера и воркеров:
+// тот же SQL, что в проде, без Postgres и контейнеров
+package dbtest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/crypto"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/migrations"
+	"github.com/shopspring/decimal"
+)
+
+// Logger молчит: вывод репозиториев только зашумил бы go test -v
+func Logger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Open - новая база в памяти с накатанными миграциями; закрывается по окончании теста
+func Open(t testing.TB) *database.DB {
+	t.Helper()
+	db, err := database.NewConnection(context.Background(), database.Config{Driver: database.DriverSQLite}, Logger())
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrations.MigrateSQLite(db.DB, Logger()); err != nil {
+		t.Fatalf("migrate sqlite: %v", err)
+	}
+	return db
+}
+
+// Keyring с постоянным тестовым ключом шифрования
+func Keyring(t testing.TB) *crypto.Keyring {
+	t.Helper()
+	enc, err := crypto.NewEncryptor(strings.Repeat("ab", crypto.KeySize))
+	if err != nil {
+		t.Fatalf("encryptor: %v", err)
+	}
+	keyring, err := crypto.NewKeyring(1, enc)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	return keyring
+}
+
+// Fixture - репозитории над одной базой
+type Fixture struct {
+	DB     *database.DB
+	Tasks  *database.TaskRepository
+	Keys   *database.APIKeyRepository
+	Users  *database.UserRepository
+	Orders *database.OrderRepository
+}
+
+func NewFixture(t testing.TB) *Fixture {
+	t.Helper()
+	db := Open(t)
+	return &Fixture{
+		DB:     db,
+		Tasks:  database.NewTaskRepository(db, Logger(), true),
+		Keys:   database.NewAPIKeyRepository(db, Keyring(t)),
+		Users:  database.NewUserRepository(db),
+		Orders: database.NewOrderRepository(db),
+	}
+}
+
+// User создает пользователя с подпиской на месяц
+func (f *Fixture) User(t testing.TB, telegramID int64) *domain.User {
+	t.Helper()
+	user := &domain.User{TelegramID: telegramID, ExpiresAt: time.Now().AddDate(0, 1, 0)}
+	if err := f.Users.GetOrCreate(context.Background(), user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// Key создает действующий ключ пользователя; key - API ключ на бирже (у фейковой биржи это аккаунт)
+func (f *Fixture) Key(t testing.TB, userID int64, key string) *domain.APIKey {
+	t.Helper()
+	apiKey := &domain.APIKey{UserID: userID, Key: key, Secret: key + "-secret", Label: key, IsValid: true}
+	if err := f.Keys.Create(context.Background(), apiKey); err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	return apiKey
+}
+
+// Task создает задачу на ключе: короткий пут symbol на BTCUSDT в статусе status
+func (f *Fixture) Task(t testing.TB, key *domain.APIKey, symbol string, trigger int64, status domain.TaskState) *domain.Task {
+	t.Helper()
+	task := &domain.Task{
+		UserID:              key.UserID,
+		APIKeyID:            key.ID,
+		TargetSide:          domain.SideSell,
+		CurrentOptionSymbol: symbol,
+		UnderlyingSymbol:    "BTCUSDT",
+		CurrentQty:          decimal.NewFromFloat(0.1),
+		TriggerPrice:        decimal.NewFromInt(trigger),
+		NextStrikeStep:      decimal.NewFromInt(1000),
+		Status:              status,
+	}
+	if err := f.Tasks.CreateTask(context.Background(), task); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	return task
+}
+
+// Reload перечитывает задачу из базы
+func (f *Fixture) Reload(t testing.TB, id int64) *domain.Task {
+	t.Helper()
+	task, err := f.Tasks.GetTaskByID(context.Background(), id)
+	if err != nil || task == nil {
+		t.Fatalf("reload task %d: %v", id, err)
+	}
+	return task
+}
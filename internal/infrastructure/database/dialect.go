@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"modernc.org/sqlite"
+)
+
+// Driver - СУБД под репозиториями. SQLite - для локального запуска без Postgres: те же
+// репозитории и почти тот же SQL, различия диалекта собраны в этом файле.
+type Driver string
+
+const (
+	DriverPostgres Driver = "postgres"
+	DriverSQLite   Driver = "sqlite"
+)
+
+// Время в SQLite хранится текстом в UTC в одном формате (_time_format=sqlite у modernc),
+// поэтому сравнения expires_at > NOW() работают как строковые.
+const sqliteTimeLayout = "2006-01-02 15:04:05.999999999-07:00"
+
+func init() {
+	// NOW() из запросов Postgres: в SQLite такой функции нет
+	sqlite.MustRegisterScalarFunction("now", 0, func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return time.Now().UTC().Format(sqliteTimeLayout), nil
+	})
+}
+
+// sqliteDSN: внешние ключи у SQLite по умолчанию выключены, а ON DELETE CASCADE на них опирается
+func sqliteDSN(path string) string {
+	if path == "" || path == ":memory:" {
+		path = ":memory:"
+	}
+	return "file:" + path + "?_time_format=sqlite&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+}
+
+// rebind приводит запрос Postgres к SQLite. Блокировка строк не нужна: соединение одно,
+// транзакции и так идут по очереди.
+func (db *DB) rebind(query string) string {
+	if db.driver != DriverSQLite {
+		return query
+	}
+	return strings.ReplaceAll(query, " FOR UPDATE", "")
+}
+
+// bindArgs переводит время в UTC: SQLite сравнивает его как текст. Срез вызывающего не меняем.
+func (db *DB) bindArgs(args []any) []any {
+	if db.driver != DriverSQLite {
+		return args
+	}
+	bound := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			bound[i] = v.UTC()
+		case *time.Time:
+			if v != nil {
+				bound[i] = v.UTC()
+			} else {
+				bound[i] = v
+			}
+		case sql.NullTime:
+			bound[i] = sql.NullTime{Time: v.Time.UTC(), Valid: v.Valid}
+		default:
+			bound[i] = arg
+		}
+	}
+	return bound
+}
+
+// inIDs - условие "колонка входит в список ID" с параметром $n: ANY у Postgres,
+// json_each у SQLite. Значение параметра дает idList.
+func (db *DB) inIDs(column string, n int) string {
+	if db.driver == DriverSQLite {
+		return column + " IN (SELECT value FROM json_each($" + strconv.Itoa(n) + "))"
+	}
+	return column + " = ANY($" + strconv.Itoa(n) + ")"
+}
+
+func (db *DB) idList(ids []int64) any {
+	if db.driver == DriverSQLite {
+		raw, _ := json.Marshal(ids)
+		return string(raw)
+	}
+	return pq.Array(ids)
+}
+
+// sqliteTaskLocks заменяет advisory lock Postgres: SQLite открывается одним процессом,
+// и лок в памяти его достаточен
+type sqliteTaskLocks struct {
+	mu     sync.Mutex
+	locked map[int64]bool
+}
+
+func (l *sqliteTaskLocks) with(ctx context.Context, id int64, fn func(ctx context.Context) error) error {
+	l.mu.Lock()
+	if l.locked[id] {
+		l.mu.Unlock()
+		return domain.ErrTaskLocked
+	}
+	l.locked[id] = true
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.locked, id)
+		l.mu.Unlock()
+	}()
+	return fn(ctx)
+}
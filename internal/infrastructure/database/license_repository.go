@@ -19,12 +19,12 @@ func NewLicenseRepository(db *DB) *LicenseRepository {
 	return &LicenseRepository{db: db}
 }
 
-func (r *LicenseRepository) Generate(ctx context.Context, durationDays int) (*domain.LicenseKey, error) {
+func (r *LicenseRepository) Generate(ctx context.Context, durationDays int, validUntil *time.Time) (*domain.LicenseKey, error) {
 	code := generateLicenseCode(durationDays)
 
 	query := `
-		INSERT INTO license_keys (code, duration_days, created_by, created_at)
-		VALUES ($1, $2, 'ADMIN', NOW())
+		INSERT INTO license_keys (code, duration_days, valid_until, created_by, created_at)
+		VALUES ($1, $2, $3, 'ADMIN', NOW())
 		RETURNING id, created_at
 	`
 
@@ -32,10 +32,11 @@ func (r *LicenseRepository) Generate(ctx context.Context, durationDays int) (*do
 		Code:         code,
 		DurationDays: durationDays,
 		IsRedeemed:   false,
+		ValidUntil:   validUntil,
 		CreatedBy:    "ADMIN",
 	}
 
-	err := r.db.QueryRowContext(ctx, query, code, durationDays).Scan(&lic.ID, &lic.CreatedAt)
+	err := r.db.QueryRowContext(ctx, query, code, durationDays, validUntil).Scan(&lic.ID, &lic.CreatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate license: %w", err)
 	}
@@ -43,39 +44,118 @@ func (r *LicenseRepository) Generate(ctx context.Context, durationDays int) (*do
 	return lic, nil
 }
 
-func (r *LicenseRepository) Redeem(ctx context.Context, code string, userID int64) error {
+// Redeem продлевает подписку от текущей даты окончания, если она еще не прошла, иначе от сейчас.
+// Пользователь блокируется в той же транзакции, чтобы две активации подряд не потеряли дни.
+func (r *LicenseRepository) Redeem(ctx context.Context, code string, userID int64) (time.Time, error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 	defer tx.Rollback()
 
 	var lic domain.LicenseKey
-	query := `SELECT id, duration_days, is_redeemed FROM license_keys WHERE code = $1 FOR UPDATE`
-	err = tx.QueryRowContext(ctx, query, code).Scan(&lic.ID, &lic.DurationDays, &lic.IsRedeemed)
+	query := `SELECT id, duration_days, is_redeemed, revoked_at, valid_until FROM license_keys WHERE code = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, code).Scan(&lic.ID, &lic.DurationDays, &lic.IsRedeemed, &lic.RevokedAt, &lic.ValidUntil)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("license not found")
+		return time.Time{}, domain.ErrLicenseNotFound
 	}
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 
-	if lic.IsRedeemed {
-		return fmt.Errorf("license already redeemed")
+	now := time.Now()
+	switch {
+	case lic.IsRedeemed:
+		return time.Time{}, domain.ErrLicenseRedeemed
+	case lic.RevokedAt != nil:
+		return time.Time{}, domain.ErrLicenseRevoked
+	case lic.IsExpired(now):
+		return time.Time{}, domain.ErrLicenseExpired
+	}
+
+	var current time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT expires_at FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&current); err != nil {
+		return time.Time{}, fmt.Errorf("failed to lock user %d: %w", userID, err)
+	}
+	newExpiry, err := domain.ExtendSubscription(current, now, lic.DurationDays)
+	if err != nil {
+		return time.Time{}, err
 	}
 
 	updateLic := `UPDATE license_keys SET is_redeemed = TRUE, redeemed_by = $1, redeemed_at = NOW() WHERE id = $2`
 	if _, err := tx.ExecContext(ctx, updateLic, userID, lic.ID); err != nil {
-		return err
+		return time.Time{}, err
 	}
 
-	newExpiry := time.Now().Add(time.Duration(lic.DurationDays) * 24 * time.Hour)
 	updateUser := `UPDATE users SET expires_at = $1 WHERE id = $2`
 	if _, err := tx.ExecContext(ctx, updateUser, newExpiry, userID); err != nil {
-		return err
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	return newExpiry, nil
+}
+
+// List - коды, новые первыми; onlyUnredeemed оставляет только неактивированные
+// (в том числе отозванные и просроченные, чтобы админ видел их статус)
+func (r *LicenseRepository) List(ctx context.Context, onlyUnredeemed bool) ([]domain.LicenseKey, error) {
+	query := `
+		SELECT id, code, duration_days, is_redeemed, redeemed_by, redeemed_at, revoked_at, valid_until,
+			   created_by, created_at
+		FROM license_keys
+		WHERE NOT $1 OR NOT is_redeemed
+		ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, onlyUnredeemed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list licenses: %w", err)
 	}
+	defer rows.Close()
+
+	var licenses []domain.LicenseKey
+	for rows.Next() {
+		var lic domain.LicenseKey
+		err := rows.Scan(&lic.ID, &lic.Code, &lic.DurationDays, &lic.IsRedeemed, &lic.RedeemedBy, &lic.RedeemedAt,
+			&lic.RevokedAt, &lic.ValidUntil, &lic.CreatedBy, &lic.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan license: %w", err)
+		}
+		licenses = append(licenses, lic)
+	}
+	return licenses, rows.Err()
+}
 
-	return tx.Commit()
+// Revoke отзывает неактивированный код. Активированный отозвать нельзя: подписка уже выдана.
+func (r *LicenseRepository) Revoke(ctx context.Context, code string) error {
+	query := `
+		UPDATE license_keys SET revoked_at = NOW()
+		WHERE code = $1 AND NOT is_redeemed AND revoked_at IS NULL
+	`
+	res, err := r.db.ExecContext(ctx, query, code)
+	if err != nil {
+		return fmt.Errorf("failed to revoke license: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	// Ничего не обновили - выясняем почему
+	var redeemed, revoked bool
+	err = r.db.QueryRowContext(ctx, `SELECT is_redeemed, revoked_at IS NOT NULL FROM license_keys WHERE code = $1`, code).
+		Scan(&redeemed, &revoked)
+	if err == sql.ErrNoRows {
+		return domain.ErrLicenseNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check license: %w", err)
+	}
+	if redeemed {
+		return domain.ErrLicenseRedeemed
+	}
+	return domain.ErrLicenseRevoked
 }
 
 func generateLicenseCode(days int) string {
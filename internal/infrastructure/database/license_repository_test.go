@@ -0,0 +1,71 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/dbtest"
+)
+
+func TestLicenseRedeem(t *testing.T) {
+	const days = 30
+	period := days * 24 * time.Hour
+	tests := []struct {
+		name    string
+		expires time.Duration // окончание подписки относительно сейчас
+		want    time.Duration // ожидаемое окончание после активации
+		wantErr error
+	}{
+		{"expired user", -10 * 24 * time.Hour, period, nil},
+		{"active user", 10 * 24 * time.Hour, 10*24*time.Hour + period, nil},
+		{"stacking cap", domain.MaxSubscriptionAhead - 24*time.Hour, 0, domain.ErrLicenseStackLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fx := dbtest.NewFixture(t)
+			licenses := database.NewLicenseRepository(fx.DB)
+
+			now := time.Now()
+			user := &domain.User{TelegramID: 1, ExpiresAt: now.Add(tt.expires)}
+			if err := fx.Users.GetOrCreate(ctx, user); err != nil {
+				t.Fatalf("create user: %v", err)
+			}
+			lic, err := licenses.Generate(ctx, days, nil)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+
+			got, err := licenses.Redeem(ctx, lic.Code, user.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("redeem err = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, err := fx.Users.GetByTelegramID(ctx, 1)
+			if err != nil {
+				t.Fatalf("reload user: %v", err)
+			}
+			if tt.wantErr != nil {
+				// Отказ не тратит код и не трогает подписку
+				if !stored.ExpiresAt.Equal(user.ExpiresAt) {
+					t.Fatalf("expiry changed to %s", stored.ExpiresAt)
+				}
+				if _, err := licenses.Redeem(ctx, lic.Code, user.ID); errors.Is(err, domain.ErrLicenseRedeemed) {
+					t.Fatal("rejected code was marked as redeemed")
+				}
+				return
+			}
+
+			if want := now.Add(tt.want); got.Sub(want).Abs() > time.Minute || !stored.ExpiresAt.Equal(got) {
+				t.Fatalf("expires %s (stored %s), want about %s", got, stored.ExpiresAt, want)
+			}
+			if _, err := licenses.Redeem(ctx, lic.Code, user.ID); !errors.Is(err, domain.ErrLicenseRedeemed) {
+				t.Fatalf("second redeem err = %v, want ErrLicenseRedeemed", err)
+			}
+		})
+	}
+}
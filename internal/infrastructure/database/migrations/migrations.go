@@ -0,0 +1,99 @@
+// Package migrations - схема БД, вшитая в бинарник. Файлы sql/NNNNNN_name.{up,down}.sql
+// применяются golang-migrate; версия хранится в таблице schema_migrations.
+// Для SQLite (локальная разработка) отдельный каталог sqlite/ со схемой, собранной целиком.
+//
+// Миграции 1-6 раньше применялись вручную и написаны через IF NOT EXISTS: на базе,
+// где схема уже есть, первый запуск просто запишет версию.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/lib/pq"
+)
+
+//go:embed sql/*.sql sqlite/*.sql
+var files embed.FS
+
+// Migrate накатывает все новые миграции. dsn - строка подключения lib/pq: мигратор
+// закрывает свое соединение по завершении, поэтому пул приложения ему не отдаем.
+func Migrate(dsn string, logger *slog.Logger) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open migration connection: %w", err)
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to init migration driver: %w", err)
+	}
+
+	m, err := newMigrate("sql", "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return err
+	}
+	defer m.Close()
+
+	return up(m, logger)
+}
+
+// MigrateSQLite накатывает схему sqlite/ на открытую базу приложения. Мигратор не закрываем:
+// он закрыл бы и пул приложения, а база :memory: пропала бы вместе с ним.
+func MigrateSQLite(db *sql.DB, logger *slog.Logger) error {
+	driver, err := sqlite.WithInstance(db, &sqlite.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to init migration driver: %w", err)
+	}
+
+	m, err := newMigrate("sqlite", "sqlite", driver)
+	if err != nil {
+		return err
+	}
+	return up(m, logger)
+}
+
+func up(m *migrate.Migrate, logger *slog.Logger) error {
+	before, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if err := m.Up(); err != nil {
+		if errors.Is(err, migrate.ErrNoChange) {
+			logger.Info("Database schema is up to date", slog.Uint64("version", uint64(before)))
+			return nil
+		}
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	after, _, err := m.Version()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	logger.Info("Database schema migrated", slog.Uint64("from", uint64(before)), slog.Uint64("to", uint64(after)))
+	return nil
+}
+
+func newMigrate(dir, name string, driver database.Driver) (*migrate.Migrate, error) {
+	source, err := iofs.New(files, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load embedded migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, name, driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init migrator: %w", err)
+	}
+	return m, nil
+}
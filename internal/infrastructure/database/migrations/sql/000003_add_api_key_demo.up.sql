@@ -0,0 +1,2 @@
+-- Демо-торговля Bybit: ключи с этим флагом ходят на api-demo.bybit.com
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS is_demo BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+-- Сеть задается на уровне ключа. NULL у существующих ключей заполняется
+-- при старте бота текущим глобальным BYBIT_TESTNET (см. APIKeyRepository.BackfillTestnet)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS is_testnet BOOLEAN;
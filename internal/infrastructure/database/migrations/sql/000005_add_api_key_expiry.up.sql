@@ -0,0 +1,2 @@
+-- Срок действия ключа на Bybit (из /v5/user/query-api). NULL - бессрочный или неизвестен
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
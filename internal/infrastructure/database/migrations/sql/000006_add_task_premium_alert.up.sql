@@ -0,0 +1,2 @@
+-- Порог премии проданного опциона (mark price), при пробое которого пользователь получает алерт. NULL - выключен
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS premium_alert_threshold NUMERIC(32, 18);
@@ -0,0 +1,3 @@
+-- Сторона открываемой ноги (Buy/Sell). Без нее роллер после рестарта угадывает сторону по позиции.
+-- NULL у существующих задач - поведение прежнее
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS target_side VARCHAR(10);
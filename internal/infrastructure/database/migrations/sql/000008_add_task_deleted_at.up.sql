@@ -0,0 +1,2 @@
+-- Мягкое удаление задач пользователем: строка остается для истории, запросы ее не видят
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,26 @@
+-- Журнал ордеров: строка пишется до отправки на биржу и обновляется ответом и исполнением.
+-- После падения посреди ролла по нему видно, что именно ушло на биржу.
+CREATE TABLE IF NOT EXISTS orders (
+    id BIGSERIAL PRIMARY KEY,
+    task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    order_link_id VARCHAR(64) NOT NULL UNIQUE,
+    symbol VARCHAR(50) NOT NULL,
+    side VARCHAR(10) NOT NULL,
+    order_type VARCHAR(10) NOT NULL,
+    qty NUMERIC(32, 18) NOT NULL,
+    price NUMERIC(32, 18),
+    time_in_force VARCHAR(10),
+    reduce_only BOOLEAN NOT NULL DEFAULT FALSE,
+
+    -- Pending до ответа биржи, Error при отказе, дальше статус ордера Bybit
+    status VARCHAR(20) NOT NULL,
+    exchange_order_id VARCHAR(64),
+    cum_exec_qty NUMERIC(32, 18) NOT NULL DEFAULT 0,
+    avg_price NUMERIC(32, 18),
+    error TEXT,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_task_id ON orders(task_id);
@@ -0,0 +1,2 @@
+-- Удаление ключа пользователем: запись остается, потому что на нее ссылаются задачи и история ордеров
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+-- Админские выборки по подписке: активные, истекшие, истекающие в ближайшие дни
+CREATE INDEX IF NOT EXISTS idx_users_expires_at ON users(expires_at);
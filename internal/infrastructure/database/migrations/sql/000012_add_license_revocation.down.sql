@@ -0,0 +1,2 @@
+ALTER TABLE license_keys DROP COLUMN IF EXISTS valid_until;
+ALTER TABLE license_keys DROP COLUMN IF EXISTS revoked_at;
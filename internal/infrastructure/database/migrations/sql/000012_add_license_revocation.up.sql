@@ -0,0 +1,4 @@
+-- Отзыв утекшего кода и срок, до которого код можно активировать. У существующих кодов
+-- обе колонки NULL: не отозваны и действуют бессрочно, как раньше.
+ALTER TABLE license_keys ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE license_keys ADD COLUMN IF NOT EXISTS valid_until TIMESTAMP WITH TIME ZONE;
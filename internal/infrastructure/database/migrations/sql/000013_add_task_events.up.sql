@@ -0,0 +1,14 @@
+-- Журнал событий задачи: роллы, завершения и т.п. Строка пишется в той же транзакции,
+-- что и изменение задачи, поэтому журнал не расходится с ее состоянием.
+CREATE TABLE IF NOT EXISTS task_events (
+    id BIGSERIAL PRIMARY KEY,
+    task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    event_type VARCHAR(20) NOT NULL,
+    from_symbol VARCHAR(50),
+    to_symbol VARCHAR(50),
+    qty NUMERIC(32, 18),
+    details TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_events_task_id ON task_events(task_id, created_at);
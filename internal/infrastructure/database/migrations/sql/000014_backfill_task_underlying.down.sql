@@ -0,0 +1,2 @@
+-- Заполненные значения не откатываем: они верные
+ALTER TABLE tasks ALTER COLUMN underlying_symbol DROP DEFAULT;
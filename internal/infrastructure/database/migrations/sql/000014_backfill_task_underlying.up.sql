@@ -0,0 +1,11 @@
+-- Старые задачи могли остаться без underlying_symbol: выводим его из символа опциона
+-- (BTC-29DEC23-40000-C -> BTCUSDT), как это делает бот при создании задачи
+UPDATE tasks
+SET underlying_symbol = CASE
+        WHEN split_part(target_symbol, '-', 1) LIKE '%USDT' THEN split_part(target_symbol, '-', 1)
+        ELSE split_part(target_symbol, '-', 1) || 'USDT'
+    END
+WHERE underlying_symbol IS NULL OR underlying_symbol = '';
+
+ALTER TABLE tasks ALTER COLUMN underlying_symbol SET DEFAULT '';
+ALTER TABLE tasks ALTER COLUMN underlying_symbol SET NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE tasks DROP COLUMN IF EXISTS roll_count;
+ALTER TABLE tasks DROP COLUMN IF EXISTS last_triggered_at;
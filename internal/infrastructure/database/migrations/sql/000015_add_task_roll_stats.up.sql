@@ -0,0 +1,4 @@
+-- Когда задача роллировалась последний раз и сколько всего: для кулдаунов, лимитов и статистики.
+-- У существующих задач истории нет: NULL и 0.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS last_triggered_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS roll_count INT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Версия ключа шифрования, которым зашифрованы key_enc/secret_enc. Все существующие
+-- записи зашифрованы единственным до сих пор ключом - версия 1.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS key_version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+-- Название задачи, которое задает пользователь (необязательное)
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS label TEXT;
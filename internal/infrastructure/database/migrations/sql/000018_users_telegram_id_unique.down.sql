@@ -0,0 +1,2 @@
+-- Ограничение UNIQUE из исходной схемы не трогаем
+DROP INDEX IF EXISTS idx_users_telegram_id;
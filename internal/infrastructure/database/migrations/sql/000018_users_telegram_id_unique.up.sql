@@ -0,0 +1,15 @@
+-- Уникальность telegram_id нужна для GetOrCreate (ON CONFLICT). В исходной схеме она задана UNIQUE,
+-- но таблица users, созданная до миграций, могла остаться без нее (CREATE TABLE IF NOT EXISTS ее не трогает).
+-- Индекс создаем, только если уникального индекса по telegram_id еще нет. Если в такой базе
+-- уже есть дубли, миграция упадет: их нужно свести вручную.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+        WHERE i.indrelid = 'users'::regclass AND i.indisunique AND i.indnatts = 1 AND a.attname = 'telegram_id'
+    ) THEN
+        CREATE UNIQUE INDEX idx_users_telegram_id ON users(telegram_id);
+    END IF;
+END $$;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_tasks_api_key_id;
@@ -0,0 +1,2 @@
+-- Задачи ключа: выключение, удаление и замена ключа, список "чем занят ключ"
+CREATE INDEX IF NOT EXISTS idx_tasks_api_key_id ON tasks(api_key_id) WHERE deleted_at IS NULL;
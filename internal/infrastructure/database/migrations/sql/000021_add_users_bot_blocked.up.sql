@@ -0,0 +1,3 @@
+-- Пользователь заблокировал бота (Telegram ответил 403): уведомления и рассылки ему не шлем,
+-- пока он снова не напишет боту
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bot_blocked_at TIMESTAMP WITH TIME ZONE;
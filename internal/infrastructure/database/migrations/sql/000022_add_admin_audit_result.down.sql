@@ -0,0 +1 @@
+ALTER TABLE admin_audit_log DROP COLUMN IF EXISTS result;
@@ -0,0 +1,2 @@
+-- Итог команды администратора: запись пишется после выполнения, "ok" или текст ошибки
+ALTER TABLE admin_audit_log ADD COLUMN IF NOT EXISTS result TEXT;
@@ -0,0 +1 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS account_uid;
@@ -0,0 +1,3 @@
+-- UID аккаунта Bybit, которому принадлежит ключ: задачи переводятся только между ключами
+-- одного аккаунта. У ключей, сохраненных раньше, NULL - UID узнается на бирже при переводе.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS account_uid BIGINT;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS task_events;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS license_keys;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,104 @@
+-- Схема для SQLite (DB_DRIVER=sqlite, локальная разработка): сразу итог миграций sql/ 1-19.
+-- Версия совпадает с последней миграцией Postgres; изменения схемы дальше вносятся в оба каталога.
+-- Деньги и количества хранятся текстом: NUMERIC в SQLite округлил бы их до float.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY,
+    telegram_id BIGINT NOT NULL UNIQUE,
+    username VARCHAR(255),
+    expires_at TIMESTAMP NOT NULL,
+    is_banned BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_users_expires_at ON users(expires_at);
+
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_enc VARCHAR(512) NOT NULL,
+    secret_enc VARCHAR(512) NOT NULL,
+    key_version INT NOT NULL DEFAULT 1,
+    label VARCHAR(255),
+    is_valid BOOLEAN NOT NULL DEFAULT TRUE,
+    is_demo BOOLEAN NOT NULL DEFAULT FALSE,
+    is_testnet BOOLEAN,
+    expires_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE tasks (
+    id INTEGER PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    api_key_id BIGINT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    target_symbol VARCHAR(50) NOT NULL,
+    target_side VARCHAR(10),
+    underlying_symbol VARCHAR(20) NOT NULL DEFAULT '',
+    current_qty TEXT NOT NULL,
+    trigger_price TEXT NOT NULL,
+    next_strike_step TEXT NOT NULL,
+    premium_alert_threshold TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'IDLE',
+    version BIGINT NOT NULL DEFAULT 1,
+    last_error TEXT,
+    last_triggered_at TIMESTAMP,
+    roll_count INT NOT NULL DEFAULT 0,
+    label TEXT,
+    deleted_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_tasks_status_underlying ON tasks(status, underlying_symbol);
+CREATE INDEX idx_tasks_user_id ON tasks(user_id);
+CREATE INDEX idx_tasks_api_key_id ON tasks(api_key_id) WHERE deleted_at IS NULL;
+
+CREATE TABLE license_keys (
+    id INTEGER PRIMARY KEY,
+    code VARCHAR(100) UNIQUE NOT NULL,
+    duration_days INT NOT NULL,
+    is_redeemed BOOLEAN NOT NULL DEFAULT FALSE,
+    redeemed_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    redeemed_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    valid_until TIMESTAMP,
+    created_by VARCHAR(50) NOT NULL DEFAULT 'ADMIN',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_license_keys_redeemed ON license_keys(is_redeemed);
+
+CREATE TABLE orders (
+    id INTEGER PRIMARY KEY,
+    task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    order_link_id VARCHAR(64) NOT NULL UNIQUE,
+    symbol VARCHAR(50) NOT NULL,
+    side VARCHAR(10) NOT NULL,
+    order_type VARCHAR(10) NOT NULL,
+    qty TEXT NOT NULL,
+    price TEXT,
+    time_in_force VARCHAR(10),
+    reduce_only BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(20) NOT NULL,
+    exchange_order_id VARCHAR(64),
+    cum_exec_qty TEXT NOT NULL DEFAULT '0',
+    avg_price TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_orders_task_id ON orders(task_id);
+
+CREATE TABLE task_events (
+    id INTEGER PRIMARY KEY,
+    task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    event_type VARCHAR(20) NOT NULL,
+    from_symbol VARCHAR(50),
+    to_symbol VARCHAR(50),
+    qty TEXT,
+    details TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_events_task_id ON task_events(task_id, created_at);
@@ -0,0 +1,9 @@
+CREATE TABLE admin_audit_log (
+    id INTEGER PRIMARY KEY,
+    admin_telegram_id BIGINT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    details TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_admin_audit_log_created_at ON admin_audit_log(created_at);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN bot_blocked_at TIMESTAMP;
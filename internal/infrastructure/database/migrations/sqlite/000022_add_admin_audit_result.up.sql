@@ -0,0 +1 @@
+ALTER TABLE admin_audit_log ADD COLUMN result TEXT;
@@ -0,0 +1 @@
+ALTER TABLE api_keys ADD COLUMN account_uid BIGINT;
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+type OrderRepository struct {
+	db *DB
+}
+
+func NewOrderRepository(db *DB) *OrderRepository {
+	return &OrderRepository{db: db}
+}
+
+// Record пишет ордер до отправки. Повтор с тем же orderLinkId (ретрай ноги, восстановление)
+// оставляет исходную строку: на бирже это тот же ордер.
+func (r *OrderRepository) Record(ctx context.Context, rec *domain.OrderRecord) error {
+	query := `
+		INSERT INTO orders (
+			task_id, order_link_id, symbol, side, order_type, qty, price, time_in_force, reduce_only,
+			status, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW())
+		ON CONFLICT (order_link_id) DO UPDATE SET updated_at = NOW()
+		RETURNING id, status, created_at, updated_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		rec.TaskID, rec.OrderLinkID, rec.Symbol, rec.Side, rec.OrderType, rec.Qty,
+		nullDecimal(rec.Price), nullString(rec.TimeInForce), rec.ReduceOnly, domain.OrderJournalPending,
+	).Scan(&rec.ID, &rec.Status, &rec.CreatedAt, &rec.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record order %s: %w", rec.OrderLinkID, err)
+	}
+	return nil
+}
+
+func (r *OrderRepository) MarkSent(ctx context.Context, orderLinkID, exchangeOrderID string) error {
+	query := `
+		UPDATE orders
+		SET status = $1, exchange_order_id = $2, error = NULL, updated_at = NOW()
+		WHERE order_link_id = $3 AND status IN ($4, $5)
+	`
+	_, err := r.db.ExecContext(ctx, query, domain.OrderStatusNew, nullString(exchangeOrderID), orderLinkID,
+		domain.OrderJournalPending, domain.OrderJournalError)
+	if err != nil {
+		return fmt.Errorf("failed to mark order %s sent: %w", orderLinkID, err)
+	}
+	return nil
+}
+
+func (r *OrderRepository) MarkFailed(ctx context.Context, orderLinkID string, errMessage string) error {
+	query := `
+		UPDATE orders
+		SET status = $1, error = $2, updated_at = NOW()
+		WHERE order_link_id = $3
+	`
+	if _, err := r.db.ExecContext(ctx, query, domain.OrderJournalError, errMessage, orderLinkID); err != nil {
+		return fmt.Errorf("failed to mark order %s failed: %w", orderLinkID, err)
+	}
+	return nil
+}
+
+func (r *OrderRepository) UpdateFromExchange(ctx context.Context, order domain.Order) error {
+	query := `
+		UPDATE orders
+		SET status = $1, exchange_order_id = COALESCE($2, exchange_order_id), price = COALESCE($3, price),
+			cum_exec_qty = $4, avg_price = $5, updated_at = NOW()
+		WHERE order_link_id = $6
+	`
+	_, err := r.db.ExecContext(ctx, query, order.Status, nullString(order.OrderID), nullDecimal(order.Price),
+		order.CumExecQty, nullDecimal(order.AvgPrice), order.OrderLinkID)
+	if err != nil {
+		return fmt.Errorf("failed to update order %s: %w", order.OrderLinkID, err)
+	}
+	return nil
+}
+
+const orderColumns = `
+	o.id, o.task_id, o.order_link_id, o.symbol, o.side, o.order_type, o.qty, o.price, o.time_in_force,
+	o.reduce_only, o.status, o.exchange_order_id, o.cum_exec_qty, o.avg_price, o.error, o.created_at, o.updated_at
+`
+
+// GetByOrderLinkID: nil - такой ордер не записывался
+func (r *OrderRepository) GetByOrderLinkID(ctx context.Context, orderLinkID string) (*domain.OrderRecord, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders o WHERE o.order_link_id = $1`
+	rec, err := scanOrder(r.db.QueryRowContext(ctx, query, orderLinkID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order %s: %w", orderLinkID, err)
+	}
+	return rec, nil
+}
+
+// ListByUserID - последние ордера пользователя по всем его задачам, новые первыми
+func (r *OrderRepository) ListByUserID(ctx context.Context, userID int64, limit int) ([]domain.OrderRecord, error) {
+	query := `
+		SELECT ` + orderColumns + `
+		FROM orders o
+		JOIN tasks t ON t.id = o.task_id
+		WHERE t.user_id = $1
+		ORDER BY o.created_at DESC, o.id DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer rows.Close()
+
+	var records []domain.OrderRecord
+	for rows.Next() {
+		rec, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		records = append(records, *rec)
+	}
+	return records, rows.Err()
+}
+
+func scanOrder(row interface{ Scan(dest ...any) error }) (*domain.OrderRecord, error) {
+	rec := &domain.OrderRecord{}
+	var price, avgPrice decimal.NullDecimal
+	var tif, exchangeOrderID, errMessage sql.NullString
+
+	err := row.Scan(
+		&rec.ID, &rec.TaskID, &rec.OrderLinkID, &rec.Symbol, &rec.Side, &rec.OrderType, &rec.Qty, &price, &tif,
+		&rec.ReduceOnly, &rec.Status, &exchangeOrderID, &rec.CumExecQty, &avgPrice, &errMessage,
+		&rec.CreatedAt, &rec.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	rec.Price = price.Decimal
+	rec.AvgPrice = avgPrice.Decimal
+	rec.TimeInForce = tif.String
+	rec.ExchangeOrderID = exchangeOrderID.String
+	rec.Error = errMessage.String
+	return rec, nil
+}
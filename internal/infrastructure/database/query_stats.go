@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Каждый запрос через DB учитывается под именем метода репозитория, из которого он вызван
+// (TaskRepository.GetActiveTasks): считаем число, ошибки, суммарное и максимальное время.
+// Запрос дольше SlowQueryThreshold пишется в лог. Значения аргументов не логируем - среди них
+// шифротексты ключей и коды лицензий, только их число.
+
+type queryStats struct {
+	mu    sync.Mutex
+	stats map[string]*domain.QueryStat
+}
+
+// observe учитывает запрос, начатый в start. sql.ErrNoRows - не ошибка, а пустой результат.
+func (db *DB) observe(name string, args int, start time.Time, err error) {
+	elapsed := time.Since(start)
+	failed := err != nil && !errors.Is(err, sql.ErrNoRows)
+	slow := elapsed >= db.slowQuery
+
+	db.stats.mu.Lock()
+	st, ok := db.stats.stats[name]
+	if !ok {
+		st = &domain.QueryStat{Name: name}
+		db.stats.stats[name] = st
+	}
+	st.Count++
+	st.Total += elapsed
+	st.Max = max(st.Max, elapsed)
+	if failed {
+		st.Errors++
+	}
+	if slow {
+		st.Slow++
+	}
+	db.stats.mu.Unlock()
+
+	if slow {
+		attrs := []any{"query", name, "duration_ms", elapsed.Milliseconds(), "args", args}
+		if failed {
+			attrs = append(attrs, "err", err)
+		}
+		db.logger.Warn("Slow database query", attrs...)
+	}
+}
+
+// QueryStats - счетчики запросов с момента запуска, самые затратные по суммарному времени первыми
+func (db *DB) QueryStats() []domain.QueryStat {
+	db.stats.mu.Lock()
+	result := make([]domain.QueryStat, 0, len(db.stats.stats))
+	for _, st := range db.stats.stats {
+		result = append(result, *st)
+	}
+	db.stats.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Total > result[j].Total })
+	return result
+}
+
+// Имена функций по PC: FuncForPC не бесплатен, а вызывающих мест немного
+var queryNames sync.Map
+
+// callerName - имя функции, вызвавшей метод DB/Tx (skip - глубина от callerName до нее)
+func callerName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	if name, ok := queryNames.Load(pc); ok {
+		return name.(string)
+	}
+
+	name := "unknown"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		// .../database.(*TaskRepository).GetActiveTasks -> TaskRepository.GetActiveTasks
+		name = fn.Name()
+		name = name[strings.LastIndex(name, "/")+1:]
+		if _, after, ok := strings.Cut(name, "."); ok {
+			name = after
+		}
+		name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	}
+	queryNames.Store(pc, name)
+	return name
+}
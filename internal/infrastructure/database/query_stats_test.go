@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowQueryLogged(t *testing.T) {
+	const queryTime = 30 * time.Millisecond
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		wantLog   bool
+		wantSlow  int64
+	}{
+		{"above threshold", 5 * time.Millisecond, true, 1},
+		{"below threshold", time.Second, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newSleepDB(t, queryTime)
+			db.slowQuery = tt.threshold
+			var logs lockedBuffer
+			db.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+			if _, err := db.ExecContext(context.Background(), `UPDATE tasks SET status = 'IDLE' WHERE id = $1`, 1); err != nil {
+				t.Fatalf("ExecContext: %v", err)
+			}
+
+			out := logs.String()
+			if logged := strings.Contains(out, "Slow database query"); logged != tt.wantLog {
+				t.Fatalf("slow query logged = %v, want %v:\n%s", logged, tt.wantLog, out)
+			}
+			if tt.wantLog {
+				// Имя метода и число аргументов, но не их значения
+				if !strings.Contains(out, "query=TestSlowQueryLogged") || !strings.Contains(out, "args=1") || strings.Contains(out, "IDLE") {
+					t.Fatalf("slow query entry:\n%s", out)
+				}
+			}
+
+			stats := db.QueryStats()
+			if len(stats) != 1 || stats[0].Count != 1 {
+				t.Fatalf("stats = %+v, want one query", stats)
+			}
+			if stats[0].Slow != tt.wantSlow {
+				t.Fatalf("slow count = %d, want %d", stats[0].Slow, tt.wantSlow)
+			}
+		})
+	}
+}
@@ -3,8 +3,11 @@ package database
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,37 +16,75 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// GetActiveTasks не отдает задачи забаненных и (если включена проверка) пользователей с истекшей
+// подпиской. Прерванные роллы таких пользователей все равно доводит восстановление (GetTasksByState).
 func (r *TaskRepository) GetActiveTasks(ctx context.Context) ([]domain.Task, error) {
+	subscription := ""
+	if r.enforceSubscriptions {
+		subscription = " AND u.expires_at > NOW()"
+	}
+	query := `
+		SELECT t.id, t.user_id, t.api_key_id, t.target_symbol, t.underlying_symbol, t.current_qty,
+			   t.trigger_price, t.next_strike_step, t.premium_alert_threshold, t.target_side, t.status, t.version, t.last_error,
+			   t.last_triggered_at, t.roll_count, t.label, t.created_at, t.updated_at
+		FROM tasks t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.status IN ($1, $2, $3) AND t.deleted_at IS NULL
+		  AND NOT u.is_banned` + subscription + `
+		ORDER BY t.id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, domain.TaskStateIdle, domain.TaskStateRollInitiated, domain.TaskStateLeg1Closed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active tasks: %w", err)
+	}
+	defer rows.Close()
+
+	return r.collectTasks(rows)
+}
+
+// GetTasksByState возвращает неудаленные задачи в любом из статусов states
+func (r *TaskRepository) GetTasksByState(ctx context.Context, states ...domain.TaskState) ([]domain.Task, error) {
+	if len(states) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(states))
+	for i, state := range states {
+		args[i] = state
+	}
 	query := `
 		SELECT id, user_id, api_key_id, target_symbol, underlying_symbol, current_qty,
-			   trigger_price, next_strike_step, status, version, last_error,
-			   created_at, updated_at
+			   trigger_price, next_strike_step, premium_alert_threshold, target_side, status, version, last_error,
+			   last_triggered_at, roll_count, label, created_at, updated_at
 		FROM tasks
-		WHERE status IN ('IDLE', 'ROLL_INITIATED', 'LEG1_CLOSED')
+		WHERE status IN (` + placeholders(1, len(states)) + `) AND deleted_at IS NULL
+		ORDER BY id
 	`
 
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get active tasks: %w", err)
+		return nil, fmt.Errorf("failed to get tasks by state: %w", err)
 	}
 	defer rows.Close()
 
-	var tasks []domain.Task
-	for rows.Next() {
-		task, err := r.scanRow(rows)
-		if err != nil {
-			return nil, err
-		}
-		tasks = append(tasks, *task)
+	return r.collectTasks(rows)
+}
+
+// placeholders - "$from, $from+1, ..." для n параметров: значения всегда идут аргументами запроса
+func placeholders(from, n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = "$" + strconv.Itoa(from+i)
 	}
-	return tasks, nil
+	return strings.Join(parts, ", ")
 }
 
 func (r *APIKeyRepository) GetActiveByUserID(ctx context.Context, userID int64) (*domain.APIKey, error) {
 	query := `
-		SELECT id, user_id, key_enc, secret_enc, label, is_valid, created_at
+		SELECT id, user_id, key_enc, secret_enc, key_version, label, is_valid, COALESCE(is_testnet, FALSE), is_demo, expires_at, account_uid, created_at
 		FROM api_keys
-		WHERE user_id = $1 AND is_valid = TRUE
+		WHERE user_id = $1 AND is_valid = TRUE AND deleted_at IS NULL
 		ORDER BY created_at DESC
 		LIMIT 1
 	`
@@ -51,24 +92,24 @@ func (r *APIKeyRepository) GetActiveByUserID(ctx context.Context, userID int64)
 	row := r.db.QueryRowContext(ctx, query, userID)
 	ak := &domain.APIKey{}
 	var keyEnc, secretEnc string
+	var keyVersion int
+	var expiresAt sql.NullTime
+	var accountUID sql.NullInt64
 
-	err := row.Scan(&ak.ID, &ak.UserID, &keyEnc, &secretEnc, &ak.Label, &ak.IsValid, &ak.CreatedAt)
+	err := row.Scan(&ak.ID, &ak.UserID, &keyEnc, &secretEnc, &keyVersion, &ak.Label, &ak.IsValid, &ak.IsTestnet, &ak.IsDemo, &expiresAt, &accountUID, &ak.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("db scan error: %w", err)
 	}
+	ak.ExpiresAt = expiresAt.Time
+	ak.AccountUID = accountUID.Int64
 
 	// КРИТИЧНО: Обработка ошибок дешифрования
-	ak.Key, err = r.encryptor.Decrypt(keyEnc)
+	ak.Key, ak.Secret, err = r.decrypt(keyVersion, keyEnc, secretEnc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt API Key for user %d: %w", userID, err)
-	}
-
-	ak.Secret, err = r.encryptor.Decrypt(secretEnc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt API Secret for user %d: %w", userID, err)
+		return nil, fmt.Errorf("failed to decrypt API key for user %d: %w", userID, err)
 	}
 
 	return ak, nil
@@ -79,25 +120,26 @@ func (r *APIKeyRepository) GetActiveByUserID(ctx context.Context, userID int64)
 type TaskRepository struct {
 	db     *DB
 	logger *slog.Logger
+	// enforceSubscriptions: GetActiveTasks не отдает задачи пользователей с истекшей подпиской
+	enforceSubscriptions bool
 }
 
-func NewTaskRepository(db *DB, logger *slog.Logger) *TaskRepository {
+func NewTaskRepository(db *DB, logger *slog.Logger, enforceSubscriptions bool) *TaskRepository {
 	return &TaskRepository{
-		db:     db,
-		logger: logger, // Теперь передается явно
+		db:                   db,
+		logger:               logger, // Теперь передается явно
+		enforceSubscriptions: enforceSubscriptions,
 	}
 }
 
-func (r *TaskRepository) RegisterError(ctx context.Context, id int64, err error) error {
+// RegisterError пишет ошибку ролла: временная возвращает задачу в IDLE, остальные - в FAILED.
+// Версия сверяется, как в UpdateTaskState: статус, который с тех пор сменил ролл (например,
+// LEG1_CLOSED после закрытия Leg 1), ошибка не перезапишет.
+func (r *TaskRepository) RegisterError(ctx context.Context, id int64, version int64, err error) error {
 	msg := err.Error()
 
-	isTransient := strings.Contains(msg, "timeout") ||
-		strings.Contains(msg, "deadline exceeded") ||
-		strings.Contains(msg, "502 Bad Gateway") ||
-		strings.Contains(msg, "504 Gateway Timeout")
-
 	var newState domain.TaskState
-	if isTransient {
+	if domain.IsTransient(err) {
 		newState = domain.TaskStateIdle
 		r.logger.Warn("Transient error registered, scheduling retry",
 			slog.Int64("task_id", id),
@@ -111,11 +153,21 @@ func (r *TaskRepository) RegisterError(ctx context.Context, id int64, err error)
 
 	query := `
 		UPDATE tasks
-		SET last_error = $1, status = $2, updated_at = NOW()
-		WHERE id = $3
+		SET last_error = $1, status = $2, version = version + 1, updated_at = NOW()
+		WHERE id = $3 AND version = $4 AND deleted_at IS NULL
 	`
-	_, dbErr := r.db.ExecContext(ctx, query, msg, newState, id)
-	return dbErr
+	result, dbErr := r.db.ExecContext(ctx, query, msg, newState, id, version)
+	if dbErr != nil {
+		return fmt.Errorf("failed to register task error: %w", dbErr)
+	}
+	rows, dbErr := result.RowsAffected()
+	if dbErr != nil {
+		return dbErr
+	}
+	if rows == 0 {
+		return fmt.Errorf("optimistic locking failed on error registration: task %d: %w", id, domain.ErrVersionConflict)
+	}
+	return nil
 }
 
 // CreateTask создает задачу. Version по дефолту = 1.
@@ -123,15 +175,16 @@ func (r *TaskRepository) CreateTask(ctx context.Context, task *domain.Task) erro
 	query := `
 		INSERT INTO tasks (
 			user_id, api_key_id, target_symbol, underlying_symbol, current_qty,
-			trigger_price, next_strike_step, status, version, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 1, NOW(), NOW())
+			trigger_price, next_strike_step, premium_alert_threshold, target_side, status, label, version, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 1, NOW(), NOW())
 		RETURNING id
 	`
 
 	err := r.db.QueryRowContext(
 		ctx, query,
 		task.UserID, task.APIKeyID, task.CurrentOptionSymbol, task.UnderlyingSymbol, task.CurrentQty,
-		task.TriggerPrice, task.NextStrikeStep, task.Status,
+		task.TriggerPrice, task.NextStrikeStep, nullDecimal(task.PremiumAlertThreshold),
+		nullString(string(task.TargetSide)), task.Status, nullString(task.Label),
 	).Scan(&task.ID)
 
 	if err != nil {
@@ -144,40 +197,65 @@ func (r *TaskRepository) CreateTask(ctx context.Context, task *domain.Task) erro
 func (r *TaskRepository) GetTaskByID(ctx context.Context, id int64) (*domain.Task, error) {
 	query := `
 		SELECT id, user_id, api_key_id, target_symbol, underlying_symbol, current_qty,
-			   trigger_price, next_strike_step, status, version, last_error,
-			   created_at, updated_at
+			   trigger_price, next_strike_step, premium_alert_threshold, target_side, status, version, last_error,
+			   last_triggered_at, roll_count, label, created_at, updated_at
 		FROM tasks
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 	return r.scanTask(r.db.QueryRowContext(ctx, query, id))
 }
 
 // GetActiveTasksByUserID возвращает активные задачи конкретного пользователя
 func (r *TaskRepository) GetActiveTasksByUserID(ctx context.Context, userID int64) ([]domain.Task, error) {
+	return r.ListTasks(ctx, userID, domain.TaskFilter{States: domain.ActiveTaskStates}, 0, 0)
+}
+
+// Порядок задается только из этого списка: в ORDER BY нельзя передать параметр
+var taskOrderSQL = map[domain.TaskOrder]string{
+	domain.TaskOrderNewest:  "created_at DESC, id DESC",
+	domain.TaskOrderOldest:  "created_at ASC, id ASC",
+	domain.TaskOrderUpdated: "updated_at DESC, id DESC",
+}
+
+// ListTasks возвращает страницу задач пользователя по фильтру
+func (r *TaskRepository) ListTasks(ctx context.Context, userID int64, filter domain.TaskFilter, limit, offset int) ([]domain.Task, error) {
+	orderBy, ok := taskOrderSQL[filter.Order]
+	if filter.Order == "" {
+		orderBy, ok = taskOrderSQL[domain.TaskOrderNewest], true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown task order %q", filter.Order)
+	}
+
+	args := []any{userID}
+	where := "user_id = $1 AND deleted_at IS NULL"
+	if len(filter.States) > 0 {
+		where += " AND status IN (" + placeholders(len(args)+1, len(filter.States)) + ")"
+		for _, state := range filter.States {
+			args = append(args, state)
+		}
+	}
+	page := ""
+	if limit > 0 {
+		page = fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+		args = append(args, limit, max(offset, 0))
+	}
+
 	query := `
 		SELECT id, user_id, api_key_id, target_symbol, underlying_symbol, current_qty,
-			   trigger_price, next_strike_step, status, version, last_error,
-			   created_at, updated_at
+			   trigger_price, next_strike_step, premium_alert_threshold, target_side, status, version, last_error,
+			   last_triggered_at, roll_count, label, created_at, updated_at
 		FROM tasks
-		WHERE user_id = $1 AND status IN ('IDLE', 'ROLL_INITIATED', 'LEG1_CLOSED', 'LEG2_OPENING')
-		ORDER BY created_at DESC
-	`
+		WHERE ` + where + `
+		ORDER BY ` + orderBy + page
 
-	rows, err := r.db.QueryContext(ctx, query, userID)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user tasks: %w", err)
 	}
 	defer rows.Close()
 
-	var tasks []domain.Task
-	for rows.Next() {
-		task, err := r.scanRow(rows)
-		if err != nil {
-			return nil, err
-		}
-		tasks = append(tasks, *task)
-	}
-	return tasks, nil
+	return r.collectTasks(rows)
 }
 
 func (r *TaskRepository) UpdateTaskState(ctx context.Context, id int64, newState domain.TaskState, version int64) error {
@@ -198,7 +276,7 @@ func (r *TaskRepository) UpdateTaskState(ctx context.Context, id int64, newState
 	}
 	if rows == 0 {
 		// Это важно для обработки гонки данных
-		return fmt.Errorf("optimistic locking failed: task %d modified concurrently", id)
+		return fmt.Errorf("optimistic locking failed: task %d: %w", id, domain.ErrVersionConflict)
 	}
 
 	return nil
@@ -207,7 +285,8 @@ func (r *TaskRepository) UpdateTaskState(ctx context.Context, id int64, newState
 func (r *TaskRepository) UpdateTaskSymbol(ctx context.Context, id int64, newSymbol string, newQty decimal.Decimal, version int64) error {
 	query := `
 		UPDATE tasks
-		SET target_symbol = $1, current_qty = $2, status = 'IDLE', version = version + 1, updated_at = NOW()
+		SET target_symbol = $1, current_qty = $2, status = 'IDLE', version = version + 1,
+			roll_count = roll_count + 1, last_triggered_at = NOW(), updated_at = NOW()
 		WHERE id = $3 AND version = $4
 	`
 
@@ -218,9 +297,215 @@ func (r *TaskRepository) UpdateTaskSymbol(ctx context.Context, id int64, newSymb
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("optimistic locking failed on symbol update: task %d", id)
+		return fmt.Errorf("optimistic locking failed on symbol update: task %d: %w", id, domain.ErrVersionConflict)
+	}
+
+	return nil
+}
+
+func (r *TaskRepository) FinalizeRoll(ctx context.Context, p domain.FinalizeRollParams) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin finalize roll tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE tasks
+		SET target_symbol = $1, current_qty = $2, status = 'IDLE', version = version + 1,
+			roll_count = roll_count + 1, last_triggered_at = NOW(), updated_at = NOW()
+		WHERE id = $3 AND version = $4
+	`
+	result, err := tx.ExecContext(ctx, query, p.ToSymbol, p.Qty, p.TaskID, p.Version)
+	if err != nil {
+		return fmt.Errorf("db exec error: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("optimistic locking failed on roll finalize: task %d: %w", p.TaskID, domain.ErrVersionConflict)
+	}
+
+	event := `
+		INSERT INTO task_events (task_id, event_type, from_symbol, to_symbol, qty, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`
+	if _, err := tx.ExecContext(ctx, event, p.TaskID, domain.TaskEventRolled, p.FromSymbol, p.ToSymbol, p.Qty); err != nil {
+		return fmt.Errorf("failed to record roll event: task %d: %w", p.TaskID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit finalize roll tx: %w", err)
+	}
+	return nil
+}
+
+func (r *TaskRepository) UpdateTaskStateWithEvent(ctx context.Context, id int64, newState domain.TaskState, version int64, event domain.TaskEventType, details string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin state tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE tasks
+		SET status = $1, version = version + 1, updated_at = NOW()
+		WHERE id = $2 AND version = $3
+	`
+	result, err := tx.ExecContext(ctx, query, newState, id, version)
+	if err != nil {
+		return fmt.Errorf("db exec error: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("optimistic locking failed: task %d: %w", id, domain.ErrVersionConflict)
 	}
 
+	if err := recordTaskEvents(ctx, tx, []int64{id}, event, details); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit state tx: %w", err)
+	}
+	return nil
+}
+
+// Пауза между попытками WithOptimisticRetry растет линейно: 20мс, 40мс, ...
+const optimisticRetryBackoff = 20 * time.Millisecond
+
+func (r *TaskRepository) WithOptimisticRetry(ctx context.Context, taskID int64, attempts int, fn func(task *domain.Task) error) error {
+	for attempt := 1; ; attempt++ {
+		task, err := r.GetTaskByID(ctx, taskID)
+		if err != nil {
+			return err
+		}
+		if task == nil {
+			return domain.ErrTaskNotFound
+		}
+
+		err = fn(task)
+		if !errors.Is(err, domain.ErrVersionConflict) || attempt >= attempts {
+			return err
+		}
+		r.logger.Debug("Optimistic lock conflict, retrying", "task_id", taskID, "attempt", attempt)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * optimisticRetryBackoff):
+		}
+	}
+}
+
+// Как часто WithTaskLock проверяет соединение, на котором держит лок
+const taskLockCheckInterval = 5 * time.Second
+
+// WithTaskLock берет advisory-лок Postgres по ID задачи: роллы одной задачи не пересекаются и
+// между экземплярами бота. Лок сессионный, на отдельном соединении пула, и снимается явно;
+// если упал процесс, его снимет Postgres вместе с сессией. Соединение проверяется каждые
+// taskLockCheckInterval: оборвалось оно - лок уже не наш, и ctx fn отменяется с ErrTaskLockLost.
+// Так же fn прерывается, если держит лок дольше TaskLockMaxHold. Запросы fn идут мимо этого соединения.
+func (r *TaskRepository) WithTaskLock(ctx context.Context, id int64, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, r.db.lockMaxHold,
+		fmt.Errorf("%w: task %d held longer than %s", domain.ErrTaskLockLost, id, r.db.lockMaxHold))
+	defer cancel()
+
+	if r.db.driver == DriverSQLite {
+		return r.db.taskLocks.with(ctx, id, fn)
+	}
+
+	conn, err := r.db.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get task lock connection: %w", err)
+	}
+	defer conn.Close()
+
+	lockCtx, cancelLock := context.WithTimeout(ctx, r.db.readTimeout)
+	defer cancelLock()
+	// Соединение лока идет мимо обертки DB, поэтому запрос лока учитываем в статистике вручную
+	timing := r.db.startQuery("TaskRepository.WithTaskLock", []any{id})
+	var locked bool
+	err = conn.QueryRowContext(lockCtx, `SELECT pg_try_advisory_lock($1)`, id).Scan(&locked)
+	timing.done(err)
+	if err != nil {
+		return fmt.Errorf("failed to lock task %d: %w", id, err)
+	}
+	if !locked {
+		return domain.ErrTaskLocked
+	}
+	defer r.unlockTask(ctx, conn, id)
+
+	fnCtx, lost := context.WithCancelCause(ctx)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		r.watchTaskLock(fnCtx, conn, id, lost)
+	}()
+
+	err = fn(fnCtx)
+	lost(nil)
+	<-watched
+	return err
+}
+
+// watchTaskLock пингует соединение лока, пока ctx жив; не ответило - отменяет ctx с ErrTaskLockLost
+func (r *TaskRepository) watchTaskLock(ctx context.Context, conn *sql.Conn, id int64, lost context.CancelCauseFunc) {
+	ticker := time.NewTicker(taskLockCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, r.db.readTimeout)
+		err := conn.PingContext(pingCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("Task lock connection lost, aborting roll", "task_id", id, "err", err)
+			lost(fmt.Errorf("%w: task %d: %v", domain.ErrTaskLockLost, id, err))
+			return
+		}
+	}
+}
+
+// unlockTask снимает лок. Не вышло - соединение выбрасываем, а не возвращаем в пул:
+// сессия с нашим локом блокировала бы задачу до перезапуска бота
+func (r *TaskRepository) unlockTask(ctx context.Context, conn *sql.Conn, id int64) {
+	unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.db.writeTimeout)
+	defer cancel()
+	if _, err := conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock($1)`, id); err != nil {
+		r.logger.Warn("Failed to release task lock, dropping its connection", "task_id", id, "err", err)
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+}
+
+// UpdatePremiumAlert задает порог алерта по премии; ноль выключает алерт
+func (r *TaskRepository) UpdatePremiumAlert(ctx context.Context, id int64, threshold decimal.Decimal) error {
+	query := `
+		UPDATE tasks
+		SET premium_alert_threshold = $1, updated_at = NOW()
+		WHERE id = $2 AND deleted_at IS NULL
+	`
+	if _, err := r.db.ExecContext(ctx, query, nullDecimal(threshold), id); err != nil {
+		return fmt.Errorf("failed to update premium alert: %w", err)
+	}
+	return nil
+}
+
+// UpdateTaskLabel меняет название задачи пользователя; пустая строка убирает его.
+// Версию не трогаем: название не влияет на ролл.
+func (r *TaskRepository) UpdateTaskLabel(ctx context.Context, id, userID int64, label string) error {
+	query := `
+		UPDATE tasks
+		SET label = $1, updated_at = NOW()
+		WHERE id = $2 AND user_id = $3 AND deleted_at IS NULL
+	`
+	res, err := r.db.ExecContext(ctx, query, nullString(label), id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update task label: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return domain.ErrTaskNotFound
+	}
 	return nil
 }
 
@@ -228,84 +513,506 @@ func (r *TaskRepository) SaveError(ctx context.Context, id int64, errMessage str
 	query := `
 		UPDATE tasks
 		SET last_error = $1, status = 'FAILED', updated_at = NOW()
-		WHERE id = $2
+		WHERE id = $2 AND deleted_at IS NULL
 	`
 	_, err := r.db.ExecContext(ctx, query, errMessage, id)
 	return err
 }
 
-// Helpers
+// PauseTask: ставить на паузу можно только ожидающую триггер задачу, посреди ролла - нет
+func (r *TaskRepository) PauseTask(ctx context.Context, id int64, version int64) error {
+	return r.switchState(ctx, id, version, domain.TaskStateIdle, domain.TaskStatePaused, "")
+}
 
-func (r *TaskRepository) scanTask(row *sql.Row) (*domain.Task, error) {
-	task := &domain.Task{}
-	var lastError sql.NullString
+// ResumeTask сбрасывает и причину паузы (например, выключенный ключ)
+func (r *TaskRepository) ResumeTask(ctx context.Context, id int64, version int64) error {
+	return r.switchState(ctx, id, version, domain.TaskStatePaused, domain.TaskStateIdle, ", last_error = NULL")
+}
 
-	err := row.Scan(
-		&task.ID, &task.UserID, &task.APIKeyID, &task.CurrentOptionSymbol, &task.UnderlyingSymbol,
-		&task.CurrentQty, &task.TriggerPrice, &task.NextStrikeStep, &task.Status, &task.Version,
-		&lastError, &task.CreatedAt, &task.UpdatedAt,
-	)
+// switchState переводит задачу from -> to с проверкой версии; extraSet - дополнительные поля SET
+func (r *TaskRepository) switchState(ctx context.Context, id int64, version int64, from, to domain.TaskState, extraSet string) error {
+	query := `
+		UPDATE tasks
+		SET status = $1, version = version + 1, updated_at = NOW()` + extraSet + `
+		WHERE id = $2 AND version = $3 AND status = $4 AND deleted_at IS NULL
+	`
+
+	result, err := r.db.ExecContext(ctx, query, to, id, version, from)
+	if err != nil {
+		return fmt.Errorf("db exec error: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("optimistic locking failed: task %d is not %s: %w", id, from, domain.ErrVersionConflict)
+	}
+	return nil
+}
+
+// Причина паузы задач пользователя, подписка которого закончилась
+const subscriptionExpiredReason = "subscription expired"
+
+// PauseTasksOfExpiredUsers ставит на паузу IDLE задачи пользователей с истекшей подпиской тем же
+// путем, что BulkPauseByUser. Пользователи блокируются до паузы: продление подписки между выборкой
+// и паузой иначе оставило бы на паузе задачи уже оплатившего пользователя.
+func (r *TaskRepository) PauseTasksOfExpiredUsers(ctx context.Context) ([]domain.Task, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin pause tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id FROM users
+		WHERE expires_at <= NOW()
+		  AND id IN (SELECT user_id FROM tasks WHERE status = 'IDLE' AND deleted_at IS NULL)
+		ORDER BY id FOR UPDATE
+	`
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select expired users: %w", err)
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan row error: %w", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	paused, err := bulkPauseByUser(ctx, tx, userIDs, subscriptionExpiredReason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pause tasks of expired users: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit pause tx: %w", err)
+	}
+	return paused, nil
+}
+
+// BulkPauseByUser ставит на паузу IDLE задачи пользователей одним UPDATE и пишет события паузы
+// в той же транзакции. Начатые роллы не трогает.
+func (r *TaskRepository) BulkPauseByUser(ctx context.Context, userIDs []int64, reason string) ([]domain.Task, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin pause tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	paused, err := bulkPauseByUser(ctx, tx, userIDs, reason)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit pause tx: %w", err)
+	}
+	return paused, nil
+}
+
+// bulkPauseByUser - BulkPauseByUser внутри чужой транзакции: бан и истечение подписки ставят
+// задачи на паузу вместе со своими изменениями
+func bulkPauseByUser(ctx context.Context, tx *Tx, userIDs []int64, reason string) ([]domain.Task, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	paused, err := pauseIdleTasks(ctx, tx, reason, tx.db.inIDs("user_id", 2), tx.db.idList(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to pause tasks of %d users: %w", len(userIDs), err)
+	}
+	return paused, nil
+}
+
+// BulkComplete завершает задачи из ids, все еще стоящие в статусе from, одним UPDATE и пишет события
+// завершения в той же транзакции. Задачи, ушедшие из from (ролл, пауза, возобновление, удаление),
+// пропускает; возвращает ID завершенных.
+func (r *TaskRepository) BulkComplete(ctx context.Context, ids []int64, from domain.TaskState, reason string) ([]int64, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin complete tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE tasks
+		SET status = 'COMPLETED', version = version + 1, updated_at = NOW()
+		WHERE ` + r.db.inIDs("id", 1) + ` AND status = $2 AND deleted_at IS NULL
+		RETURNING id
+	`
+	rows, err := tx.QueryContext(ctx, query, r.db.idList(ids), from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete %d tasks: %w", len(ids), err)
+	}
+	completed, err := collectIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordTaskEvents(ctx, tx, completed, domain.TaskEventCompleted, reason); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit complete tx: %w", err)
+	}
+	return completed, nil
+}
+
+// pauseIdleTasks ставит на паузу IDLE задачи, выбранные условием cond над tasks ($1 - причина,
+// args начинаются с $2), и пишет события паузы. Вызывается внутри транзакции.
+// Без псевдонима таблицы: SQLite не понимает его в RETURNING.
+func pauseIdleTasks(ctx context.Context, tx *Tx, reason, cond string, args ...any) ([]domain.Task, error) {
+	query := `
+		UPDATE tasks
+		SET status = 'PAUSED', last_error = $1, version = version + 1, updated_at = NOW()
+		WHERE status = 'IDLE' AND deleted_at IS NULL AND ` + cond + `
+		RETURNING id, user_id, api_key_id, target_symbol, label
+	`
+	rows, err := tx.QueryContext(ctx, query, append([]any{reason}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paused []domain.Task
+	var ids []int64
+	for rows.Next() {
+		task := domain.Task{Status: domain.TaskStatePaused, LastError: reason}
+		var label sql.NullString
+		if err := rows.Scan(&task.ID, &task.UserID, &task.APIKeyID, &task.CurrentOptionSymbol, &label); err != nil {
+			return nil, fmt.Errorf("scan row error: %w", err)
+		}
+		task.Label = label.String
+		paused = append(paused, task)
+		ids = append(ids, task.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := recordTaskEvents(ctx, tx, ids, domain.TaskEventPaused, reason); err != nil {
+		return nil, err
+	}
+	return paused, nil
+}
+
+// recordTaskEvents пишет одно событие на каждую задачу из ids одним INSERT; символ и объем берет из задачи
+func recordTaskEvents(ctx context.Context, tx *Tx, ids []int64, eventType domain.TaskEventType, details string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO task_events (task_id, event_type, from_symbol, qty, details, created_at)
+		SELECT id, $2, target_symbol, current_qty, $3, NOW()
+		FROM tasks
+		WHERE ` + tx.db.inIDs("id", 1) + `
+	`
+	if _, err := tx.ExecContext(ctx, query, tx.db.idList(ids), eventType, nullString(details)); err != nil {
+		return fmt.Errorf("failed to record %s events for %d tasks: %w", eventType, len(ids), err)
+	}
+	return nil
+}
+
+func collectIDs(rows *Rows) ([]int64, error) {
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan row error: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetTasksByAPIKeyID - неудаленные задачи ключа в любом статусе
+func (r *TaskRepository) GetTasksByAPIKeyID(ctx context.Context, apiKeyID int64) ([]domain.Task, error) {
+	query := `
+		SELECT id, user_id, api_key_id, target_symbol, underlying_symbol, current_qty,
+			   trigger_price, next_strike_step, premium_alert_threshold, target_side, status, version, last_error,
+			   last_triggered_at, roll_count, label, created_at, updated_at
+		FROM tasks
+		WHERE api_key_id = $1 AND deleted_at IS NULL
+		ORDER BY id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, apiKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tasks of api key %d: %w", apiKeyID, err)
+	}
+	defer rows.Close()
+
+	return r.collectTasks(rows)
+}
+
+func (r *TaskRepository) ListTaskEvents(ctx context.Context, taskID int64, limit int) ([]domain.TaskEvent, error) {
+	query := `
+		SELECT id, task_id, event_type, from_symbol, to_symbol, qty, details, created_at
+		FROM task_events
+		WHERE task_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, taskID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events of task %d: %w", taskID, err)
+	}
+	defer rows.Close()
+
+	var events []domain.TaskEvent
+	for rows.Next() {
+		var e domain.TaskEvent
+		var fromSymbol, toSymbol, details sql.NullString
+		var qty decimal.NullDecimal
+		if err := rows.Scan(&e.ID, &e.TaskID, &e.Type, &fromSymbol, &toSymbol, &qty, &details, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		e.FromSymbol, e.ToSymbol, e.Details = fromSymbol.String, toSymbol.String, details.String
+		e.Qty = qty.Decimal
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ReassignTasksToKey переводит задачи в IDLE и PAUSED со старого ключа на новый. Ключи должны
+// принадлежать одному пользователю, а что это один аккаунт Bybit, проверяет вызывающий. Задачи
+// посреди ролла остаются на старом ключе: восстановление должно смотреть позицию того аккаунта,
+// где ушел Leg 1. Версия растет, чтобы ролл, прочитавший задачу до перевода, не перезаписал ее.
+func (r *TaskRepository) ReassignTasksToKey(ctx context.Context, oldKeyID, newKeyID int64) (int64, error) {
+	query := `
+		UPDATE tasks
+		SET api_key_id = $2, version = version + 1, updated_at = NOW()
+		WHERE api_key_id = $1 AND deleted_at IS NULL AND status IN ('IDLE', 'PAUSED')
+		  AND user_id = (SELECT user_id FROM api_keys WHERE id = $2)
+	`
+
+	res, err := r.db.ExecContext(ctx, query, oldKeyID, newKeyID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reassign tasks to key %d: %w", newKeyID, err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteTask помечает задачу удаленной. Версия растет, чтобы ролл, успевший прочитать задачу
+// до удаления, не перезаписал ее своим UpdateTaskState.
+func (r *TaskRepository) DeleteTask(ctx context.Context, id int64, userID int64) error {
+	query := `
+		UPDATE tasks
+		SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		  AND status NOT IN ('ROLL_INITIATED', 'LEG1_CLOSED', 'LEG2_OPENING')
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	// Ничего не удалили: выясняем причину для пользователя
+	task, err := r.GetTaskByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if task == nil || task.UserID != userID {
+		return domain.ErrTaskNotFound
+	}
+	return fmt.Errorf("task %d is %s: %w", id, task.Status, domain.ErrTaskMidRoll)
+}
+
+// UpdateTaskParams меняет триггер и шаг. Посреди ролла правка запрещена: роллер уже выбрал
+// следующий страйк по старому шагу.
+func (r *TaskRepository) UpdateTaskParams(ctx context.Context, id int64, trigger, step decimal.Decimal, version int64) error {
+	query := `
+		UPDATE tasks
+		SET trigger_price = $1, next_strike_step = $2, version = version + 1, updated_at = NOW()
+		WHERE id = $3 AND version = $4 AND status IN ('IDLE', 'PAUSED') AND deleted_at IS NULL
+	`
+
+	result, err := r.db.ExecContext(ctx, query, trigger, step, id, version)
+	if err != nil {
+		return fmt.Errorf("failed to update task params: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	task, err := r.GetTaskByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case task == nil:
+		return domain.ErrTaskNotFound
+	case task.IsMidRoll():
+		return fmt.Errorf("task %d is %s: %w", id, task.Status, domain.ErrTaskMidRoll)
+	default:
+		return fmt.Errorf("optimistic locking failed on params update: task %d: %w", id, domain.ErrVersionConflict)
+	}
+}
+
+// Helpers
+
+func (r *TaskRepository) scanTask(row *Row) (*domain.Task, error) {
+	task, err := scanTaskFields(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("scan error: %w", err)
 	}
-	if lastError.Valid {
-		task.LastError = lastError.String
+	return task, nil
+}
+
+func (r *TaskRepository) scanRow(rows *Rows) (*domain.Task, error) {
+	task, err := scanTaskFields(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan row error: %w", err)
 	}
 	return task, nil
 }
 
-func (r *TaskRepository) scanRow(rows *sql.Rows) (*domain.Task, error) {
+// collectTasks читает задачи списка. Строку, которая не читается, пропускаем с ошибкой в логе:
+// одна битая legacy-запись не должна прятать от Manager все остальные задачи.
+func (r *TaskRepository) collectTasks(rows *Rows) ([]domain.Task, error) {
+	var tasks []domain.Task
+	for rows.Next() {
+		task, err := r.scanRow(rows)
+		if err != nil {
+			r.logger.Error("Skipping unreadable task row", "err", err)
+			continue
+		}
+		tasks = append(tasks, *task)
+	}
+	return tasks, rows.Err()
+}
+
+func scanTaskFields(row interface{ Scan(dest ...any) error }) (*domain.Task, error) {
 	task := &domain.Task{}
 	var lastError sql.NullString
+	var premiumAlert decimal.NullDecimal
+	var targetSide sql.NullString
+	var underlying sql.NullString
+	var lastTriggered sql.NullTime
+	var label sql.NullString
 
-	err := rows.Scan(
-		&task.ID, &task.UserID, &task.APIKeyID, &task.CurrentOptionSymbol, &task.UnderlyingSymbol,
-		&task.CurrentQty, &task.TriggerPrice, &task.NextStrikeStep, &task.Status, &task.Version,
-		&lastError, &task.CreatedAt, &task.UpdatedAt,
+	err := row.Scan(
+		&task.ID, &task.UserID, &task.APIKeyID, &task.CurrentOptionSymbol, &underlying,
+		&task.CurrentQty, &task.TriggerPrice, &task.NextStrikeStep, &premiumAlert, &targetSide, &task.Status, &task.Version,
+		&lastError, &lastTriggered, &task.RollCount, &label, &task.CreatedAt, &task.UpdatedAt,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("scan row error: %w", err)
+		return nil, err
 	}
 	if lastError.Valid {
 		task.LastError = lastError.String
 	}
+	task.PremiumAlertThreshold = premiumAlert.Decimal
+	task.TargetSide = domain.Side(targetSide.String)
+	task.Label = label.String
+	if lastTriggered.Valid {
+		task.LastTriggeredAt = &lastTriggered.Time
+	}
+
+	// Старые задачи создавались без underlying_symbol: выводим его из символа опциона
+	task.UnderlyingSymbol = underlying.String
+	if task.UnderlyingSymbol == "" {
+		if sym, err := domain.ParseOptionSymbol(task.CurrentOptionSymbol); err == nil {
+			task.UnderlyingSymbol = sym.Underlying()
+		}
+	}
 	return task, nil
 }
 
+// nullDecimal: ноль пишем как NULL
+func nullDecimal(d decimal.Decimal) decimal.NullDecimal {
+	return decimal.NullDecimal{Decimal: d, Valid: !d.IsZero()}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 // ---------------- API Key & User Repositories ----------------
 
 type APIKeyRepository struct {
-	db        *DB
-	encryptor *crypto.Encryptor
+	db      *DB
+	keyring *crypto.Keyring
 }
 
-func NewAPIKeyRepository(db *DB, encryptor *crypto.Encryptor) *APIKeyRepository {
-	return &APIKeyRepository{db: db, encryptor: encryptor}
+func NewAPIKeyRepository(db *DB, keyring *crypto.Keyring) *APIKeyRepository {
+	return &APIKeyRepository{db: db, keyring: keyring}
 }
 
-func (r *APIKeyRepository) Create(ctx context.Context, apiKey *domain.APIKey) error {
-	keyEnc, err := r.encryptor.Encrypt(apiKey.Key)
-	if err != nil {
-		return fmt.Errorf("failed to encrypt key: %w", err)
+// encrypt шифрует пару текущим ключом и возвращает его версию для колонки key_version
+func (r *APIKeyRepository) encrypt(key, secret string) (keyEnc, secretEnc string, version int, err error) {
+	version, enc := r.keyring.Current()
+	if keyEnc, err = enc.Encrypt(key); err != nil {
+		return "", "", 0, fmt.Errorf("failed to encrypt key: %w", err)
+	}
+	if secretEnc, err = enc.Encrypt(secret); err != nil {
+		return "", "", 0, fmt.Errorf("failed to encrypt secret: %w", err)
 	}
+	return keyEnc, secretEnc, version, nil
+}
 
-	secretEnc, err := r.encryptor.Encrypt(apiKey.Secret)
+// decrypt расшифровывает пару ключом ее версии; версии нет в keyring - domain.ErrUnknownKeyVersion
+func (r *APIKeyRepository) decrypt(version int, keyEnc, secretEnc string) (key, secret string, err error) {
+	enc, ok := r.keyring.Get(version)
+	if !ok {
+		return "", "", fmt.Errorf("key version %d: %w", version, domain.ErrUnknownKeyVersion)
+	}
+	if key, err = enc.Decrypt(keyEnc); err != nil {
+		return "", "", fmt.Errorf("failed to decrypt key: %w", err)
+	}
+	if secret, err = enc.Decrypt(secretEnc); err != nil {
+		return "", "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return key, secret, nil
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, apiKey *domain.APIKey) error {
+	keyEnc, secretEnc, keyVersion, err := r.encrypt(apiKey.Key, apiKey.Secret)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt secret: %w", err)
+		return err
 	}
 
 	query := `
-		INSERT INTO api_keys (user_id, key_enc, secret_enc, label, is_valid, created_at)
-		VALUES ($1, $2, $3, $4, $5, NOW())
+		INSERT INTO api_keys (user_id, key_enc, secret_enc, key_version, label, is_valid, is_testnet, is_demo, expires_at, account_uid, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
 		RETURNING id
 	`
 
 	err = r.db.QueryRowContext(
 		ctx, query,
-		apiKey.UserID, keyEnc, secretEnc, apiKey.Label, apiKey.IsValid,
+		apiKey.UserID, keyEnc, secretEnc, keyVersion, apiKey.Label, apiKey.IsValid, apiKey.IsTestnet, apiKey.IsDemo, nullTime(apiKey.ExpiresAt),
+		nullInt64(apiKey.AccountUID),
 	).Scan(&apiKey.ID)
 
 	if err != nil {
@@ -317,7 +1024,7 @@ func (r *APIKeyRepository) Create(ctx context.Context, apiKey *domain.APIKey) er
 
 func (r *APIKeyRepository) GetByID(ctx context.Context, id int64) (*domain.APIKey, error) {
 	query := `
-		SELECT id, user_id, key_enc, secret_enc, label, is_valid, created_at
+		SELECT id, user_id, key_enc, secret_enc, key_version, label, is_valid, COALESCE(is_testnet, FALSE), is_demo, expires_at, account_uid, created_at
 		FROM api_keys
 		WHERE id = $1
 	`
@@ -326,9 +1033,12 @@ func (r *APIKeyRepository) GetByID(ctx context.Context, id int64) (*domain.APIKe
 
 	ak := &domain.APIKey{}
 	var keyEnc, secretEnc string
+	var keyVersion int
+	var expiresAt sql.NullTime
+	var accountUID sql.NullInt64
 
 	err := row.Scan(
-		&ak.ID, &ak.UserID, &keyEnc, &secretEnc, &ak.Label, &ak.IsValid, &ak.CreatedAt,
+		&ak.ID, &ak.UserID, &keyEnc, &secretEnc, &keyVersion, &ak.Label, &ak.IsValid, &ak.IsTestnet, &ak.IsDemo, &expiresAt, &accountUID, &ak.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -336,15 +1046,12 @@ func (r *APIKeyRepository) GetByID(ctx context.Context, id int64) (*domain.APIKe
 	if err != nil {
 		return nil, fmt.Errorf("failed to get api key: %w", err)
 	}
+	ak.ExpiresAt = expiresAt.Time
+	ak.AccountUID = accountUID.Int64
 
-	ak.Key, err = r.encryptor.Decrypt(keyEnc)
+	ak.Key, ak.Secret, err = r.decrypt(keyVersion, keyEnc, secretEnc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt key: %w", err)
-	}
-
-	ak.Secret, err = r.encryptor.Decrypt(secretEnc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+		return nil, fmt.Errorf("failed to decrypt api key %d: %w", id, err)
 	}
 
 	return ak, nil
@@ -352,9 +1059,9 @@ func (r *APIKeyRepository) GetByID(ctx context.Context, id int64) (*domain.APIKe
 
 func (r *APIKeyRepository) GetByUserID(ctx context.Context, userID int64) ([]domain.APIKey, error) {
 	query := `
-		SELECT id, user_id, key_enc, secret_enc, label, is_valid, created_at
+		SELECT id, user_id, key_enc, secret_enc, key_version, label, is_valid, COALESCE(is_testnet, FALSE), is_demo, expires_at, account_uid, created_at
 		FROM api_keys
-		WHERE user_id = $1
+		WHERE user_id = $1 AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`
 
@@ -368,31 +1075,135 @@ func (r *APIKeyRepository) GetByUserID(ctx context.Context, userID int64) ([]dom
 	for rows.Next() {
 		ak := &domain.APIKey{}
 		var keyEnc, secretEnc string
+		var keyVersion int
+		var expiresAt sql.NullTime
+		var accountUID sql.NullInt64
 
 		err := rows.Scan(
-			&ak.ID, &ak.UserID, &keyEnc, &secretEnc, &ak.Label, &ak.IsValid, &ak.CreatedAt,
+			&ak.ID, &ak.UserID, &keyEnc, &secretEnc, &keyVersion, &ak.Label, &ak.IsValid, &ak.IsTestnet, &ak.IsDemo, &expiresAt, &accountUID, &ak.CreatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan api key: %w", err)
 		}
-
-		ak.Key, _ = r.encryptor.Decrypt(keyEnc)
-		ak.Secret, _ = r.encryptor.Decrypt(secretEnc)
+		ak.ExpiresAt = expiresAt.Time
+		ak.AccountUID = accountUID.Int64
+
+		// Одна битая запись (например, зашифрованная другим ключом) не должна прятать весь список
+		var decErr error
+		ak.Key, ak.Secret, decErr = r.decrypt(keyVersion, keyEnc, secretEnc)
+		if decErr != nil {
+			ak.Key, ak.Secret = "", ""
+			ak.Undecryptable = true
+		}
 
 		keys = append(keys, *ak)
 	}
 
-	return keys, nil
+	return keys, rows.Err()
+}
+
+// Update перешифровывает новую пару ключ/секрет в той же записи. Ключ прошел проверку на бирже,
+// поэтому запись снова валидна. Пока задача ключа посреди ролла, замена запрещена
+// (domain.ErrTaskMidRoll): ролл должен закончиться тем ключом, которым начат.
+func (r *APIKeyRepository) Update(ctx context.Context, id int64, newKey, newSecret string, expiresAt time.Time, accountUID int64) error {
+	keyEnc, secretEnc, keyVersion, err := r.encrypt(newKey, newSecret)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE api_keys
+		SET key_enc = $1, secret_enc = $2, key_version = $3, expires_at = $4, account_uid = $5, is_valid = TRUE
+		WHERE id = $6
+		  AND NOT EXISTS (
+			SELECT 1 FROM tasks
+			WHERE api_key_id = $6 AND deleted_at IS NULL
+			  AND status IN ('ROLL_INITIATED', 'LEG1_CLOSED', 'LEG2_OPENING')
+		  )
+	`
+	res, err := r.db.ExecContext(ctx, query, keyEnc, secretEnc, keyVersion, nullTime(expiresAt), nullInt64(accountUID), id)
+	if err != nil {
+		return fmt.Errorf("failed to update api key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM api_keys WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check api key %d: %w", id, err)
+	}
+	if !exists {
+		return fmt.Errorf("api key %d not found", id)
+	}
+	return fmt.Errorf("api key %d: %w", id, domain.ErrTaskMidRoll)
+}
+
+// BackfillTestnet проставляет сеть ключам, созданным до появления is_testnet
+func (r *APIKeyRepository) BackfillTestnet(ctx context.Context, testnet bool) (int64, error) {
+	query := `UPDATE api_keys SET is_testnet = $1 WHERE is_testnet IS NULL`
+
+	res, err := r.db.ExecContext(ctx, query, testnet)
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill api key network: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// nullTime: нулевое время пишем как NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullInt64: ноль (значение неизвестно) пишем как NULL
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
 }
 
-func (r *APIKeyRepository) Invalidate(ctx context.Context, id int64) error {
-	query := `UPDATE api_keys SET is_valid = FALSE WHERE id = $1`
+// Invalidate выключает ключ и в той же транзакции ставит на паузу его задачи в IDLE: иначе они
+// срабатывали бы по триггеру и падали на исполнении. Возвращает приостановленные задачи.
+func (r *APIKeyRepository) Invalidate(ctx context.Context, id int64) ([]domain.Task, error) {
+	return r.disable(ctx, id, `UPDATE api_keys SET is_valid = FALSE WHERE id = $1`, "api key invalidated")
+}
+
+// Delete прячет ключ из списков и выключает его, задачи ставит на паузу как Invalidate.
+// Запись остается для задач и журнала ордеров.
+func (r *APIKeyRepository) Delete(ctx context.Context, id int64) ([]domain.Task, error) {
+	return r.disable(ctx, id, `UPDATE api_keys SET is_valid = FALSE, deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, "api key deleted")
+}
+
+// disable выполняет keyQuery и паузу задач ключа одной транзакцией. Задачи посреди ролла не трогаем:
+// пауза стерла бы шаг, с которого ролл нужно довести.
+func (r *APIKeyRepository) disable(ctx context.Context, id int64, keyQuery string, reason string) ([]domain.Task, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin api key tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, keyQuery, id); err != nil {
+		return nil, fmt.Errorf("failed to disable api key %d: %w", id, err)
+	}
 
-	_, err := r.db.ExecContext(ctx, query, id)
+	paused, err := pauseIdleTasks(ctx, tx, reason, "api_key_id = $2", id)
 	if err != nil {
-		return fmt.Errorf("failed to invalidate api key: %w", err)
+		return nil, fmt.Errorf("failed to pause tasks of api key %d: %w", id, err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit api key tx: %w", err)
+	}
+	return paused, nil
+}
+
+func (r *APIKeyRepository) SetLabel(ctx context.Context, id int64, label string) error {
+	query := `UPDATE api_keys SET label = $1 WHERE id = $2 AND deleted_at IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, label, id); err != nil {
+		return fmt.Errorf("failed to relabel api key: %w", err)
+	}
 	return nil
 }
 
@@ -404,20 +1215,25 @@ func NewUserRepository(db *DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
+// GetOrCreate регистрирует пользователя или, если он уже есть, обновляет ему username.
+// Один запрос с ON CONFLICT: повторный /start не создаст вторую строку. В user записывается
+// сохраненная строка, поэтому ExpiresAt и IsBanned существующего пользователя не перетираются.
+// Раз пользователь пишет боту, бот у него не заблокирован: отметка снимается.
+func (r *UserRepository) GetOrCreate(ctx context.Context, user *domain.User) error {
 	query := `
 		INSERT INTO users (telegram_id, username, expires_at, is_banned, created_at)
 		VALUES ($1, $2, $3, $4, NOW())
-		RETURNING id
+		ON CONFLICT (telegram_id) DO UPDATE SET username = EXCLUDED.username, bot_blocked_at = NULL
+		RETURNING id, telegram_id, username, expires_at, is_banned, created_at, bot_blocked_at
 	`
 
 	err := r.db.QueryRowContext(
 		ctx, query,
 		user.TelegramID, user.Username, user.ExpiresAt, user.IsBanned,
-	).Scan(&user.ID)
+	).Scan(&user.ID, &user.TelegramID, &user.Username, &user.ExpiresAt, &user.IsBanned, &user.CreatedAt, &user.BotBlockedAt)
 
 	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+		return fmt.Errorf("failed to get or create user: %w", err)
 	}
 
 	return nil
@@ -425,7 +1241,7 @@ func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
 
 func (r *UserRepository) GetByTelegramID(ctx context.Context, telegramID int64) (*domain.User, error) {
 	query := `
-		SELECT id, telegram_id, username, expires_at, is_banned, created_at
+		SELECT id, telegram_id, username, expires_at, is_banned, created_at, bot_blocked_at
 		FROM users
 		WHERE telegram_id = $1
 	`
@@ -434,7 +1250,7 @@ func (r *UserRepository) GetByTelegramID(ctx context.Context, telegramID int64)
 
 	user := &domain.User{}
 	err := row.Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.ExpiresAt, &user.IsBanned, &user.CreatedAt,
+		&user.ID, &user.TelegramID, &user.Username, &user.ExpiresAt, &user.IsBanned, &user.CreatedAt, &user.BotBlockedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -446,22 +1262,75 @@ func (r *UserRepository) GetByTelegramID(ctx context.Context, telegramID int64)
 	return user, nil
 }
 
-func (r *UserRepository) UpdateSubscription(ctx context.Context, telegramID int64, expiresAt time.Time) error {
-	query := `UPDATE users SET expires_at = $1 WHERE telegram_id = $2`
+// GetByID - пользователь по внутреннему ID (задачи и ключи ссылаются на него)
+func (r *UserRepository) GetByID(ctx context.Context, id int64) (*domain.User, error) {
+	query := `
+		SELECT id, telegram_id, username, expires_at, is_banned, created_at, bot_blocked_at
+		FROM users
+		WHERE id = $1
+	`
 
-	_, err := r.db.ExecContext(ctx, query, expiresAt, telegramID)
+	user := &domain.User{}
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&user.ID, &user.TelegramID, &user.Username, &user.ExpiresAt, &user.IsBanned, &user.CreatedAt, &user.BotBlockedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update subscription: %w", err)
+		return nil, fmt.Errorf("failed to get user %d: %w", id, err)
 	}
+	return user, nil
+}
 
+// MarkBotBlocked: время первой блокировки не перезаписываем
+func (r *UserRepository) MarkBotBlocked(ctx context.Context, id int64) error {
+	query := `UPDATE users SET bot_blocked_at = NOW() WHERE id = $1 AND bot_blocked_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to mark user %d bot blocked: %w", id, err)
+	}
 	return nil
 }
 
+// ExtendSubscription продлевает подписку на days дней под блокировкой строки, как Redeem:
+// параллельные продления и активации кодов не теряют дни друг друга
+func (r *UserRepository) ExtendSubscription(ctx context.Context, telegramID int64, days int) (time.Time, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var current time.Time
+	query := `SELECT id, expires_at FROM users WHERE telegram_id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, telegramID).Scan(&id, &current)
+	if err == sql.ErrNoRows {
+		return time.Time{}, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to lock user %d: %w", telegramID, err)
+	}
+
+	expiresAt, err := domain.ExtendSubscription(current, time.Now(), days)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET expires_at = $1 WHERE id = $2`, expiresAt, id); err != nil {
+		return time.Time{}, fmt.Errorf("failed to update subscription: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
 func (r *UserRepository) IsActive(ctx context.Context, telegramID int64) (bool, error) {
-	query := `SELECT expires_at FROM users WHERE telegram_id = $1`
+	query := `SELECT expires_at, is_banned FROM users WHERE telegram_id = $1`
 
-	var expiresAt time.Time
-	err := r.db.QueryRowContext(ctx, query, telegramID).Scan(&expiresAt)
+	user := domain.User{}
+	err := r.db.QueryRowContext(ctx, query, telegramID).Scan(&user.ExpiresAt, &user.IsBanned)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
@@ -469,5 +1338,108 @@ func (r *UserRepository) IsActive(ctx context.Context, telegramID int64) (bool,
 		return false, fmt.Errorf("failed to check subscription: %w", err)
 	}
 
-	return time.Now().Before(expiresAt), nil
-}
\ No newline at end of file
+	return user.HasAccess(time.Now()), nil
+}
+
+// Причина паузы, которую видит пользователь в /status
+const bannedPauseReason = "user banned"
+
+func (r *UserRepository) SetBanned(ctx context.Context, telegramID int64, banned bool) ([]domain.Task, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin ban tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, `UPDATE users SET is_banned = $1 WHERE telegram_id = $2 RETURNING id`, banned, telegramID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set banned for user %d: %w", telegramID, err)
+	}
+
+	var paused []domain.Task
+	if banned {
+		// Начатые роллы не трогаем: их доводит восстановление, иначе позиция останется наполовину закрытой
+		paused, err = bulkPauseByUser(ctx, tx, []int64{userID}, bannedPauseReason)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pause tasks of user %d: %w", telegramID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit ban tx: %w", err)
+	}
+	return paused, nil
+}
+
+// Поля пользователя и число его активных задач. Подзапрос считается только для строк страницы
+// и идет по idx_tasks_user_id, поэтому не зависит от размера таблицы задач.
+const userSummaryColumns = `
+	u.id, u.telegram_id, COALESCE(u.username, ''), u.expires_at, u.is_banned, u.created_at, u.bot_blocked_at,
+	(SELECT COUNT(*) FROM tasks t
+	 WHERE t.user_id = u.id AND t.deleted_at IS NULL
+	   AND t.status IN ('IDLE', 'ROLL_INITIATED', 'LEG1_CLOSED', 'LEG2_OPENING', 'PAUSED'))
+`
+
+// List - страница пользователей по фильтру админки
+func (r *UserRepository) List(ctx context.Context, filter domain.UserFilter, limit, offset int) ([]domain.UserSummary, error) {
+	var where, orderBy string
+	switch filter {
+	case domain.UserFilterAll, "":
+		where, orderBy = "TRUE", "u.created_at DESC, u.id DESC"
+	case domain.UserFilterActive:
+		where, orderBy = "u.expires_at > NOW() AND NOT u.is_banned", "u.created_at DESC, u.id DESC"
+	case domain.UserFilterExpired:
+		where, orderBy = "u.expires_at <= NOW()", "u.expires_at DESC, u.id DESC"
+	case domain.UserFilterBanned:
+		where, orderBy = "u.is_banned", "u.created_at DESC, u.id DESC"
+	case domain.UserFilterReachable:
+		where, orderBy = "NOT u.is_banned AND u.bot_blocked_at IS NULL", "u.id"
+	default:
+		return nil, fmt.Errorf("unknown user filter %q", filter)
+	}
+
+	query := `SELECT ` + userSummaryColumns + ` FROM users u WHERE ` + where + ` ORDER BY ` + orderBy + ` LIMIT $1 OFFSET $2`
+	return r.querySummaries(ctx, query, limit, max(offset, 0))
+}
+
+func (r *UserRepository) CountActive(ctx context.Context) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM users WHERE expires_at > NOW() AND NOT is_banned`
+	if err := r.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count active users: %w", err)
+	}
+	return count, nil
+}
+
+func (r *UserRepository) GetExpiringWithin(ctx context.Context, d time.Duration) ([]domain.UserSummary, error) {
+	query := `
+		SELECT ` + userSummaryColumns + `
+		FROM users u
+		WHERE u.expires_at > NOW() AND u.expires_at <= $1 AND NOT u.is_banned
+		ORDER BY u.expires_at, u.id
+	`
+	return r.querySummaries(ctx, query, time.Now().Add(d))
+}
+
+func (r *UserRepository) querySummaries(ctx context.Context, query string, args ...any) ([]domain.UserSummary, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []domain.UserSummary
+	for rows.Next() {
+		var u domain.UserSummary
+		err := rows.Scan(&u.ID, &u.TelegramID, &u.Username, &u.ExpiresAt, &u.IsBanned, &u.CreatedAt, &u.BotBlockedAt, &u.ActiveTasks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,610 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/dbtest"
+)
+
+func count(t *testing.T, fx *dbtest.Fixture, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := fx.DB.QueryRowContext(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return n
+}
+
+func TestUserGetOrCreateConcurrent(t *testing.T) {
+	fx := dbtest.NewFixture(t)
+
+	const workers = 10
+	ids := make([]int64, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := &domain.User{TelegramID: 42, Username: fmt.Sprintf("user%d", i)}
+			errs[i] = fx.Users.GetOrCreate(context.Background(), user)
+			ids[i] = user.ID
+		}()
+	}
+	wg.Wait()
+
+	for i := range errs {
+		if errs[i] != nil {
+			t.Fatalf("GetOrCreate #%d: %v", i, errs[i])
+		}
+		if ids[i] != ids[0] {
+			t.Fatalf("GetOrCreate #%d returned user %d, want %d", i, ids[i], ids[0])
+		}
+	}
+	if n := count(t, fx, `SELECT COUNT(*) FROM users WHERE telegram_id = $1`, 42); n != 1 {
+		t.Fatalf("%d user rows, want 1", n)
+	}
+}
+
+func TestGetTasksByStatePlaceholders(t *testing.T) {
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	key := fx.Key(t, user.ID, "key-1")
+
+	states := []domain.TaskState{
+		domain.TaskStateIdle, domain.TaskStateRollInitiated, domain.TaskStateLeg1Closed, domain.TaskStateLeg2Opening,
+		domain.TaskStateCompleted, domain.TaskStateFailed, domain.TaskStatePaused,
+	}
+	for _, state := range states {
+		fx.Task(t, key, "BTC-27DEC24-60000-P", 59000, state)
+	}
+
+	// Плейсхолдеры $1..$n собираются по числу статусов: каждый набор выбирает ровно свои задачи
+	for n := 1; n <= len(states); n++ {
+		tasks, err := fx.Tasks.GetTasksByState(context.Background(), states[:n]...)
+		if err != nil {
+			t.Fatalf("%d states: %v", n, err)
+		}
+		if len(tasks) != n {
+			t.Fatalf("%d states: got %d tasks", n, len(tasks))
+		}
+		for i, task := range tasks {
+			if task.Status != states[i] {
+				t.Fatalf("%d states: task %d has status %s, want %s", n, task.ID, task.Status, states[i])
+			}
+		}
+	}
+
+	if tasks, err := fx.Tasks.GetTasksByState(context.Background()); err != nil || tasks != nil {
+		t.Fatalf("no states: %v, %v", tasks, err)
+	}
+}
+
+func TestFinalizeRollAtomic(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	task := fx.Task(t, fx.Key(t, user.ID, "key-1"), "BTC-27DEC24-60000-P", 59000, domain.TaskStateLeg1Closed)
+	task = fx.Reload(t, task.ID)
+
+	// Сбой записи события после UPDATE задачи: транзакция должна откатить и его
+	if _, err := fx.DB.ExecContext(ctx, `
+		CREATE TRIGGER fail_task_events BEFORE INSERT ON task_events
+		BEGIN SELECT RAISE(ABORT, 'injected failure'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	params := domain.FinalizeRollParams{
+		TaskID:     task.ID,
+		Version:    task.Version,
+		FromSymbol: task.CurrentOptionSymbol,
+		ToSymbol:   "BTC-27DEC24-59000-P",
+		Qty:        task.CurrentQty,
+	}
+	if err := fx.Tasks.FinalizeRoll(ctx, params); err == nil {
+		t.Fatal("FinalizeRoll succeeded despite failed event insert")
+	}
+	got := fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateLeg1Closed || got.CurrentOptionSymbol != task.CurrentOptionSymbol ||
+		got.Version != task.Version || got.RollCount != 0 {
+		t.Fatalf("task changed after rollback: %+v", got)
+	}
+
+	// После сбоя тот же вызов проходит целиком: задача и событие вместе
+	if _, err := fx.DB.ExecContext(ctx, `DROP TRIGGER fail_task_events`); err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	if err := fx.Tasks.FinalizeRoll(ctx, params); err != nil {
+		t.Fatalf("FinalizeRoll: %v", err)
+	}
+	got = fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.CurrentOptionSymbol != params.ToSymbol || got.RollCount != 1 {
+		t.Fatalf("task not finalized: %+v", got)
+	}
+	if n := count(t, fx, `SELECT COUNT(*) FROM task_events WHERE task_id = $1 AND event_type = $2`, task.ID, domain.TaskEventRolled); n != 1 {
+		t.Fatalf("%d roll events, want 1", n)
+	}
+}
+
+func TestBulkCompleteReturnsCompleted(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	key := fx.Key(t, user.ID, "key-1")
+
+	idle := fx.Task(t, key, "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+	paused := fx.Task(t, key, "BTC-27DEC24-59000-P", 58000, domain.TaskStatePaused)
+	rolling := fx.Task(t, key, "BTC-27DEC24-58000-P", 57000, domain.TaskStateRollInitiated)
+
+	completed, err := fx.Tasks.BulkComplete(ctx, []int64{idle.ID, paused.ID, rolling.ID}, domain.TaskStateIdle, "option expired")
+	if err != nil {
+		t.Fatalf("BulkComplete: %v", err)
+	}
+	if !slices.Equal(completed, []int64{idle.ID}) {
+		t.Fatalf("completed %v, want only IDLE task %d", completed, idle.ID)
+	}
+	if got := fx.Reload(t, paused.ID); got.Status != domain.TaskStatePaused {
+		t.Fatalf("paused task became %s", got.Status)
+	}
+	if n := count(t, fx, `SELECT COUNT(*) FROM task_events WHERE event_type = $1`, domain.TaskEventCompleted); n != 1 {
+		t.Fatalf("%d completion events, want 1", n)
+	}
+}
+
+func BenchmarkBulkComplete(b *testing.B) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(b)
+	user := fx.User(b, 1)
+	key := fx.Key(b, user.ID, "key-1")
+
+	// Большая экспирация: тысячи задач одного дня
+	ids := make([]int64, 2000)
+	for i := range ids {
+		ids[i] = fx.Task(b, key, "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle).ID
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		completed, err := fx.Tasks.BulkComplete(ctx, ids, domain.TaskStateIdle, "option expired")
+		if err != nil || len(completed) != len(ids) {
+			b.Fatalf("BulkComplete: %d of %d, %v", len(completed), len(ids), err)
+		}
+
+		b.StopTimer()
+		if _, err := fx.DB.ExecContext(ctx, `UPDATE tasks SET status = 'IDLE'`); err != nil {
+			b.Fatalf("reset tasks: %v", err)
+		}
+		b.StartTimer()
+	}
+}
+
+func TestUserExtendSubscription(t *testing.T) {
+	const days = 30
+	period := days * 24 * time.Hour
+	tests := []struct {
+		name    string
+		expires time.Duration // окончание подписки относительно сейчас
+		want    time.Duration // ожидаемое окончание после продления
+		wantErr error
+	}{
+		{"expired user", -10 * 24 * time.Hour, period, nil},
+		{"active user", 10 * 24 * time.Hour, 10*24*time.Hour + period, nil},
+		{"stacking cap", domain.MaxSubscriptionAhead - 24*time.Hour, 0, domain.ErrLicenseStackLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fx := dbtest.NewFixture(t)
+			now := time.Now()
+			user := &domain.User{TelegramID: 1, ExpiresAt: now.Add(tt.expires)}
+			if err := fx.Users.GetOrCreate(ctx, user); err != nil {
+				t.Fatalf("create user: %v", err)
+			}
+
+			got, err := fx.Users.ExtendSubscription(ctx, 1, days)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("extend err = %v, want %v", err, tt.wantErr)
+			}
+			stored, err := fx.Users.GetByTelegramID(ctx, 1)
+			if err != nil {
+				t.Fatalf("reload user: %v", err)
+			}
+			if tt.wantErr != nil {
+				if !stored.ExpiresAt.Equal(user.ExpiresAt) {
+					t.Fatalf("expiry changed to %s", stored.ExpiresAt)
+				}
+				return
+			}
+			if want := now.Add(tt.want); got.Sub(want).Abs() > time.Minute || !stored.ExpiresAt.Equal(got) {
+				t.Fatalf("expires %s (stored %s), want about %s", got, stored.ExpiresAt, want)
+			}
+		})
+	}
+
+	t.Run("missing user", func(t *testing.T) {
+		fx := dbtest.NewFixture(t)
+		if _, err := fx.Users.ExtendSubscription(context.Background(), 404, days); !errors.Is(err, domain.ErrUserNotFound) {
+			t.Fatalf("err = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestUserExtendSubscriptionConcurrent(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+
+	// Параллельные продления складываются, ни одно не теряется
+	const workers = 10
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = fx.Users.ExtendSubscription(ctx, user.TelegramID, 1)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("extend #%d: %v", i, err)
+		}
+	}
+	stored, err := fx.Users.GetByTelegramID(ctx, user.TelegramID)
+	if err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	if got, want := stored.ExpiresAt.Sub(user.ExpiresAt), workers*24*time.Hour; (got - want).Abs() > time.Second {
+		t.Fatalf("extended by %s, want %s", got, want)
+	}
+}
+
+func TestWithTaskLock(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		checkTaskLockContention(t, dbtest.NewFixture(t).Tasks, 1)
+	})
+
+	// Advisory-лок Postgres проверяем на живой базе, если она есть (docker-compose up -d postgres)
+	t.Run("postgres", func(t *testing.T) {
+		host := os.Getenv("TEST_POSTGRES_HOST")
+		if host == "" {
+			t.Skip("TEST_POSTGRES_HOST not set")
+		}
+		db, err := database.NewConnection(context.Background(), database.Config{
+			Host: host, Port: 5432, User: "bybit_roller", Password: "secret_password", DBName: "bybit_roller",
+			SSLMode: "disable", ConnectAttempts: 1,
+		}, dbtest.Logger())
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		// Лок не требует строки задачи; ID из времени, чтобы не пересечься с живым ботом
+		checkTaskLockContention(t, database.NewTaskRepository(db, dbtest.Logger(), false), time.Now().UnixNano())
+	})
+}
+
+func TestWithTaskLockMaxHold(t *testing.T) {
+	const maxHold = 50 * time.Millisecond
+	db, err := database.NewConnection(context.Background(), database.Config{Driver: database.DriverSQLite, TaskLockMaxHold: maxHold}, dbtest.Logger())
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	tasks := database.NewTaskRepository(db, dbtest.Logger(), false)
+
+	// Ролл, который не отпускает лок (бесконечные повторы Leg 2), прерывается по пределу
+	start := time.Now()
+	err = tasks.WithTaskLock(context.Background(), 1, func(ctx context.Context) error {
+		<-ctx.Done()
+		return context.Cause(ctx)
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("lock held for %s, want about %s", elapsed, maxHold)
+	}
+	if !errors.Is(err, domain.ErrTaskLockLost) {
+		t.Fatalf("err = %v, want ErrTaskLockLost", err)
+	}
+
+	var ran bool
+	if err := tasks.WithTaskLock(context.Background(), 1, func(context.Context) error { ran = true; return nil }); err != nil || !ran {
+		t.Fatalf("lock after max hold: ran %v, err %v", ran, err)
+	}
+}
+
+func TestWithTaskLockLostConnection(t *testing.T) {
+	host := os.Getenv("TEST_POSTGRES_HOST")
+	if host == "" {
+		t.Skip("TEST_POSTGRES_HOST not set")
+	}
+	ctx := context.Background()
+	db, err := database.NewConnection(ctx, database.Config{
+		Host: host, Port: 5432, User: "bybit_roller", Password: "secret_password", DBName: "bybit_roller",
+		SSLMode: "disable", ConnectAttempts: 1,
+	}, dbtest.Logger())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	tasks := database.NewTaskRepository(db, dbtest.Logger(), false)
+	// ID укладывается в 32 бита: в pg_locks он целиком в objid
+	taskID := time.Now().Unix()
+
+	// Сессию лока убивает база (idle timeout, обрыв): ролл должен прерваться, а не идти без лока
+	err = tasks.WithTaskLock(ctx, taskID, func(ctx context.Context) error {
+		if _, err := db.ExecContext(ctx, `SELECT pg_terminate_backend(pid) FROM pg_locks
+			WHERE locktype = 'advisory' AND classid = 0 AND objid = $1`, taskID); err != nil {
+			t.Errorf("terminate lock session: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(time.Minute):
+			return errors.New("roll kept running without the lock")
+		}
+	})
+	if !errors.Is(err, domain.ErrTaskLockLost) {
+		t.Fatalf("err = %v, want ErrTaskLockLost", err)
+	}
+	if err := tasks.WithTaskLock(ctx, taskID, func(context.Context) error { return nil }); err != nil {
+		t.Fatalf("lock after lost session: %v", err)
+	}
+}
+
+// checkTaskLockContention: второй вызов получает ErrTaskLocked, пока первый внутри fn, и берет лок после
+func checkTaskLockContention(t *testing.T, tasks *database.TaskRepository, taskID int64) {
+	t.Helper()
+	ctx := context.Background()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	firstDone := make(chan error, 1)
+	go func() {
+		firstDone <- tasks.WithTaskLock(ctx, taskID, func(context.Context) error {
+			close(entered)
+			<-release
+			return nil
+		})
+	}()
+	<-entered
+
+	err := tasks.WithTaskLock(ctx, taskID, func(context.Context) error {
+		t.Error("second caller ran while the first held the lock")
+		return nil
+	})
+	if !errors.Is(err, domain.ErrTaskLocked) {
+		t.Fatalf("second caller err = %v, want ErrTaskLocked", err)
+	}
+	// Лок одной задачи не держит другие
+	if err := tasks.WithTaskLock(ctx, taskID+1, func(context.Context) error { return nil }); err != nil {
+		t.Fatalf("other task: %v", err)
+	}
+
+	close(release)
+	if err := <-firstDone; err != nil {
+		t.Fatalf("first caller: %v", err)
+	}
+
+	var ran bool
+	if err := tasks.WithTaskLock(ctx, taskID, func(context.Context) error { ran = true; return nil }); err != nil || !ran {
+		t.Fatalf("lock after release: ran %v, err %v", ran, err)
+	}
+}
+
+func TestAPIKeyDisablePausesWithEvents(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	key := fx.Key(t, user.ID, "key-1")
+	other := fx.Key(t, user.ID, "key-2")
+
+	idle := fx.Task(t, key, "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+	rolling := fx.Task(t, key, "BTC-27DEC24-59000-P", 58000, domain.TaskStateLeg1Closed)
+	untouched := fx.Task(t, other, "BTC-27DEC24-58000-P", 57000, domain.TaskStateIdle)
+
+	paused, err := fx.Keys.Invalidate(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("Invalidate: %v", err)
+	}
+	if len(paused) != 1 || paused[0].ID != idle.ID || paused[0].APIKeyID != key.ID {
+		t.Fatalf("paused %+v, want only IDLE task %d", paused, idle.ID)
+	}
+	if got := fx.Reload(t, rolling.ID); got.Status != domain.TaskStateLeg1Closed {
+		t.Fatalf("task mid-roll became %s", got.Status)
+	}
+	if got := fx.Reload(t, untouched.ID); got.Status != domain.TaskStateIdle {
+		t.Fatalf("task of another key became %s", got.Status)
+	}
+	// Пауза из-за ключа видна в журнале событий, как пауза по бану и подписке
+	events, err := fx.Tasks.ListTaskEvents(ctx, idle.ID, 1)
+	if err != nil || len(events) != 1 || events[0].Type != domain.TaskEventPaused || events[0].Details != "api key invalidated" {
+		t.Fatalf("events %+v, %v", events, err)
+	}
+}
+
+func TestReassignTasksToKeySkipsMidRoll(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	oldKey := fx.Key(t, user.ID, "key-1")
+	newKey := &domain.APIKey{UserID: user.ID, Key: "key-2", Secret: "secret", IsValid: true, AccountUID: 1001}
+	if err := fx.Keys.Create(ctx, newKey); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	idle := fx.Task(t, oldKey, "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+	paused := fx.Task(t, oldKey, "BTC-27DEC24-59000-P", 58000, domain.TaskStatePaused)
+	initiated := fx.Task(t, oldKey, "BTC-27DEC24-58000-P", 57000, domain.TaskStateRollInitiated)
+	leg1 := fx.Task(t, oldKey, "BTC-27DEC24-57000-P", 56000, domain.TaskStateLeg1Closed)
+
+	n, err := fx.Tasks.ReassignTasksToKey(ctx, oldKey.ID, newKey.ID)
+	if err != nil || n != 2 {
+		t.Fatalf("ReassignTasksToKey: %d, %v; want 2", n, err)
+	}
+	for _, task := range []*domain.Task{idle, paused} {
+		if got := fx.Reload(t, task.ID); got.APIKeyID != newKey.ID || got.Version == task.Version {
+			t.Fatalf("task %d: key %d version %d, want key %d and a new version", task.ID, got.APIKeyID, got.Version, newKey.ID)
+		}
+	}
+	// Восстановление ролла должно идти в аккаунте, где ушел Leg 1
+	for _, task := range []*domain.Task{initiated, leg1} {
+		if got := fx.Reload(t, task.ID); got.APIKeyID != oldKey.ID {
+			t.Fatalf("mid-roll task %d moved to key %d", task.ID, got.APIKeyID)
+		}
+	}
+
+	stored, err := fx.Keys.GetByID(ctx, newKey.ID)
+	if err != nil || stored.AccountUID != 1001 {
+		t.Fatalf("stored key %+v, %v; want account uid 1001", stored, err)
+	}
+	if stored, _ := fx.Keys.GetByID(ctx, oldKey.ID); stored.AccountUID != 0 {
+		t.Fatalf("key without uid read back as %d", stored.AccountUID)
+	}
+}
+
+func TestAPIKeyUpdateRefusedMidRoll(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	key := fx.Key(t, user.ID, "key-1")
+	task := fx.Task(t, key, "BTC-27DEC24-60000-P", 59000, domain.TaskStateLeg1Closed)
+
+	err := fx.Keys.Update(ctx, key.ID, "key-2", "secret-2", time.Time{}, 1001)
+	if !errors.Is(err, domain.ErrTaskMidRoll) {
+		t.Fatalf("Update mid-roll: %v, want ErrTaskMidRoll", err)
+	}
+	if stored, _ := fx.Keys.GetByID(ctx, key.ID); stored.Key != "key-1" {
+		t.Fatalf("key replaced mid-roll with %q", stored.Key)
+	}
+
+	if err := fx.Tasks.UpdateTaskState(ctx, task.ID, domain.TaskStateIdle, fx.Reload(t, task.ID).Version); err != nil {
+		t.Fatalf("UpdateTaskState: %v", err)
+	}
+	if err := fx.Keys.Update(ctx, key.ID, "key-2", "secret-2", time.Time{}, 1001); err != nil {
+		t.Fatalf("Update after roll: %v", err)
+	}
+	stored, err := fx.Keys.GetByID(ctx, key.ID)
+	if err != nil || stored.Key != "key-2" || stored.AccountUID != 1001 {
+		t.Fatalf("stored key %+v, %v", stored, err)
+	}
+}
+
+func TestBanAndExpiryPauseThroughBulkPause(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	expired := fx.User(t, 1)
+	banned := fx.User(t, 2)
+	active := fx.User(t, 3)
+	if _, err := fx.DB.ExecContext(ctx, `UPDATE users SET expires_at = $1 WHERE id = $2`, time.Now().Add(-time.Hour), expired.ID); err != nil {
+		t.Fatalf("expire user: %v", err)
+	}
+
+	expiredIdle := fx.Task(t, fx.Key(t, expired.ID, "key-1"), "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+	expiredRolling := fx.Task(t, fx.Key(t, expired.ID, "key-1b"), "BTC-27DEC24-59000-P", 58000, domain.TaskStateLeg1Closed)
+	bannedIdle := fx.Task(t, fx.Key(t, banned.ID, "key-2"), "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+	activeIdle := fx.Task(t, fx.Key(t, active.ID, "key-3"), "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+
+	paused, err := fx.Tasks.PauseTasksOfExpiredUsers(ctx)
+	if err != nil || len(paused) != 1 || paused[0].ID != expiredIdle.ID {
+		t.Fatalf("expiry paused %+v, %v; want task %d", paused, err, expiredIdle.ID)
+	}
+	paused, err = fx.Users.SetBanned(ctx, banned.TelegramID, true)
+	if err != nil || len(paused) != 1 || paused[0].ID != bannedIdle.ID {
+		t.Fatalf("ban paused %+v, %v; want task %d", paused, err, bannedIdle.ID)
+	}
+
+	for _, tt := range []struct {
+		task    *domain.Task
+		want    domain.TaskState
+		details string
+	}{
+		{expiredIdle, domain.TaskStatePaused, "subscription expired"},
+		{expiredRolling, domain.TaskStateLeg1Closed, ""},
+		{bannedIdle, domain.TaskStatePaused, "user banned"},
+		{activeIdle, domain.TaskStateIdle, ""},
+	} {
+		if got := fx.Reload(t, tt.task.ID); got.Status != tt.want {
+			t.Fatalf("task %d is %s, want %s", tt.task.ID, got.Status, tt.want)
+		}
+		events, err := fx.Tasks.ListTaskEvents(ctx, tt.task.ID, 1)
+		if err != nil {
+			t.Fatalf("events: %v", err)
+		}
+		if tt.details == "" && len(events) != 0 || tt.details != "" && (len(events) != 1 || events[0].Details != tt.details) {
+			t.Fatalf("task %d events %+v, want pause %q", tt.task.ID, events, tt.details)
+		}
+	}
+}
+
+func TestListTasksCombinedFilters(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	key := fx.Key(t, user.ID, "key-1")
+	other := fx.User(t, 2)
+	fx.Task(t, fx.Key(t, other.ID, "key-2"), "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+
+	states := []domain.TaskState{
+		domain.TaskStateIdle, domain.TaskStatePaused, domain.TaskStateCompleted, domain.TaskStateFailed, domain.TaskStateRollInitiated,
+	}
+	ids := make([]int64, len(states))
+	for i, state := range states {
+		ids[i] = fx.Task(t, key, "BTC-27DEC24-60000-P", 59000, state).ID
+	}
+	deleted := fx.Task(t, key, "BTC-27DEC24-60000-P", 59000, domain.TaskStateIdle)
+	if err := fx.Tasks.DeleteTask(ctx, deleted.ID, user.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	// Порядок изменений не совпадает с порядком создания
+	for i, minutesAgo := range []int{40, 20, 10, 50, 30} {
+		if _, err := fx.DB.ExecContext(ctx, `UPDATE tasks SET updated_at = $1 WHERE id = $2`,
+			time.Now().Add(-time.Duration(minutesAgo)*time.Minute), ids[i]); err != nil {
+			t.Fatalf("set updated_at: %v", err)
+		}
+	}
+	idle, paused, completed, failed, rolling := ids[0], ids[1], ids[2], ids[3], ids[4]
+
+	tests := []struct {
+		name   string
+		filter domain.TaskFilter
+		limit  int
+		offset int
+		want   []int64
+	}{
+		{"all, newest first", domain.TaskFilter{}, 0, 0, []int64{rolling, failed, completed, paused, idle}},
+		{"active, oldest first", domain.TaskFilter{States: domain.ActiveTaskStates, Order: domain.TaskOrderOldest}, 0, 0, []int64{idle, paused, rolling}},
+		{"history, recently updated", domain.TaskFilter{States: domain.HistoryTaskStates, Order: domain.TaskOrderUpdated}, 0, 0, []int64{completed, failed}},
+		{"active, recently updated, first page", domain.TaskFilter{States: domain.ActiveTaskStates, Order: domain.TaskOrderUpdated}, 2, 0, []int64{paused, rolling}},
+		{"active, recently updated, second page", domain.TaskFilter{States: domain.ActiveTaskStates, Order: domain.TaskOrderUpdated}, 2, 2, []int64{idle}},
+		{"one state, past the end", domain.TaskFilter{States: []domain.TaskState{domain.TaskStatePaused}}, 10, 1, nil},
+		{"negative offset", domain.TaskFilter{States: domain.HistoryTaskStates, Order: domain.TaskOrderOldest}, 1, -5, []int64{completed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, err := fx.Tasks.ListTasks(ctx, user.ID, tt.filter, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("ListTasks: %v", err)
+			}
+			var got []int64
+			for _, task := range tasks {
+				got = append(got, task.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("tasks %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := fx.Tasks.ListTasks(ctx, user.ID, domain.TaskFilter{Order: "random"}, 0, 0); err == nil {
+		t.Fatal("unknown order: want error")
+	}
+}
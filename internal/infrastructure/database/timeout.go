@@ -0,0 +1,195 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// Все запросы репозиториев идут через методы DB ниже и ограничены по времени: зависший Postgres
+// не должен держать воркер посреди ролла. SELECT получает ReadTimeout, остальное и транзакции -
+// WriteTimeout. Запрос, прерванный этим лимитом, возвращает domain.ErrDatabaseTimeout.
+// Время каждого запроса учитывается в статистике (query_stats.go): для выборок - до Close/Scan.
+// Для SQLite запрос и аргументы приводятся к диалекту (dialect.go).
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	timing := db.startQuery(callerName(1), args)
+	opCtx, cancel := db.opContext(ctx, query)
+	defer cancel()
+
+	res, err := db.DB.ExecContext(opCtx, db.rebind(query), db.bindArgs(args)...)
+	err = dbError(ctx, opCtx, err)
+	timing.done(err)
+	return res, err
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	timing := db.startQuery(callerName(1), args)
+	opCtx, cancel := db.opContext(ctx, query)
+	rows, err := db.DB.QueryContext(opCtx, db.rebind(query), db.bindArgs(args)...)
+	if err != nil {
+		cancel()
+		err = dbError(ctx, opCtx, err)
+		timing.done(err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, ctx: ctx, opCtx: opCtx, cancel: cancel, timing: timing}, nil
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	timing := db.startQuery(callerName(1), args)
+	opCtx, cancel := db.opContext(ctx, query)
+	return &Row{row: db.DB.QueryRowContext(opCtx, db.rebind(query), db.bindArgs(args)...), ctx: ctx, opCtx: opCtx, cancel: cancel, timing: timing}
+}
+
+// BeginTx: лимит WriteTimeout на всю транзакцию. Долгие транзакции (лок задачи на время ролла)
+// открываются через db.DB.BeginTx напрямую.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	opCtx, cancel := context.WithTimeout(ctx, db.writeTimeout)
+	tx, err := db.DB.BeginTx(opCtx, opts)
+	if err != nil {
+		cancel()
+		return nil, dbError(ctx, opCtx, err)
+	}
+	return &Tx{db: db, tx: tx, ctx: ctx, opCtx: opCtx, cancel: cancel}, nil
+}
+
+func (db *DB) opContext(ctx context.Context, query string) (context.Context, context.CancelFunc) {
+	if isReadQuery(query) {
+		return context.WithTimeout(ctx, db.readTimeout)
+	}
+	return context.WithTimeout(ctx, db.writeTimeout)
+}
+
+func isReadQuery(query string) bool {
+	q := strings.TrimSpace(query)
+	return len(q) >= 6 && strings.EqualFold(q[:6], "SELECT")
+}
+
+// queryTiming - начатый запрос для статистики; done учитывает его один раз
+type queryTiming struct {
+	db       *DB
+	name     string
+	args     int
+	start    time.Time
+	observed bool
+}
+
+func (db *DB) startQuery(name string, args []any) *queryTiming {
+	return &queryTiming{db: db, name: name, args: len(args), start: time.Now()}
+}
+
+func (t *queryTiming) done(err error) {
+	if t.observed {
+		return
+	}
+	t.observed = true
+	t.db.observe(t.name, t.args, t.start, err)
+}
+
+// dbError помечает ошибку запроса, оборванного лимитом операции (а не отменой ctx вызывающего)
+func dbError(ctx, opCtx context.Context, err error) error {
+	if err != nil && ctx.Err() == nil && errors.Is(opCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", domain.ErrDatabaseTimeout, err)
+	}
+	return err
+}
+
+// Rows освобождает лимит операции на Close
+type Rows struct {
+	*sql.Rows
+	ctx, opCtx context.Context
+	cancel     context.CancelFunc
+	timing     *queryTiming
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	r.timing.done(r.Err())
+	return err
+}
+
+func (r *Rows) Err() error {
+	return dbError(r.ctx, r.opCtx, r.Rows.Err())
+}
+
+// Row освобождает лимит операции после Scan
+type Row struct {
+	row        *sql.Row
+	ctx, opCtx context.Context
+	cancel     context.CancelFunc
+	timing     *queryTiming
+}
+
+func (r *Row) Scan(dest ...any) error {
+	defer r.cancel()
+	err := dbError(r.ctx, r.opCtx, r.row.Scan(dest...))
+	r.timing.done(err)
+	return err
+}
+
+// Tx - транзакция под лимитом BeginTx; запросы внутри ограничены им же
+type Tx struct {
+	db         *DB
+	tx         *sql.Tx
+	ctx, opCtx context.Context
+	cancel     context.CancelFunc
+}
+
+// stmtContext - контекст запроса внутри транзакции: ctx вызывающего под сроком транзакции.
+// Без срока зависший запрос держал бы и Rollback: sql.Tx ждет завершения идущего запроса.
+func (t *Tx) stmtContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, _ := t.opCtx.Deadline()
+	return context.WithDeadline(ctx, deadline)
+}
+
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	timing := t.db.startQuery(callerName(1), args)
+	stmtCtx, cancel := t.stmtContext(ctx)
+	defer cancel()
+
+	res, err := t.tx.ExecContext(stmtCtx, t.db.rebind(query), t.db.bindArgs(args)...)
+	err = dbError(ctx, stmtCtx, err)
+	timing.done(err)
+	return res, err
+}
+
+func (t *Tx) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	timing := t.db.startQuery(callerName(1), args)
+	stmtCtx, cancel := t.stmtContext(ctx)
+	rows, err := t.tx.QueryContext(stmtCtx, t.db.rebind(query), t.db.bindArgs(args)...)
+	if err != nil {
+		cancel()
+		err = dbError(ctx, stmtCtx, err)
+		timing.done(err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, ctx: ctx, opCtx: stmtCtx, cancel: cancel, timing: timing}, nil
+}
+
+func (t *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	timing := t.db.startQuery(callerName(1), args)
+	stmtCtx, cancel := t.stmtContext(ctx)
+	return &Row{row: t.tx.QueryRowContext(stmtCtx, t.db.rebind(query), t.db.bindArgs(args)...), ctx: ctx, opCtx: stmtCtx, cancel: cancel, timing: timing}
+}
+
+// Commit учитывается в статистике отдельно, под именем вызвавшего метода с суффиксом commit
+func (t *Tx) Commit() error {
+	defer t.cancel()
+	timing := t.db.startQuery(callerName(1)+" commit", nil)
+	err := dbError(t.ctx, t.opCtx, t.tx.Commit())
+	timing.done(err)
+	return err
+}
+
+// Rollback после Commit безопасен (sql.ErrTxDone), поэтому его можно откладывать через defer
+func (t *Tx) Rollback() error {
+	defer t.cancel()
+	return t.tx.Rollback()
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// sleepConnector - драйвер зависшей базы: каждый запрос спит delay или до отмены ctx, как pq
+type sleepConnector struct {
+	delay time.Duration
+}
+
+func (c sleepConnector) Connect(context.Context) (driver.Conn, error) { return sleepConn(c), nil }
+func (c sleepConnector) Driver() driver.Driver                        { return c }
+func (c sleepConnector) Open(string) (driver.Conn, error)             { return sleepConn(c), nil }
+
+type sleepConn struct {
+	delay time.Duration
+}
+
+func (c sleepConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c sleepConn) Close() error              { return nil }
+func (c sleepConn) Begin() (driver.Tx, error) { return sleepTx{}, nil }
+
+func (c sleepConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return sleepTx{}, nil
+}
+
+func (c sleepConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.sleep(ctx); err != nil {
+		return nil, err
+	}
+	return driver.ResultNoRows, nil
+}
+
+func (c sleepConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := c.sleep(ctx); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("query finished after sleep")
+}
+
+func (c sleepConn) sleep(ctx context.Context) error {
+	select {
+	case <-time.After(c.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type sleepTx struct{}
+
+func (sleepTx) Commit() error   { return nil }
+func (sleepTx) Rollback() error { return nil }
+
+func newSleepDB(t *testing.T, delay time.Duration) *DB {
+	t.Helper()
+	db := &DB{
+		DB:           sql.OpenDB(sleepConnector{delay: delay}),
+		driver:       DriverPostgres,
+		readTimeout:  50 * time.Millisecond,
+		writeTimeout: 50 * time.Millisecond,
+		slowQuery:    time.Hour,
+		stats:        queryStats{stats: make(map[string]*domain.QueryStat)},
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		taskLocks:    sqliteTaskLocks{locked: make(map[int64]bool)},
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestStatementsTimeOutOnHungDatabase(t *testing.T) {
+	// Ответа базы не дождаться: запрос обязан вернуться по лимиту операции
+	const bound = time.Second
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, db *DB) error
+	}{
+		{"select", func(ctx context.Context, db *DB) error {
+			var n int
+			return db.QueryRowContext(ctx, `SELECT 1`).Scan(&n)
+		}},
+		{"update", func(ctx context.Context, db *DB) error {
+			_, err := db.ExecContext(ctx, `UPDATE tasks SET status = 'IDLE' WHERE id = $1`, 1)
+			return err
+		}},
+		{"tx exec", func(ctx context.Context, db *DB) error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			_, err = tx.ExecContext(ctx, `UPDATE tasks SET status = 'IDLE' WHERE id = $1`, 1)
+			return err
+		}},
+		{"tx select", func(ctx context.Context, db *DB) error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			var n int
+			return tx.QueryRowContext(ctx, `SELECT 1 FROM tasks WHERE id = $1 FOR UPDATE`, 1).Scan(&n)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newSleepDB(t, time.Minute)
+
+			start := time.Now()
+			err := tt.run(context.Background(), db)
+			if elapsed := time.Since(start); elapsed > bound {
+				t.Fatalf("returned after %s, want under %s", elapsed, bound)
+			}
+			if !errors.Is(err, domain.ErrDatabaseTimeout) {
+				t.Fatalf("err = %v, want ErrDatabaseTimeout", err)
+			}
+			if !domain.IsTransient(err) {
+				t.Fatalf("timeout %v is not transient", err)
+			}
+		})
+	}
+}
+
+func TestTxStatementHonoursCallerCancel(t *testing.T) {
+	db := newSleepDB(t, time.Minute)
+	db.writeTimeout = time.Minute
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Отмена вызывающего - не таймаут базы
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = tx.ExecContext(ctx, `UPDATE tasks SET status = 'IDLE' WHERE id = $1`, 1)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, domain.ErrDatabaseTimeout) {
+		t.Fatalf("err = %v, want caller deadline", err)
+	}
+}
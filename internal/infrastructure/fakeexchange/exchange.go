@@ -0,0 +1,498 @@
+package fakeexchange
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// Config - стартовое состояние биржи в памяти
+type Config struct {
+	// Индексные цены базовых активов ("BTCUSDT")
+	IndexPrices map[string]decimal.Decimal
+	// PriceScript - последовательность индексных цен, по шагу на каждый Advance.
+	// После последнего шага цена остается на месте.
+	PriceScript map[string][]decimal.Decimal
+	// Сетка страйков по базовой монете ("BTC")
+	Strikes map[string]StrikeGrid
+	// Сколько ежедневных экспираций (08:00 UTC) вперед доступно
+	ExpiryDays int
+	Equity     decimal.Decimal
+	// Позиции, которые получает каждый новый ключ при первом обращении
+	StartPositions []domain.Position
+}
+
+// StrikeGrid - детерминированная цепочка страйков Min, Min+Step, ..., Max
+type StrikeGrid struct {
+	Min  decimal.Decimal
+	Max  decimal.Decimal
+	Step decimal.Decimal
+}
+
+// Failures - внедряемые сбои
+type Failures struct {
+	// RejectOrder - номер ордера (с 1, считая все ордера биржи), который будет отклонен; 0 - не отклонять
+	RejectOrder int
+	// RejectErr - ошибка отклонения, по умолчанию domain.ErrInsufficientMargin
+	RejectErr error
+	// LoseReply - номер ордера, который биржа исполнит, но ответ на него потеряется (ErrExchangeUnavailable)
+	LoseReply int
+	// PositionTimeout - GetPosition висит до отмены контекста
+	PositionTimeout bool
+	// PositionDelay - задержка ответа GetPosition: медленный ролл для тестов очереди и остановки
+	PositionDelay time.Duration
+}
+
+// DefaultConfig - BTC и ETH с плавным падением BTC и короткий пут BTC у каждого ключа,
+// чтобы в локальном режиме ролл срабатывал без настройки
+func DefaultConfig(now time.Time) Config {
+	script := make([]decimal.Decimal, 0, 17)
+	for price := int64(60000); price >= 56000; price -= 250 {
+		script = append(script, decimal.NewFromInt(price))
+	}
+
+	expiry := nextExpiry(now)
+	return Config{
+		IndexPrices: map[string]decimal.Decimal{
+			"BTCUSDT": decimal.NewFromInt(60000),
+			"ETHUSDT": decimal.NewFromInt(3000),
+		},
+		PriceScript: map[string][]decimal.Decimal{
+			"BTCUSDT": script,
+		},
+		Strikes: map[string]StrikeGrid{
+			"BTC": {Min: decimal.NewFromInt(40000), Max: decimal.NewFromInt(80000), Step: decimal.NewFromInt(1000)},
+			"ETH": {Min: decimal.NewFromInt(2000), Max: decimal.NewFromInt(4000), Step: decimal.NewFromInt(50)},
+		},
+		ExpiryDays: 7,
+		Equity:     decimal.NewFromInt(10000),
+		StartPositions: []domain.Position{{
+			Symbol:     fmt.Sprintf("BTC-%s-58000-P", domain.FormatExpiryCode(expiry)),
+			Side:       domain.SideSell,
+			Qty:        decimal.NewFromFloat(0.1),
+			EntryPrice: decimal.NewFromInt(450),
+		}},
+	}
+}
+
+// Exchange - реализация domain.ExchangeAdapter в памяти для локального режима и тестов.
+// Ордера исполняются сразу по mark, если лимитная цена его пересекает; GTC без пересечения
+// остается в стакане до AmendOrder/CancelOrder.
+type Exchange struct {
+	mu sync.Mutex
+
+	cfg         Config
+	indexPrices map[string]decimal.Decimal
+	script      map[string][]decimal.Decimal
+	optionMarks map[string]decimal.Decimal
+	failures    Failures
+	margin      *domain.MarginInfo // nil - по Equity из Config
+	now         func() time.Time
+
+	// Состояние по API ключу (creds.Key)
+	accounts map[string]*account
+	orderSeq int
+}
+
+type account struct {
+	uid       int64 // UID аккаунта: у каждого API ключа свой аккаунт
+	positions map[string]domain.Position
+	orders    map[string]domain.Order // по orderLinkId
+	closedPnL []domain.ClosedPnL
+}
+
+func New(cfg Config) *Exchange {
+	e := &Exchange{
+		cfg:         cfg,
+		indexPrices: make(map[string]decimal.Decimal),
+		script:      make(map[string][]decimal.Decimal),
+		optionMarks: make(map[string]decimal.Decimal),
+		accounts:    make(map[string]*account),
+		now:         time.Now,
+	}
+	for symbol, price := range cfg.IndexPrices {
+		e.indexPrices[symbol] = price
+	}
+	for symbol, steps := range cfg.PriceScript {
+		e.script[symbol] = append([]decimal.Decimal(nil), steps...)
+	}
+	return e
+}
+
+// --- Управление сценарием ---
+
+// SetIndexPrice задает индексную цену базового актива
+func (e *Exchange) SetIndexPrice(symbol string, price decimal.Decimal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.indexPrices[symbol] = price
+}
+
+// SetOptionMark фиксирует mark опциона вместо модельной цены
+func (e *Exchange) SetOptionMark(symbol string, price decimal.Decimal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.optionMarks[symbol] = price
+}
+
+// SetPosition кладет позицию ключу (Qty = 0 - удалить)
+func (e *Exchange) SetPosition(apiKey string, pos domain.Position) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc := e.account(apiKey)
+	if pos.Qty.IsZero() {
+		delete(acc.positions, pos.Symbol)
+		return
+	}
+	acc.positions[pos.Symbol] = pos
+}
+
+// SetOrder кладет ордер ключу как уже отправленный: ордер прерванного ролла для тестов восстановления
+func (e *Exchange) SetOrder(apiKey string, order domain.Order) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.account(apiKey).orders[order.OrderLinkID] = order
+}
+
+// SetMarginInfo фиксирует ответ GetMarginInfo для всех ключей
+func (e *Exchange) SetMarginInfo(info domain.MarginInfo) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.margin = &info
+}
+
+func (e *Exchange) SetFailures(f Failures) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = f
+}
+
+// Advance делает один шаг сценария цен
+func (e *Exchange) Advance() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for symbol, steps := range e.script {
+		if len(steps) == 0 {
+			continue
+		}
+		e.indexPrices[symbol] = steps[0]
+		e.script[symbol] = steps[1:]
+	}
+}
+
+// RunScript двигает сценарий цен раз в interval до отмены контекста
+func (e *Exchange) RunScript(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Advance()
+		}
+	}
+}
+
+// IndexPrice - текущая индексная цена без ошибок, для стрима
+func (e *Exchange) IndexPrice(symbol string) (decimal.Decimal, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	price, ok := e.indexPrices[symbol]
+	return price, ok
+}
+
+// account возвращает состояние ключа, создавая его со стартовыми позициями. Вызывать под mu.
+func (e *Exchange) account(apiKey string) *account {
+	acc, ok := e.accounts[apiKey]
+	if ok {
+		return acc
+	}
+
+	acc = &account{
+		uid:       int64(len(e.accounts) + 1),
+		positions: make(map[string]domain.Position),
+		orders:    make(map[string]domain.Order),
+	}
+	for _, pos := range e.cfg.StartPositions {
+		acc.positions[pos.Symbol] = pos
+	}
+	e.accounts[apiKey] = acc
+	return acc
+}
+
+// --- domain.ExchangeAdapter: рыночные данные ---
+
+func (e *Exchange) GetIndexPrice(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	price, ok := e.IndexPrice(symbol)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("index price not found for %s: %w", symbol, domain.ErrInvalidSymbol)
+	}
+	return price, nil
+}
+
+// GetUnderlyingPrice отдает индексную цену для любого source: у фейка одна цена базового актива
+func (e *Exchange) GetUnderlyingPrice(ctx context.Context, symbol string, source domain.PriceSource) (decimal.Decimal, error) {
+	return e.GetIndexPrice(ctx, symbol)
+}
+
+func (e *Exchange) GetMarkPrice(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.markPrice(symbol)
+}
+
+func (e *Exchange) GetOrderbook(ctx context.Context, symbol string, depth int) (domain.Orderbook, error) {
+	mark, err := e.GetMarkPrice(ctx, symbol)
+	if err != nil {
+		return domain.Orderbook{}, err
+	}
+	if depth <= 0 {
+		depth = 5
+	}
+
+	book := domain.Orderbook{Symbol: symbol, Time: e.now()}
+	size := decimal.NewFromInt(10)
+	for i := 1; i <= depth; i++ {
+		offset := decimal.NewFromFloat(0.01).Mul(decimal.NewFromInt(int64(i)))
+		book.Bids = append(book.Bids, domain.OrderbookLevel{Price: mark.Mul(decimal.NewFromInt(1).Sub(offset)).Round(1), Size: size})
+		book.Asks = append(book.Asks, domain.OrderbookLevel{Price: mark.Mul(decimal.NewFromInt(1).Add(offset)).Round(1), Size: size})
+	}
+	return book, nil
+}
+
+func (e *Exchange) GetOptionStrikes(ctx context.Context, baseCoin string, expiryDate string) ([]decimal.Decimal, error) {
+	grid, ok := e.cfg.Strikes[baseCoin]
+	if !ok {
+		return nil, fmt.Errorf("no strikes found for %s %s", baseCoin, expiryDate)
+	}
+	return grid.strikes(), nil
+}
+
+func (e *Exchange) GetOptionExpiries(ctx context.Context, baseCoin string) ([]domain.OptionExpiry, error) {
+	if _, ok := e.cfg.Strikes[baseCoin]; !ok {
+		return nil, fmt.Errorf("no expiries found for %s", baseCoin)
+	}
+
+	first := nextExpiry(e.now())
+	expiries := make([]domain.OptionExpiry, 0, e.cfg.ExpiryDays)
+	for day := 0; day < e.cfg.ExpiryDays; day++ {
+		t := first.AddDate(0, 0, day)
+		expiries = append(expiries, domain.OptionExpiry{Time: t, Code: domain.FormatExpiryCode(t)})
+	}
+	return expiries, nil
+}
+
+func (e *Exchange) GetOptionChain(ctx context.Context, baseCoin string, expiryDate string) ([]domain.OptionQuote, error) {
+	strikes, err := e.GetOptionStrikes(ctx, baseCoin, expiryDate)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	quotes := make([]domain.OptionQuote, 0, len(strikes)*2)
+	for _, strike := range strikes {
+		for _, side := range []string{"C", "P"} {
+			symbol := fmt.Sprintf("%s-%s-%s-%s", baseCoin, expiryDate, strike.String(), side)
+			mark, err := e.markPrice(symbol)
+			if err != nil {
+				return nil, err
+			}
+			quotes = append(quotes, domain.OptionQuote{
+				Symbol:    symbol,
+				Strike:    strike,
+				Side:      side,
+				MarkPrice: mark,
+				BidPrice:  mark.Mul(decimal.NewFromFloat(0.98)).Round(1),
+				AskPrice:  mark.Mul(decimal.NewFromFloat(1.02)).Round(1),
+				MarkIV:    decimal.NewFromFloat(0.6),
+				Delta:     e.delta(baseCoin, strike, side),
+			})
+		}
+	}
+	return quotes, nil
+}
+
+func (e *Exchange) GetDeliveryPrice(ctx context.Context, baseCoin, symbol string) (decimal.Decimal, error) {
+	return e.GetIndexPrice(ctx, baseCoin+"USDT")
+}
+
+// --- domain.ExchangeAdapter: аккаунт ---
+
+func (e *Exchange) GetPosition(ctx context.Context, creds domain.APIKey, symbol string) (domain.Position, error) {
+	e.mu.Lock()
+	timeout, delay := e.failures.PositionTimeout, e.failures.PositionDelay
+	e.mu.Unlock()
+
+	if timeout {
+		<-ctx.Done()
+		return domain.Position{}, ctx.Err()
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return domain.Position{}, ctx.Err()
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	pos, ok := e.account(creds.Key).positions[symbol]
+	if !ok {
+		return domain.Position{Symbol: symbol}, nil
+	}
+	return e.withMark(pos), nil
+}
+
+func (e *Exchange) GetPositions(ctx context.Context, creds domain.APIKey) ([]domain.Position, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(creds.Key)
+	positions := make([]domain.Position, 0, len(acc.positions))
+	for _, pos := range acc.positions {
+		positions = append(positions, e.withMark(pos))
+	}
+	return positions, nil
+}
+
+func (e *Exchange) GetMarginInfo(ctx context.Context, creds domain.APIKey) (domain.MarginInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.margin != nil {
+		return *e.margin, nil
+	}
+	return domain.MarginInfo{
+		TotalEquity:        e.cfg.Equity,
+		TotalMarginBalance: e.cfg.Equity,
+		AvailableBalance:   e.cfg.Equity,
+		MMR:                decimal.NewFromFloat(0.1),
+	}, nil
+}
+
+func (e *Exchange) GetAccountInfo(ctx context.Context, creds domain.APIKey) (domain.AccountInfo, error) {
+	return domain.AccountInfo{UnifiedMarginStatus: 4, MarginMode: domain.MarginModeRegular}, nil
+}
+
+func (e *Exchange) GetAPIKeyInfo(ctx context.Context, creds domain.APIKey) (domain.APIKeyInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return domain.APIKeyInfo{
+		Permissions: map[string][]string{
+			domain.PermissionGroupOptions: {domain.PermissionOptionsTrade},
+		},
+		ExpiresAt:  e.now().AddDate(0, 3, 0),
+		Unified:    true,
+		AccountUID: e.account(creds.Key).uid,
+	}, nil
+}
+
+func (e *Exchange) GetClosedPnL(ctx context.Context, creds domain.APIKey, startTime, endTime time.Time) ([]domain.ClosedPnL, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var records []domain.ClosedPnL
+	for _, r := range e.account(creds.Key).closedPnL {
+		if !r.CreatedAt.Before(startTime) && r.CreatedAt.Before(endTime) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// --- Вспомогательное (вызывать под mu) ---
+
+func (e *Exchange) markPrice(symbol string) (decimal.Decimal, error) {
+	if price, ok := e.optionMarks[symbol]; ok {
+		return price, nil
+	}
+	if price, ok := e.indexPrices[symbol]; ok {
+		return price, nil
+	}
+
+	sym, err := domain.ParseOptionSymbol(symbol)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("symbol not found: %w", domain.ErrInvalidSymbol)
+	}
+	index, ok := e.indexPrices[sym.BaseCoin+"USDT"]
+	if !ok || !e.cfg.Strikes[sym.BaseCoin].contains(sym.Strike) {
+		return decimal.Zero, fmt.Errorf("symbol %s not found: %w", symbol, domain.ErrInvalidSymbol)
+	}
+
+	// Модельная цена: внутренняя стоимость плюс 0.5% индекса временной стоимости
+	timeValue := index.Mul(decimal.NewFromFloat(0.005))
+	return sym.IntrinsicValue(index).Add(timeValue).Round(1), nil
+}
+
+// delta - грубая линейная оценка, достаточная для выбора страйка по дельте
+func (e *Exchange) delta(baseCoin string, strike decimal.Decimal, side string) decimal.Decimal {
+	index := e.indexPrices[baseCoin+"USDT"]
+	if index.IsZero() {
+		return decimal.Zero
+	}
+
+	half := decimal.NewFromFloat(0.5)
+	callDelta := half.Add(index.Sub(strike).Div(index).Mul(decimal.NewFromInt(5)))
+	if callDelta.GreaterThan(decimal.NewFromInt(1)) {
+		callDelta = decimal.NewFromInt(1)
+	}
+	if callDelta.IsNegative() {
+		callDelta = decimal.Zero
+	}
+
+	if side == "P" {
+		return callDelta.Sub(decimal.NewFromInt(1)).Round(3)
+	}
+	return callDelta.Round(3)
+}
+
+func (e *Exchange) withMark(pos domain.Position) domain.Position {
+	mark, err := e.markPrice(pos.Symbol)
+	if err != nil {
+		return pos
+	}
+	pos.MarkPrice = mark
+
+	diff := mark.Sub(pos.EntryPrice)
+	if pos.Side == domain.SideSell {
+		diff = diff.Neg()
+	}
+	pos.UnrealizedPnL = diff.Mul(pos.Qty)
+	return pos
+}
+
+func (g StrikeGrid) strikes() []decimal.Decimal {
+	if !g.Step.IsPositive() {
+		return nil
+	}
+	var strikes []decimal.Decimal
+	for s := g.Min; s.LessThanOrEqual(g.Max); s = s.Add(g.Step) {
+		strikes = append(strikes, s)
+	}
+	return strikes
+}
+
+func (g StrikeGrid) contains(strike decimal.Decimal) bool {
+	if !g.Step.IsPositive() || strike.LessThan(g.Min) || strike.GreaterThan(g.Max) {
+		return false
+	}
+	return strike.Sub(g.Min).Mod(g.Step).IsZero()
+}
+
+// nextExpiry - ближайшая ежедневная экспирация в 08:00 UTC, еще не наступившая
+func nextExpiry(now time.Time) time.Time {
+	now = now.UTC()
+	expiry := time.Date(now.Year(), now.Month(), now.Day(), 8, 0, 0, 0, time.UTC)
+	if !expiry.After(now) {
+		expiry = expiry.AddDate(0, 0, 1)
+	}
+	return expiry
+}
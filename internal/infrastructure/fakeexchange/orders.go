@@ -0,0 +1,205 @@
+package fakeexchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+var errOrderNotFound = errors.New("order not exists or too late to cancel")
+
+func (e *Exchange) PlaceOrder(ctx context.Context, creds domain.APIKey, req domain.OrderRequest) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.placeOrder(creds.Key, req)
+}
+
+func (e *Exchange) PlaceBatchOrders(ctx context.Context, creds domain.APIKey, reqs []domain.OrderRequest) ([]domain.BatchOrderResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results := make([]domain.BatchOrderResult, len(reqs))
+	for i, req := range reqs {
+		orderID, err := e.placeOrder(creds.Key, req)
+		results[i] = domain.BatchOrderResult{OrderLinkID: req.OrderLinkID, OrderID: orderID, Err: err}
+	}
+	return results, nil
+}
+
+func (e *Exchange) AmendOrder(ctx context.Context, creds domain.APIKey, symbol, orderLinkID string, newPrice, newQty decimal.Decimal) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(creds.Key)
+	order, ok := acc.orders[orderLinkID]
+	if !ok || !order.IsActive() {
+		return fmt.Errorf("amend %s: %w", orderLinkID, errOrderNotFound)
+	}
+
+	if newPrice.IsPositive() {
+		order.Price = newPrice
+	}
+	if newQty.IsPositive() {
+		order.Qty = newQty
+	}
+	acc.orders[orderLinkID] = order
+
+	e.tryFill(acc, orderLinkID, false)
+	return nil
+}
+
+func (e *Exchange) CancelOrder(ctx context.Context, creds domain.APIKey, symbol, orderLinkID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(creds.Key)
+	order, ok := acc.orders[orderLinkID]
+	if !ok || !order.IsActive() {
+		return fmt.Errorf("cancel %s: %w", orderLinkID, errOrderNotFound)
+	}
+	order.Status = domain.OrderStatusCancelled
+	acc.orders[orderLinkID] = order
+	return nil
+}
+
+func (e *Exchange) GetOrder(ctx context.Context, creds domain.APIKey, symbol, orderLinkID string) (domain.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	order, ok := e.account(creds.Key).orders[orderLinkID]
+	if !ok {
+		return domain.Order{}, fmt.Errorf("order %s: %w", orderLinkID, domain.ErrOrderNotFound)
+	}
+	return order, nil
+}
+
+// placeOrder - под mu
+func (e *Exchange) placeOrder(apiKey string, req domain.OrderRequest) (string, error) {
+	e.orderSeq++
+	if e.failures.RejectOrder == e.orderSeq {
+		rejectErr := e.failures.RejectErr
+		if rejectErr == nil {
+			rejectErr = domain.ErrInsufficientMargin
+		}
+		return "", fmt.Errorf("injected rejection of order #%d: %w", e.orderSeq, rejectErr)
+	}
+
+	acc := e.account(apiKey)
+	if _, exists := acc.orders[req.OrderLinkID]; exists && req.OrderLinkID != "" {
+		return "", fmt.Errorf("order link id %s: %w", req.OrderLinkID, domain.ErrDuplicateOrderLinkID)
+	}
+	if _, err := e.markPrice(req.Symbol); err != nil {
+		return "", err
+	}
+	if !req.Qty.IsPositive() {
+		return "", fmt.Errorf("invalid qty %s", req.Qty)
+	}
+
+	linkID := req.OrderLinkID
+	if linkID == "" {
+		linkID = fmt.Sprintf("fake-%d", e.orderSeq)
+	}
+
+	order := domain.Order{
+		OrderID:     fmt.Sprintf("fake-order-%d", e.orderSeq),
+		OrderLinkID: linkID,
+		Symbol:      req.Symbol,
+		Side:        req.Side,
+		Status:      domain.OrderStatusNew,
+		Price:       req.Price,
+		Qty:         req.Qty,
+	}
+	acc.orders[linkID] = order
+
+	e.tryFill(acc, linkID, req.ReduceOnly)
+	if filled := acc.orders[linkID]; !filled.IsFilled() && req.TimeInForce == "IOC" {
+		filled.Status = domain.OrderStatusCancelled
+		acc.orders[linkID] = filled
+	}
+	if e.failures.LoseReply == e.orderSeq {
+		return "", fmt.Errorf("injected lost reply for order #%d: %w", e.orderSeq, domain.ErrExchangeUnavailable)
+	}
+	return order.OrderID, nil
+}
+
+// tryFill исполняет ордер по mark, если лимит его пересекает (рыночный - всегда)
+func (e *Exchange) tryFill(acc *account, linkID string, reduceOnly bool) {
+	order := acc.orders[linkID]
+	mark, err := e.markPrice(order.Symbol)
+	if err != nil {
+		return
+	}
+
+	crosses := order.Price.IsZero() ||
+		(order.Side == domain.SideBuy && order.Price.GreaterThanOrEqual(mark)) ||
+		(order.Side == domain.SideSell && order.Price.LessThanOrEqual(mark))
+	if !crosses {
+		return
+	}
+
+	qty := order.RemainingQty()
+	if reduceOnly {
+		pos := acc.positions[order.Symbol]
+		if pos.Side == order.Side || pos.Qty.IsZero() {
+			order.Status = domain.OrderStatusCancelled
+			acc.orders[linkID] = order
+			return
+		}
+		qty = decimal.Min(qty, pos.Qty)
+	}
+
+	e.applyFill(acc, order.Symbol, order.Side, qty, mark)
+
+	order.CumExecQty = order.CumExecQty.Add(qty)
+	order.AvgPrice = mark
+	order.Status = domain.OrderStatusFilled
+	acc.orders[linkID] = order
+}
+
+// applyFill меняет позицию на исполненный объем и пишет реализованный PnL при сокращении
+func (e *Exchange) applyFill(acc *account, symbol, side string, qty, price decimal.Decimal) {
+	pos, ok := acc.positions[symbol]
+	if !ok || pos.Qty.IsZero() {
+		acc.positions[symbol] = domain.Position{Symbol: symbol, Side: side, Qty: qty, EntryPrice: price}
+		return
+	}
+
+	if pos.Side == side {
+		// Наращиваем: средняя цена входа
+		total := pos.Qty.Add(qty)
+		pos.EntryPrice = pos.EntryPrice.Mul(pos.Qty).Add(price.Mul(qty)).Div(total)
+		pos.Qty = total
+		acc.positions[symbol] = pos
+		return
+	}
+
+	closed := decimal.Min(pos.Qty, qty)
+	pnl := price.Sub(pos.EntryPrice).Mul(closed)
+	if pos.Side == domain.SideSell {
+		pnl = pnl.Neg()
+	}
+	acc.closedPnL = append(acc.closedPnL, domain.ClosedPnL{
+		Symbol:        symbol,
+		Side:          side,
+		Qty:           closed,
+		AvgEntryPrice: pos.EntryPrice,
+		AvgExitPrice:  price,
+		ClosedPnL:     pnl,
+		CreatedAt:     e.now(),
+	})
+
+	rest := pos.Qty.Sub(qty)
+	switch {
+	case rest.IsZero():
+		delete(acc.positions, symbol)
+	case rest.IsPositive():
+		pos.Qty = rest
+		acc.positions[symbol] = pos
+	default:
+		// Переворот позиции
+		acc.positions[symbol] = domain.Position{Symbol: symbol, Side: side, Qty: rest.Neg(), EntryPrice: price}
+	}
+}
@@ -0,0 +1,195 @@
+package fakeexchange
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+const sourceFake = "fake-exchange"
+
+// Stream - MarketStreamer поверх Exchange: раз в interval публикует индексные цены подписанных символов
+type Stream struct {
+	exchange *Exchange
+	interval time.Duration
+
+	mu       sync.Mutex
+	symbols  map[string]bool
+	last     map[string]domain.PriceUpdateEvent
+	bySymbol map[string]map[chan domain.PriceUpdateEvent]struct{}
+	closed   bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewStream(exchange *Exchange, interval time.Duration) *Stream {
+	return &Stream{
+		exchange: exchange,
+		interval: interval,
+		symbols:  make(map[string]bool),
+		last:     make(map[string]domain.PriceUpdateEvent),
+		bySymbol: make(map[string]map[chan domain.PriceUpdateEvent]struct{}),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (s *Stream) Subscribe(ctx context.Context, symbols []string) (<-chan domain.PriceUpdateEvent, error) {
+	if err := s.AddSubscriptions(symbols); err != nil {
+		return nil, err
+	}
+
+	out := make(chan domain.PriceUpdateEvent, 100)
+	s.wg.Add(1)
+	go s.publish(ctx, out)
+	return out, nil
+}
+
+// SubscribeSymbol: тики идут, пока работает хотя бы один Subscribe
+func (s *Stream) SubscribeSymbol(symbol string) (<-chan domain.PriceUpdateEvent, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, errors.New("fake stream is closed")
+	}
+
+	ch := make(chan domain.PriceUpdateEvent, 100)
+	if s.bySymbol[symbol] == nil {
+		s.bySymbol[symbol] = make(map[chan domain.PriceUpdateEvent]struct{})
+	}
+	s.bySymbol[symbol][ch] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := s.bySymbol[symbol][ch]; ok {
+				delete(s.bySymbol[symbol], ch)
+				if len(s.bySymbol[symbol]) == 0 {
+					delete(s.bySymbol, symbol)
+				}
+				close(ch)
+			}
+		})
+	}
+	return ch, unsubscribe, nil
+}
+
+func (s *Stream) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		for _, subs := range s.bySymbol {
+			for ch := range subs {
+				close(ch)
+			}
+		}
+		s.bySymbol = nil
+	}
+	return nil
+}
+
+func (s *Stream) GetLastPrice(symbol string) (decimal.Decimal, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.last[symbol]
+	return event.Price, event.Time, ok
+}
+
+// Status: одно "соединение", которое никогда не рвется
+func (s *Stream) Status() domain.StreamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return domain.StreamStatus{
+		Source:      sourceFake,
+		Connections: []domain.ConnectionStatus{{Connected: !s.closed, Symbols: len(s.symbols)}},
+	}
+}
+
+// Health: фейковая биржа не замолкает, предупреждений не бывает
+func (s *Stream) Health() <-chan domain.StreamHealthEvent {
+	return nil
+}
+
+func (s *Stream) AddSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, symbol := range symbols {
+		s.symbols[symbol] = true
+	}
+	return nil
+}
+
+func (s *Stream) RemoveSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, symbol := range symbols {
+		delete(s.symbols, symbol)
+		delete(s.last, symbol)
+	}
+	return nil
+}
+
+func (s *Stream) publish(ctx context.Context, out chan<- domain.PriceUpdateEvent) {
+	defer s.wg.Done()
+	defer close(out)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		symbols := make([]string, 0, len(s.symbols)+len(s.bySymbol))
+		for symbol := range s.symbols {
+			symbols = append(symbols, symbol)
+		}
+		for symbol := range s.bySymbol {
+			if !s.symbols[symbol] {
+				symbols = append(symbols, symbol)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, symbol := range symbols {
+			price, ok := s.exchange.IndexPrice(symbol)
+			if !ok {
+				continue
+			}
+
+			event := domain.PriceUpdateEvent{Symbol: symbol, Price: price, Time: time.Now(), Source: sourceFake}
+			s.mu.Lock()
+			if s.symbols[symbol] {
+				s.last[symbol] = event
+				select {
+				case out <- event:
+				default:
+				}
+			}
+			for ch := range s.bySymbol[symbol] {
+				select {
+				case ch <- event:
+				default:
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
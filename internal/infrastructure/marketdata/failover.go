@@ -0,0 +1,275 @@
+package marketdata
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// Failover - MarketStreamer поверх двух источников: тики идут из основного, а по символам,
+// на которые сработал сторож тишины основного (StreamSilent), - из запасного. Первый же тик
+// основного по символу возвращает символ обратно. Запасной подписан всегда, чтобы
+// переключение было мгновенным.
+type Failover struct {
+	primary   domain.MarketStreamer
+	secondary domain.MarketStreamer
+	// Символ основного -> символ запасного; если символа нет в карте, он совпадает
+	toSecondary   map[string]string
+	fromSecondary map[string]string
+	logger        *slog.Logger
+
+	failedOver map[string]time.Time // Символы основного, которые сейчас берутся из запасного
+	mu         sync.Mutex
+
+	health chan domain.StreamHealthEvent
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewFailover(primary, secondary domain.MarketStreamer, symbolMap map[string]string) *Failover {
+	f := &Failover{
+		primary:       primary,
+		secondary:     secondary,
+		toSecondary:   make(map[string]string, len(symbolMap)),
+		fromSecondary: make(map[string]string, len(symbolMap)),
+		logger:        slog.Default().With("component", "market_failover"),
+		failedOver:    make(map[string]time.Time),
+		health:        make(chan domain.StreamHealthEvent, 10),
+		stop:          make(chan struct{}),
+	}
+	for from, to := range symbolMap {
+		f.toSecondary[from] = to
+		f.fromSecondary[to] = from
+	}
+
+	f.wg.Add(1)
+	go f.watchPrimary()
+	return f
+}
+
+func (f *Failover) Subscribe(ctx context.Context, symbols []string) (<-chan domain.PriceUpdateEvent, error) {
+	primaryCh, err := f.primary.Subscribe(ctx, symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	// Без запасного работаем как раньше: он нужен только на случай аварии основного
+	secondaryCh, err := f.secondary.Subscribe(ctx, f.mapSymbols(symbols))
+	if err != nil {
+		f.logger.Error("Fallback stream subscription failed", "err", err)
+	}
+
+	return f.merge(primaryCh, secondaryCh), nil
+}
+
+func (f *Failover) SubscribeSymbol(symbol string) (<-chan domain.PriceUpdateEvent, func(), error) {
+	primaryCh, primaryUnsub, err := f.primary.SubscribeSymbol(symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secondaryCh, secondaryUnsub, err := f.secondary.SubscribeSymbol(f.mapSymbol(symbol))
+	if err != nil {
+		f.logger.Error("Fallback stream subscription failed", "symbol", symbol, "err", err)
+		secondaryCh, secondaryUnsub = nil, func() {}
+	}
+
+	unsubscribe := func() {
+		primaryUnsub()
+		secondaryUnsub()
+	}
+	return f.merge(primaryCh, secondaryCh), unsubscribe, nil
+}
+
+func (f *Failover) AddSubscriptions(symbols []string) error {
+	if err := f.primary.AddSubscriptions(symbols); err != nil {
+		return err
+	}
+	if err := f.secondary.AddSubscriptions(f.mapSymbols(symbols)); err != nil {
+		f.logger.Error("Fallback stream subscription failed", "err", err)
+	}
+	return nil
+}
+
+func (f *Failover) RemoveSubscriptions(symbols []string) error {
+	f.mu.Lock()
+	for _, symbol := range symbols {
+		delete(f.failedOver, symbol)
+	}
+	f.mu.Unlock()
+
+	if err := f.secondary.RemoveSubscriptions(f.mapSymbols(symbols)); err != nil {
+		f.logger.Error("Fallback stream unsubscription failed", "err", err)
+	}
+	return f.primary.RemoveSubscriptions(symbols)
+}
+
+// GetLastPrice: пока символ на запасном, берем более свежую из двух цен
+func (f *Failover) GetLastPrice(symbol string) (decimal.Decimal, time.Time, bool) {
+	price, at, ok := f.primary.GetLastPrice(symbol)
+	if !f.isFailedOver(symbol) {
+		return price, at, ok
+	}
+
+	fallbackPrice, fallbackAt, fallbackOK := f.secondary.GetLastPrice(f.mapSymbol(symbol))
+	if fallbackOK && (!ok || fallbackAt.After(at)) {
+		return fallbackPrice, fallbackAt, true
+	}
+	return price, at, ok
+}
+
+func (f *Failover) Status() domain.StreamStatus {
+	primary := f.primary.Status()
+	secondary := f.secondary.Status()
+
+	return domain.StreamStatus{
+		Source:      primary.Source + " + " + secondary.Source,
+		Connections: append(primary.Connections, secondary.Connections...),
+		Stats: domain.StreamStats{
+			MessagesReceived:  primary.Stats.MessagesReceived + secondary.Stats.MessagesReceived,
+			EventsPublished:   primary.Stats.EventsPublished + secondary.Stats.EventsPublished,
+			EventsDropped:     primary.Stats.EventsDropped + secondary.Stats.EventsDropped,
+			Reconnects:        primary.Stats.Reconnects + secondary.Stats.Reconnects,
+			SubscribeFailures: primary.Stats.SubscribeFailures + secondary.Stats.SubscribeFailures,
+		},
+	}
+}
+
+// Health пересылает события основного источника
+func (f *Failover) Health() <-chan domain.StreamHealthEvent {
+	return f.health
+}
+
+func (f *Failover) Close() error {
+	f.stopOnce.Do(func() { close(f.stop) })
+
+	err := f.primary.Close()
+	if secondaryErr := f.secondary.Close(); err == nil {
+		err = secondaryErr
+	}
+	f.wg.Wait()
+	return err
+}
+
+func (f *Failover) watchPrimary() {
+	defer f.wg.Done()
+
+	health := f.primary.Health()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case event := <-health:
+			if event.Kind == domain.StreamSilent || event.Kind == domain.StreamDown {
+				f.failOver(event.Symbols)
+			}
+			select {
+			case f.health <- event:
+			default:
+			}
+		}
+	}
+}
+
+func (f *Failover) failOver(symbols []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for _, symbol := range symbols {
+		if _, ok := f.failedOver[symbol]; !ok {
+			f.failedOver[symbol] = now
+			f.logger.Warn("⚠️ Primary stream is silent, switching to fallback", "symbol", symbol, "fallback_symbol", f.mapSymbol(symbol))
+		}
+	}
+}
+
+func (f *Failover) recover(symbol string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if since, ok := f.failedOver[symbol]; ok {
+		delete(f.failedOver, symbol)
+		f.logger.Info("✅ Primary stream is back, leaving fallback", "symbol", symbol, "on_fallback", time.Since(since).Round(time.Second))
+	}
+}
+
+func (f *Failover) isFailedOver(symbol string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.failedOver[symbol]
+	return ok
+}
+
+// merge сводит два канала в один. Выход небуферизованный: пока потребитель занят,
+// вытеснение старых тиков остается за очередями самих источников.
+func (f *Failover) merge(primaryCh, secondaryCh <-chan domain.PriceUpdateEvent) <-chan domain.PriceUpdateEvent {
+	out := make(chan domain.PriceUpdateEvent)
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer close(out)
+
+		for {
+			var event domain.PriceUpdateEvent
+			select {
+			case <-f.stop:
+				return
+			case e, ok := <-primaryCh:
+				if !ok {
+					// Основной закрылся (ctx или Close) - вместе с ним заканчивается и подписка
+					return
+				}
+				f.recover(e.Symbol)
+				event = e
+			case e, ok := <-secondaryCh:
+				if !ok {
+					secondaryCh = nil
+					continue
+				}
+				symbol := f.unmapSymbol(e.Symbol)
+				if !f.isFailedOver(symbol) {
+					continue
+				}
+				e.Symbol = symbol
+				event = e
+			}
+
+			select {
+			case out <- event:
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (f *Failover) mapSymbol(symbol string) string {
+	if mapped, ok := f.toSecondary[symbol]; ok {
+		return mapped
+	}
+	return symbol
+}
+
+func (f *Failover) unmapSymbol(symbol string) string {
+	if mapped, ok := f.fromSecondary[symbol]; ok {
+		return mapped
+	}
+	return symbol
+}
+
+func (f *Failover) mapSymbols(symbols []string) []string {
+	mapped := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		mapped[i] = f.mapSymbol(symbol)
+	}
+	return mapped
+}
@@ -0,0 +1,198 @@
+package ticklog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+const filePrefix = "ticks-"
+
+// Config - запись тиков в NDJSON-файлы по дням: <Dir>/ticks-YYYY-MM-DD.ndjson
+type Config struct {
+	Dir           string
+	BufferSize    int // Емкость кольцевого буфера между сбросами
+	FlushInterval time.Duration
+	Retention     time.Duration // Файлы старше удаляются; 0 - хранить всегда
+}
+
+// Tick - строка файла записи
+type Tick struct {
+	Time    time.Time       `json:"t"`
+	Symbol  string          `json:"symbol"`
+	Price   decimal.Decimal `json:"price"`
+	Source  string          `json:"source"`
+	Testnet bool            `json:"testnet"`
+	Option  bool            `json:"option,omitempty"`
+}
+
+// Recorder копит тики в кольцевом буфере и сбрасывает их на диск в своей горутине.
+// Record только копирует тик под замком: запись не тормозит обработку цен, а при
+// переполнении буфера вытесняются самые старые тики.
+type Recorder struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	ring        []Tick
+	start       int // Индекс самого старого тика
+	count       int
+	overwritten int64 // Вытеснено с прошлого сброса
+}
+
+func NewRecorder(cfg Config, logger *slog.Logger) (*Recorder, error) {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create tick record dir: %w", err)
+	}
+
+	return &Recorder{
+		cfg:    cfg,
+		logger: logger.With("component", "tick_recorder"),
+		ring:   make([]Tick, cfg.BufferSize),
+	}, nil
+}
+
+func (r *Recorder) Record(event domain.PriceUpdateEvent, testnet, option bool) {
+	tick := Tick{
+		Time:    event.Time,
+		Symbol:  event.Symbol,
+		Price:   event.Price,
+		Source:  event.Source,
+		Testnet: testnet,
+		Option:  option,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count == len(r.ring) {
+		r.ring[r.start] = tick
+		r.start = (r.start + 1) % len(r.ring)
+		r.overwritten++
+		return
+	}
+	r.ring[(r.start+r.count)%len(r.ring)] = tick
+	r.count++
+}
+
+// Run сбрасывает буфер раз в FlushInterval и чистит старые файлы; при отмене ctx - финальный сброс
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	r.cleanup()
+	lastCleanup := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.flush()
+			return
+		case <-ticker.C:
+			r.flush()
+			if time.Since(lastCleanup) > time.Hour {
+				r.cleanup()
+				lastCleanup = time.Now()
+			}
+		}
+	}
+}
+
+func (r *Recorder) flush() {
+	r.mu.Lock()
+	ticks := make([]Tick, r.count)
+	for i := range ticks {
+		ticks[i] = r.ring[(r.start+i)%len(r.ring)]
+	}
+	overwritten := r.overwritten
+	r.start, r.count, r.overwritten = 0, 0, 0
+	r.mu.Unlock()
+
+	if overwritten > 0 {
+		r.logger.Warn("Tick buffer overflowed, oldest ticks were not recorded",
+			"lost", overwritten,
+			"buffer_size", len(r.ring))
+	}
+	if len(ticks) == 0 {
+		return
+	}
+
+	// Тики одного сброса могут попасть на границу суток
+	byFile := make(map[string][]Tick)
+	for _, tick := range ticks {
+		name := filepath.Join(r.cfg.Dir, filePrefix+tick.Time.UTC().Format("2006-01-02")+".ndjson")
+		byFile[name] = append(byFile[name], tick)
+	}
+	for name, fileTicks := range byFile {
+		if err := appendTicks(name, fileTicks); err != nil {
+			r.logger.Error("Failed to write ticks", "file", name, "count", len(fileTicks), "err", err)
+		}
+	}
+}
+
+func appendTicks(name string, ticks []Tick) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, tick := range ticks {
+		if err := enc.Encode(tick); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// cleanup удаляет файлы старше Retention (по дате в имени)
+func (r *Recorder) cleanup() {
+	if r.cfg.Retention <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(r.cfg.Dir)
+	if err != nil {
+		r.logger.Error("Failed to list tick records", "err", err)
+		return
+	}
+
+	cutoff := time.Now().UTC().Add(-r.cfg.Retention)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, ".ndjson") {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), ".ndjson"))
+		if err != nil || !day.Add(24*time.Hour).Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.cfg.Dir, name)); err != nil {
+			r.logger.Warn("Failed to remove old tick record", "file", name, "err", err)
+			continue
+		}
+		r.logger.Info("Removed old tick record", "file", name)
+	}
+}
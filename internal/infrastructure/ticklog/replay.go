@@ -0,0 +1,246 @@
+package ticklog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+const sourceReplay = "replay"
+
+// ReplayStream - MarketStreamer, проигрывающий записанные тики одной сети с исходными
+// интервалами (Speed > 1 ускоряет). Для локального режима: воспроизвести инцидент
+// на фейковой бирже детерминированно.
+type ReplayStream struct {
+	ticks []Tick
+	speed float64
+
+	mu       sync.Mutex
+	symbols  map[string]bool
+	last     map[string]domain.PriceUpdateEvent
+	bySymbol map[string]map[chan domain.PriceUpdateEvent]struct{}
+	started  bool
+	closed   bool
+	position int // Сколько тиков уже проиграно
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewReplayStream читает NDJSON-файл записи и берет из него тики нужной сети (опционные пропускаются)
+func NewReplayStream(path string, testnet bool, speed float64) (*ReplayStream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tick record: %w", err)
+	}
+	defer f.Close()
+
+	var ticks []Tick
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var tick Tick
+		if err := json.Unmarshal(scanner.Bytes(), &tick); err != nil {
+			return nil, fmt.Errorf("tick record %s line %d: %w", path, line, err)
+		}
+		if tick.Testnet == testnet && !tick.Option {
+			ticks = append(ticks, tick)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tick record: %w", err)
+	}
+
+	if speed <= 0 {
+		speed = 1
+	}
+	return &ReplayStream{
+		ticks:    ticks,
+		speed:    speed,
+		symbols:  make(map[string]bool),
+		last:     make(map[string]domain.PriceUpdateEvent),
+		bySymbol: make(map[string]map[chan domain.PriceUpdateEvent]struct{}),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Len - число тиков к проигрыванию
+func (s *ReplayStream) Len() int {
+	return len(s.ticks)
+}
+
+// Subscribe запускает проигрывание; запись проигрывается один раз, повторный Subscribe - ошибка
+func (s *ReplayStream) Subscribe(ctx context.Context, symbols []string) (<-chan domain.PriceUpdateEvent, error) {
+	if err := s.AddSubscriptions(symbols); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("replay stream is closed")
+	}
+	if s.started {
+		return nil, errors.New("replay stream is already playing")
+	}
+	s.started = true
+
+	out := make(chan domain.PriceUpdateEvent, 100)
+	s.wg.Add(1)
+	go s.play(ctx, out)
+	return out, nil
+}
+
+func (s *ReplayStream) SubscribeSymbol(symbol string) (<-chan domain.PriceUpdateEvent, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, errors.New("replay stream is closed")
+	}
+
+	ch := make(chan domain.PriceUpdateEvent, 100)
+	if s.bySymbol[symbol] == nil {
+		s.bySymbol[symbol] = make(map[chan domain.PriceUpdateEvent]struct{})
+	}
+	s.bySymbol[symbol][ch] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := s.bySymbol[symbol][ch]; ok {
+				delete(s.bySymbol[symbol], ch)
+				if len(s.bySymbol[symbol]) == 0 {
+					delete(s.bySymbol, symbol)
+				}
+				close(ch)
+			}
+		})
+	}
+	return ch, unsubscribe, nil
+}
+
+func (s *ReplayStream) AddSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, symbol := range symbols {
+		s.symbols[symbol] = true
+	}
+	return nil
+}
+
+func (s *ReplayStream) RemoveSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, symbol := range symbols {
+		delete(s.symbols, symbol)
+		delete(s.last, symbol)
+	}
+	return nil
+}
+
+func (s *ReplayStream) GetLastPrice(symbol string) (decimal.Decimal, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.last[symbol]
+	return event.Price, event.Time, ok
+}
+
+// Status: "соединение" живо, пока не проиграна вся запись
+func (s *ReplayStream) Status() domain.StreamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return domain.StreamStatus{
+		Source: sourceReplay,
+		Connections: []domain.ConnectionStatus{{
+			Connected: s.started && !s.closed && s.position < len(s.ticks),
+			Symbols:   len(s.symbols),
+		}},
+		Stats: domain.StreamStats{EventsPublished: int64(s.position)},
+	}
+}
+
+func (s *ReplayStream) Health() <-chan domain.StreamHealthEvent {
+	return nil
+}
+
+func (s *ReplayStream) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		for _, subs := range s.bySymbol {
+			for ch := range subs {
+				close(ch)
+			}
+		}
+		s.bySymbol = nil
+	}
+	return nil
+}
+
+func (s *ReplayStream) play(ctx context.Context, out chan<- domain.PriceUpdateEvent) {
+	defer s.wg.Done()
+	defer close(out)
+
+	for i, tick := range s.ticks {
+		if i > 0 {
+			gap := time.Duration(float64(tick.Time.Sub(s.ticks[i-1].Time)) / s.speed)
+			if gap > 0 {
+				select {
+				case <-time.After(gap):
+				case <-s.stop:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		// Время - текущее: для Manager и статуса это свежий тик, исходное время есть в записи
+		event := domain.PriceUpdateEvent{
+			Symbol: tick.Symbol,
+			Price:  tick.Price,
+			Time:   time.Now(),
+			Source: sourceReplay + ":" + tick.Source,
+		}
+
+		s.mu.Lock()
+		s.position = i + 1
+		subscribed := s.symbols[tick.Symbol]
+		if subscribed {
+			s.last[tick.Symbol] = event
+		}
+		for ch := range s.bySymbol[tick.Symbol] {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+		s.mu.Unlock()
+
+		if !subscribed {
+			continue
+		}
+		// Ждем потребителя: пропуск тика сделал бы воспроизведение недетерминированным
+		select {
+		case out <- event:
+		case <-s.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
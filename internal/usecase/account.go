@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// AccountNotReadyError - результат проверки аккаунта, совпадает с domain.ErrAccountNotReady
+type AccountNotReadyError struct {
+	Issues []domain.AccountIssue
+}
+
+func (e *AccountNotReadyError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = string(issue)
+	}
+	return fmt.Sprintf("%s: %s", domain.ErrAccountNotReady, strings.Join(issues, ", "))
+}
+
+func (e *AccountNotReadyError) Is(target error) bool {
+	return target == domain.ErrAccountNotReady
+}
+
+// KeyPermissionError - ключу не хватает прав для ролла опционов
+type KeyPermissionError struct {
+	Missing []string
+}
+
+func (e *KeyPermissionError) Error() string {
+	return fmt.Sprintf("%s: api key lacks permissions: %s", domain.ErrAccountNotReady, strings.Join(e.Missing, ", "))
+}
+
+func (e *KeyPermissionError) Is(target error) bool {
+	return target == domain.ErrAccountNotReady
+}
+
+// ValidateAPIKey проверяет права ключа через /v5/user/query-api.
+// Свойства ключа (срок действия) возвращаются и при нехватке прав.
+func ValidateAPIKey(ctx context.Context, exchange domain.ExchangeAdapter, creds domain.APIKey) (domain.APIKeyInfo, error) {
+	info, err := exchange.GetAPIKeyInfo(ctx, creds)
+	if err != nil {
+		return domain.APIKeyInfo{}, fmt.Errorf("fetch api key info: %w", err)
+	}
+
+	if missing := info.MissingPermissions(); len(missing) > 0 {
+		return info, &KeyPermissionError{Missing: missing}
+	}
+	return info, nil
+}
+
+// ValidateAccount проверяет, что аккаунт ключа может торговать опционами (UTA, не изолированная маржа)
+func ValidateAccount(ctx context.Context, exchange domain.ExchangeAdapter, creds domain.APIKey) error {
+	info, err := exchange.GetAccountInfo(ctx, creds)
+	if err != nil {
+		return fmt.Errorf("fetch account info: %w", err)
+	}
+
+	if issues := info.Issues(); len(issues) > 0 {
+		return &AccountNotReadyError{Issues: issues}
+	}
+	return nil
+}
+
+// diagnoseAccount дополняет ошибку ордера причиной из /v5/account/info, если биржа отказала из-за аккаунта
+func (s *RollerService) diagnoseAccount(ctx context.Context, apiKey domain.APIKey, err error) error {
+	if !errors.Is(err, domain.ErrAccountNotReady) {
+		return err
+	}
+
+	var notReady *AccountNotReadyError
+	if diagErr := ValidateAccount(ctx, s.exchange, apiKey); errors.As(diagErr, &notReady) {
+		return fmt.Errorf("%w (%s)", err, notReady.Error())
+	}
+	return err
+}
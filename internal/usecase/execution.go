@@ -0,0 +1,244 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// ExecutionModeIOC - агрессивная лимитка IOC с запасом от mark (поведение по умолчанию)
+	ExecutionModeIOC = "ioc"
+	// ExecutionModeChase - лимитка GTC от mark, которую двигаем через amend до исполнения
+	ExecutionModeChase = "chase"
+)
+
+// ExecutionConfig - как роллер выставляет ордера ног
+type ExecutionConfig struct {
+	Mode string
+
+	ChaseInterval time.Duration
+	// ChaseStep - на сколько (доля от mark) ордер сдвигается к исполнению за итерацию
+	ChaseStep decimal.Decimal
+	// ChaseMaxDistance - предельный сдвиг от mark, после него добиваем остаток через IOC
+	ChaseMaxDistance decimal.Decimal
+
+	// MaxAccountMMR - MMR аккаунта (0..1), с которого ролл не начинается и Leg 2 не открывается; 0 - без проверки
+	MaxAccountMMR decimal.Decimal
+}
+
+func DefaultExecutionConfig() ExecutionConfig {
+	return ExecutionConfig{
+		Mode:             ExecutionModeIOC,
+		ChaseInterval:    3 * time.Second,
+		ChaseStep:        decimal.NewFromFloat(0.02),
+		ChaseMaxDistance: decimal.NewFromFloat(0.10),
+		MaxAccountMMR:    decimal.NewFromFloat(0.9),
+	}
+}
+
+// executeOrder выставляет ордер ноги задачи taskID выбранным способом.
+// В req задаются символ, сторона, объем, reduceOnly и orderLinkId; цену и тип выбирает режим.
+func (s *RollerService) executeOrder(ctx context.Context, apiKey domain.APIKey, taskID int64, req domain.OrderRequest, markPrice decimal.Decimal, log *slog.Logger) error {
+	if s.execution.Mode == ExecutionModeChase {
+		return s.chaseOrder(ctx, apiKey, taskID, req, markPrice, log)
+	}
+	return s.placeAggressive(ctx, apiKey, taskID, req, markPrice, log)
+}
+
+func (s *RollerService) placeAggressive(ctx context.Context, apiKey domain.APIKey, taskID int64, req domain.OrderRequest, markPrice decimal.Decimal, log *slog.Logger) error {
+	req.OrderType = domain.OrderTypeLimit
+	req.Price = s.calculateSafeLimitPrice(req.Side, markPrice)
+	req.TimeInForce = "IOC"
+
+	log.Info("Placing aggressive IOC limit",
+		slog.String("symbol", req.Symbol),
+		slog.String("side", req.Side),
+		slog.String("mark_price", markPrice.String()),
+		slog.String("limit_price", req.Price.String()),
+		slog.String("qty", req.Qty.String()))
+
+	err := s.sendOrder(ctx, apiKey, taskID, req, log)
+	if errors.Is(err, domain.ErrDuplicateOrderLinkID) {
+		log.Warn("Order already placed earlier, continuing", slog.String("order_link_id", req.OrderLinkID))
+		return nil
+	}
+	if err == nil {
+		// IOC уже отработал: сохраняем в журнал, сколько исполнилось
+		if order, err := s.exchange.GetOrder(ctx, apiKey, req.Symbol, req.OrderLinkID); err == nil {
+			s.journalOrder(ctx, order, log)
+		}
+	}
+	return err
+}
+
+// chaseOrder ставит лимитку по mark и каждые ChaseInterval двигает ее к исполнению
+// через AmendOrder. Когда сдвиг превышает ChaseMaxDistance, ордер снимается,
+// а остаток добивается агрессивным IOC, чтобы не потерять гарантию исполнения.
+func (s *RollerService) chaseOrder(ctx context.Context, apiKey domain.APIKey, taskID int64, req domain.OrderRequest, markPrice decimal.Decimal, log *slog.Logger) error {
+	req.OrderType = domain.OrderTypeLimit
+	req.Price = markPrice
+	req.TimeInForce = "GTC"
+
+	log.Info("Starting chase",
+		slog.String("symbol", req.Symbol),
+		slog.String("side", req.Side),
+		slog.String("price", req.Price.String()),
+		slog.String("qty", req.Qty.String()))
+
+	err := s.sendOrder(ctx, apiKey, taskID, req, log)
+	if errors.Is(err, domain.ErrDuplicateOrderLinkID) {
+		// Ордер остался от прошлой попытки: продолжаем гнать его
+		log.Warn("Chase order already placed earlier, resuming", slog.String("order_link_id", req.OrderLinkID))
+	} else if err != nil {
+		return err
+	}
+
+	for step := 1; ; step++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.execution.ChaseInterval):
+		}
+
+		order, err := s.exchange.GetOrder(ctx, apiKey, req.Symbol, req.OrderLinkID)
+		if err != nil {
+			return fmt.Errorf("chase: fetch order %s: %w", req.OrderLinkID, err)
+		}
+		s.journalOrder(ctx, order, log)
+
+		if order.IsFilled() {
+			log.Info("Chase order filled",
+				slog.String("order_link_id", req.OrderLinkID),
+				slog.String("avg_price", order.AvgPrice.String()),
+				slog.Int("steps", step))
+			return nil
+		}
+
+		offset := s.execution.ChaseStep.Mul(decimal.NewFromInt(int64(step)))
+		if !order.IsActive() || offset.GreaterThan(s.execution.ChaseMaxDistance) {
+			return s.finishChase(ctx, apiKey, taskID, req, order, log)
+		}
+
+		if mark, err := s.exchange.GetMarkPrice(ctx, req.Symbol); err == nil {
+			markPrice = mark
+		} else {
+			log.Warn("Chase: mark price unavailable, using previous", slog.String("err", err.Error()))
+		}
+
+		newPrice := chasePrice(req.Side, markPrice, offset)
+		if err := s.exchange.AmendOrder(ctx, apiKey, req.Symbol, req.OrderLinkID, newPrice, decimal.Zero); err != nil {
+			// Ордер мог исполниться между GetOrder и amend - следующая итерация это увидит
+			log.Warn("Chase amend failed", slog.String("price", newPrice.String()), slog.String("err", err.Error()))
+			continue
+		}
+
+		log.Info("Chase re-priced",
+			slog.String("order_link_id", req.OrderLinkID),
+			slog.String("mark_price", markPrice.String()),
+			slog.String("price", newPrice.String()),
+			slog.Int("step", step))
+	}
+}
+
+// finishChase снимает лимитку и добивает неисполненный остаток через IOC
+func (s *RollerService) finishChase(ctx context.Context, apiKey domain.APIKey, taskID int64, req domain.OrderRequest, order domain.Order, log *slog.Logger) error {
+	if order.IsActive() {
+		if err := s.exchange.CancelOrder(ctx, apiKey, req.Symbol, req.OrderLinkID); err != nil {
+			log.Warn("Chase cancel failed", slog.String("err", err.Error()))
+		}
+		// Перечитываем: между последней проверкой и отменой могли пройти сделки
+		if fresh, err := s.exchange.GetOrder(ctx, apiKey, req.Symbol, req.OrderLinkID); err == nil {
+			order = fresh
+			s.journalOrder(ctx, order, log)
+		}
+	}
+
+	if order.IsFilled() {
+		return nil
+	}
+
+	remaining := order.RemainingQty()
+	if !remaining.IsPositive() {
+		return nil
+	}
+
+	markPrice, err := s.exchange.GetMarkPrice(ctx, req.Symbol)
+	if err != nil {
+		return fmt.Errorf("chase fallback: mark price: %w", err)
+	}
+
+	log.Warn("Chase limit reached, sending IOC for remainder",
+		slog.String("order_link_id", req.OrderLinkID),
+		slog.String("remaining", remaining.String()))
+
+	fallback := req
+	fallback.Qty = remaining
+	fallback.OrderLinkID = req.OrderLinkID + "-ioc"
+	return s.placeAggressive(ctx, apiKey, taskID, fallback, markPrice, log)
+}
+
+// sendOrder отправляет ордер на биржу, записав его в журнал до отправки и ответ биржи после.
+// Сбой журнала ордер не останавливает: незакрытая нога опаснее пропуска в истории.
+// Журнал пишется и при отмене ctx, иначе при остановке бота потеряется именно то, что ушло на биржу.
+func (s *RollerService) sendOrder(ctx context.Context, apiKey domain.APIKey, taskID int64, req domain.OrderRequest, log *slog.Logger) error {
+	journalCtx := context.WithoutCancel(ctx)
+	if s.orders != nil {
+		rec := &domain.OrderRecord{
+			TaskID:      taskID,
+			OrderLinkID: req.OrderLinkID,
+			Symbol:      req.Symbol,
+			Side:        req.Side,
+			OrderType:   req.OrderType,
+			Qty:         req.Qty,
+			Price:       req.Price,
+			TimeInForce: req.TimeInForce,
+			ReduceOnly:  req.ReduceOnly,
+		}
+		if err := s.orders.Record(journalCtx, rec); err != nil {
+			log.Error("Order journal write failed, sending anyway", slog.String("order_link_id", req.OrderLinkID), slog.String("err", err.Error()))
+		}
+	}
+
+	orderID, err := s.exchange.PlaceOrder(ctx, apiKey, req)
+	if s.orders == nil {
+		return err
+	}
+
+	var journalErr error
+	switch {
+	case err == nil:
+		journalErr = s.orders.MarkSent(journalCtx, req.OrderLinkID, orderID)
+	case errors.Is(err, domain.ErrDuplicateOrderLinkID):
+		// Ордер с этим orderLinkId уже на бирже: его строка в журнале от первой попытки
+	default:
+		journalErr = s.orders.MarkFailed(journalCtx, req.OrderLinkID, err.Error())
+	}
+	if journalErr != nil {
+		log.Error("Order journal update failed", slog.String("order_link_id", req.OrderLinkID), slog.String("err", journalErr.Error()))
+	}
+	return err
+}
+
+// journalOrder переносит в журнал состояние ордера на бирже (исполнение, цену после amend)
+func (s *RollerService) journalOrder(ctx context.Context, order domain.Order, log *slog.Logger) {
+	if s.orders == nil {
+		return
+	}
+	if err := s.orders.UpdateFromExchange(context.WithoutCancel(ctx), order); err != nil {
+		log.Warn("Order journal update failed", slog.String("order_link_id", order.OrderLinkID), slog.String("err", err.Error()))
+	}
+}
+
+// chasePrice - цена, сдвинутая от mark на offset в сторону исполнения
+func chasePrice(side string, markPrice, offset decimal.Decimal) decimal.Decimal {
+	if side == domain.SideBuy {
+		return markPrice.Mul(decimal.NewFromInt(1).Add(offset))
+	}
+	return markPrice.Mul(decimal.NewFromInt(1).Sub(offset))
+}
@@ -0,0 +1,243 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// leg1Recovery - что делать с задачей, ролл которой прервался в ROLL_INITIATED
+// (статус уже переключен, а LEG1_CLOSED еще не сохранен)
+type leg1Recovery int
+
+const (
+	// Закрывающий ордер не выставлялся или умер без исполнения: ролла не было, задача снова ждет триггер
+	leg1ResetIdle leg1Recovery = iota
+	// Ордер еще в стакане: снимаем его и закрываем остаток, вторая нога - на весь закрытый объем
+	leg1Retry
+	// Позицию закрыл наш ордер, не успели сохранить LEG1_CLOSED: открываем вторую ногу
+	leg1ResumeLeg2
+	// Позиции нет, а нашего исполнения нет: позицию закрыли вне бота
+	leg1Complete
+	// Ордер снят после частичного исполнения: закрываем остаток и открываем вторую ногу на весь объем
+	leg1CloseRest
+)
+
+func (r leg1Recovery) String() string {
+	switch r {
+	case leg1ResetIdle:
+		return "reset_idle"
+	case leg1Retry:
+		return "retry_leg1"
+	case leg1ResumeLeg2:
+		return "resume_leg2"
+	case leg1Complete:
+		return "complete"
+	case leg1CloseRest:
+		return "close_rest"
+	}
+	return "unknown"
+}
+
+// decideLeg1Recovery - таблица решений по позиции на бирже и закрывающим ордерам ноги
+// (найденным из leg1CloseLinkIDs); исполнение - сумма cumExecQty всех ордеров:
+//
+//	позиция   ордера                                   решение
+//	0         есть исполнение                          resume_leg2
+//	0         нет / без исполнения                     complete
+//	открыта   какой-то в стакане                       retry_leg1
+//	открыта   сняты после частичного исполнения        close_rest
+//	открыта   нет / сняты / отклонены без исполнения   reset_idle
+func decideLeg1Recovery(positionQty decimal.Decimal, orders []domain.Order) leg1Recovery {
+	filled := closedQty(orders).IsPositive()
+	active := slices.ContainsFunc(orders, func(o domain.Order) bool { return o.IsActive() })
+	switch {
+	case positionQty.IsZero() && filled:
+		return leg1ResumeLeg2
+	case positionQty.IsZero():
+		return leg1Complete
+	case active:
+		return leg1Retry
+	case filled:
+		return leg1CloseRest
+	default:
+		return leg1ResetIdle
+	}
+}
+
+// leg1CloseLinkIDs - закрывающие ордера ноги: основной, его добивающий IOC в режиме chase (-ioc)
+// и закрытие остатка при восстановлении (-rest, в chase с собственным -ioc)
+func leg1CloseLinkIDs(task *domain.Task) []string {
+	base := closeOrderLinkID(task)
+	return []string{base, base + "-ioc", base + "-rest", base + "-rest-ioc"}
+}
+
+// closedQty - сколько позиции закрыли ордера вместе
+func closedQty(orders []domain.Order) decimal.Decimal {
+	total := decimal.Zero
+	for _, o := range orders {
+		total = total.Add(o.CumExecQty)
+	}
+	return total
+}
+
+// recoverLeg1 доводит ролл, прерванный до сохранения LEG1_CLOSED: по бирже выясняет,
+// успели ли уйти закрывающие ордера, и решает по decideLeg1Recovery
+func (s *RollerService) recoverLeg1(ctx context.Context, apiKey domain.APIKey, task *domain.Task, log *slog.Logger) error {
+	position, err := s.exchange.GetPosition(ctx, apiKey, task.CurrentOptionSymbol)
+	if err != nil {
+		// Статус не трогаем: восстановление повторится при следующей сверке
+		return fmt.Errorf("recovery: fetch position: %w", err)
+	}
+
+	var closeOrders []domain.Order
+	for _, linkID := range leg1CloseLinkIDs(task) {
+		order, err := s.exchange.GetOrder(ctx, apiKey, task.CurrentOptionSymbol, linkID)
+		switch {
+		case err == nil:
+			closeOrders = append(closeOrders, order)
+			s.journalOrder(ctx, order, log)
+		case !errors.Is(err, domain.ErrOrderNotFound):
+			return fmt.Errorf("recovery: fetch order %s: %w", linkID, err)
+		}
+	}
+
+	// Решает биржа; журнал показывает, что бот успел отправить (Pending - ответа не дождались)
+	orderLinkID := closeOrderLinkID(task)
+	journalStatus := "none"
+	if s.orders != nil {
+		if rec, err := s.orders.GetByOrderLinkID(ctx, orderLinkID); err != nil {
+			log.Warn("Order journal lookup failed", slog.String("err", err.Error()))
+		} else if rec != nil {
+			journalStatus = rec.Status
+		}
+	}
+
+	filled := closedQty(closeOrders)
+	decision := decideLeg1Recovery(position.Qty, closeOrders)
+	log.Warn("🔎 Interrupted Leg 1 checked",
+		slog.String("decision", decision.String()),
+		slog.String("position_qty", position.Qty.String()),
+		slog.String("order_link_id", orderLinkID),
+		slog.Int("orders_found", len(closeOrders)),
+		slog.String("filled_qty", filled.String()),
+		slog.String("journal_status", journalStatus))
+
+	switch decision {
+	case leg1ResumeLeg2:
+		task.CurrentQty = filled
+		s.saveLeg1Checkpoint(ctx, task, log)
+		return s.finishLeg2(ctx, apiKey, task, log)
+
+	case leg1Retry:
+		closed, err := s.resumeLeg1(ctx, apiKey, task, closeOrders, log)
+		if err != nil {
+			return err
+		}
+		task.CurrentQty = closed
+		s.saveLeg1Checkpoint(ctx, task, log)
+		return s.finishLeg2(ctx, apiKey, task, log)
+
+	case leg1CloseRest:
+		closed, err := s.closeRest(ctx, apiKey, task, position, filled, log)
+		if err != nil {
+			return err
+		}
+		task.CurrentQty = closed
+		s.saveLeg1Checkpoint(ctx, task, log)
+		return s.finishLeg2(ctx, apiKey, task, log)
+
+	case leg1Complete:
+		if err := s.taskRepo.UpdateTaskStateWithEvent(ctx, task.ID, domain.TaskStateCompleted, task.Version,
+			domain.TaskEventCompleted, "position closed outside the bot during an interrupted roll"); err != nil {
+			return err
+		}
+		s.notify(task, fmt.Sprintf("ℹ️ Позиции %s на бирже нет: ее закрыли вне бота, пока ролл был прерван. Задача #%d завершена.", task.CurrentOptionSymbol, task.ID))
+		return nil
+
+	default:
+		log.Warn("↩️ Roll never reached the exchange, task reset to IDLE", slog.String("order_link_id", orderLinkID))
+		return s.taskRepo.UpdateTaskStateWithEvent(ctx, task.ID, domain.TaskStateIdle, task.Version,
+			domain.TaskEventRollReset, fmt.Sprintf("close order %s was not executed", orderLinkID))
+	}
+}
+
+// resumeLeg1 доводит закрытие, когда ордер прерванного ролла еще в стакане. Гнать его дальше
+// некому, а повторный processLeg1 взял бы объем второй ноги по остатку позиции и потерял
+// исполненное до сбоя. Поэтому ордера снимаем и закрываем остаток; возвращает весь закрытый объем.
+func (s *RollerService) resumeLeg1(ctx context.Context, apiKey domain.APIKey, task *domain.Task, orders []domain.Order, log *slog.Logger) (decimal.Decimal, error) {
+	for i, order := range orders {
+		if !order.IsActive() {
+			continue
+		}
+		if err := s.exchange.CancelOrder(ctx, apiKey, task.CurrentOptionSymbol, order.OrderLinkID); err != nil {
+			// Мог исполниться до отмены: решает перечитанный ордер
+			log.Warn("Cancel of interrupted close order failed", slog.String("order_link_id", order.OrderLinkID), slog.String("err", err.Error()))
+		}
+		fresh, err := s.exchange.GetOrder(ctx, apiKey, task.CurrentOptionSymbol, order.OrderLinkID)
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("recovery: fetch order %s after cancel: %w", order.OrderLinkID, err)
+		}
+		if fresh.IsActive() {
+			return decimal.Zero, fmt.Errorf("recovery: close order %s still active after cancel", order.OrderLinkID)
+		}
+		s.journalOrder(ctx, fresh, log)
+		orders[i] = fresh
+	}
+
+	// Позицию перечитываем после отмены: до нее ордер мог добрать исполнение
+	position, err := s.exchange.GetPosition(ctx, apiKey, task.CurrentOptionSymbol)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("recovery: fetch position after cancel: %w", err)
+	}
+	filled := closedQty(orders)
+	if position.Qty.IsZero() {
+		if !filled.IsPositive() {
+			return decimal.Zero, fmt.Errorf("recovery: position %s gone without our fill", task.CurrentOptionSymbol)
+		}
+		return filled, nil
+	}
+	return s.closeRest(ctx, apiKey, task, position, filled, log)
+}
+
+// closeRest закрывает остаток позиции после частично исполненных и снятых закрывающих ордеров.
+// Возвращает весь закрытый объем: filled прежних ордеров плюс то, что ушло из позиции сейчас.
+// Если остаток не закрылся совсем, задача остается в ROLL_INITIATED до следующей сверки.
+func (s *RollerService) closeRest(ctx context.Context, apiKey domain.APIKey, task *domain.Task, position domain.Position, filled decimal.Decimal, log *slog.Logger) (decimal.Decimal, error) {
+	markPrice, err := s.exchange.GetMarkPrice(ctx, task.CurrentOptionSymbol)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("recovery: mark price: %w", err)
+	}
+	closeSide := domain.SideBuy
+	if position.Side == domain.SideBuy {
+		closeSide = domain.SideSell
+	}
+
+	err = s.executeOrder(ctx, apiKey, task.ID, domain.OrderRequest{
+		Symbol:      task.CurrentOptionSymbol,
+		Side:        closeSide,
+		Qty:         position.Qty,
+		ReduceOnly:  true,
+		OrderLinkID: closeOrderLinkID(task) + "-rest",
+	}, markPrice, log)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	// Объем считаем по позиции: в режиме chase остаток добивается отдельным IOC ордером
+	after, err := s.exchange.GetPosition(ctx, apiKey, task.CurrentOptionSymbol)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("recovery: fetch position after close: %w", err)
+	}
+	closedNow := position.Qty.Sub(after.Qty)
+	if !closedNow.IsPositive() {
+		return decimal.Zero, fmt.Errorf("recovery: rest of position %s not closed (%s left)", task.CurrentOptionSymbol, after.Qty)
+	}
+	log.Info("Rest of Leg 1 closed", slog.String("filled_before", filled.String()), slog.String("closed_now", closedNow.String()))
+	return filled.Add(closedNow), nil
+}
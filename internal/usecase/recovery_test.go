@@ -0,0 +1,258 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+func TestDecideLeg1Recovery(t *testing.T) {
+	qty := decimal.NewFromFloat(0.1)
+	part := decimal.NewFromFloat(0.04)
+	order := func(status string, filled decimal.Decimal) domain.Order {
+		return domain.Order{Status: status, Qty: qty, CumExecQty: filled}
+	}
+	// ioc - добивающий ордер режима chase на остаток после снятой лимитки
+	ioc := func(status string, filled decimal.Decimal) domain.Order {
+		return domain.Order{Status: status, Qty: qty.Sub(part), CumExecQty: filled}
+	}
+
+	orders := []struct {
+		name   string
+		orders []domain.Order
+	}{
+		{"no order", nil},
+		{"new", []domain.Order{order(domain.OrderStatusNew, decimal.Zero)}},
+		{"partially filled", []domain.Order{order(domain.OrderStatusPartiallyFilled, part)}},
+		{"filled", []domain.Order{order(domain.OrderStatusFilled, qty)}},
+		{"cancelled after partial fill", []domain.Order{order(domain.OrderStatusCancelled, part)}},
+		{"cancelled", []domain.Order{order(domain.OrderStatusCancelled, decimal.Zero)}},
+		{"rejected", []domain.Order{order(domain.OrderStatusRejected, decimal.Zero)}},
+		{"chase cancelled, ioc filled", []domain.Order{
+			order(domain.OrderStatusCancelled, decimal.Zero), {Status: domain.OrderStatusFilled, Qty: qty, CumExecQty: qty},
+		}},
+		{"chase partial, ioc filled rest", []domain.Order{
+			order(domain.OrderStatusCancelled, part), ioc(domain.OrderStatusFilled, qty.Sub(part)),
+		}},
+		{"chase partial, ioc missed", []domain.Order{
+			order(domain.OrderStatusCancelled, part), ioc(domain.OrderStatusCancelled, decimal.Zero),
+		}},
+		{"chase cancelled, ioc missed", []domain.Order{
+			order(domain.OrderStatusCancelled, decimal.Zero), ioc(domain.OrderStatusCancelled, decimal.Zero),
+		}},
+	}
+	want := map[string][2]leg1Recovery{ // [позиция 0, позиция открыта]
+		"no order":                       {leg1Complete, leg1ResetIdle},
+		"new":                            {leg1Complete, leg1Retry},
+		"partially filled":               {leg1ResumeLeg2, leg1Retry},
+		"filled":                         {leg1ResumeLeg2, leg1CloseRest},
+		"cancelled after partial fill":   {leg1ResumeLeg2, leg1CloseRest},
+		"cancelled":                      {leg1Complete, leg1ResetIdle},
+		"rejected":                       {leg1Complete, leg1ResetIdle},
+		"chase cancelled, ioc filled":    {leg1ResumeLeg2, leg1CloseRest},
+		"chase partial, ioc filled rest": {leg1ResumeLeg2, leg1CloseRest},
+		"chase partial, ioc missed":      {leg1ResumeLeg2, leg1CloseRest},
+		"chase cancelled, ioc missed":    {leg1Complete, leg1ResetIdle},
+	}
+
+	for _, o := range orders {
+		for i, position := range []decimal.Decimal{decimal.Zero, qty} {
+			name := o.name + "/position " + position.String()
+			t.Run(name, func(t *testing.T) {
+				if got := decideLeg1Recovery(position, o.orders); got != want[o.name][i] {
+					t.Fatalf("got %s, want %s", got, want[o.name][i])
+				}
+			})
+		}
+	}
+}
+
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (n *recordingNotifier) NotifyUser(userID int64, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+func (n *recordingNotifier) NotifyAdmin(string) error { return nil }
+
+// interrupted - задача, ролл которой прервался в ROLL_INITIATED
+func (e *rollerEnv) interrupted(t *testing.T) *domain.Task {
+	t.Helper()
+	task := e.fx.Task(t, e.key, e.symbol, 59000, domain.TaskStateRollInitiated)
+	return e.fx.Reload(t, task.ID)
+}
+
+func (e *rollerEnv) lastEvent(t *testing.T, taskID int64) domain.TaskEvent {
+	t.Helper()
+	events, err := e.fx.Tasks.ListTaskEvents(context.Background(), taskID, 1)
+	if err != nil || len(events) == 0 {
+		t.Fatalf("no task events: %v", err)
+	}
+	return events[0]
+}
+
+func TestRecoverLeg1ClosesRest(t *testing.T) {
+	env := newRollerEnv(t)
+	task := env.interrupted(t)
+
+	// Закрывающий IOC взял 0.04 из 0.1 и был снят, на бирже осталось 0.06
+	pos, _ := env.exchange.GetPosition(context.Background(), *env.key, env.symbol)
+	pos.Qty = decimal.NewFromFloat(0.06)
+	env.exchange.SetPosition(env.key.Key, pos)
+	env.exchange.SetOrder(env.key.Key, domain.Order{
+		OrderLinkID: closeOrderLinkID(task),
+		Symbol:      env.symbol,
+		Side:        string(domain.SideBuy),
+		Status:      domain.OrderStatusCancelled,
+		Qty:         decimal.NewFromFloat(0.1),
+		CumExecQty:  decimal.NewFromFloat(0.04),
+	})
+
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.Zero); err != nil {
+		t.Fatalf("recovery: %v", err)
+	}
+	if qty := env.positionQty(t, env.symbol); !qty.IsZero() {
+		t.Fatalf("old leg qty %s, want 0", qty)
+	}
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.RollCount != 1 {
+		t.Fatalf("roll not finished: status %s, rolls %d", got.Status, got.RollCount)
+	}
+	if qty := env.positionQty(t, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("new leg qty %s, want 0.1", qty)
+	}
+}
+
+func TestRecoverLeg1CountsChaseIOC(t *testing.T) {
+	tests := []struct {
+		name      string
+		gtcFilled float64 // исполнено лимиткой chase до снятия, остальное закрыл ее -ioc
+	}{
+		{"ioc closed everything", 0},
+		{"ioc closed the rest", 0.04},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newRollerEnv(t)
+			task := env.interrupted(t)
+			qty := decimal.NewFromFloat(0.1)
+			gtcFilled := decimal.NewFromFloat(tt.gtcFilled)
+
+			env.exchange.SetPosition(env.key.Key, domain.Position{Symbol: env.symbol})
+			env.exchange.SetOrder(env.key.Key, domain.Order{
+				OrderLinkID: closeOrderLinkID(task), Symbol: env.symbol, Side: string(domain.SideBuy),
+				Status: domain.OrderStatusCancelled, Qty: qty, CumExecQty: gtcFilled,
+			})
+			env.exchange.SetOrder(env.key.Key, domain.Order{
+				OrderLinkID: closeOrderLinkID(task) + "-ioc", Symbol: env.symbol, Side: string(domain.SideBuy),
+				Status: domain.OrderStatusFilled, Qty: qty.Sub(gtcFilled), CumExecQty: qty.Sub(gtcFilled),
+			})
+
+			if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.Zero); err != nil {
+				t.Fatalf("recovery: %v", err)
+			}
+			// Позицию закрыл бот, а не кто-то вне его: ролл доводится, вторая нога на весь объем
+			got := env.fx.Reload(t, task.ID)
+			if got.Status != domain.TaskStateIdle || got.RollCount != 1 {
+				t.Fatalf("roll not finished: status %s, rolls %d", got.Status, got.RollCount)
+			}
+			if qty := env.positionQty(t, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+				t.Fatalf("new leg qty %s, want 0.1", qty)
+			}
+		})
+	}
+}
+
+func TestRecoverLeg1CompleteNotifies(t *testing.T) {
+	env := newRollerEnv(t)
+	notifier := &recordingNotifier{}
+	env.roller.notifier = notifier
+	task := env.interrupted(t)
+	env.exchange.SetPosition(env.key.Key, domain.Position{Symbol: env.symbol})
+
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.Zero); err != nil {
+		t.Fatalf("recovery: %v", err)
+	}
+	if got := env.fx.Reload(t, task.ID); got.Status != domain.TaskStateCompleted {
+		t.Fatalf("status %s, want COMPLETED", got.Status)
+	}
+	if ev := env.lastEvent(t, task.ID); ev.Type != domain.TaskEventCompleted || ev.Details == "" {
+		t.Fatalf("event %+v", ev)
+	}
+	if len(notifier.messages) != 1 {
+		t.Fatalf("notifications %q, want one", notifier.messages)
+	}
+}
+
+func TestRecoverLeg1ResetWritesEvent(t *testing.T) {
+	env := newRollerEnv(t)
+	task := env.interrupted(t)
+
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.Zero); err != nil {
+		t.Fatalf("recovery: %v", err)
+	}
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.RollCount != 0 {
+		t.Fatalf("status %s, rolls %d", got.Status, got.RollCount)
+	}
+	if ev := env.lastEvent(t, task.ID); ev.Type != domain.TaskEventRollReset || ev.FromSymbol != env.symbol {
+		t.Fatalf("event %+v", ev)
+	}
+	if qty := env.positionQty(t, env.symbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("position touched: qty %s", qty)
+	}
+}
+
+func TestRecoverLeg1ActivePartiallyFilled(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{"ioc", ExecutionModeIOC},
+		{"chase", ExecutionModeChase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newRollerEnv(t)
+			env.roller.execution.Mode = tt.mode
+			env.roller.execution.ChaseInterval = 10 * time.Millisecond
+			task := env.interrupted(t)
+
+			// Лимитка chase успела взять 0.04 из 0.1 и еще стоит в стакане, на бирже осталось 0.06
+			env.exchange.SetPosition(env.key.Key, domain.Position{Symbol: env.symbol, Side: domain.SideSell, Qty: decimal.NewFromFloat(0.06)})
+			env.exchange.SetOrder(env.key.Key, domain.Order{
+				OrderLinkID: closeOrderLinkID(task), Symbol: env.symbol, Side: string(domain.SideBuy),
+				Status: domain.OrderStatusPartiallyFilled, Price: decimal.NewFromInt(1),
+				Qty: decimal.NewFromFloat(0.1), CumExecQty: decimal.NewFromFloat(0.04),
+			})
+
+			if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.Zero); err != nil {
+				t.Fatalf("recovery: %v", err)
+			}
+			if order, _ := env.exchange.GetOrder(context.Background(), *env.key, env.symbol, closeOrderLinkID(task)); order.IsActive() {
+				t.Fatalf("interrupted close order left in the book: %+v", order)
+			}
+			if qty := env.positionQty(t, env.symbol); !qty.IsZero() {
+				t.Fatalf("old leg qty %s, want 0", qty)
+			}
+			got := env.fx.Reload(t, task.ID)
+			if got.Status != domain.TaskStateIdle || got.RollCount != 1 {
+				t.Fatalf("roll not finished: status %s, rolls %d", got.Status, got.RollCount)
+			}
+			// Вторая нога на весь закрытый объем, включая исполненное до сбоя
+			if qty := env.positionQty(t, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+				t.Fatalf("new leg qty %s, want 0.1", qty)
+			}
+		})
+	}
+}
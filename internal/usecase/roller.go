@@ -2,6 +2,7 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time" // <--- 1. Импорт добавлен
@@ -10,17 +11,25 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+const leg2RetryDelay = 3 * time.Second
+
 type RollerService struct {
-	exchange domain.ExchangeAdapter
-	taskRepo domain.TaskRepository
-	logger   *slog.Logger
+	exchange  domain.ExchangeAdapter
+	taskRepo  domain.TaskRepository
+	orders    domain.OrderRepository // журнал ордеров, может быть nil
+	notifier  domain.NotificationService // может быть nil
+	execution ExecutionConfig
+	logger    *slog.Logger
 }
 
-func NewRollerService(exchange domain.ExchangeAdapter, taskRepo domain.TaskRepository, logger *slog.Logger) *RollerService {
+func NewRollerService(exchange domain.ExchangeAdapter, taskRepo domain.TaskRepository, orders domain.OrderRepository, notifier domain.NotificationService, execution ExecutionConfig, logger *slog.Logger) *RollerService {
 	return &RollerService{
-		exchange: exchange,
-		taskRepo: taskRepo,
-		logger:   logger,
+		exchange:  exchange,
+		taskRepo:  taskRepo,
+		orders:    orders,
+		notifier:  notifier,
+		execution: execution,
+		logger:    logger,
 	}
 }
 
@@ -30,10 +39,17 @@ func (s *RollerService) ExecuteRoll(ctx context.Context, apiKey domain.APIKey, t
 		slog.String("symbol", task.UnderlyingSymbol),
 	)
 
+	// Цены и страйки берем из той же сети, что и ключ
+	ctx = domain.WithKeyNetwork(ctx, apiKey)
+
 	// 1. RECOVERY MODE (не требует проверки цены)
-	if task.Status == domain.TaskStateLeg1Closed {
+	switch task.Status {
+	case domain.TaskStateLeg1Closed:
 		log.Warn("⚠️ RECOVERY MODE: Resuming to prevent naked position.")
-		return s.processLeg2(ctx, apiKey, task, log)
+		return s.finishLeg2(ctx, apiKey, task, log)
+	case domain.TaskStateRollInitiated:
+		log.Warn("⚠️ RECOVERY MODE: Checking interrupted Leg 1.")
+		return s.recoverLeg1(ctx, apiKey, task, log)
 	}
 
 	// 2. TRIGGER CHECK (на основе ПЕРЕДАННОЙ цены)
@@ -45,29 +61,94 @@ func (s *RollerService) ExecuteRoll(ctx context.Context, apiKey domain.APIKey, t
 		slog.String("price", currentPrice.String()), 
 		slog.String("trigger", task.TriggerPrice.String()))
 
+	// Маржа у границы ликвидации: ролл откладываем до смены статуса, задача просто ждет
+	// следующего тика - без версии, ошибки задачи и счета в бюджете ошибок
+	if err := s.checkMargin(ctx, apiKey, "roll", log); err != nil {
+		log.Warn("Roll postponed", slog.String("err", err.Error()))
+		return nil
+	}
+
 	// 3. Блокировка и выполнение (Optimistic Locking)
 	if err := s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateRollInitiated, task.Version); err != nil {
 		return nil // Кто-то другой уже начал ролл
 	}
 	task.Version++
 
+	return s.runLegs(ctx, apiKey, task, log)
+}
+
+// runLegs выполняет обе ноги ролла; задача уже в ROLL_INITIATED
+func (s *RollerService) runLegs(ctx context.Context, apiKey domain.APIKey, task *domain.Task, log *slog.Logger) error {
 	// ---------------------------------------------------------
 	// 4. ВЫПОЛНЕНИЕ LEG 1 (CLOSE OLD POSITION)
 	// ---------------------------------------------------------
-	if err := s.processLeg1(ctx, apiKey, task, log); err != nil {
+	finished, err := s.processLeg1(ctx, apiKey, task, log)
+	if err != nil {
+		// Остановка бота или потеря лока задачи - не ошибка задачи: статус не трогаем,
+		// задачу подберет восстановление
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			log.Warn("Roll interrupted by shutdown during Leg 1", slog.String("err", err.Error()))
+			return err
+		}
+		// Закрывающий ордер мог уйти и исполниться: IDLE здесь значило бы, что следующий тик
+		// увидит пустую позицию и завершит задачу без второй ноги. Оставляем ROLL_INITIATED -
+		// исход по бирже разберет recoverLeg1.
+		if errors.Is(err, errCloseOrderSent) && (domain.IsTransient(err) || errors.Is(err, errLeg1Unconfirmed)) {
+			log.Warn("Leg 1 outcome unknown, task left for recovery", slog.String("err", err.Error()))
+			return err
+		}
+		err = s.diagnoseAccount(ctx, apiKey, err)
 		s.handleError(ctx, task, fmt.Errorf("leg 1 failed: %w", err))
 		return err
 	}
+	if finished {
+		// Задача закрыта без ролла (экспирация, позиции нет): вторая нога не нужна
+		return nil
+	}
 
 	// ---------------------------------------------------------
 	// 5. ВЫПОЛНЕНИЕ LEG 2 (OPEN NEW POSITION)
 	// ---------------------------------------------------------
 	// Сразу переходим ко второй ноге без прерывания
-	if err := s.processLeg2(ctx, apiKey, task, log); err != nil {
-		// Это фатальная ошибка: мы закрыли старую, но не открыли новую.
-		// Ставим статус FAILED, чтобы админ вмешался.
-		_ = s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateFailed, task.Version)
-		return fmt.Errorf("🔥 FATAL: Leg 2 failed after Leg 1 closed! Position is naked. Err: %w", err)
+	return s.finishLeg2(ctx, apiKey, task, log)
+}
+
+// finishLeg2 открывает вторую ногу, повторяя попытки на временных ошибках.
+// Неустранимая ошибка (маржа, символ) переводит задачу в FAILED.
+func (s *RollerService) finishLeg2(ctx context.Context, apiKey domain.APIKey, task *domain.Task, log *slog.Logger) error {
+	retryCount := 0
+	for {
+		err := s.processLeg2(ctx, apiKey, task, log)
+		if err == nil {
+			break
+		}
+
+		// Graceful Shutdown: задача остается в LEG1_CLOSED и будет восстановлена
+		if ctx.Err() != nil {
+			log.Warn("Context cancelled during Leg 2 retry loop. Task remains in LEG1_CLOSED state.")
+			return context.Cause(ctx)
+		}
+
+		if !domain.IsTransient(err) {
+			err = s.diagnoseAccount(ctx, apiKey, err)
+			// Это фатальная ошибка: мы закрыли старую, но не открыли новую.
+			// Ставим статус FAILED, чтобы админ вмешался.
+			_ = s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateFailed, task.Version)
+			s.notify(task, fmt.Sprintf("🔥 Задача #%d (%s): старая позиция закрыта, новая не открыта: %v. Задача остановлена, откройте позицию на бирже вручную.",
+				task.ID, task.Title(), err))
+			return fmt.Errorf("🔥 FATAL: Leg 2 failed after Leg 1 closed! Position is naked. Err: %w", err)
+		}
+
+		retryCount++
+		log.Error("⚠️ Leg 2 failed, retrying...",
+			slog.Int("attempt", retryCount),
+			slog.String("err", err.Error()))
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(leg2RetryDelay):
+		}
 	}
 
 	log.Info("🎉 Roll sequence completed successfully")
@@ -75,7 +156,8 @@ func (s *RollerService) ExecuteRoll(ctx context.Context, apiKey domain.APIKey, t
 }
 
 // processLeg1: Получает текущую позицию, закрывает её и обновляет статус в БД.
-func (s *RollerService) processLeg1(ctx context.Context, apiKey domain.APIKey, task *domain.Task, log *slog.Logger) error {
+// finished=true - задача завершена без ролла, Leg 2 открывать не нужно.
+func (s *RollerService) processLeg1(ctx context.Context, apiKey domain.APIKey, task *domain.Task, log *slog.Logger) (finished bool, err error) {
 	if task.TargetSide == "" {
 		s.logger.Warn("TargetSide is empty in Leg 2 (likely after restart), defaulting to SELL")
 		task.TargetSide = domain.SideSell
@@ -94,7 +176,11 @@ func (s *RollerService) processLeg1(ctx context.Context, apiKey domain.APIKey, t
 				"expiry_utc", expiryTime)
 
 			// <--- ВАЖНО: Передаем 4 аргумента: context, ID, State, Version
-			return s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateCompleted, task.Version)
+			if err := s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateCompleted, task.Version); err != nil {
+				return true, err
+			}
+			s.notify(task, fmt.Sprintf("⌛ Опцион %s экспирировал, ролл не нужен. Задача #%d завершена.", task.CurrentOptionSymbol, task.ID))
+			return true, nil
 		}
 	} else {
 		// Если не смогли распарсить дату, просто ворним и работаем дальше
@@ -108,14 +194,18 @@ func (s *RollerService) processLeg1(ctx context.Context, apiKey domain.APIKey, t
 	// 1. Получаем реальную позицию с биржи
 	position, err := s.exchange.GetPosition(ctx, apiKey, task.CurrentOptionSymbol)
 	if err != nil {
-		return fmt.Errorf("fetch position: %w", err)
+		return false, fmt.Errorf("fetch position: %w", err)
 	}
 
 	// Если позиция 0, возможно ее закрыли руками или ликвидировало
 	if position.Qty.IsZero() {
 		log.Info("Position not found (qty is 0), completing task", "task_id", task.ID)
 		// Тоже считаем задачу выполненной, раз позиции нет
-		return s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateCompleted, task.Version)
+		if err := s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateCompleted, task.Version); err != nil {
+			return true, err
+		}
+		s.notify(task, fmt.Sprintf("ℹ️ Позиции %s на бирже нет (закрыта вручную или ликвидирована). Задача #%d завершена.", task.CurrentOptionSymbol, task.ID))
+		return true, nil
 	}
 
 	task.CurrentQty = position.Qty
@@ -123,7 +213,7 @@ func (s *RollerService) processLeg1(ctx context.Context, apiKey domain.APIKey, t
 
 	markPrice, err := s.exchange.GetMarkPrice(ctx, task.CurrentOptionSymbol)
 	if err != nil {
-		return fmt.Errorf("failed to get mark price for leg1: %w", err)
+		return false, fmt.Errorf("failed to get mark price for leg1: %w", err)
 	}
 	closeSide := domain.SideBuy
 	if position.Side == domain.SideBuy {
@@ -133,42 +223,67 @@ func (s *RollerService) processLeg1(ctx context.Context, apiKey domain.APIKey, t
 		task.TargetSide = domain.Side(position.Side) 
 	}
 
-	// Рассчитываем агрессивную цену
-	safePrice := s.calculateSafeLimitPrice(string(closeSide), markPrice)
-
-	log.Info("Executing Leg 1 (Close) with Aggressive Limit", 
+	log.Info("Executing Leg 1 (Close)",
 		slog.String("symbol", task.CurrentOptionSymbol),
 		slog.String("qty", position.Qty.String()),
 		slog.String("side", string(closeSide)),
 		slog.String("mark_price", markPrice.String()),
-		slog.String("limit_price", safePrice.String()))
+		slog.String("mode", s.execution.Mode))
 
-	// 2. Формируем ордер на закрытие (Aggressive Limit IOC)
-	// Идемпотентный ID
-	orderLinkID := fmt.Sprintf("close-%d-v%d", task.ID, task.Version)
+	// 2. Закрываем позицию. Идемпотентный ID
+	orderLinkID := closeOrderLinkID(task)
 
-	_, err = s.exchange.PlaceOrder(ctx, apiKey, domain.OrderRequest{
+	err = s.executeOrder(ctx, apiKey, task.ID, domain.OrderRequest{
 		Symbol:      task.CurrentOptionSymbol,
 		Side:        closeSide,
-		OrderType:   domain.OrderTypeLimit, // <--- ИЗМЕНЕНО
-		Price:       safePrice,             // <--- НОВОЕ
-		TimeInForce: "IOC",                 // <--- НОВОЕ (Immediate Or Cancel)
 		Qty:         position.Qty,
 		ReduceOnly:  true,
 		OrderLinkID: orderLinkID,
-	})
+	}, markPrice, log)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("%w: %w", errCloseOrderSent, err)
+	}
+
+	// Успех ордера еще не закрытие: дубль orderLinkId (лимитка chase от прошлого запуска)
+	// и IOC без полного исполнения тоже возвращают nil. Checkpoint - только по позиции.
+	if err := s.confirmLeg1Closed(ctx, apiKey, task); err != nil {
+		return false, fmt.Errorf("%w: %w", errCloseOrderSent, err)
+	}
+
+	s.saveLeg1Checkpoint(ctx, task, log)
+	return false, nil
+}
+
+var (
+	// errCloseOrderSent - Leg 1 упала после отправки закрывающего ордера: исход на бирже неизвестен
+	errCloseOrderSent = errors.New("close order may have been sent")
+	// errLeg1Unconfirmed - ордер Leg 1 принят, а позиция закрыта не вся: что исполнилось, разберет recoverLeg1
+	errLeg1Unconfirmed = errors.New("leg 1 close not confirmed by position")
+)
+
+// confirmLeg1Closed проверяет, что старой позиции на бирже больше нет
+func (s *RollerService) confirmLeg1Closed(ctx context.Context, apiKey domain.APIKey, task *domain.Task) error {
+	after, err := s.exchange.GetPosition(ctx, apiKey, task.CurrentOptionSymbol)
+	if err != nil {
+		return fmt.Errorf("fetch position after close: %w", err)
 	}
+	if !after.Qty.IsZero() {
+		return fmt.Errorf("%w: %s still open (%s of %s)", errLeg1Unconfirmed, task.CurrentOptionSymbol, after.Qty, task.CurrentQty)
+	}
+	return nil
+}
 
-	// 3. CHECKPOINT: Сохраняем статус LEG1_CLOSED
+func closeOrderLinkID(task *domain.Task) string {
+	return fmt.Sprintf("close-%d-v%d", task.ID, task.Version)
+}
+
+// saveLeg1Checkpoint - 3. CHECKPOINT: Сохраняем статус LEG1_CLOSED
+func (s *RollerService) saveLeg1Checkpoint(ctx context.Context, task *domain.Task, log *slog.Logger) {
 	if err := s.taskRepo.UpdateTaskState(ctx, task.ID, domain.TaskStateLeg1Closed, task.Version); err != nil {
 		log.Error("CRITICAL DB ERROR: Failed to save LEG1_CLOSED", slog.String("err", err.Error()))
 	} else {
 		task.Version++
 	}
-
-	return nil
 }
 
 // processLeg2: Вычисляет следующий страйк и открывает новую позицию.
@@ -198,83 +313,90 @@ func (s *RollerService) processLeg2(ctx context.Context, apiKey domain.APIKey, t
 		slog.String("old_symbol", task.CurrentOptionSymbol),
 		slog.String("new_symbol", nextSymbolStr),
 		slog.String("qty", task.CurrentQty.String()))
-	
+
+
+	// Leg 1 могла занять время, а новая короткая нога сама добавит маржи: перепроверяем.
+	// Ошибка временная - finishLeg2 повторит ногу, когда маржа освободится.
+	if err := s.checkMargin(ctx, apiKey, "Leg 2", log); err != nil {
+		return err
+	}
+
 	nextMarkPrice, err := s.exchange.GetMarkPrice(ctx, nextSymbolStr)
 	if err != nil {
 		return fmt.Errorf("failed to get mark price for leg2 (%s): %w", nextSymbolStr, err)
 	}
 
-	// Рассчитываем агрессивную цену для открытия
-	safeOpenPrice := s.calculateSafeLimitPrice(string(task.TargetSide), nextMarkPrice)
-
-	log.Info("Executing Leg 2 (Open) with Aggressive Limit",
-		slog.String("method", "SmartStrikeSelection"),
-		slog.String("old_symbol", task.CurrentOptionSymbol),
-		slog.String("new_symbol", nextSymbolStr),
-		slog.String("mark_price", nextMarkPrice.String()),
-		slog.String("limit_price", safeOpenPrice.String()),
-		slog.String("qty", task.CurrentQty.String()))
-
-	// 4. Открываем новую позицию (Aggressive Limit IOC)
+	// 4. Открываем новую позицию
 	orderLinkID := fmt.Sprintf("open-%d-v%d", task.ID, task.Version)
 
-	_, err = s.exchange.PlaceOrder(ctx, apiKey, domain.OrderRequest{
+	err = s.executeOrder(ctx, apiKey, task.ID, domain.OrderRequest{
 		Symbol:      nextSymbolStr,
 		Side:        string(task.TargetSide),
-		OrderType:   domain.OrderTypeLimit, // <--- ИЗМЕНЕНО
-		Price:       safeOpenPrice,         // <--- НОВОЕ
-		TimeInForce: "IOC",                 // <--- НОВОЕ
 		Qty:         task.CurrentQty,
 		OrderLinkID: orderLinkID,
-	})
+	}, nextMarkPrice, log)
 	if err != nil {
 		return err
 	}
 
 	// 5. Финализация
-	if err := s.taskRepo.UpdateTaskSymbol(ctx, task.ID, nextSymbolStr, task.CurrentQty, task.Version); err != nil {
+	err = s.taskRepo.FinalizeRoll(ctx, domain.FinalizeRollParams{
+		TaskID:     task.ID,
+		Version:    task.Version,
+		FromSymbol: task.CurrentOptionSymbol,
+		ToSymbol:   nextSymbolStr,
+		Qty:        task.CurrentQty,
+	})
+	if err != nil {
 		log.Error("Failed to update task final state", slog.String("err", err.Error()))
 		return nil
 	}
-	retryCount := 0
-	for {
-		// Проверяем, не выключается ли бот (Graceful Shutdown)
-		if ctx.Err() != nil {
-			log.Warn("Context cancelled during Leg 2 retry loop. Task remains in LEG1_CLOSED state.")
-			return ctx.Err()
-		}
 
-		err := s.processLeg2(ctx, apiKey, task, log)
-		if err == nil {
-			// УСПЕХ! Выходим из цикла.
-			break
-		}
+	s.notify(task, fmt.Sprintf("🔁 Задача #%d: ролл %s → %s, объем %s.",
+		task.ID, task.CurrentOptionSymbol, nextSymbolStr, task.CurrentQty.String()))
+	return nil
+}
 
-		retryCount++
-		// Логируем ошибку, но НЕ меняем статус на FAILED.
-		// Мы будем долбить биржу до победного.
-		log.Error("⚠️ Leg 2 failed, retrying...",
-			slog.Int("attempt", retryCount),
-			slog.String("err", err.Error()))
+// checkMargin не дает начать ролл или открыть Leg 2, если аккаунт уже у границы ликвидации;
+// stage - этап для лога и ошибки. Ошибка получения баланса ролл не блокирует: ролл сам уводит
+// позицию от опасного страйка, а после Leg 1 голая позиция хуже отказа биржи.
+func (s *RollerService) checkMargin(ctx context.Context, apiKey domain.APIKey, stage string, log *slog.Logger) error {
+	limit := s.execution.MaxAccountMMR
+	if !limit.IsPositive() {
+		return nil
+	}
 
-		// Ждем перед повтором (Backoff strategy)
-		// Можно сделать экспоненциальную задержку, но для начала хватит фиксированной.
-		// Важно использовать select с ctx.Done, чтобы не зависнуть при выключении.
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(3 * time.Second):
-			// Продолжаем цикл
-		}
+	margin, err := s.exchange.GetMarginInfo(ctx, apiKey)
+	if err != nil {
+		log.Warn("Could not fetch margin info, continuing", slog.String("stage", stage), slog.String("err", err.Error()))
+		return nil
 	}
 
-	log.Info("🎉 Roll sequence completed successfully")
+	log.Info("Margin check",
+		slog.String("stage", stage),
+		slog.String("total_equity", margin.TotalEquity.String()),
+		slog.String("total_margin_balance", margin.TotalMarginBalance.String()),
+		slog.String("mmr", margin.MMR.String()))
+
+	if margin.MMR.GreaterThanOrEqual(limit) {
+		return fmt.Errorf("account MMR %s reached limit %s before %s: %w", margin.MMR, limit, stage, domain.ErrAccountMarginHigh)
+	}
 	return nil
+}
 
+// notify сообщает владельцу задачи итог ролла. Уведомление не влияет на результат ролла:
+// ошибку только пишем в лог.
+func (s *RollerService) notify(task *domain.Task, message string) {
+	if s.notifier == nil {
+		return
+	}
+	if err := s.notifier.NotifyUser(task.UserID, message); err != nil {
+		s.logger.Warn("Failed to notify user", "task_id", task.ID, "err", err)
+	}
 }
 
 func (s *RollerService) handleError(ctx context.Context, task *domain.Task, err error) {
-	_ = s.taskRepo.RegisterError(ctx, task.ID, err)
+	_ = s.taskRepo.RegisterError(ctx, task.ID, task.Version, err)
 }
 
 // calculateSafeLimitPrice рассчитывает цену для Агрессивной Лимитки.
@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/dbtest"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/fakeexchange"
+	"github.com/shopspring/decimal"
+)
+
+// rollerEnv - роллер над SQLite и фейковой биржей; у ключа на бирже короткий пут symbol объемом 0.1
+type rollerEnv struct {
+	fx       *dbtest.Fixture
+	exchange *fakeexchange.Exchange
+	roller   *RollerService
+	key      *domain.APIKey
+	symbol   string
+}
+
+func newRollerEnv(t *testing.T) *rollerEnv {
+	t.Helper()
+	fx := dbtest.NewFixture(t)
+	exCfg := fakeexchange.DefaultConfig(time.Now())
+	exchange := fakeexchange.New(exCfg)
+	user := fx.User(t, 1)
+	return &rollerEnv{
+		fx:       fx,
+		exchange: exchange,
+		roller:   NewRollerService(exchange, fx.Tasks, fx.Orders, nil, DefaultExecutionConfig(), dbtest.Logger()),
+		key:      fx.Key(t, user.ID, "key-1"),
+		symbol:   exCfg.StartPositions[0].Symbol,
+	}
+}
+
+func (e *rollerEnv) positionQty(t *testing.T, symbol string) decimal.Decimal {
+	t.Helper()
+	pos, err := e.exchange.GetPosition(context.Background(), *e.key, symbol)
+	if err != nil {
+		t.Fatalf("get position: %v", err)
+	}
+	return pos.Qty
+}
+
+func TestExecuteRollPostponedOnHighMMR(t *testing.T) {
+	env := newRollerEnv(t)
+	env.exchange.SetMarginInfo(domain.MarginInfo{MMR: decimal.NewFromFloat(0.95)})
+	task := env.fx.Reload(t, env.fx.Task(t, env.key, env.symbol, 59000, domain.TaskStateIdle).ID)
+
+	// Каждый тик при высокой марже: ролл откладывается, не трогая задачу
+	for i := 0; i < 3; i++ {
+		if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.NewFromInt(58500)); err != nil {
+			t.Fatalf("tick %d: %v", i, err)
+		}
+	}
+
+	if qty := env.positionQty(t, env.symbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("position qty %s, want 0.1", qty)
+	}
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.Version != task.Version || got.LastError != "" {
+		t.Fatalf("task changed: status %s, version %d (was %d), last error %q", got.Status, got.Version, task.Version, got.LastError)
+	}
+}
+
+func TestExecuteRollZeroBalances(t *testing.T) {
+	env := newRollerEnv(t)
+	// Пустой UTA-аккаунт: все поля нулевые, MMR 0 - ролл не блокируется
+	env.exchange.SetMarginInfo(domain.MarginInfo{})
+	task := env.fx.Task(t, env.key, env.symbol, 59000, domain.TaskStateIdle)
+
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.NewFromInt(58500)); err != nil {
+		t.Fatalf("roll: %v", err)
+	}
+	got := env.fx.Reload(t, task.ID)
+	if got.RollCount != 1 || got.CurrentOptionSymbol == env.symbol {
+		t.Fatalf("roll not done: rolls %d, symbol %s", got.RollCount, got.CurrentOptionSymbol)
+	}
+}
+
+func TestLeg2WaitsForMargin(t *testing.T) {
+	env := newRollerEnv(t)
+	// Leg 1 закрыта, а MMR у границы: новая нога ждет, задача остается в LEG1_CLOSED
+	env.exchange.SetMarginInfo(domain.MarginInfo{MMR: decimal.NewFromFloat(0.99)})
+	env.exchange.SetPosition(env.key.Key, domain.Position{Symbol: env.symbol})
+	task := env.fx.Task(t, env.key, env.symbol, 59000, domain.TaskStateLeg1Closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := env.roller.ExecuteRoll(ctx, *env.key, task, decimal.Zero); err == nil {
+		t.Fatal("leg 2 opened despite high MMR")
+	}
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateLeg1Closed || got.RollCount != 0 {
+		t.Fatalf("status %s, rolls %d, want LEG1_CLOSED without roll", got.Status, got.RollCount)
+	}
+
+	// Маржа освободилась: восстановление открывает ногу
+	env.exchange.SetMarginInfo(domain.MarginInfo{MMR: decimal.NewFromFloat(0.5)})
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, got, decimal.Zero); err != nil {
+		t.Fatalf("recovery: %v", err)
+	}
+	got = env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.RollCount != 1 {
+		t.Fatalf("leg 2 not opened: status %s, rolls %d", got.Status, got.RollCount)
+	}
+	if qty := env.positionQty(t, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("new leg qty %s, want 0.1", qty)
+	}
+}
+
+func TestLeg1LostReplyLeftForRecovery(t *testing.T) {
+	env := newRollerEnv(t)
+	// Закрывающий ордер исполнился, а ответ биржи потерялся
+	env.exchange.SetFailures(fakeexchange.Failures{LoseReply: 1})
+	task := env.fx.Task(t, env.key, env.symbol, 59000, domain.TaskStateIdle)
+
+	err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.NewFromInt(58500))
+	if !domain.IsTransient(err) {
+		t.Fatalf("err = %v, want transient", err)
+	}
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateRollInitiated {
+		t.Fatalf("status %s, want ROLL_INITIATED for recovery", got.Status)
+	}
+
+	// Восстановление видит исполнение и открывает вторую ногу, а не завершает задачу
+	env.exchange.SetFailures(fakeexchange.Failures{})
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, got, decimal.Zero); err != nil {
+		t.Fatalf("recovery: %v", err)
+	}
+	got = env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.RollCount != 1 {
+		t.Fatalf("roll not finished: status %s, rolls %d", got.Status, got.RollCount)
+	}
+	if qty := env.positionQty(t, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("new leg qty %s, want 0.1", qty)
+	}
+}
+
+func TestLeg1DuplicateUnfilledLeftForRecovery(t *testing.T) {
+	env := newRollerEnv(t)
+	task := env.fx.Reload(t, env.fx.Task(t, env.key, env.symbol, 59000, domain.TaskStateIdle).ID)
+
+	// Лимитка chase от прошлого запуска с тем же orderLinkId стоит далеко от рынка:
+	// IOC получает дубль, но позиция не закрыта - checkpoint ставить нельзя
+	next := *task
+	next.Version++
+	env.exchange.SetOrder(env.key.Key, domain.Order{
+		OrderLinkID: closeOrderLinkID(&next), Symbol: env.symbol, Side: string(domain.SideBuy),
+		Status: domain.OrderStatusNew, Price: decimal.NewFromInt(1), Qty: decimal.NewFromFloat(0.1),
+	})
+
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, task, decimal.NewFromInt(58500)); err == nil {
+		t.Fatal("leg 1 reported closed while the position is open")
+	}
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateRollInitiated || got.RollCount != 0 {
+		t.Fatalf("status %s, rolls %d, want ROLL_INITIATED without roll", got.Status, got.RollCount)
+	}
+	if qty := env.positionQty(t, env.symbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("old leg qty %s, want 0.1", qty)
+	}
+
+	// Восстановление снимает висящую лимитку и доводит ролл
+	if err := env.roller.ExecuteRoll(context.Background(), *env.key, got, decimal.Zero); err != nil {
+		t.Fatalf("recovery: %v", err)
+	}
+	got = env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.RollCount != 1 {
+		t.Fatalf("roll not finished: status %s, rolls %d", got.Status, got.RollCount)
+	}
+	if qty := env.positionQty(t, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("new leg qty %s, want 0.1", qty)
+	}
+}
@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// SetAccountStream включает приватный стрим по ключам активных задач: итоги ордеров попадают
+// в журнал без опроса, а позиция, закрытая мимо бота (вручную, ликвидацией), сразу завершает
+// задачу, не дожидаясь триггера. Вызывать до Run
+func (m *Manager) SetAccountStream(stream domain.AccountStreamer, orders domain.OrderRepository) {
+	m.account = stream
+	m.orders = orders
+}
+
+// watchAccounts держит приватные соединения ровно для ключей задач (под reloadMu). Соединение
+// живет до Unwatch, а не до ctx: ReloadTasks зовут и с коротким ctx запроса из бота
+func (m *Manager) watchAccounts(ctx context.Context, tasks []domain.Task) {
+	if m.account == nil {
+		return
+	}
+
+	wanted := make(map[int64]string)
+	for _, task := range tasks {
+		if _, ok := wanted[task.APIKeyID]; ok {
+			continue
+		}
+		key, err := m.keys.get(ctx, task.APIKeyID)
+		if err != nil || key == nil {
+			m.logger.Warn("Failed to load api key for account stream", "api_key_id", task.APIKeyID, "err", err)
+			// Уже открытое соединение не рвем из-за сбоя чтения
+			if watched, ok := m.watchedKeys[task.APIKeyID]; ok {
+				wanted[task.APIKeyID] = watched
+			}
+			continue
+		}
+
+		// После ротации соединение авторизовано старым ключом: переподключаем
+		if watched, ok := m.watchedKeys[key.ID]; ok && watched != key.Key {
+			m.account.Unwatch(key.ID)
+		}
+		if err := m.account.Watch(context.WithoutCancel(ctx), *key); err != nil {
+			m.logger.Warn("Failed to watch api key account", "api_key_id", key.ID, "err", err)
+			continue
+		}
+		wanted[key.ID] = key.Key
+	}
+
+	for id := range m.watchedKeys {
+		if _, ok := wanted[id]; !ok {
+			m.account.Unwatch(id)
+		}
+	}
+	m.watchedKeys = wanted
+}
+
+// unwatchAccounts закрывает все приватные соединения при остановке
+func (m *Manager) unwatchAccounts() {
+	if m.account == nil {
+		return
+	}
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	for id := range m.watchedKeys {
+		m.account.Unwatch(id)
+	}
+	m.watchedKeys = nil
+}
+
+// consumeAccount разбирает обновления приватного стрима до отмены ctx
+func (m *Manager) consumeAccount(ctx context.Context) {
+	for {
+		select {
+		case event := <-m.account.OrderUpdates():
+			m.journalOrder(ctx, event)
+		case event := <-m.account.PositionUpdates():
+			if event.Qty.IsZero() {
+				m.retireClosedPosition(ctx, event)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// journalOrder переносит в журнал итог ордера. Промежуточные статусы пропускаем: стрим может
+// прислать их позже, чем роллер записал итог, и журнал откатился бы назад
+func (m *Manager) journalOrder(ctx context.Context, event domain.OrderUpdateEvent) {
+	order := domain.Order{
+		OrderID:     event.OrderID,
+		OrderLinkID: event.OrderLinkID,
+		Symbol:      event.Symbol,
+		Side:        event.Side,
+		Status:      event.Status,
+		Qty:         event.Qty,
+		CumExecQty:  event.CumExecQty,
+		AvgPrice:    event.AvgPrice,
+	}
+	if m.orders == nil || order.OrderLinkID == "" || order.IsActive() {
+		return
+	}
+	// Ордера не из журнала (выставленные вручную) запрос не затрагивает
+	if err := m.orders.UpdateFromExchange(ctx, order); err != nil {
+		m.logger.Warn("Failed to journal order update", "order_link_id", order.OrderLinkID, "status", order.Status, "err", err)
+	}
+}
+
+// retireClosedPosition завершает IDLE-задачи, позицию которых закрыли мимо бота. Проверка идет
+// под локом задачи: во время ролла нулевая позиция - это закрытая им же первая нога
+func (m *Manager) retireClosedPosition(ctx context.Context, event domain.PositionUpdateEvent) {
+	m.mu.RLock()
+	var ids []int64
+	for _, task := range m.activeTasks {
+		if task.APIKeyID == event.APIKeyID && task.CurrentOptionSymbol == event.Symbol && task.Status == domain.TaskStateIdle {
+			ids = append(ids, task.ID)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, id := range ids {
+		var retired *domain.Task
+		err := m.repo.WithTaskLock(ctx, id, func(ctx context.Context) error {
+			task, err := m.repo.GetTaskByID(ctx, id)
+			if err != nil || task == nil {
+				return err
+			}
+			// Ролл успел пройти: задача на другом символе или еще в работе
+			if task.Status != domain.TaskStateIdle || task.CurrentOptionSymbol != event.Symbol {
+				return nil
+			}
+			if err := m.repo.UpdateTaskStateWithEvent(ctx, id, domain.TaskStateCompleted, task.Version,
+				domain.TaskEventCompleted, "position closed outside the bot"); err != nil {
+				return err
+			}
+			retired = task
+			return nil
+		})
+		if errors.Is(err, domain.ErrTaskLocked) {
+			m.logger.Debug("Task is being rolled, closed position ignored", "task_id", id, "symbol", event.Symbol)
+			continue
+		}
+		if err != nil {
+			// Не страшно: роллер сам завершит задачу без позиции на следующем триггере
+			m.logger.Warn("Failed to complete task with closed position", "task_id", id, "err", err)
+			continue
+		}
+		if retired == nil {
+			continue
+		}
+
+		m.logger.Info("Position closed outside the bot, task completed", "task_id", id, "symbol", event.Symbol)
+		m.notify(retired.UserID, fmt.Sprintf("ℹ️ Позиции %s на бирже нет (закрыта вручную или ликвидирована). Задача #%d завершена.",
+			retired.CurrentOptionSymbol, retired.ID))
+		m.syncTask(ctx, id)
+	}
+}
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+// fakeAccountStream - приватный стрим, обновления которого подает тест
+type fakeAccountStream struct {
+	mu        sync.Mutex
+	watched   map[int64]string // APIKeyID -> ключ соединения
+	unwatched []int64
+
+	orders    chan domain.OrderUpdateEvent
+	positions chan domain.PositionUpdateEvent
+}
+
+func newFakeAccountStream() *fakeAccountStream {
+	return &fakeAccountStream{
+		watched:   make(map[int64]string),
+		orders:    make(chan domain.OrderUpdateEvent),
+		positions: make(chan domain.PositionUpdateEvent),
+	}
+}
+
+func (s *fakeAccountStream) Watch(_ context.Context, creds domain.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.watched[creds.ID]; !ok {
+		s.watched[creds.ID] = creds.Key
+	}
+	return nil
+}
+
+func (s *fakeAccountStream) Unwatch(apiKeyID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watched, apiKeyID)
+	s.unwatched = append(s.unwatched, apiKeyID)
+}
+
+func (s *fakeAccountStream) OrderUpdates() <-chan domain.OrderUpdateEvent       { return s.orders }
+func (s *fakeAccountStream) PositionUpdates() <-chan domain.PositionUpdateEvent { return s.positions }
+
+func (s *fakeAccountStream) snapshot() map[int64]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.watched)
+}
+
+func TestWatchAccountsFollowsTasks(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, Config{Workers: 1})
+	stream := newFakeAccountStream()
+	env.manager.SetAccountStream(stream, env.fx.Orders)
+
+	first := env.task(t, 1, domain.TaskStateIdle)
+	second := env.task(t, 2, domain.TaskStateIdle)
+	if err := env.manager.ReloadTasks(ctx); err != nil {
+		t.Fatalf("ReloadTasks: %v", err)
+	}
+	if got := stream.snapshot(); len(got) != 2 || got[first.APIKeyID] != "key-1" || got[second.APIKeyID] != "key-2" {
+		t.Fatalf("watched %v, want both task keys", got)
+	}
+
+	// Ключ ротировали: соединение переоткрывается с новым ключом
+	if err := env.fx.Keys.Update(ctx, first.APIKeyID, "key-1-rotated", "secret-rotated", time.Now().AddDate(0, 1, 0), 0); err != nil {
+		t.Fatalf("rotate key: %v", err)
+	}
+	env.manager.InvalidateUserKeys(first.UserID)
+	if err := env.manager.ReloadTasks(ctx); err != nil {
+		t.Fatalf("ReloadTasks: %v", err)
+	}
+	if got := stream.snapshot(); got[first.APIKeyID] != "key-1-rotated" {
+		t.Fatalf("watched %v, want rotated key", got)
+	}
+
+	// Задач на ключе не осталось: соединение закрывается
+	if err := env.fx.Tasks.PauseTask(ctx, second.ID, env.fx.Reload(t, second.ID).Version); err != nil {
+		t.Fatalf("PauseTask: %v", err)
+	}
+	if err := env.manager.ReloadTasks(ctx); err != nil {
+		t.Fatalf("ReloadTasks: %v", err)
+	}
+	if got := stream.snapshot(); len(got) != 1 || got[first.APIKeyID] == "" {
+		t.Fatalf("watched %v, want only the first key", got)
+	}
+
+	env.manager.unwatchAccounts()
+	if got := stream.snapshot(); len(got) != 0 {
+		t.Fatalf("watched %v after shutdown", got)
+	}
+}
+
+func TestRetireClosedPosition(t *testing.T) {
+	tests := []struct {
+		name       string
+		otherKey   bool
+		symbol     string // "" - символ задачи
+		rolling    bool   // Лок задачи держит ролл
+		wantStatus domain.TaskState
+	}{
+		{"closed by hand", false, "", false, domain.TaskStateCompleted},
+		{"other symbol", false, "BTC-27DEC24-50000-P", false, domain.TaskStateIdle},
+		{"other key", true, "", false, domain.TaskStateIdle},
+		{"roll in progress", false, "", true, domain.TaskStateIdle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t, Config{Workers: 1})
+			notifier := &recordingNotifier{}
+			env.manager.notifier = notifier
+			env.manager.SetAccountStream(newFakeAccountStream(), env.fx.Orders)
+			task := env.task(t, 1, domain.TaskStateIdle)
+			if err := env.manager.ReloadTasks(ctx); err != nil {
+				t.Fatalf("ReloadTasks: %v", err)
+			}
+
+			event := domain.PositionUpdateEvent{APIKeyID: task.APIKeyID, Symbol: task.CurrentOptionSymbol}
+			if tt.otherKey {
+				event.APIKeyID++
+			}
+			if tt.symbol != "" {
+				event.Symbol = tt.symbol
+			}
+			if tt.rolling {
+				err := env.fx.Tasks.WithTaskLock(ctx, task.ID, func(ctx context.Context) error {
+					env.manager.retireClosedPosition(ctx, event)
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("WithTaskLock: %v", err)
+				}
+			} else {
+				env.manager.retireClosedPosition(ctx, event)
+			}
+
+			got := env.fx.Reload(t, task.ID)
+			if got.Status != tt.wantStatus {
+				t.Fatalf("status %s, want %s", got.Status, tt.wantStatus)
+			}
+			retired := tt.wantStatus == domain.TaskStateCompleted
+			if notified := len(notifier.users) > 0; notified != retired {
+				t.Fatalf("notified %v, want %v", notifier.users, retired)
+			}
+			if jobs := env.manager.triggeredTasks(domain.PriceUpdateEvent{Symbol: "BTCUSDT", Price: decimal.NewFromInt(50000)}, false); (len(jobs) == 0) != retired {
+				t.Fatalf("triggered jobs %+v after the update, task retired %v", jobs, retired)
+			}
+		})
+	}
+}
+
+func TestJournalOrderFinalStatusOnly(t *testing.T) {
+	tests := []struct {
+		status     string
+		wantStatus string
+	}{
+		{domain.OrderStatusNew, domain.OrderJournalPending},
+		{domain.OrderStatusPartiallyFilled, domain.OrderJournalPending},
+		{domain.OrderStatusFilled, domain.OrderStatusFilled},
+		{domain.OrderStatusCancelled, domain.OrderStatusCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			env := newTestEnv(t, Config{Workers: 1})
+			stream := newFakeAccountStream()
+			env.manager.SetAccountStream(stream, env.fx.Orders)
+			task := env.task(t, 1, domain.TaskStateIdle)
+			rec := &domain.OrderRecord{TaskID: task.ID, OrderLinkID: "close-1-v2", Symbol: task.CurrentOptionSymbol,
+				Side: string(domain.SideBuy), OrderType: "Limit", Qty: decimal.NewFromFloat(0.1)}
+			if err := env.fx.Orders.Record(ctx, rec); err != nil {
+				t.Fatalf("Record: %v", err)
+			}
+			go env.manager.consumeAccount(ctx)
+
+			stream.orders <- domain.OrderUpdateEvent{APIKeyID: task.APIKeyID, Symbol: rec.Symbol, OrderID: "ex-1", OrderLinkID: rec.OrderLinkID,
+				Side: rec.Side, Status: tt.status, Qty: rec.Qty, CumExecQty: decimal.NewFromFloat(0.05), AvgPrice: decimal.NewFromInt(120)}
+			// Следующее событие примут только после обработки первого
+			stream.orders <- domain.OrderUpdateEvent{OrderLinkID: "manual-order", Status: domain.OrderStatusFilled}
+
+			got, err := env.fx.Orders.GetByOrderLinkID(ctx, rec.OrderLinkID)
+			if err != nil {
+				t.Fatalf("GetByOrderLinkID: %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Fatalf("journal status %s, want %s", got.Status, tt.wantStatus)
+			}
+			if journaled := tt.wantStatus != domain.OrderJournalPending; journaled && (!got.CumExecQty.Equal(decimal.NewFromFloat(0.05)) || got.ExchangeOrderID != "ex-1") {
+				t.Fatalf("journaled fill %s, order id %q", got.CumExecQty, got.ExchangeOrderID)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Bybit публикует цену поставки не мгновенно после 08:00 UTC
+	expirySettleBuffer = 5 * time.Minute
+	// Если цены поставки так и нет, закрываем задачу без отчета о сеттлменте
+	deliveryPriceGrace = time.Hour
+	// За сколько до истечения API-ключа предупреждаем пользователя
+	keyExpiryWarning = 3 * 24 * time.Hour
+	// Причина завершения в журнале событий задачи
+	expiredTaskReason = "option expired"
+)
+
+// ExpirySweeper закрывает задачи, опцион которых экспирировал без ролла,
+// и сообщает пользователю, чем закончилась экспирация (ITM/OTM и сумма расчета).
+type ExpirySweeper struct {
+	repo     domain.TaskRepository
+	keyRepo  domain.APIKeyRepository
+	exchange domain.ExchangeAdapter
+	notifier domain.NotificationService // может быть nil
+	// enforceSubscriptions: ставить на паузу задачи пользователей с истекшей подпиской
+	enforceSubscriptions bool
+	interval             time.Duration
+	logger               *slog.Logger
+
+	// Ключи, о скором истечении которых уже предупредили (в пределах процесса)
+	warnedKeys map[int64]bool
+}
+
+func NewExpirySweeper(
+	repo domain.TaskRepository,
+	keyRepo domain.APIKeyRepository,
+	exchange domain.ExchangeAdapter,
+	notifier domain.NotificationService,
+	enforceSubscriptions bool,
+	interval time.Duration,
+	logger *slog.Logger,
+) *ExpirySweeper {
+	return &ExpirySweeper{
+		repo:                 repo,
+		keyRepo:              keyRepo,
+		exchange:             exchange,
+		notifier:             notifier,
+		enforceSubscriptions: enforceSubscriptions,
+		interval:             interval,
+		logger:               logger.With("component", "expiry_sweeper"),
+		warnedKeys:           make(map[int64]bool),
+	}
+}
+
+func (s *ExpirySweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ExpirySweeper) sweep(ctx context.Context) {
+	if s.enforceSubscriptions {
+		s.pauseExpiredSubscriptions(ctx)
+	}
+
+	// Все незавершенные задачи, а не только отслеживаемые: опцион на паузе или у забаненного
+	// пользователя (истекшей подписки) тоже экспирирует, и задача иначе навсегда осталась бы открытой
+	tasks, err := s.repo.GetTasksByState(ctx, domain.ActiveTaskStates...)
+	if err != nil {
+		s.logger.Error("Failed to load tasks", "err", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	var settled []settledTask
+	for i := range tasks {
+		task := &tasks[i]
+		// Задачи в середине ролла доводит роллер
+		if task.Status != domain.TaskStateIdle && task.Status != domain.TaskStatePaused {
+			continue
+		}
+
+		expiry, err := domain.ParseExpirationFromSymbol(task.CurrentOptionSymbol)
+		if err != nil || now.Before(expiry.Add(expirySettleBuffer)) {
+			continue
+		}
+
+		if report, ok := s.settle(ctx, task, expiry, now); ok {
+			settled = append(settled, settledTask{task: task, report: report})
+		}
+	}
+	s.completeSettled(ctx, settled)
+
+	s.warnExpiringKeys(ctx, now)
+}
+
+// settledTask - экспирировавшая задача, готовая к завершению, и отчет для пользователя
+type settledTask struct {
+	task   *domain.Task
+	report string
+}
+
+// completeSettled завершает задачи одним запросом: в большую экспирацию их тысячи
+func (s *ExpirySweeper) completeSettled(ctx context.Context, settled []settledTask) {
+	if len(settled) == 0 {
+		return
+	}
+
+	byStatus := make(map[domain.TaskState][]int64)
+	for _, st := range settled {
+		byStatus[st.task.Status] = append(byStatus[st.task.Status], st.task.ID)
+	}
+	// Задачу, сменившую статус после загрузки (пауза, возобновление, удаление), BulkComplete пропускает:
+	// отчет об экспирации по ней не шлем, иначе пользователь получит "завершена" о незавершенной задаче
+	done := make(map[int64]bool, len(settled))
+	for _, status := range []domain.TaskState{domain.TaskStateIdle, domain.TaskStatePaused} {
+		ids := byStatus[status]
+		if len(ids) == 0 {
+			continue
+		}
+		completed, err := s.repo.BulkComplete(ctx, ids, status, expiredTaskReason)
+		if err != nil {
+			s.logger.Error("Failed to complete expired tasks", "status", status, "count", len(ids), "err", err)
+			continue
+		}
+		for _, id := range completed {
+			done[id] = true
+		}
+	}
+	if len(done) == 0 {
+		return
+	}
+	if len(done) != len(settled) {
+		s.logger.Warn("Some expired tasks changed state before completion", "expected", len(settled), "completed", len(done))
+	}
+	s.logger.Info("Expired tasks completed", "count", len(done))
+
+	if s.notifier == nil {
+		return
+	}
+	for _, st := range settled {
+		if !done[st.task.ID] {
+			continue
+		}
+		if err := s.notifier.NotifyUser(st.task.UserID, st.report); err != nil {
+			s.logger.Warn("Failed to notify user about expiry", "task_id", st.task.ID, "err", err)
+		}
+	}
+}
+
+// pauseExpiredSubscriptions ставит на паузу задачи пользователей, подписка которых закончилась,
+// и просит продлить ее. Менеджер перестает следить за ними на ближайшей сверке.
+func (s *ExpirySweeper) pauseExpiredSubscriptions(ctx context.Context) {
+	paused, err := s.repo.PauseTasksOfExpiredUsers(ctx)
+	if err != nil {
+		s.logger.Error("Failed to pause tasks of expired subscriptions", "err", err)
+		return
+	}
+	if len(paused) == 0 {
+		return
+	}
+	s.logger.Info("Tasks paused: subscription expired", "count", len(paused))
+	if s.notifier == nil {
+		return
+	}
+
+	byUser := make(map[int64][]domain.Task)
+	var users []int64
+	for _, task := range paused {
+		if _, ok := byUser[task.UserID]; !ok {
+			users = append(users, task.UserID)
+		}
+		byUser[task.UserID] = append(byUser[task.UserID], task)
+	}
+	for _, userID := range users {
+		var sb strings.Builder
+		sb.WriteString("⏸ Подписка закончилась, задачи приостановлены:\n")
+		for _, task := range byUser[userID] {
+			sb.WriteString(fmt.Sprintf("• #%d %s\n", task.ID, task.Title()))
+		}
+		sb.WriteString("Продлите подписку и возобновите задачи в /status.")
+		if err := s.notifier.NotifyUser(userID, sb.String()); err != nil {
+			s.logger.Warn("Failed to notify about expired subscription", "user_id", userID, "err", err)
+		}
+	}
+}
+
+// warnExpiringKeys предупреждает владельцев отслеживаемых задач, что Bybit скоро отключит их ключ
+func (s *ExpirySweeper) warnExpiringKeys(ctx context.Context, now time.Time) {
+	if s.notifier == nil {
+		return
+	}
+	tasks, err := s.repo.GetActiveTasks(ctx)
+	if err != nil {
+		s.logger.Error("Failed to load tasks for key expiry warnings", "err", err)
+		return
+	}
+
+	for _, task := range tasks {
+		if s.warnedKeys[task.APIKeyID] {
+			continue
+		}
+
+		key, err := s.keyRepo.GetByID(ctx, task.APIKeyID)
+		if err != nil || key == nil || key.ExpiresAt.IsZero() || key.ExpiresAt.Sub(now) > keyExpiryWarning {
+			continue
+		}
+		s.warnedKeys[task.APIKeyID] = true
+
+		msg := fmt.Sprintf("🔑 API-ключ истекает %s UTC. После этого Bybit отключит его, и задачи перестанут роллироваться. Добавьте новый ключ заранее.",
+			key.ExpiresAt.UTC().Format("02.01.2006 15:04"))
+		if err := s.notifier.NotifyUser(task.UserID, msg); err != nil {
+			s.logger.Warn("Failed to warn about api key expiry", "api_key_id", task.APIKeyID, "err", err)
+		}
+	}
+}
+
+// settle получает цену поставки и готовит отчет; ok == false - цены еще нет, повторим на следующем проходе
+func (s *ExpirySweeper) settle(ctx context.Context, task *domain.Task, expiry, now time.Time) (string, bool) {
+	log := s.logger.With(slog.Int64("task_id", task.ID), slog.String("symbol", task.CurrentOptionSymbol))
+
+	sym, err := domain.ParseOptionSymbol(task.CurrentOptionSymbol)
+	if err != nil {
+		return "", false
+	}
+
+	if key, err := s.keyRepo.GetByID(ctx, task.APIKeyID); err == nil && key != nil {
+		ctx = domain.WithKeyNetwork(ctx, *key)
+	}
+
+	deliveryPrice, err := s.exchange.GetDeliveryPrice(ctx, sym.BaseCoin, sym.Original)
+	if err != nil {
+		if now.Before(expiry.Add(deliveryPriceGrace)) {
+			log.Warn("Delivery price not available yet, will retry", "err", err)
+			return "", false
+		}
+		log.Warn("Delivery price unavailable, completing task without settlement report", "err", err)
+	}
+
+	log.Info("Expired task settled", "delivery_price", deliveryPrice.String())
+	return expiryReport(task, sym, deliveryPrice), true
+}
+
+// expiryReport описывает исход экспирации. Сторона позиции после экспирации
+// уже не видна на бирже, поэтому берем TargetSide задачи (по умолчанию короткая).
+func expiryReport(task *domain.Task, sym domain.OptionSymbol, deliveryPrice decimal.Decimal) string {
+	if deliveryPrice.IsZero() {
+		return fmt.Sprintf("⌛ Опцион %s экспирировал. Цена поставки недоступна, задача #%d завершена.",
+			task.Title(), task.ID)
+	}
+
+	intrinsic := sym.IntrinsicValue(deliveryPrice)
+	if intrinsic.IsZero() {
+		return fmt.Sprintf("⌛ Опцион %s экспирировал вне денег (OTM).\nЦена поставки: %s\nЗадача #%d завершена.",
+			task.Title(), deliveryPrice.StringFixed(2), task.ID)
+	}
+
+	settlement := intrinsic.Mul(task.CurrentQty)
+	direction := "списано"
+	if task.TargetSide == domain.SideBuy {
+		direction = "начислено"
+	}
+
+	return fmt.Sprintf("⌛ Опцион %s экспирировал в деньгах (ITM).\nЦена поставки: %s\nРасчет: ~%s USDT %s\nЗадача #%d завершена.",
+		task.Title(), deliveryPrice.StringFixed(2), settlement.StringFixed(2), direction, task.ID)
+}
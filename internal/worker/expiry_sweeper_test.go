@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/dbtest"
+)
+
+type recordingNotifier struct {
+	mu    sync.Mutex
+	users []int64
+	admin []string
+}
+
+func (n *recordingNotifier) NotifyUser(userID int64, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.users = append(n.users, userID)
+	return nil
+}
+
+func (n *recordingNotifier) NotifyAdmin(message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.admin = append(n.admin, message)
+	return nil
+}
+
+func (n *recordingNotifier) adminMessages() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.admin)
+}
+
+func TestCompleteSettledNotifiesCompletedOnly(t *testing.T) {
+	env := newTestEnv(t, DefaultConfig())
+	notifier := &recordingNotifier{}
+	sweeper := NewExpirySweeper(env.fx.Tasks, env.fx.Keys, env.exchange, notifier, true, 0, dbtest.Logger())
+
+	expired := env.task(t, 1, domain.TaskStateIdle)
+	// Пользователь поставил задачу на паузу между выборкой и завершением
+	raced := env.task(t, 2, domain.TaskStateIdle)
+	if err := env.fx.Tasks.PauseTask(context.Background(), raced.ID, env.fx.Reload(t, raced.ID).Version); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+
+	sweeper.completeSettled(context.Background(), []settledTask{
+		{task: expired, report: "expired"},
+		{task: raced, report: "expired"},
+	})
+
+	if got := env.fx.Reload(t, raced.ID); got.Status != domain.TaskStatePaused {
+		t.Fatalf("raced task became %s", got.Status)
+	}
+	if got := env.fx.Reload(t, expired.ID); got.Status != domain.TaskStateCompleted {
+		t.Fatalf("expired task is %s", got.Status)
+	}
+	if len(notifier.users) != 1 || notifier.users[0] != expired.UserID {
+		t.Fatalf("notified users %v, want only %d", notifier.users, expired.UserID)
+	}
+}
+
+func TestSweepCompletesExpiredTasksOutsideMonitoring(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, DefaultConfig())
+	notifier := &recordingNotifier{}
+	sweeper := NewExpirySweeper(env.fx.Tasks, env.fx.Keys, env.exchange, notifier, false, 0, dbtest.Logger())
+
+	const expiredSymbol = "BTC-27DEC24-60000-P"
+	newTask := func(telegramID int64, status domain.TaskState, userSQL string) *domain.Task {
+		user := env.fx.User(t, telegramID)
+		if userSQL != "" {
+			if _, err := env.fx.DB.ExecContext(ctx, userSQL, user.ID); err != nil {
+				t.Fatalf("update user: %v", err)
+			}
+		}
+		key := env.fx.Key(t, user.ID, "key-"+strconv.FormatInt(telegramID, 10))
+		return env.fx.Task(t, key, expiredSymbol, 59000, status)
+	}
+
+	tests := []struct {
+		name string
+		task *domain.Task
+		want domain.TaskState
+	}{
+		{"idle", newTask(1, domain.TaskStateIdle, ""), domain.TaskStateCompleted},
+		{"paused", newTask(2, domain.TaskStatePaused, ""), domain.TaskStateCompleted},
+		{"banned user", newTask(3, domain.TaskStateIdle, `UPDATE users SET is_banned = TRUE WHERE id = $1`), domain.TaskStateCompleted},
+		{"expired subscription", newTask(4, domain.TaskStatePaused, `UPDATE users SET expires_at = '2020-01-01' WHERE id = $1`), domain.TaskStateCompleted},
+		// Середину ролла доводит роллер, не экспирировавший опцион не трогаем
+		{"mid roll", newTask(5, domain.TaskStateRollInitiated, ""), domain.TaskStateRollInitiated},
+		{"not expired", env.task(t, 6, domain.TaskStatePaused), domain.TaskStatePaused},
+	}
+
+	sweeper.sweep(ctx)
+
+	var wantNotified []int64
+	for _, tt := range tests {
+		if got := env.fx.Reload(t, tt.task.ID); got.Status != tt.want {
+			t.Errorf("%s: task is %s, want %s", tt.name, got.Status, tt.want)
+		}
+		if tt.want == domain.TaskStateCompleted {
+			wantNotified = append(wantNotified, tt.task.UserID)
+		}
+	}
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	if !slices.Equal(notifier.users, wantNotified) {
+		t.Fatalf("notified users %v, want %v", notifier.users, wantNotified)
+	}
+}
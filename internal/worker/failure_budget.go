@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// failureBudget считает ошибки роллов по задачам: задача, которая падает на каждом тике
+// (битый ключ, делистинг символа), иначе долбила бы биржу бесконечно. Учитываются только
+// ошибки подряд в пределах окна; успешный ролл обнуляет счетчик.
+type failureBudget struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	failures map[int64][]time.Time
+}
+
+func newFailureBudget(limit int, window time.Duration) *failureBudget {
+	return &failureBudget{
+		limit:    limit,
+		window:   window,
+		failures: make(map[int64][]time.Time),
+	}
+}
+
+// fail отмечает ошибку; open=true - лимит исчерпан, задачу пора останавливать
+func (b *failureBudget) fail(taskID int64, now time.Time) (count int, open bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recent := b.failures[taskID][:0]
+	for _, at := range b.failures[taskID] {
+		if now.Sub(at) < b.window {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	b.failures[taskID] = recent
+
+	return len(recent), len(recent) >= b.limit
+}
+
+func (b *failureBudget) reset(taskID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, taskID)
+}
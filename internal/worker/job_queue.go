@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// jobQueue - очередь роллов с вытеснением по задаче: пока задача ждет воркера, новый тик
+// заменяет ее цену, а не встает в очередь вторым заданием. Воркер всегда берет свежую цену,
+// а главный цикл Manager не блокируется, когда все воркеры заняты.
+// Задача, которую уже выполняет воркер, в очередь не попадает: пачка тиков за триггером
+// не должна запускать второй ролл той же задачи.
+// Роллы одного API-ключа идут строго по одному и по порядку: вторая нога следующего ролла
+// не должна уйти раньше, чем маржа аккаунта учтет предыдущий. Разные ключи - параллельно.
+// Восстановление прерванных роллов (Recovery) встает в начало очереди и не ограничено
+// ее емкостью: пока оно ждет, позиция аккаунта неполная.
+type jobQueue struct {
+	mu       sync.Mutex
+	pending  map[int64]jobDTO
+	order    []int64         // ID задач: сначала восстановление, затем в порядке первого поступления
+	inFlight map[int64]int64 // Задача в работе -> ее API-ключ
+	busyKeys map[int64]bool  // Ключи, по которым идет ролл
+	capacity int             // Максимум ждущих задач
+	closed   bool            // Остановка: новые задачи не принимаются, очередь дорабатывается
+	notify   chan struct{}
+}
+
+type pushResult int
+
+const (
+	pushQueued   pushResult = iota // Поставлена или обновлена цена ждущей
+	pushInFlight                   // Задачу уже выполняет воркер
+	pushFull                       // Очередь заполнена
+	pushClosed                     // Очередь остановлена
+)
+
+func newJobQueue(capacity int) *jobQueue {
+	return &jobQueue{
+		pending:  make(map[int64]jobDTO),
+		inFlight: make(map[int64]int64),
+		busyKeys: make(map[int64]bool),
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// push ставит задачу в очередь или обновляет цену уже ждущей
+func (q *jobQueue) push(job jobDTO) pushResult {
+	if job.Recovery {
+		return q.pushRecovery(job)
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return pushClosed
+	}
+	if _, busy := q.inFlight[job.TaskID]; busy {
+		q.mu.Unlock()
+		return pushInFlight
+	}
+	if queued, ok := q.pending[job.TaskID]; ok {
+		// Тик не снимает пометку восстановления с ждущей задачи
+		job.Recovery = queued.Recovery
+	} else {
+		if len(q.order) >= q.capacity {
+			q.mu.Unlock()
+			return pushFull
+		}
+		q.order = append(q.order, job.TaskID)
+	}
+	q.pending[job.TaskID] = job
+	q.mu.Unlock()
+
+	q.signal()
+	return pushQueued
+}
+
+// pushRecovery ставит задачу за уже ждущими восстановлениями, впереди обычных роллов
+func (q *jobQueue) pushRecovery(job jobDTO) pushResult {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return pushClosed
+	}
+	if _, busy := q.inFlight[job.TaskID]; busy {
+		q.mu.Unlock()
+		return pushInFlight
+	}
+	if queued, ok := q.pending[job.TaskID]; ok && queued.Recovery {
+		q.mu.Unlock()
+		return pushQueued
+	}
+
+	order := make([]int64, 0, len(q.order)+1)
+	inserted := false
+	for _, id := range q.order {
+		if id == job.TaskID {
+			continue // Ждала как обычный ролл - переносим вперед
+		}
+		if !inserted && !q.pending[id].Recovery {
+			order = append(order, job.TaskID)
+			inserted = true
+		}
+		order = append(order, id)
+	}
+	if !inserted {
+		order = append(order, job.TaskID)
+	}
+	q.order = order
+	q.pending[job.TaskID] = job
+	q.mu.Unlock()
+
+	q.signal()
+	return pushQueued
+}
+
+// stats - число ждущих задач и задач в работе
+func (q *jobQueue) stats() (queued, inFlight int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.order), len(q.inFlight)
+}
+
+// done снимает отметку "в работе" с задачи и ее ключа; воркер вызывает его по завершении задачи
+func (q *jobQueue) done(taskID int64) {
+	q.mu.Lock()
+	if keyID, ok := q.inFlight[taskID]; ok {
+		delete(q.inFlight, taskID)
+		delete(q.busyKeys, keyID)
+	}
+	more := len(q.order) > 0 || q.closed
+	q.mu.Unlock()
+
+	if more {
+		// Ждущие задачи этого ключа могли быть заблокированы; после close - будим на выход
+		q.signal()
+	}
+}
+
+// close останавливает прием задач; pop отдает оставшиеся, а на пустой очереди возвращает false
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// pop ждет первую задачу, ключ которой свободен, и помечает ее "в работе" до вызова done;
+// false - ctx отменен или очередь закрыта и пуста
+func (q *jobQueue) pop(ctx context.Context) (jobDTO, bool) {
+	for {
+		q.mu.Lock()
+		for i, id := range q.order {
+			job := q.pending[id]
+			if q.busyKeys[job.APIKeyID] {
+				continue
+			}
+
+			q.order = append(q.order[:i:i], q.order[i+1:]...)
+			delete(q.pending, id)
+			q.inFlight[id] = job.APIKeyID
+			q.busyKeys[job.APIKeyID] = true
+			more := len(q.order) > 0 || q.closed
+			q.mu.Unlock()
+
+			if more {
+				// Сигнал один на всех: будим следующего воркера сами
+				q.signal()
+			}
+			return job, true
+		}
+		if q.closed && len(q.order) == 0 {
+			q.mu.Unlock()
+			// Цепочкой будим остальных ждущих воркеров, чтобы они тоже вышли
+			q.signal()
+			return jobDTO{}, false
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return jobDTO{}, false
+		}
+	}
+}
+
+func (q *jobQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func job(taskID, keyID int64, price int64) jobDTO {
+	return jobDTO{TaskID: taskID, APIKeyID: keyID, Price: decimal.NewFromInt(price)}
+}
+
+// popNow забирает задание, не дожидаясь новых: false - свободного задания нет
+func popNow(t *testing.T, q *jobQueue) (jobDTO, bool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	return q.pop(ctx)
+}
+
+func TestJobQueueSameKeyInOrder(t *testing.T) {
+	q := newJobQueue(10)
+	q.push(job(1, 100, 1))
+	q.push(job(2, 100, 1))
+	q.push(job(3, 100, 1))
+
+	for _, want := range []int64{1, 2, 3} {
+		got, ok := popNow(t, q)
+		if !ok || got.TaskID != want {
+			t.Fatalf("pop = %d (%v), want %d", got.TaskID, ok, want)
+		}
+		// Пока ролл ключа идет, следующая задача того же ключа ждет
+		if next, ok := popNow(t, q); ok {
+			t.Fatalf("task %d popped while key is busy", next.TaskID)
+		}
+		q.done(got.TaskID)
+	}
+}
+
+func TestJobQueueKeysInParallel(t *testing.T) {
+	q := newJobQueue(10)
+	q.push(job(1, 100, 1))
+	q.push(job(2, 100, 1))
+	q.push(job(3, 200, 1))
+
+	first, _ := popNow(t, q)
+	// Задача 2 ждет ключ 100, задача 3 другого ключа обгоняет ее
+	second, ok := popNow(t, q)
+	if first.TaskID != 1 || !ok || second.TaskID != 3 {
+		t.Fatalf("popped %d, %d (%v), want 1, 3", first.TaskID, second.TaskID, ok)
+	}
+	if _, inFlight := q.stats(); inFlight != 2 {
+		t.Fatalf("in flight %d, want 2", inFlight)
+	}
+
+	q.done(first.TaskID)
+	if third, ok := popNow(t, q); !ok || third.TaskID != 2 {
+		t.Fatalf("pop = %d (%v), want 2", third.TaskID, ok)
+	}
+}
+
+func TestJobQueueCoalescesTicks(t *testing.T) {
+	q := newJobQueue(10)
+	for price := int64(1); price <= 10; price++ {
+		if res := q.push(job(1, 100, price)); res != pushQueued {
+			t.Fatalf("push %d = %v, want queued", price, res)
+		}
+	}
+	if queued, _ := q.stats(); queued != 1 {
+		t.Fatalf("queued %d, want 1", queued)
+	}
+
+	got, _ := popNow(t, q)
+	if !got.Price.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("price %s, want latest tick 10", got.Price)
+	}
+	// Тики по задаче в работе в очередь не попадают
+	for price := int64(11); price <= 20; price++ {
+		if res := q.push(job(1, 100, price)); res != pushInFlight {
+			t.Fatalf("push %d = %v, want in flight", price, res)
+		}
+	}
+	if queued, _ := q.stats(); queued != 0 {
+		t.Fatalf("queued %d, want 0", queued)
+	}
+}
+
+func TestJobQueueRecoveryFirst(t *testing.T) {
+	q := newJobQueue(1)
+	q.push(job(1, 100, 1))
+	// Восстановление не ограничено емкостью и встает впереди обычных роллов
+	if res := q.push(jobDTO{TaskID: 2, APIKeyID: 200, Recovery: true}); res != pushQueued {
+		t.Fatalf("recovery push = %v", res)
+	}
+	if res := q.push(job(3, 300, 1)); res != pushFull {
+		t.Fatalf("push over capacity = %v, want full", res)
+	}
+
+	got, _ := popNow(t, q)
+	if got.TaskID != 2 || !got.Recovery {
+		t.Fatalf("pop = %+v, want recovery of task 2", got)
+	}
+}
+
+func TestJobQueueCloseDrains(t *testing.T) {
+	q := newJobQueue(10)
+	q.push(job(1, 100, 1))
+	q.push(job(2, 200, 1))
+	q.close()
+
+	if res := q.push(job(3, 300, 1)); res != pushClosed {
+		t.Fatalf("push after close = %v, want closed", res)
+	}
+	for _, want := range []int64{1, 2} {
+		got, ok := q.pop(context.Background())
+		if !ok || got.TaskID != want {
+			t.Fatalf("pop = %d (%v), want %d", got.TaskID, ok, want)
+		}
+		q.done(got.TaskID)
+	}
+	// Закрытая пустая очередь отпускает воркера сразу, без ожидания ctx
+	if _, ok := q.pop(context.Background()); ok {
+		t.Fatal("pop on closed empty queue returned a job")
+	}
+}
@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+)
+
+// keyCache - расшифрованные API ключи для воркеров: GetByID - это поход в БД и расшифровка
+// AES-GCM прямо перед отправкой ордеров. Секрет хранится только в памяти, в []byte, и
+// затирается при вытеснении; копии-строки, отданные роллу, живут до сборки мусора.
+type keyCache struct {
+	repo domain.APIKeyRepository
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[int64]*cachedKey
+	// Поколения ключей пользователя: invalidateUser ставит пользователю следующий номер seq.
+	// Чтение из БД, начатое до инвалидации, в кэш не попадает - пользователь станет известен
+	// только после чтения, поэтому сравниваем с seq на момент начала.
+	seq         uint64
+	generations map[int64]uint64
+}
+
+type cachedKey struct {
+	key     domain.APIKey // Без Secret
+	secret  []byte
+	expires time.Time
+}
+
+func newKeyCache(repo domain.APIKeyRepository, ttl time.Duration) *keyCache {
+	return &keyCache{
+		repo:        repo,
+		ttl:         ttl,
+		entries:     make(map[int64]*cachedKey),
+		generations: make(map[int64]uint64),
+	}
+}
+
+// get отдает ключ из кэша или читает его из репозитория; (nil, nil) - ключа нет
+func (c *keyCache) get(ctx context.Context, id int64) (*domain.APIKey, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[id]; ok && now.Before(entry.expires) {
+		key := entry.key
+		key.Secret = string(entry.secret)
+		c.mu.Unlock()
+		return &key, nil
+	}
+	seq := c.seq
+	c.mu.Unlock()
+
+	key, err := c.repo.GetByID(ctx, id)
+	if err != nil || key == nil {
+		return key, err
+	}
+
+	entry := &cachedKey{key: *key, secret: []byte(key.Secret), expires: now.Add(c.ttl)}
+	entry.key.Secret = ""
+
+	c.mu.Lock()
+	if c.generations[key.UserID] > seq {
+		// Ключи пользователя сменились, пока мы читали: прочитанное могло устареть
+		c.mu.Unlock()
+		clear(entry.secret)
+		return key, nil
+	}
+	c.evictLocked(id)
+	c.entries[id] = entry
+	// Заодно выметаем протухшие: ключей немного, полный проход дешевле отдельной горутины
+	for otherID, other := range c.entries {
+		if !now.Before(other.expires) {
+			c.evictLocked(otherID)
+		}
+	}
+	c.mu.Unlock()
+
+	return key, nil
+}
+
+// invalidateUser выбрасывает ключи пользователя: следующий ролл прочитает их из БД
+func (c *keyCache) invalidateUser(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	c.generations[userID] = c.seq
+	for id, entry := range c.entries {
+		if entry.key.UserID == userID {
+			c.evictLocked(id)
+		}
+	}
+}
+
+func (c *keyCache) evictLocked(id int64) {
+	entry, ok := c.entries[id]
+	if !ok {
+		return
+	}
+	clear(entry.secret)
+	delete(c.entries, id)
+}
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/dbtest"
+)
+
+// countingKeys считает чтения ключей; fetched/release позволяют придержать чтение посередине
+type countingKeys struct {
+	domain.APIKeyRepository
+	calls    atomic.Int32
+	fetched  chan struct{}
+	release  chan struct{}
+	blocking atomic.Bool
+}
+
+func (r *countingKeys) GetByID(ctx context.Context, id int64) (*domain.APIKey, error) {
+	r.calls.Add(1)
+	key, err := r.APIKeyRepository.GetByID(ctx, id)
+	if r.blocking.Load() {
+		r.fetched <- struct{}{}
+		<-r.release
+	}
+	return key, err
+}
+
+func TestKeyCacheSkipsFetchRacingInvalidation(t *testing.T) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(t)
+	user := fx.User(t, 1)
+	other := fx.User(t, 2)
+	key := fx.Key(t, user.ID, "key-1")
+	otherKey := fx.Key(t, other.ID, "key-2")
+
+	repo := &countingKeys{APIKeyRepository: fx.Keys, fetched: make(chan struct{}), release: make(chan struct{})}
+	cache := newKeyCache(repo, time.Minute)
+
+	// Ключ прочитан до ротации, а в кэш попал бы после нее
+	repo.blocking.Store(true)
+	done := make(chan error)
+	go func() {
+		_, err := cache.get(ctx, key.ID)
+		done <- err
+	}()
+	<-repo.fetched
+	cache.invalidateUser(user.ID)
+	close(repo.release)
+	if err := <-done; err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	repo.blocking.Store(false)
+
+	if _, err := cache.get(ctx, key.ID); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if calls := repo.calls.Load(); calls != 2 {
+		t.Fatalf("%d reads, want 2: stale fetch was cached", calls)
+	}
+	// Теперь ключ в кэше
+	if _, err := cache.get(ctx, key.ID); err != nil || repo.calls.Load() != 2 {
+		t.Fatalf("key not cached after clean fetch: %d reads, %v", repo.calls.Load(), err)
+	}
+
+	// Инвалидация одного пользователя не мешает кэшировать ключи другого
+	for i := 0; i < 2; i++ {
+		if _, err := cache.get(ctx, otherKey.ID); err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	}
+	if calls := repo.calls.Load(); calls != 3 {
+		t.Fatalf("%d reads, want 3", calls)
+	}
+}
+
+// BenchmarkKeyCacheGet - задержка получения ключа перед ордером: из кэша и из БД с расшифровкой
+func BenchmarkKeyCacheGet(b *testing.B) {
+	ctx := context.Background()
+	fx := dbtest.NewFixture(b)
+	key := fx.Key(b, fx.User(b, 1).ID, "key-1")
+
+	for _, bc := range []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"cached", time.Hour},
+		{"uncached", 0}, // Нулевой TTL: каждый вызов идет в репозиторий
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cache := newKeyCache(fx.Keys, bc.ttl)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if got, err := cache.get(ctx, key.ID); err != nil || got == nil {
+					b.Fatalf("get: %v, %v", got, err)
+				}
+			}
+		})
+	}
+}
@@ -2,87 +2,500 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/romanzzaa/bybit-options-roller/internal/domain"
-	"github.com/romanzzaa/bybit-options-roller/internal/usecase"
-	
+
 	"github.com/shopspring/decimal"
 )
 
+// jobDTO - задача к роллу. Саму задачу воркер читает из БД перед исполнением: кэш Manager
+// не видит Version и символ, которые меняет роллер, а указатель в кэш делили бы воркеры
 type jobDTO struct {
-	Task  *domain.Task
-	Price decimal.Decimal
+	TaskID   int64
+	APIKeyID int64 // Роллы одного ключа выполняются по одному
+	Price    decimal.Decimal
+	Recovery bool // Довести прерванный ролл: идет вне очереди и без проверки цены
+}
+
+// MarketFeeds - рыночные стримы по сетям: цены testnet и mainnet отличаются,
+// поэтому задача слушает сеть своего ключа. Опционные стримы могут быть nil.
+type MarketFeeds struct {
+	Mainnet        domain.MarketStreamer
+	Testnet        domain.MarketStreamer
+	MainnetOptions domain.MarketStreamer
+	TestnetOptions domain.MarketStreamer
+}
+
+func (f MarketFeeds) prices(testnet bool) domain.MarketStreamer {
+	if testnet {
+		return f.Testnet
+	}
+	return f.Mainnet
+}
+
+func (f MarketFeeds) options(testnet bool) domain.MarketStreamer {
+	if testnet {
+		return f.TestnetOptions
+	}
+	return f.MainnetOptions
+}
+
+// Алерт по премии снова взводится, когда премия опустилась ниже этой доли порога:
+// иначе колебания вокруг порога слали бы алерт на каждом тике
+var premiumAlertRearm = decimal.NewFromFloat(0.95)
+
+// Config - размеры пула роллов
+type Config struct {
+	Workers   int // Воркеров, параллельно выполняющих роллы
+	QueueSize int // Максимум задач, ждущих воркера
+	// Период сверки кэша задач с БД: ловит то, что пропустили обновления после роллов
+	ReconcileInterval time.Duration
+	// Сколько при остановке ждать начатые роллы и очередь, прежде чем прервать их
+	DrainTimeout time.Duration
+	// FailureLimit ошибок ролла подряд за FailureWindow переводят задачу в FAILED
+	FailureLimit  int
+	FailureWindow time.Duration
+	// Сколько держать расшифрованный API ключ в памяти между роллами
+	KeyCacheTTL time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Workers:           5,
+		QueueSize:         100,
+		ReconcileInterval: time.Minute,
+		DrainTimeout:      30 * time.Second,
+		FailureLimit:      5,
+		FailureWindow:     10 * time.Minute,
+		KeyCacheTTL:       5 * time.Minute,
+	}
+}
+
+// Stats - загрузка пула роллов для подбора Workers и QueueSize
+type Stats struct {
+	Workers     int
+	QueueSize   int
+	Queued      int
+	InFlight    int
+	ActiveTasks int
+}
+
+// feedEvent - тик с пометкой, из какого стрима он пришел
+type feedEvent struct {
+	event   domain.PriceUpdateEvent
+	testnet bool
+	option  bool
+}
+
+type quoteKey struct {
+	symbol  string
+	testnet bool
+}
+
+// feedKey - один из стримов MarketFeeds
+type feedKey struct {
+	testnet bool
+	option  bool
+}
+
+// TickRecorder сохраняет полученные тики для разбора инцидентов. Record вызывается
+// из цикла тиков и не должен блокироваться.
+type TickRecorder interface {
+	Record(event domain.PriceUpdateEvent, testnet, option bool)
+}
+
+// Roller выполняет ролл задачи; в боте это usecase.RollerService
+type Roller interface {
+	ExecuteRoll(ctx context.Context, apiKey domain.APIKey, task *domain.Task, currentPrice decimal.Decimal) error
 }
 
 type Manager struct {
 	repo     domain.TaskRepository
 	keyRepo  domain.APIKeyRepository
-	roller   *usecase.RollerService
-	streamer domain.MarketStreamer
+	roller   Roller
+	feeds    MarketFeeds
+	notifier domain.NotificationService // может быть nil
+	cfg      Config
 	logger   *slog.Logger
 
-	jobChan chan jobDTO
-	
+	// Последние опционные тики по сетям
+	optionQuotes map[quoteKey]domain.PriceUpdateEvent
+	quotesMu     sync.RWMutex
+
+	// Задача -> опцион, по которому уже отправлен алерт о премии. Только из цикла Run
+	premiumAlerted map[int64]string
+
+	recorder TickRecorder // может быть nil
+
+	// Опрос цен по REST, пока стрим сети лежит (StreamDown). nil - опрос выключен
+	pollExchange domain.ExchangeAdapter
+	pollSource   domain.PriceSource // Поле тикера, как у стрима (TRIGGER_PRICE_SOURCE)
+	pollInterval time.Duration
+	degraded     map[bool]map[string]bool // Сеть -> символы упавшего стрима
+	degradedMu   sync.Mutex
+
+	// Приватный стрим ордеров и позиций (SetAccountStream). nil - выключен
+	account     domain.AccountStreamer
+	orders      domain.OrderRepository
+	watchedKeys map[int64]string // APIKeyID -> ключ, с которым открыто соединение; под reloadMu
+
+	jobs     *jobQueue
+	workers  sync.WaitGroup
+	failures *failureBudget
+	keys     *keyCache
+
 	// --- Hot Reload State ---
-	activeTasks []domain.Task // Кэш задач в памяти
-	mu          sync.RWMutex  // Замок для защиты activeTasks от гонки данных
+	activeTasks   []domain.Task               // Кэш задач в памяти
+	keyTestnet    map[int64]bool              // Сеть ключа по APIKeyID
+	subscriptions map[feedKey]map[string]bool // Символы, нужные активным задачам, по стримам
+	mu            sync.RWMutex                // Замок для защиты activeTasks от гонки данных
+	// Перечитывания набора задач идут по одному: иначе поздний релоад затер бы свежий
+	reloadMu sync.Mutex
 }
 
 func NewManager(
 	tr domain.TaskRepository,
 	kr domain.APIKeyRepository,
-	roller *usecase.RollerService,
-	streamer domain.MarketStreamer,
+	roller Roller,
+	feeds MarketFeeds,
+	notifier domain.NotificationService,
+	cfg Config,
 	logger *slog.Logger,
 ) *Manager {
+	defaults := DefaultConfig()
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaults.Workers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaults.QueueSize
+	}
+	if cfg.ReconcileInterval <= 0 {
+		cfg.ReconcileInterval = defaults.ReconcileInterval
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = defaults.DrainTimeout
+	}
+	if cfg.FailureLimit <= 0 {
+		cfg.FailureLimit = defaults.FailureLimit
+	}
+	if cfg.FailureWindow <= 0 {
+		cfg.FailureWindow = defaults.FailureWindow
+	}
+	if cfg.KeyCacheTTL <= 0 {
+		cfg.KeyCacheTTL = defaults.KeyCacheTTL
+	}
+
 	return &Manager{
-		repo:     tr,
-		keyRepo:  kr,
-		roller:   roller,
-		streamer: streamer,
-		logger:   logger,
-		jobChan:  make(chan jobDTO, 100),
+		repo:           tr,
+		keyRepo:        kr,
+		roller:         roller,
+		feeds:          feeds,
+		notifier:       notifier,
+		cfg:            cfg,
+		optionQuotes:   make(map[quoteKey]domain.PriceUpdateEvent),
+		premiumAlerted: make(map[int64]string),
+		keyTestnet:     make(map[int64]bool),
+		subscriptions:  make(map[feedKey]map[string]bool),
+		degraded:       make(map[bool]map[string]bool),
+		logger:         logger,
+		jobs:           newJobQueue(cfg.QueueSize),
+		failures:       newFailureBudget(cfg.FailureLimit, cfg.FailureWindow),
+		keys:           newKeyCache(kr, cfg.KeyCacheTTL),
+	}
+}
+
+// OptionQuote возвращает последний тик по опциону из WebSocket нужной сети
+func (m *Manager) OptionQuote(symbol string, testnet bool) (domain.PriceUpdateEvent, bool) {
+	m.quotesMu.RLock()
+	defer m.quotesMu.RUnlock()
+	quote, ok := m.optionQuotes[quoteKey{symbol: symbol, testnet: testnet}]
+	return quote, ok
+}
+
+// Stats - текущая загрузка очереди и воркеров
+func (m *Manager) Stats() Stats {
+	queued, inFlight := m.jobs.stats()
+
+	m.mu.RLock()
+	activeTasks := len(m.activeTasks)
+	m.mu.RUnlock()
+
+	return Stats{
+		Workers:     m.cfg.Workers,
+		QueueSize:   m.cfg.QueueSize,
+		Queued:      queued,
+		InFlight:    inFlight,
+		ActiveTasks: activeTasks,
 	}
 }
 
-// ReloadTasks вызывает Handler, когда пользователь добавил задачу
+// SetTickRecorder включает запись тиков; вызывать до Run
+func (m *Manager) SetTickRecorder(recorder TickRecorder) {
+	m.recorder = recorder
+}
+
+// SetRESTFallback включает опрос цен базовых активов через REST на время падения стрима; вызывать до Run.
+// source - то же поле тикера, по которому стрим проверяет триггеры
+func (m *Manager) SetRESTFallback(exchange domain.ExchangeAdapter, source domain.PriceSource, interval time.Duration) {
+	m.pollExchange = exchange
+	m.pollSource = source
+	m.pollInterval = interval
+}
+
+// InvalidateUserKeys сбрасывает закэшированные ключи пользователя; Handler вызывает его,
+// когда пользователь меняет ключи
+func (m *Manager) InvalidateUserKeys(userID int64) {
+	m.keys.invalidateUser(userID)
+}
+
+// OptionPremium - последний тик по текущему опциону задачи (mark price - премия)
+func (m *Manager) OptionPremium(task domain.Task) (domain.PriceUpdateEvent, bool) {
+	m.mu.RLock()
+	testnet := m.keyTestnet[task.APIKeyID]
+	m.mu.RUnlock()
+
+	return m.OptionQuote(task.CurrentOptionSymbol, testnet)
+}
+
+// UnderlyingPrice - последняя цена базового актива задачи из стрима сети ее ключа.
+// ok=false, если по символу еще не было тиков (например, задача только что создана).
+func (m *Manager) UnderlyingPrice(task domain.Task) (decimal.Decimal, time.Time, bool) {
+	m.mu.RLock()
+	testnet := m.keyTestnet[task.APIKeyID]
+	m.mu.RUnlock()
+
+	feed := m.feeds.prices(testnet)
+	if feed == nil {
+		return decimal.Zero, time.Time{}, false
+	}
+	return feed.GetLastPrice(task.UnderlyingSymbol)
+}
+
+// ReloadTasks вызывает Handler, когда пользователь добавил задачу, и периодическая сверка в Run
 func (m *Manager) ReloadTasks(ctx context.Context) error {
-	m.logger.Info("🔄 Hot Reloading tasks...")
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	m.logger.Debug("🔄 Hot Reloading tasks...")
 
 	// 1. Идем в базу за свежим списком
-	newTasks, err := m.repo.GetActiveTasks(ctx) 
+	newTasks, err := m.repo.GetActiveTasks(ctx)
 	if err != nil {
 		return err
 	}
+	keyTestnet := m.resolveKeyNetworks(ctx, newTasks)
+
+	if err := m.applyTasks(newTasks, keyTestnet); err != nil {
+		return err
+	}
+	m.watchAccounts(ctx, newTasks)
+	m.enqueueRecovery(ctx)
+
+	m.logger.Debug("✅ Tasks reloaded", "count", len(newTasks))
+	return nil
+}
+
+// Сколько ROLL_INITIATED должен провисеть без изменений, чтобы считаться прерванным
+const rollRecoveryGrace = time.Minute
+
+// enqueueRecovery ставит вне очереди задачи, ролл которых прервался между ногами: ждать
+// для них тика за триггер нельзя - ShouldRoll пропускает не-IDLE задачи, и прерванный
+// ролл не завершился бы никогда
+func (m *Manager) enqueueRecovery(ctx context.Context) {
+	tasks, err := m.repo.GetTasksByState(ctx, domain.TaskStateRollInitiated, domain.TaskStateLeg1Closed)
+	if err != nil {
+		m.logger.Error("Failed to load interrupted rolls", "err", err)
+		return
+	}
+
+	for _, task := range tasks {
+		// Свежий ROLL_INITIATED - скорее всего ролл идет прямо сейчас (в другом экземпляре);
+		// его подберет следующая сверка. LEG1_CLOSED ждать нельзя: позиция уже закрыта.
+		if task.Status == domain.TaskStateRollInitiated && time.Since(task.UpdatedAt) < rollRecoveryGrace {
+			continue
+		}
+
+		switch m.jobs.push(jobDTO{TaskID: task.ID, APIKeyID: task.APIKeyID, Recovery: true}) {
+		case pushQueued:
+			m.logger.Warn("🚑 Interrupted roll queued for recovery", "task_id", task.ID, "status", task.Status)
+		case pushClosed:
+			return
+		}
+	}
+}
+
+// refreshTask обновляет кэш по одной задаче после ролла: активную заменяет свежей копией,
+// завершенную или упавшую убирает из мониторинга. nil - задачи больше нет в БД.
+func (m *Manager) refreshTask(taskID int64, fresh *domain.Task) error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	m.mu.RLock()
+	tasks := make([]domain.Task, 0, len(m.activeTasks))
+	for _, task := range m.activeTasks {
+		if task.ID != taskID {
+			tasks = append(tasks, task)
+		}
+	}
+	keyTestnet := make(map[int64]bool, len(m.keyTestnet))
+	for id, testnet := range m.keyTestnet {
+		keyTestnet[id] = testnet
+	}
+	m.mu.RUnlock()
+
+	switch {
+	case fresh == nil:
+		m.logger.Info("Task deleted, removed from monitoring", "task_id", taskID)
+	case fresh.IsActive():
+		tasks = append(tasks, *fresh)
+	default:
+		m.logger.Info("Task finished, removed from monitoring", "task_id", taskID, "status", fresh.Status)
+	}
+
+	return m.applyTasks(tasks, keyTestnet)
+}
+
+// applyTasks подменяет набор задач и приводит подписки стримов в соответствие с ним (под reloadMu)
+func (m *Manager) applyTasks(newTasks []domain.Task, keyTestnet map[int64]bool) error {
+	wanted := map[feedKey][]string{
+		{testnet: false}:               underlyingSymbols(newTasks, keyTestnet, false),
+		{testnet: true}:                underlyingSymbols(newTasks, keyTestnet, true),
+		{testnet: false, option: true}: optionSymbols(newTasks, keyTestnet, false),
+		{testnet: true, option: true}:  optionSymbols(newTasks, keyTestnet, true),
+	}
 
 	// 2. Обновляем кэш под замком (Thread-Safe)
 	m.mu.Lock()
+	addedTasks, removedTasks := diffTaskIDs(m.activeTasks, newTasks)
 	m.activeTasks = newTasks
+	m.keyTestnet = keyTestnet
+	stale := make(map[feedKey][]string)
+	var subscribed, unsubscribed []string
+	for key, symbols := range wanted {
+		set := make(map[string]bool, len(symbols))
+		for _, symbol := range symbols {
+			set[symbol] = true
+			if !m.subscriptions[key][symbol] {
+				subscribed = append(subscribed, symbol)
+			}
+		}
+		for symbol := range m.subscriptions[key] {
+			if !set[symbol] {
+				stale[key] = append(stale[key], symbol)
+				unsubscribed = append(unsubscribed, symbol)
+			}
+		}
+		m.subscriptions[key] = set
+	}
 	m.mu.Unlock()
 
-	// 3. Собираем символы для подписки
-	symbolMap := make(map[string]bool)
+	// Пустой дифф (обычная периодическая сверка) не логируем, чтобы не шуметь
+	if len(addedTasks)+len(removedTasks)+len(subscribed)+len(unsubscribed) > 0 {
+		m.logger.Info("🔄 Monitored tasks changed",
+			"tasks", len(newTasks),
+			"added_tasks", addedTasks,
+			"removed_tasks", removedTasks,
+			"subscribed", subscribed,
+			"unsubscribed", unsubscribed)
+	}
+
+	// 3. Отписываемся от символов, по которым не осталось задач
+	m.removeStale(stale)
+
+	// 4. Динамически подписываемся на WebSocket каждой сети
+	for _, testnet := range []bool{false, true} {
+		if symbols := wanted[feedKey{testnet: testnet}]; len(symbols) > 0 {
+			feed := m.feeds.prices(testnet)
+			if feed == nil {
+				m.logger.Error("No price feed for network", "testnet", testnet, "symbols", symbols)
+			} else if err := feed.AddSubscriptions(symbols); err != nil {
+				m.logger.Error("Failed to add subscriptions", "err", err)
+				return err
+			}
+		}
+
+		if feed := m.feeds.options(testnet); feed != nil {
+			if options := wanted[feedKey{testnet: testnet, option: true}]; len(options) > 0 {
+				if err := feed.AddSubscriptions(options); err != nil {
+					// Опционные тики не влияют на триггер, поэтому не валим релоад
+					m.logger.Error("Failed to add option subscriptions", "err", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// diffTaskIDs - ID задач, которые появились и пропали при замене набора
+func diffTaskIDs(oldTasks, newTasks []domain.Task) (added, removed []int64) {
+	oldIDs := make(map[int64]bool, len(oldTasks))
+	for _, task := range oldTasks {
+		oldIDs[task.ID] = true
+	}
+	newIDs := make(map[int64]bool, len(newTasks))
 	for _, task := range newTasks {
-		symbolMap[task.UnderlyingSymbol] = true
+		newIDs[task.ID] = true
+		if !oldIDs[task.ID] {
+			added = append(added, task.ID)
+		}
 	}
-	var symbols []string
-	for sym := range symbolMap {
-		symbols = append(symbols, sym)
+	for _, task := range oldTasks {
+		if !newIDs[task.ID] {
+			removed = append(removed, task.ID)
+		}
 	}
+	return added, removed
+}
+
+// removeStale отписывает стримы от символов без задач и чистит их опционные котировки
+func (m *Manager) removeStale(stale map[feedKey][]string) {
+	for key, symbols := range stale {
+		feed := m.feeds.prices(key.testnet)
+		if key.option {
+			feed = m.feeds.options(key.testnet)
+
+			m.quotesMu.Lock()
+			for _, symbol := range symbols {
+				delete(m.optionQuotes, quoteKey{symbol: symbol, testnet: key.testnet})
+			}
+			m.quotesMu.Unlock()
+		}
+		if feed == nil {
+			continue
+		}
 
-	// 4. Динамически подписываемся на WebSocket
-	// Внимание: Этот метод требует обновления в интерфейсе MarketStreamer (см. Шаг 2 и 3)
-	if len(symbols) > 0 {
-		if err := m.streamer.AddSubscriptions(symbols); err != nil {
-			m.logger.Error("Failed to add subscriptions", "err", err)
-			return err
+		if err := feed.RemoveSubscriptions(symbols); err != nil {
+			m.logger.Warn("Failed to remove subscriptions", "symbols", symbols, "err", err)
+			continue
 		}
+		m.logger.Info("Unsubscribed symbols without tasks", "symbols", symbols, "testnet", key.testnet, "option", key.option)
 	}
-	
-	m.logger.Info("✅ Tasks reloaded", "count", len(newTasks))
-	return nil
+}
+
+// resolveKeyNetworks узнает сеть каждого ключа; ключ, который не удалось прочитать, считаем mainnet
+func (m *Manager) resolveKeyNetworks(ctx context.Context, tasks []domain.Task) map[int64]bool {
+	networks := make(map[int64]bool)
+	for _, task := range tasks {
+		if _, ok := networks[task.APIKeyID]; ok {
+			continue
+		}
+		networks[task.APIKeyID] = false
+
+		key, err := m.keys.get(ctx, task.APIKeyID)
+		if err != nil || key == nil {
+			m.logger.Error("Failed to resolve api key network", "api_key_id", task.APIKeyID, "err", err)
+			continue
+		}
+		networks[task.APIKeyID] = key.IsTestnet
+	}
+	return networks
 }
 
 func (m *Manager) Run(ctx context.Context) {
@@ -95,75 +508,630 @@ func (m *Manager) Run(ctx context.Context) {
 
 	// Подписка (даже если список пуст, запускаем слушателя)
 	m.mu.RLock()
-	initialSymbols := make([]string, 0)
-	for _, t := range m.activeTasks {
-		initialSymbols = append(initialSymbols, t.UnderlyingSymbol)
-	}
+	tasks := m.activeTasks
+	keyTestnet := m.keyTestnet
 	m.mu.RUnlock()
 
-	priceUpdates, err := m.streamer.Subscribe(initialSymbols)
-	if err != nil {
-		m.logger.Error("CRITICAL: Failed to initialize stream", "err", err)
+	events := make(chan feedEvent, 100)
+	subscribed := 0
+	for _, testnet := range []bool{false, true} {
+		if feed := m.feeds.prices(testnet); feed != nil {
+			updates, err := feed.Subscribe(ctx, underlyingSymbols(tasks, keyTestnet, testnet))
+			if err != nil {
+				m.logger.Error("CRITICAL: Failed to initialize stream", "testnet", testnet, "err", err)
+				return
+			}
+			go forwardEvents(ctx, updates, events, testnet, false)
+			go m.watchHealth(ctx, feed.Health(), testnet, false)
+			subscribed++
+		}
+
+		if feed := m.feeds.options(testnet); feed != nil {
+			updates, err := feed.Subscribe(ctx, optionSymbols(tasks, keyTestnet, testnet))
+			if err != nil {
+				m.logger.Error("Failed to initialize option stream", "testnet", testnet, "err", err)
+				continue
+			}
+			go forwardEvents(ctx, updates, events, testnet, true)
+			go m.watchHealth(ctx, feed.Health(), testnet, true)
+		}
+	}
+	if subscribed == 0 {
+		m.logger.Error("CRITICAL: No price feeds configured")
 		return
 	}
+	if m.pollExchange != nil {
+		go m.pollPrices(ctx, events)
+	}
+	if m.account != nil {
+		go m.consumeAccount(ctx)
+	}
+
+	// Воркеры. Роллы не отменяются вместе с ctx: начатый ролл (между ногами особенно)
+	// лучше довести до конца, поэтому их прерывает только drain по истечении DrainTimeout
+	rollCtx, cancelRolls := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRolls()
 
-	// Воркеры
-	for i := 0; i < 5; i++ {
-		go m.worker(ctx, i)
+	m.logger.Info("Starting roll workers", "workers", m.cfg.Workers, "queue_size", m.cfg.QueueSize)
+	for i := 0; i < m.cfg.Workers; i++ {
+		m.workers.Add(1)
+		go m.worker(rollCtx, i)
 	}
 
+	// Периодическая сверка с БД дополняет явный ReloadTasks из бота: подхватывает задачи,
+	// измененные мимо Handler (вручную в БД, другим процессом)
+	m.logger.Info("Task reconciliation enabled", "interval", m.cfg.ReconcileInterval)
+	reconcile := time.NewTicker(m.cfg.ReconcileInterval)
+	defer reconcile.Stop()
+
 	// Loop
 	m.logger.Info("Manager loop started.")
 	for {
 		select {
-		case event, ok := <-priceUpdates:
-			if !ok {
-				return
+		case <-reconcile.C:
+			// В фоне: запросы к БД не должны задерживать тики
+			go func() {
+				if err := m.ReloadTasks(ctx); err != nil {
+					m.logger.Error("Task reconciliation failed", "err", err)
+				}
+			}()
+
+		case fe := <-events:
+			if m.recorder != nil {
+				m.recorder.Record(fe.event, fe.testnet, fe.option)
 			}
 
-			// Читаем задачи под R-замком (параллельное чтение разрешено)
+			// Тик по символу, от которого уже отписались, может прийти после RemoveSubscriptions
 			m.mu.RLock()
-			var affectedTasks []*domain.Task
-			// Важно: activeTasks теперь актуален всегда
-			for i := range m.activeTasks {
-				// Берем указатель на задачу в слайсе, чтобы не копировать
-				task := &m.activeTasks[i] 
-				if task.UnderlyingSymbol == event.Symbol && task.ShouldRoll(event.Price) {
-					affectedTasks = append(affectedTasks, task)
-				}
-			}
+			known := m.subscriptions[feedKey{testnet: fe.testnet, option: fe.option}][fe.event.Symbol]
 			m.mu.RUnlock()
+			if !known {
+				continue
+			}
+
+			if fe.option {
+				m.quotesMu.Lock()
+				m.optionQuotes[quoteKey{symbol: fe.event.Symbol, testnet: fe.testnet}] = fe.event
+				m.quotesMu.Unlock()
 
-			for _, task := range affectedTasks {
-				m.jobChan <- jobDTO{Task: task, Price: event.Price}
+				m.checkPremiumAlerts(fe.event, fe.testnet)
+				continue
+			}
+
+			event := fe.event
+			for _, job := range m.triggeredTasks(event, fe.testnet) {
+				switch m.jobs.push(job) {
+				case pushInFlight:
+					m.logger.Debug("Task is already being rolled, tick skipped", "task_id", job.TaskID, "price", event.Price)
+				case pushFull:
+					// Задача сработает на следующем тике, когда воркеры разгребут очередь
+					m.logger.Warn("Job queue is full, roll postponed", "task_id", job.TaskID, "queue_size", m.cfg.QueueSize)
+				}
 			}
 
 		case <-ctx.Done():
+			m.closeFeeds()
+			m.unwatchAccounts()
+			m.drain(cancelRolls)
 			return
 		}
 	}
 }
 
+// drain - остановка пула: новые задания больше не принимаются, воркеры дорабатывают очередь
+// и начатые роллы. Если не уложились в DrainTimeout, оставшиеся роллы прерываются -
+// их доведет режим восстановления при следующем запуске.
+func (m *Manager) drain(cancelRolls context.CancelFunc) {
+	m.jobs.close()
+
+	queued, inFlight := m.jobs.stats()
+	m.logger.Info("⏳ Draining roll workers", "queued", queued, "in_flight", inFlight, "timeout", m.cfg.DrainTimeout)
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		m.logger.Info("✅ Roll workers drained")
+	case <-time.After(m.cfg.DrainTimeout):
+		queued, inFlight = m.jobs.stats()
+		m.logger.Warn("Drain timeout, aborting unfinished rolls", "queued", queued, "in_flight", inFlight)
+		cancelRolls()
+		<-done
+	}
+}
+
+// triggeredTasks - задания по задачам сети тика, триггер которых пробит. Набор задач читается
+// под R-замком: ReloadTasks подменяет его целиком, и цикл всегда видит актуальный список.
+func (m *Manager) triggeredTasks(event domain.PriceUpdateEvent, testnet bool) []jobDTO {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var jobs []jobDTO
+	for _, task := range m.activeTasks {
+		if m.keyTestnet[task.APIKeyID] != testnet {
+			continue
+		}
+		if task.UnderlyingSymbol == event.Symbol && task.ShouldRoll(event.Price) {
+			jobs = append(jobs, jobDTO{TaskID: task.ID, APIKeyID: task.APIKeyID, Price: event.Price})
+		}
+	}
+	return jobs
+}
+
+// FeedStatus - состояние одного рыночного стрима для админки
+type FeedStatus struct {
+	Feed   string
+	Status domain.StreamStatus
+}
+
+// StreamStatus возвращает состояние всех настроенных стримов
+func (m *Manager) StreamStatus() []FeedStatus {
+	feeds := []struct {
+		name string
+		feed domain.MarketStreamer
+	}{
+		{"mainnet", m.feeds.Mainnet},
+		{"testnet", m.feeds.Testnet},
+		{"mainnet options", m.feeds.MainnetOptions},
+		{"testnet options", m.feeds.TestnetOptions},
+	}
+
+	var statuses []FeedStatus
+	for _, f := range feeds {
+		if f.feed == nil {
+			continue
+		}
+		statuses = append(statuses, FeedStatus{Feed: f.name, Status: f.feed.Status()})
+	}
+	return statuses
+}
+
+// closeFeeds останавливает все стримы; один стрим может обслуживать обе сети
+func (m *Manager) closeFeeds() {
+	closed := make(map[domain.MarketStreamer]bool)
+	for _, feed := range []domain.MarketStreamer{m.feeds.Mainnet, m.feeds.Testnet, m.feeds.MainnetOptions, m.feeds.TestnetOptions} {
+		if feed == nil || closed[feed] {
+			continue
+		}
+		closed[feed] = true
+
+		if err := feed.Close(); err != nil {
+			m.logger.Error("Failed to close market stream", "err", err)
+		}
+	}
+	m.logger.Info("Market streams closed")
+}
+
 func (m *Manager) worker(ctx context.Context, id int) {
+	defer m.workers.Done()
 	defer func() {
 		if r := recover(); r != nil {
-			m.logger.Error("Worker panicked! Restarting...", 
-				slog.Int("worker_id", id), 
+			m.logger.Error("Worker panicked! Restarting...",
+				slog.Int("worker_id", id),
 				slog.Any("panic", r))
-			// Перезапускаем воркера, чтобы пул не истощился
+			// Паники роллов ловит process; сюда доходят только сбои самого воркера.
+			// Перезапускаем воркера, чтобы пул не истощился (Add до Done - drain не проскочит)
+			m.workers.Add(1)
 			go m.worker(ctx, id)
 		}
 	}()
+	for {
+		job, ok := m.jobs.pop(ctx)
+		if !ok {
+			return
+		}
+		m.process(ctx, job)
+	}
+}
+
+// process выполняет ролл по свежей копии задачи из БД; отметка "в работе" снимается и при панике
+func (m *Manager) process(ctx context.Context, job jobDTO) {
+	defer m.jobs.done(job.TaskID)
+	defer m.recoverRoll(ctx, job.TaskID)
+
+	// Второй экземпляр бота (резерв) видит те же тики: задачу катает тот, кто первым взял лок
+	err := m.repo.WithTaskLock(ctx, job.TaskID, func(ctx context.Context) error {
+		m.execute(ctx, job)
+		return nil
+	})
+	if errors.Is(err, domain.ErrTaskLocked) {
+		m.logger.Debug("Task is being rolled by another instance, skipped", "task_id", job.TaskID)
+		return
+	}
+	if err != nil {
+		m.logger.Error("Failed to lock task for roll", "task_id", job.TaskID, "err", err)
+	}
+}
+
+// execute - ролл под локом задачи
+func (m *Manager) execute(ctx context.Context, job jobDTO) {
+	task, err := m.repo.GetTaskByID(ctx, job.TaskID)
+	if err != nil {
+		m.logger.Error("Failed to load task for roll", "task_id", job.TaskID, "err", err)
+		return
+	}
+	if task == nil {
+		m.logger.Warn("Triggered task no longer exists", "task_id", job.TaskID)
+		return
+	}
+	// Задание восстановления идет без цены: если ролл тем временем довели (другой экземпляр
+	// под локом или сам ролл между выборкой и push), задача уже в IDLE, и ShouldRoll(0)
+	// для пута запустил бы настоящий ролл, которого никто не просил
+	if job.Recovery && !task.NeedsRecovery() {
+		m.logger.Info("Interrupted roll already finished, recovery skipped", "task_id", task.ID, "status", task.Status)
+		return
+	}
+
+	apiKey, err := m.keys.get(ctx, task.APIKeyID)
+	if err != nil || apiKey == nil {
+		m.logger.Error("Failed to load api key for roll", "task_id", task.ID, "api_key_id", task.APIKeyID, "err", err)
+		return
+	}
+	err = m.roller.ExecuteRoll(ctx, *apiKey, task, job.Price)
+	m.trackResult(ctx, task, err)
+
+	m.syncTask(ctx, task.ID)
+}
+
+// trackResult ведет бюджет ошибок задачи: исчерпав его, задача уходит в FAILED и больше
+// не диспетчеризуется, а владелец получает уведомление
+func (m *Manager) trackResult(ctx context.Context, task *domain.Task, err error) {
+	if err == nil {
+		m.failures.reset(task.ID)
+		return
+	}
+	// Лок задачи потерян посреди ролла: его доведет восстановление под новым локом
+	if errors.Is(err, domain.ErrTaskLockLost) {
+		m.logger.Warn("Roll aborted: task lock lost", "task_id", task.ID, "err", err)
+		return
+	}
+	// Прерванный остановкой ролл - не ошибка задачи
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	count, open := m.failures.fail(task.ID, time.Now())
+	if !open {
+		m.logger.Warn("Roll failed", "task_id", task.ID, "failures", count, "limit", m.cfg.FailureLimit, "err", err)
+		return
+	}
+
+	// Ролл мог остановиться между ногами (исход закрывающего ордера неизвестен или Leg 1 закрыта):
+	// FAILED оставил бы позицию наполовину переложенной. Такую задачу доводит восстановление.
+	fresh, getErr := m.repo.GetTaskByID(ctx, task.ID)
+	if getErr != nil || fresh == nil {
+		m.logger.Error("Failed to reload failing task", "task_id", task.ID, "err", getErr)
+		return
+	}
+	if fresh.IsMidRoll() {
+		m.logger.Error("⛔ Circuit open on an interrupted roll, leaving it to recovery",
+			"task_id", task.ID,
+			"status", fresh.Status,
+			"failures", count,
+			"err", err)
+		m.failures.reset(task.ID)
+		m.notify(task.UserID, fmt.Sprintf("⚠️ Задача #%d: %d ошибок ролла подряд за %.0f мин, ролл остановился между ногами (%s). Бот попробует довести его, проверьте позицию на бирже. Последняя ошибка: %v.",
+			task.ID, count, m.cfg.FailureWindow.Minutes(), fresh.Status, err))
+		return
+	}
+
+	m.logger.Error("⛔ Circuit open: task keeps failing, stopping it",
+		"task_id", task.ID,
+		"failures", count,
+		"window", m.cfg.FailureWindow,
+		"err", err)
+
+	// %v, а не %w: временная последняя ошибка не должна вернуть задачу в IDLE
+	reason := fmt.Errorf("circuit open: %d failures within %s, last: %v", count, m.cfg.FailureWindow, err)
+	if regErr := m.repo.RegisterError(ctx, task.ID, fresh.Version, reason); regErr != nil {
+		m.logger.Error("Failed to stop failing task", "task_id", task.ID, "err", regErr)
+		return
+	}
+	m.failures.reset(task.ID)
+
+	m.notify(task.UserID, fmt.Sprintf("⛔ Задача #%d остановлена: %d ошибок ролла подряд за %.0f мин. Последняя ошибка: %v. Проверьте API ключ и опцион, затем создайте задачу заново.",
+		task.ID, count, m.cfg.FailureWindow.Minutes(), err))
+}
+
+// recoverRoll перехватывает панику ролла: ошибка пишется в задачу, а воркер берет следующее задание.
+// Задачу посреди ролла не трогаем: ее статус нужен enqueueRecovery, чтобы довести ролл.
+func (m *Manager) recoverRoll(ctx context.Context, taskID int64) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	m.logger.Error("🔥 Roll panicked",
+		"task_id", taskID,
+		"panic", r,
+		"stack", string(debug.Stack()))
+
+	task, err := m.repo.GetTaskByID(ctx, taskID)
+	if err != nil || task == nil {
+		m.logger.Error("Failed to load panicked task", "task_id", taskID, "err", err)
+		return
+	}
+	if !task.IsMidRoll() {
+		if err := m.repo.RegisterError(ctx, taskID, task.Version, fmt.Errorf("roll panicked: %v", r)); err != nil {
+			m.logger.Error("Failed to register roll panic", "task_id", taskID, "err", err)
+		}
+	}
+	if task := m.syncTask(ctx, taskID); task != nil {
+		m.notify(task.UserID, fmt.Sprintf("⚠️ Ролл задачи #%d (%s) прерван внутренней ошибкой. Проверьте позицию на бирже и статус задачи в /status.",
+			task.ID, task.Title()))
+	}
+}
+
+// syncTask перечитывает задачу после ролла. Ролл меняет символ и статус задачи в БД: обновляем
+// кэш, чтобы завершенная задача больше не сканировалась, а подписка переехала на новый опцион.
+// Возвращает свежую копию (nil, если задачи нет или ее не удалось прочитать).
+func (m *Manager) syncTask(ctx context.Context, taskID int64) *domain.Task {
+	fresh, err := m.repo.GetTaskByID(ctx, taskID)
+	if err != nil {
+		// Поправит периодическая сверка
+		m.logger.Error("Failed to reload task after roll", "task_id", taskID, "err", err)
+		return nil
+	}
+	if err := m.refreshTask(taskID, fresh); err != nil {
+		m.logger.Error("Failed to refresh task after roll", "task_id", taskID, "err", err)
+	}
+	return fresh
+}
+
+// checkPremiumAlerts предупреждает владельцев задач, если премия их проданного опциона
+// пробила порог: это ранний сигнал, что цена идет к триггеру
+func (m *Manager) checkPremiumAlerts(event domain.PriceUpdateEvent, testnet bool) {
+	if event.Price.IsZero() {
+		return
+	}
+
+	m.mu.RLock()
+	var tasks []domain.Task
+	for _, task := range m.activeTasks {
+		if task.CurrentOptionSymbol == event.Symbol && m.keyTestnet[task.APIKeyID] == testnet && task.PremiumAlertThreshold.IsPositive() {
+			tasks = append(tasks, task)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, task := range tasks {
+		alerted := m.premiumAlerted[task.ID] == event.Symbol
+		switch {
+		case !alerted && event.Price.GreaterThanOrEqual(task.PremiumAlertThreshold):
+			m.premiumAlerted[task.ID] = event.Symbol
+			m.logger.Warn("Premium alert threshold breached",
+				"task_id", task.ID,
+				"symbol", event.Symbol,
+				"premium", event.Price,
+				"threshold", task.PremiumAlertThreshold)
+			m.notify(task.UserID, fmt.Sprintf("⚠️ Премия опциона %s выросла до %s (порог %s). Цена базового актива приближается к триггеру %s.",
+				task.Title(), event.Price.String(), task.PremiumAlertThreshold.String(), task.TriggerPrice.String()))
+		case alerted && event.Price.LessThan(task.PremiumAlertThreshold.Mul(premiumAlertRearm)):
+			delete(m.premiumAlerted, task.ID)
+		}
+	}
+}
+
+// notify не ждет Telegram: NotificationService только ставит сообщение в очередь
+func (m *Manager) notify(userID int64, message string) {
+	if m.notifier == nil {
+		return
+	}
+	if err := m.notifier.NotifyUser(userID, message); err != nil {
+		m.logger.Warn("Failed to notify user", "user_id", userID, "err", err)
+	}
+}
+
+// notifyAdmin, как notify, только ставит сообщение администратору в очередь
+func (m *Manager) notifyAdmin(message string) {
+	if m.notifier == nil {
+		return
+	}
+	if err := m.notifier.NotifyAdmin(message); err != nil {
+		m.logger.Warn("Failed to notify admin", "err", err)
+	}
+}
+
+// fallbackNote - чем проверяются триггеры, пока стрим лежит
+func (m *Manager) fallbackNote(option bool) string {
+	switch {
+	case option:
+		return "Премии опционов не обновляются."
+	case m.pollExchange != nil:
+		return fmt.Sprintf("Цены опрашиваются по REST раз в %s.", m.pollInterval)
+	default:
+		return "Опрос по REST выключен: триггеры не проверяются."
+	}
+}
+
+func networkName(testnet bool) string {
+	if testnet {
+		return "testnet"
+	}
+	return "mainnet"
+}
+
+// watchHealth логирует предупреждения стрима о молчащих и отклоненных символах: пока тиков нет, триггеры не срабатывают.
+// Падение стрима цен переключает его символы на опрос по REST до восстановления.
+func (m *Manager) watchHealth(ctx context.Context, health <-chan domain.StreamHealthEvent, testnet, option bool) {
 	for {
 		select {
-		case job := <-m.jobChan:
-			apiKey, err := m.keyRepo.GetByID(ctx, job.Task.APIKeyID)
-			if err != nil {
+		case event := <-health:
+			switch event.Kind {
+			case domain.StreamDown:
+				m.logger.Error("🚨 Market stream is down, triggers depend on REST polling",
+					"source", event.Source,
+					"symbols", event.Symbols,
+					"down_for", event.Silence.Round(time.Second),
+					"reason", event.Reason,
+					"testnet", testnet,
+					"option", option,
+					"polling", m.pollExchange != nil && !option)
+				if !option {
+					m.setDegraded(testnet, event.Symbols, true)
+				}
+				m.notifyAdmin(fmt.Sprintf("🚨 Стрим цен %s лежит %s (%s): %s. %s",
+					networkName(testnet), event.Silence.Round(time.Second), event.Reason, strings.Join(event.Symbols, ", "), m.fallbackNote(option)))
+				continue
+			case domain.StreamRecovered:
+				m.logger.Info("✅ Market stream recovered",
+					"source", event.Source,
+					"symbols", event.Symbols,
+					"testnet", testnet,
+					"option", option)
+				if !option {
+					m.setDegraded(testnet, event.Symbols, false)
+				}
+				m.notifyAdmin(fmt.Sprintf("✅ Стрим цен %s восстановлен: %s", networkName(testnet), strings.Join(event.Symbols, ", ")))
 				continue
 			}
-			_ = m.roller.ExecuteRoll(ctx, *apiKey, job.Task, job.Price)
+
+			if event.Kind == domain.StreamRejected {
+				// Повторять бессмысленно: задачи по этим символам не сработают, пока символ не исправят
+				m.logger.Error("🚨 Exchange rejected subscription, tasks on these symbols will never trigger",
+					"source", event.Source,
+					"symbols", event.Symbols,
+					"reason", event.Reason,
+					"testnet", testnet)
+				continue
+			}
+			m.logger.Warn("⚠️ Market stream went silent, positions are not monitored until it recovers",
+				"source", event.Source,
+				"symbols", event.Symbols,
+				"silence", event.Silence,
+				"testnet", testnet)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Manager) setDegraded(testnet bool, symbols []string, down bool) {
+	m.degradedMu.Lock()
+	defer m.degradedMu.Unlock()
+
+	if m.degraded[testnet] == nil {
+		m.degraded[testnet] = make(map[string]bool)
+	}
+	for _, symbol := range symbols {
+		if down {
+			m.degraded[testnet][symbol] = true
+		} else {
+			delete(m.degraded[testnet], symbol)
+		}
+	}
+}
+
+// Источник цен опроса: Bybit REST tickers; после двоеточия - поле тикера (pollSource)
+const sourceRESTPoll = "bybit-rest-poll:"
+
+// pollPrices, пока стрим сети лежит, раз в pollInterval запрашивает цены его символов по полю
+// pollSource и отдает их в общий цикл тиков как обычные события
+func (m *Manager) pollPrices(ctx context.Context, events chan<- feedEvent) {
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+
+	polling := map[bool]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, testnet := range []bool{false, true} {
+			symbols := m.degradedSymbols(testnet)
+			if active := len(symbols) > 0; active != polling[testnet] {
+				polling[testnet] = active
+				if active {
+					m.logger.Warn("🛟 REST price polling started", "testnet", testnet, "symbols", symbols, "interval", m.pollInterval)
+				} else {
+					m.logger.Info("REST price polling stopped", "testnet", testnet)
+				}
+			}
+
+			for _, symbol := range symbols {
+				price, err := m.pollExchange.GetUnderlyingPrice(domain.WithNetwork(ctx, testnet), symbol, m.pollSource)
+				if err != nil {
+					m.logger.Warn("REST price poll failed", "symbol", symbol, "testnet", testnet, "err", err)
+					continue
+				}
+
+				event := domain.PriceUpdateEvent{Symbol: symbol, Price: price, Time: time.Now(), Source: sourceRESTPoll + string(m.pollSource)}
+				select {
+				case events <- feedEvent{event: event, testnet: testnet}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// degradedSymbols - символы упавшего стрима сети, по которым еще есть задачи
+func (m *Manager) degradedSymbols(testnet bool) []string {
+	m.degradedMu.Lock()
+	down := make([]string, 0, len(m.degraded[testnet]))
+	for symbol := range m.degraded[testnet] {
+		down = append(down, symbol)
+	}
+	m.degradedMu.Unlock()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	symbols := down[:0]
+	for _, symbol := range down {
+		if m.subscriptions[feedKey{testnet: testnet}][symbol] {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+func forwardEvents(ctx context.Context, in <-chan domain.PriceUpdateEvent, out chan<- feedEvent, testnet, option bool) {
+	for {
+		select {
+		case event, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case out <- feedEvent{event: event, testnet: testnet, option: option}:
+			case <-ctx.Done():
+				return
+			}
 		case <-ctx.Done():
 			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+func underlyingSymbols(tasks []domain.Task, keyTestnet map[int64]bool, testnet bool) []string {
+	seen := make(map[string]bool)
+	symbols := make([]string, 0)
+	for _, task := range tasks {
+		if keyTestnet[task.APIKeyID] != testnet || seen[task.UnderlyingSymbol] {
+			continue
+		}
+		seen[task.UnderlyingSymbol] = true
+		symbols = append(symbols, task.UnderlyingSymbol)
+	}
+	return symbols
+}
+
+func optionSymbols(tasks []domain.Task, keyTestnet map[int64]bool, testnet bool) []string {
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, task := range tasks {
+		if keyTestnet[task.APIKeyID] != testnet || task.CurrentOptionSymbol == "" || seen[task.CurrentOptionSymbol] {
+			continue
+		}
+		seen[task.CurrentOptionSymbol] = true
+		symbols = append(symbols, task.CurrentOptionSymbol)
+	}
+	return symbols
+}
@@ -0,0 +1,611 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romanzzaa/bybit-options-roller/internal/domain"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/database/dbtest"
+	"github.com/romanzzaa/bybit-options-roller/internal/infrastructure/fakeexchange"
+	"github.com/romanzzaa/bybit-options-roller/internal/usecase"
+	"github.com/shopspring/decimal"
+)
+
+// testEnv - Manager над SQLite и фейковой биржей. Каждый новый ключ получает на бирже
+// короткий пут symbol объемом 0.1.
+type testEnv struct {
+	fx       *dbtest.Fixture
+	exchange *fakeexchange.Exchange
+	manager  *Manager
+	symbol   string
+}
+
+func newTestEnv(t *testing.T, cfg Config) *testEnv {
+	t.Helper()
+	fx := dbtest.NewFixture(t)
+	exCfg := fakeexchange.DefaultConfig(time.Now())
+	exchange := fakeexchange.New(exCfg)
+	roller := usecase.NewRollerService(exchange, fx.Tasks, fx.Orders, nil, usecase.DefaultExecutionConfig(), dbtest.Logger())
+	return &testEnv{
+		fx:       fx,
+		exchange: exchange,
+		manager:  NewManager(fx.Tasks, fx.Keys, roller, MarketFeeds{}, nil, cfg, dbtest.Logger()),
+		symbol:   exCfg.StartPositions[0].Symbol,
+	}
+}
+
+// task создает задачу на новом ключе пользователя telegramID
+func (e *testEnv) task(t *testing.T, telegramID int64, status domain.TaskState) *domain.Task {
+	t.Helper()
+	user := e.fx.User(t, telegramID)
+	key := e.fx.Key(t, user.ID, "key-"+strconv.FormatInt(telegramID, 10))
+	return e.fx.Task(t, key, e.symbol, 59000, status)
+}
+
+// sameKeyTask - вторая задача на ключе task по другому страйку; позицию на бирже кладет сама
+func (e *testEnv) sameKeyTask(t *testing.T, task *domain.Task) *domain.Task {
+	t.Helper()
+	key, err := e.fx.Keys.GetByID(context.Background(), task.APIKeyID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	symbol := strings.Replace(e.symbol, "-58000-", "-50000-", 1)
+	e.exchange.SetPosition(key.Key, domain.Position{Symbol: symbol, Side: domain.SideSell, Qty: decimal.NewFromFloat(0.1)})
+	return e.fx.Task(t, key, symbol, 59000, domain.TaskStateIdle)
+}
+
+// start загружает задачи и запускает воркеров, как Run, но без стримов: тики подает тест
+func (e *testEnv) start(t *testing.T) context.CancelFunc {
+	t.Helper()
+	if err := e.manager.ReloadTasks(context.Background()); err != nil {
+		t.Fatalf("reload tasks: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < e.manager.cfg.Workers; i++ {
+		e.manager.workers.Add(1)
+		go e.manager.worker(ctx, i)
+	}
+	return cancel
+}
+
+// tick ставит в очередь задачи, сработавшие на цене BTC, как цикл Run
+func (e *testEnv) tick(price int64) {
+	event := domain.PriceUpdateEvent{Symbol: "BTCUSDT", Price: decimal.NewFromInt(price)}
+	for _, job := range e.manager.triggeredTasks(event, false) {
+		e.manager.jobs.push(job)
+	}
+}
+
+// fakeRoller - ролл по сценарию теста: run решает исход по задаче
+type fakeRoller struct {
+	mu    sync.Mutex
+	calls []int64
+	run   func(task *domain.Task) error
+}
+
+func (r *fakeRoller) ExecuteRoll(_ context.Context, _ domain.APIKey, task *domain.Task, _ decimal.Decimal) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, task.ID)
+	r.mu.Unlock()
+	return r.run(task)
+}
+
+// useRoller пересобирает Manager над тем же окружением с другим роллом
+func (e *testEnv) useRoller(cfg Config, roller Roller, notifier domain.NotificationService) {
+	e.manager = NewManager(e.fx.Tasks, e.fx.Keys, roller, MarketFeeds{}, notifier, cfg, dbtest.Logger())
+}
+
+func (e *testEnv) rollEventID(t *testing.T, taskID int64) int64 {
+	t.Helper()
+	events, err := e.fx.Tasks.ListTaskEvents(context.Background(), taskID, 1)
+	if err != nil || len(events) == 0 || events[0].Type != domain.TaskEventRolled {
+		t.Fatalf("task %d has no roll event: %v %+v", taskID, err, events)
+	}
+	return events[0].ID
+}
+
+func (e *testEnv) position(t *testing.T, task *domain.Task, symbol string) decimal.Decimal {
+	t.Helper()
+	key, err := e.fx.Keys.GetByID(context.Background(), task.APIKeyID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	pos, err := e.exchange.GetPosition(context.Background(), *key, symbol)
+	if err != nil {
+		t.Fatalf("get position: %v", err)
+	}
+	return pos.Qty
+}
+
+func TestExecuteRecoverySkipsFinishedRoll(t *testing.T) {
+	env := newTestEnv(t, DefaultConfig())
+	// Ролл уже довели, пока задание восстановления ждало воркера: задача снова в IDLE,
+	// а триггер пута выше нулевой цены задания
+	task := env.task(t, 1, domain.TaskStateIdle)
+
+	env.manager.execute(context.Background(), jobDTO{TaskID: task.ID, APIKeyID: task.APIKeyID, Recovery: true})
+
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.Version != task.Version || got.RollCount != 0 {
+		t.Fatalf("task changed: status %s, version %d, rolls %d", got.Status, got.Version, got.RollCount)
+	}
+	if qty := env.position(t, task, env.symbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("position was touched: qty %s", qty)
+	}
+}
+
+func TestExecuteRecoveryFinishesLeg2(t *testing.T) {
+	env := newTestEnv(t, DefaultConfig())
+	task := env.task(t, 1, domain.TaskStateLeg1Closed)
+	key, _ := env.fx.Keys.GetByID(context.Background(), task.APIKeyID)
+	env.exchange.SetPosition(key.Key, domain.Position{Symbol: env.symbol})
+
+	env.manager.execute(context.Background(), jobDTO{TaskID: task.ID, APIKeyID: task.APIKeyID, Recovery: true})
+
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.RollCount != 1 || got.CurrentOptionSymbol == env.symbol {
+		t.Fatalf("leg 2 not finished: status %s, rolls %d, symbol %s", got.Status, got.RollCount, got.CurrentOptionSymbol)
+	}
+	if qty := env.position(t, task, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("new leg qty %s, want 0.1", qty)
+	}
+}
+
+func TestTicksDuringRollStartOneRoll(t *testing.T) {
+	env := newTestEnv(t, Config{Workers: 2})
+	env.exchange.SetFailures(fakeexchange.Failures{PositionDelay: 100 * time.Millisecond})
+	task := env.task(t, 1, domain.TaskStateIdle)
+	cancel := env.start(t)
+
+	// Пачка тиков за триггером, пока первый ролл еще идет
+	for i := 0; i < 10; i++ {
+		env.tick(58500 - int64(i)*10)
+		time.Sleep(5 * time.Millisecond)
+	}
+	env.manager.drain(cancel)
+
+	if got := env.fx.Reload(t, task.ID); got.RollCount != 1 || got.Status != domain.TaskStateIdle {
+		t.Fatalf("rolls %d, status %s, want one finished roll", got.RollCount, got.Status)
+	}
+}
+
+func TestRollsOfOneKeyRunInOrder(t *testing.T) {
+	env := newTestEnv(t, Config{Workers: 3})
+	env.exchange.SetFailures(fakeexchange.Failures{PositionDelay: 50 * time.Millisecond})
+	first := env.task(t, 1, domain.TaskStateIdle)
+	second := env.sameKeyTask(t, first)
+	cancel := env.start(t)
+
+	env.tick(58500)
+	env.manager.drain(cancel)
+
+	if env.rollEventID(t, first.ID) > env.rollEventID(t, second.ID) {
+		t.Fatal("second task of the key rolled before the first")
+	}
+}
+
+func TestRollsOfDifferentKeysRunInParallel(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	elapsed := func(sameKey bool) time.Duration {
+		env := newTestEnv(t, Config{Workers: 2})
+		env.exchange.SetFailures(fakeexchange.Failures{PositionDelay: delay})
+		first := env.task(t, 1, domain.TaskStateIdle)
+		if sameKey {
+			env.sameKeyTask(t, first)
+		} else {
+			env.task(t, 2, domain.TaskStateIdle)
+		}
+		cancel := env.start(t)
+
+		start := time.Now()
+		env.tick(58500)
+		env.manager.drain(cancel)
+		return time.Since(start)
+	}
+
+	serial, parallel := elapsed(true), elapsed(false)
+	// Ролл ждет биржу несколько раз по delay: по одному ключу роллы идут друг за другом,
+	// по разным - одновременно и укладываются примерно во время одного
+	if parallel > serial*3/4 {
+		t.Fatalf("different keys took %s, same key %s: rolls did not overlap", parallel, serial)
+	}
+}
+
+func TestDrainWaitsForSlowRoll(t *testing.T) {
+	env := newTestEnv(t, Config{Workers: 1, DrainTimeout: 5 * time.Second})
+	env.exchange.SetFailures(fakeexchange.Failures{PositionDelay: 100 * time.Millisecond})
+	task := env.task(t, 1, domain.TaskStateIdle)
+	cancel := env.start(t)
+
+	env.tick(58500)
+	waitInFlight(t, env.manager)
+	env.manager.drain(cancel)
+
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateIdle || got.RollCount != 1 {
+		t.Fatalf("roll not finished during drain: status %s, rolls %d", got.Status, got.RollCount)
+	}
+	if qty := env.position(t, task, got.CurrentOptionSymbol); !qty.Equal(decimal.NewFromFloat(0.1)) {
+		t.Fatalf("new leg qty %s, want 0.1", qty)
+	}
+}
+
+func TestDrainTimeoutAbortsRoll(t *testing.T) {
+	env := newTestEnv(t, Config{Workers: 1, DrainTimeout: 50 * time.Millisecond})
+	env.exchange.SetFailures(fakeexchange.Failures{PositionTimeout: true})
+	task := env.task(t, 1, domain.TaskStateIdle)
+	cancel := env.start(t)
+
+	env.tick(58500)
+	waitInFlight(t, env.manager)
+
+	done := make(chan struct{})
+	go func() {
+		env.manager.drain(cancel)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not abort the hung roll")
+	}
+	// Ролл прерван до биржи: задачу доведет восстановление при следующем запуске
+	if got := env.fx.Reload(t, task.ID); got.RollCount != 0 {
+		t.Fatalf("rolls %d, want 0", got.RollCount)
+	}
+}
+
+func waitInFlight(t *testing.T, m *Manager) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, inFlight := m.jobs.stats(); inFlight > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("roll did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerSurvivesRollPanic(t *testing.T) {
+	cfg := Config{Workers: 1}
+	env := newTestEnv(t, cfg)
+	broken := env.task(t, 1, domain.TaskStateIdle)
+	healthy := env.task(t, 2, domain.TaskStateIdle)
+
+	rolled := make(chan int64, 1)
+	notifier := &recordingNotifier{}
+	env.useRoller(cfg, &fakeRoller{run: func(task *domain.Task) error {
+		if task.ID == broken.ID {
+			panic("nil pointer in roll")
+		}
+		rolled <- task.ID
+		return nil
+	}}, notifier)
+	cancel := env.start(t)
+	defer cancel()
+
+	// Единственный воркер: следующее задание он возьмет, только если пережил панику
+	env.manager.jobs.push(jobDTO{TaskID: broken.ID, APIKeyID: broken.APIKeyID})
+	env.manager.jobs.push(jobDTO{TaskID: healthy.ID, APIKeyID: healthy.APIKeyID})
+	select {
+	case id := <-rolled:
+		if id != healthy.ID {
+			t.Fatalf("rolled task %d, want %d", id, healthy.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker stopped after a panicking roll")
+	}
+
+	got := env.fx.Reload(t, broken.ID)
+	if got.Status != domain.TaskStateFailed || !strings.Contains(got.LastError, "panicked") {
+		t.Fatalf("panicked task: status %s, last error %q", got.Status, got.LastError)
+	}
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	if len(notifier.users) != 1 || notifier.users[0] != broken.UserID {
+		t.Fatalf("notified %v, want owner %d of the panicked task", notifier.users, broken.UserID)
+	}
+}
+
+func TestFailureBudget(t *testing.T) {
+	const limit = 3
+	failing := errors.New("injected roll failure")
+	tests := []struct {
+		name     string
+		window   time.Duration
+		outcomes []error       // исходы роллов подряд
+		pause    time.Duration // пауза между роллами
+		want     domain.TaskState
+	}{
+		{"limit within window", time.Minute, []error{failing, failing, failing}, 0, domain.TaskStateFailed},
+		{"success resets", time.Minute, []error{failing, failing, nil, failing, failing}, 0, domain.TaskStateIdle},
+		{"failures outside window", 50 * time.Millisecond, []error{failing, failing, failing}, 60 * time.Millisecond, domain.TaskStateIdle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{FailureLimit: limit, FailureWindow: tt.window}
+			env := newTestEnv(t, cfg)
+			task := env.task(t, 1, domain.TaskStateIdle)
+			notifier := &recordingNotifier{}
+			roller := &fakeRoller{}
+			env.useRoller(cfg, roller, notifier)
+
+			for i, outcome := range tt.outcomes {
+				if i > 0 {
+					time.Sleep(tt.pause)
+				}
+				roller.run = func(*domain.Task) error { return outcome }
+				env.manager.execute(context.Background(), jobDTO{TaskID: task.ID, APIKeyID: task.APIKeyID})
+			}
+
+			got := env.fx.Reload(t, task.ID)
+			if got.Status != tt.want {
+				t.Fatalf("status %s after %d rolls, want %s (last error %q)", got.Status, len(tt.outcomes), tt.want, got.LastError)
+			}
+			wantNotified := 0
+			if tt.want == domain.TaskStateFailed {
+				wantNotified = 1
+				if !strings.Contains(got.LastError, "circuit open") {
+					t.Fatalf("last error %q, want circuit open", got.LastError)
+				}
+			}
+			if len(notifier.users) != wantNotified {
+				t.Fatalf("%d notifications, want %d", len(notifier.users), wantNotified)
+			}
+		})
+	}
+}
+
+func TestFailureBudgetLeavesInterruptedRoll(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{FailureLimit: 2, FailureWindow: time.Minute}
+	env := newTestEnv(t, cfg)
+	task := env.task(t, 1, domain.TaskStateIdle)
+	notifier := &recordingNotifier{}
+	// Закрывающий ордер ушел, ответа нет: роллер оставляет задачу в ROLL_INITIATED для восстановления
+	env.useRoller(cfg, &fakeRoller{run: func(task *domain.Task) error {
+		if task.Status == domain.TaskStateIdle {
+			if err := env.fx.Tasks.UpdateTaskState(ctx, task.ID, domain.TaskStateRollInitiated, task.Version); err != nil {
+				return err
+			}
+		}
+		return errors.New("close order may have been sent: timeout")
+	}}, notifier)
+
+	for i := 0; i < cfg.FailureLimit; i++ {
+		env.manager.execute(ctx, jobDTO{TaskID: task.ID, APIKeyID: task.APIKeyID})
+	}
+
+	got := env.fx.Reload(t, task.ID)
+	if got.Status != domain.TaskStateRollInitiated || strings.Contains(got.LastError, "circuit open") {
+		t.Fatalf("status %s, last error %q; want ROLL_INITIATED left to recovery", got.Status, got.LastError)
+	}
+	if len(notifier.users) != 1 || notifier.users[0] != task.UserID {
+		t.Fatalf("notified %v, want owner %d", notifier.users, task.UserID)
+	}
+}
+
+func TestRollPanicAfterLeg1KeepsRecoveryState(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Workers: 1}
+	env := newTestEnv(t, cfg)
+	task := env.task(t, 1, domain.TaskStateIdle)
+	notifier := &recordingNotifier{}
+	env.useRoller(cfg, &fakeRoller{run: func(task *domain.Task) error {
+		if err := env.fx.Tasks.UpdateTaskState(ctx, task.ID, domain.TaskStateLeg1Closed, task.Version); err != nil {
+			return err
+		}
+		panic("nil pointer in leg 2")
+	}}, notifier)
+
+	env.manager.process(ctx, jobDTO{TaskID: task.ID, APIKeyID: task.APIKeyID})
+
+	// FAILED оставил бы позицию закрытой без второй ноги: enqueueRecovery должен ее подобрать
+	if got := env.fx.Reload(t, task.ID); got.Status != domain.TaskStateLeg1Closed {
+		t.Fatalf("status %s after panic, want LEG1_CLOSED", got.Status)
+	}
+	if len(notifier.users) != 1 {
+		t.Fatalf("%d notifications, want 1", len(notifier.users))
+	}
+}
+
+// recordingStreamer - ценовой стрим, который только запоминает изменения подписок
+type recordingStreamer struct {
+	mu      sync.Mutex
+	added   []string
+	removed []string
+}
+
+func (s *recordingStreamer) Subscribe(context.Context, []string) (<-chan domain.PriceUpdateEvent, error) {
+	return make(chan domain.PriceUpdateEvent), nil
+}
+
+func (s *recordingStreamer) SubscribeSymbol(string) (<-chan domain.PriceUpdateEvent, func(), error) {
+	return make(chan domain.PriceUpdateEvent), func() {}, nil
+}
+
+func (s *recordingStreamer) AddSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.added = append(s.added, symbols...)
+	return nil
+}
+
+func (s *recordingStreamer) RemoveSubscriptions(symbols []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = append(s.removed, symbols...)
+	return nil
+}
+
+func (s *recordingStreamer) GetLastPrice(string) (decimal.Decimal, time.Time, bool) {
+	return decimal.Zero, time.Time{}, false
+}
+
+func (s *recordingStreamer) Status() domain.StreamStatus             { return domain.StreamStatus{} }
+func (s *recordingStreamer) Health() <-chan domain.StreamHealthEvent { return nil }
+func (s *recordingStreamer) Close() error                            { return nil }
+
+func TestReloadTasksMidStream(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, Config{Workers: 1})
+	feed := &recordingStreamer{}
+	env.manager.feeds = MarketFeeds{Mainnet: feed}
+	cancel := env.start(t)
+	defer cancel()
+
+	// Задачу создали, пока стрим уже идет: до ReloadTasks Manager о ней не знает
+	task := env.task(t, 1, domain.TaskStateIdle)
+	if jobs := env.manager.triggeredTasks(domain.PriceUpdateEvent{Symbol: "BTCUSDT", Price: decimal.NewFromInt(58500)}, false); len(jobs) != 0 {
+		t.Fatalf("task triggered before reload: %+v", jobs)
+	}
+
+	if err := env.manager.ReloadTasks(ctx); err != nil {
+		t.Fatalf("ReloadTasks: %v", err)
+	}
+	feed.mu.Lock()
+	added := append([]string(nil), feed.added...)
+	feed.mu.Unlock()
+	if len(added) != 1 || added[0] != "BTCUSDT" {
+		t.Fatalf("subscribed %v, want BTCUSDT", added)
+	}
+
+	// Следующий тик за триггером запускает ролл новой задачи
+	env.tick(58500)
+	deadline := time.Now().Add(5 * time.Second)
+	for env.fx.Reload(t, task.ID).RollCount == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("task created mid-stream did not roll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Задачу поставили на паузу: после перезагрузки тики ее не трогают, а символ отписан
+	got := env.fx.Reload(t, task.ID)
+	if err := env.fx.Tasks.PauseTask(ctx, task.ID, got.Version); err != nil {
+		t.Fatalf("PauseTask: %v", err)
+	}
+	if err := env.manager.ReloadTasks(ctx); err != nil {
+		t.Fatalf("ReloadTasks: %v", err)
+	}
+	if jobs := env.manager.triggeredTasks(domain.PriceUpdateEvent{Symbol: "BTCUSDT", Price: decimal.NewFromInt(50000)}, false); len(jobs) != 0 {
+		t.Fatalf("paused task still triggers: %+v", jobs)
+	}
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if len(feed.removed) != 1 || feed.removed[0] != "BTCUSDT" {
+		t.Fatalf("unsubscribed %v, want BTCUSDT", feed.removed)
+	}
+}
+
+func TestStreamDownPollsUntilRecovered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	env := newTestEnv(t, Config{Workers: 1})
+	notifier := &recordingNotifier{}
+	env.manager.notifier = notifier
+	env.manager.feeds = MarketFeeds{Mainnet: &recordingStreamer{}}
+	env.manager.SetRESTFallback(env.exchange, domain.PriceSourceMark, 10*time.Millisecond)
+	env.task(t, 1, domain.TaskStateIdle)
+	if err := env.manager.ReloadTasks(ctx); err != nil {
+		t.Fatalf("ReloadTasks: %v", err)
+	}
+	env.exchange.SetIndexPrice("BTCUSDT", decimal.NewFromInt(61000))
+
+	health := make(chan domain.StreamHealthEvent)
+	events := make(chan feedEvent)
+	go env.manager.watchHealth(ctx, health, false, false)
+	go env.manager.pollPrices(ctx, events)
+
+	// Пока стрим жив, опроса нет
+	select {
+	case fe := <-events:
+		t.Fatalf("polled while the stream is up: %+v", fe.event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	health <- domain.StreamHealthEvent{Kind: domain.StreamDown, Source: "bybit", Symbols: []string{"BTCUSDT"}, Silence: time.Minute, Reason: "dial failed"}
+	select {
+	case fe := <-events:
+		if fe.testnet || fe.option || fe.event.Symbol != "BTCUSDT" || !fe.event.Price.Equal(decimal.NewFromInt(61000)) {
+			t.Fatalf("polled event %+v (testnet %v, option %v)", fe.event, fe.testnet, fe.option)
+		}
+		if fe.event.Source != "bybit-rest-poll:mark" {
+			t.Fatalf("source %q, want the configured mark field", fe.event.Source)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream down did not start polling")
+	}
+
+	health <- domain.StreamHealthEvent{Kind: domain.StreamRecovered, Source: "bybit", Symbols: []string{"BTCUSDT"}}
+	// Опрос, начатый до восстановления, еще может отдать тик; после паузы тиков быть не должно
+	deadline := time.After(100 * time.Millisecond)
+	for drained := false; !drained; {
+		select {
+		case <-events:
+		case <-deadline:
+			drained = true
+		}
+	}
+	select {
+	case fe := <-events:
+		t.Fatalf("polled after recovery: %+v", fe.event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	admin := notifier.adminMessages()
+	if len(admin) != 2 || !strings.Contains(admin[0], "лежит") || !strings.Contains(admin[1], "восстановлен") {
+		t.Fatalf("admin notifications %q, want down and recovered", admin)
+	}
+}
+
+func TestTriggeredTasksRollWithFreshCopies(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Workers: 2}
+	env := newTestEnv(t, cfg)
+	first := env.task(t, 1, domain.TaskStateIdle)
+	second := env.sameKeyTask(t, first)
+
+	type rolled struct {
+		symbol  string
+		version int64
+	}
+	var mu sync.Mutex
+	seen := make(map[int64][]rolled)
+	roller := &fakeRoller{run: func(task *domain.Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[task.ID] = append(seen[task.ID], rolled{task.CurrentOptionSymbol, task.Version})
+		return nil
+	}}
+	env.useRoller(cfg, roller, nil)
+	cancel := env.start(t)
+
+	// После загрузки в кэш Manager первую задачу перевели на другой страйк: кэш устарел
+	fresh := strings.Replace(env.symbol, "-58000-", "-57000-", 1)
+	if err := env.fx.Tasks.UpdateTaskSymbol(ctx, first.ID, fresh, decimal.NewFromFloat(0.1), first.Version); err != nil {
+		t.Fatalf("UpdateTaskSymbol: %v", err)
+	}
+	want := map[int64]rolled{
+		first.ID:  {fresh, env.fx.Reload(t, first.ID).Version},
+		second.ID: {second.CurrentOptionSymbol, second.Version},
+	}
+
+	// Обе задачи срабатывают на одном тике, одной пачкой заданий
+	env.tick(58500)
+	env.manager.drain(cancel)
+
+	for id, w := range want {
+		if got := seen[id]; len(got) != 1 || got[0] != w {
+			t.Errorf("task %d rolled with %+v, want once with %+v", id, got, w)
+		}
+	}
+}